@@ -15,8 +15,16 @@ class FileController {
   }
   async getFilesList(): Response<FileDto[]> {
     try {
-      const response = await instance.get<FileDto[]>('/files');
-      return { payload: response.data, type: 'payload' };
+      // The server returns at most 500 files per page, so page until all are loaded
+      const files: FileDto[] = [];
+      for (;;) {
+        const response = await instance.get<{ total: number; files: FileDto[] }>('/files', {
+          params: { limit: 500, offset: files.length },
+        });
+        files.push(...response.data.files);
+        if (response.data.files.length === 0 || files.length >= response.data.total) break;
+      }
+      return { payload: files, type: 'payload' };
     } catch (error) {
       console.log('Got api load error: ', error);
       return { message: parseErrorMessage(error), type: 'error' };
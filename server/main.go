@@ -2,16 +2,32 @@ package main
 
 import (
 	"os"
+	"pdfsrv/src/controllers"
 	"pdfsrv/src/database"
 	"pdfsrv/src/migration"
 	"pdfsrv/src/routes"
+	"pdfsrv/src/storage"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 func main() {
 	database.Connect()
+	// Storage is connected first, migrations may read stored content
+	storage.Connect()
 	migration.AutoMigrate()
+	if !fiber.IsChild() {
+		// Only the parent process runs the background tasks, so each runs once
+		controllers.ResumeScans()
+		go controllers.IndexMissingText()
+		controllers.ResumeOCRJobs()
+		controllers.ResumeConversionJobs()
+		go controllers.RunJobs()
+		go controllers.RunStorageGC()
+		go controllers.RunRenderCacheEviction()
+		go controllers.RunTrashPurge()
+		go controllers.RunFileExpiry()
+	}
 	app := fiber.New(fiber.Config{
 		Prefork:   true,
 		BodyLimit: 1024 * 1024 * 1000,
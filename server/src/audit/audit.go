@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// Audited actions
+const (
+	FileUpload           = "file.upload"
+	FileCreate           = "file.create"
+	FileUpdate           = "file.update"
+	FileDelete           = "file.delete"
+	FileRestore          = "file.restore"
+	FilePurge            = "file.purge"
+	FileExpire           = "file.expire"
+	FileMove             = "file.move"
+	FileLock             = "file.lock"
+	FileUnlock           = "file.unlock"
+	FileVersionUpload    = "file.version.upload"
+	FileInfected         = "file.infected"
+	FilePermissionSet    = "file.permission.set"
+	FilePermissionRevoke = "file.permission.revoke"
+	ShareLinkCreate      = "share_link.create"
+	ShareLinkRevoke      = "share_link.revoke"
+	DrawingCreate        = "drawing.create"
+	DrawingUpdate        = "drawing.update"
+	DrawingDelete        = "drawing.delete"
+	DrawingRevert        = "drawing.revert"
+	DrawingRestore       = "drawing.restore"
+	DrawingLock          = "drawing.lock"
+	DrawingUnlock        = "drawing.unlock"
+	DrawingGroupCreate   = "drawing_group.create"
+	DrawingGroupUpdate   = "drawing_group.update"
+	DrawingGroupDelete   = "drawing_group.delete"
+	LayerCreate          = "layer.create"
+	LayerUpdate          = "layer.update"
+	LayerDelete          = "layer.delete"
+	CommentCreate        = "comment.create"
+	CommentUpdate        = "comment.update"
+	CommentDelete        = "comment.delete"
+	UserUpdate           = "user.update"
+)
+
+// Entity types
+const (
+	EntityFile                = "file"
+	EntityDrawing             = "drawing"
+	EntityDrawingGroup        = "drawing_group"
+	EntityLayer               = "layer"
+	EntityFileComment         = "file_comment"
+	EntityDrawingComment      = "drawing_comment"
+	EntityFilePermission      = "file_permission"
+	EntityFileGroupPermission = "file_group_permission"
+	EntityShareLink           = "share_link"
+	EntityUser                = "user"
+)
+
+// snapshot returns the state of an entity to keep in an audit entry. Drawings
+// are kept without their image, which can be megabytes of data URL and is in
+// the drawing's history anyway.
+func snapshot(entity interface{}) interface{} {
+	switch drawing := entity.(type) {
+	case models.Drawing:
+		drawing.Image = ""
+		return drawing
+	case *models.Drawing:
+		if drawing != nil {
+			kept := *drawing
+			kept.Image = ""
+			return kept
+		}
+	}
+	return entity
+}
+
+// Record stores an audit entry for the caller of the request. Failures are
+// logged rather than returned so auditing never breaks the action itself.
+func Record(c *fiber.Ctx, action, entityType string, entityID uint, before, after interface{}) {
+	entry := models.AuditEntry{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     snapshot(before),
+		After:      snapshot(after),
+		IP:         c.IP(),
+	}
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		actorID := claims.UserID()
+		entry.ActorID = &actorID
+		entry.ActorName = claims.Username
+	}
+	if workspace := middleware.CurrentWorkspace(c); workspace != nil {
+		entry.WorkspaceID = &workspace.ID
+	}
+
+	if err := database.DB.Create(&entry).Error; err != nil {
+		fmt.Printf("ERROR recording audit entry %s %s %d: %v\n", action, entityType, entityID, err)
+	}
+}
+
+// RecordSystem stores an audit entry for an action the server took on its own,
+// outside of a request
+func RecordSystem(action, entityType string, entityID uint, workspaceID *uint, before, after interface{}) {
+	entry := models.AuditEntry{
+		WorkspaceID: workspaceID,
+		ActorName:   "system",
+		Action:      action,
+		EntityType:  entityType,
+		EntityID:    entityID,
+		Before:      snapshot(before),
+		After:       snapshot(after),
+	}
+	if err := database.DB.Create(&entry).Error; err != nil {
+		fmt.Printf("ERROR recording audit entry %s %s %d: %v\n", action, entityType, entityID, err)
+	}
+}
+
+// RecordUser stores an audit entry for an action taken on behalf of a user
+// outside of a request, like a queued job
+func RecordUser(actorID uint, action, entityType string, entityID uint, workspaceID *uint, before, after interface{}) {
+	entry := models.AuditEntry{
+		WorkspaceID: workspaceID,
+		ActorID:     &actorID,
+		Action:      action,
+		EntityType:  entityType,
+		EntityID:    entityID,
+		Before:      snapshot(before),
+		After:       snapshot(after),
+	}
+	var user models.User
+	if err := database.DB.Select("username").First(&user, actorID).Error; err == nil {
+		entry.ActorName = user.Username
+	}
+	if err := database.DB.Create(&entry).Error; err != nil {
+		fmt.Printf("ERROR recording audit entry %s %s %d: %v\n", action, entityType, entityID, err)
+	}
+}
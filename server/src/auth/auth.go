@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// Authentication sources recorded on users
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+	SourceOIDC  = "oidc"
+)
+
+// ErrInvalidCredentials is returned by backends that don't know the user or reject the password
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Backend verifies username and password credentials and returns the matching user,
+// provisioning it first if the backend is an external directory
+type Backend interface {
+	Name() string
+	Authenticate(username, password string) (models.User, error)
+}
+
+// Backends returns the backends listed in AUTH_BACKENDS in the order they are tried.
+// Only the local backend is used when the env var isn't set.
+func Backends() ([]Backend, error) {
+	names := EnvList("AUTH_BACKENDS")
+	if len(names) == 0 {
+		names = []string{SourceLocal}
+	}
+
+	backends := make([]Backend, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case SourceLocal:
+			backends = append(backends, LocalBackend{})
+		case SourceLDAP:
+			backend, err := NewLDAPBackendFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, backend)
+		default:
+			return nil, fmt.Errorf("unknown auth backend %q", name)
+		}
+	}
+	return backends, nil
+}
+
+// Authenticate tries each configured backend until one accepts the credentials.
+// Errors other than invalid credentials are returned only if no backend succeeds.
+func Authenticate(username, password string) (models.User, error) {
+	backends, err := Backends()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	lastErr := ErrInvalidCredentials
+	for _, backend := range backends {
+		user, err := backend.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			fmt.Printf("ERROR %s auth backend: %v\n", backend.Name(), err)
+			lastErr = err
+		}
+	}
+	return models.User{}, lastErr
+}
+
+// EnvList splits a comma separated env var into trimmed, non-empty values
+func EnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// RoleForGroups maps directory groups to the most privileged matching role using
+// the <prefix>_ADMIN_GROUPS, <prefix>_EDITOR_GROUPS and <prefix>_VIEWER_GROUPS env
+// vars, falling back to <prefix>_DEFAULT_ROLE
+func RoleForGroups(prefix string, groups []string) string {
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[group] = true
+	}
+
+	for _, mapping := range []struct {
+		env  string
+		role string
+	}{
+		{prefix + "_ADMIN_GROUPS", models.RoleAdmin},
+		{prefix + "_EDITOR_GROUPS", models.RoleEditor},
+		{prefix + "_VIEWER_GROUPS", models.RoleViewer},
+	} {
+		for _, group := range EnvList(mapping.env) {
+			if member[group] {
+				return mapping.role
+			}
+		}
+	}
+
+	if role := os.Getenv(prefix + "_DEFAULT_ROLE"); models.ValidRole(role) {
+		return role
+	}
+	return models.RoleViewer
+}
+
+// usernameTaken reports whether an account, deleted or not, has the username
+func usernameTaken(username string) bool {
+	var count int64
+	database.DB.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count)
+	return count > 0
+}
+
+// ProvisionExternalUser finds or creates the account of a directory user and
+// refreshes its role and email from the directory
+func ProvisionExternalUser(source, username, email, role string) (models.User, error) {
+	var user models.User
+	result := database.DB.Where("auth_source = ? AND external_name = ?", source, username).First(&user)
+	if result.Error == nil {
+		user.Role = role
+		if email != "" {
+			user.Email = email
+		}
+		return user, database.DB.Save(&user).Error
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return user, result.Error
+	}
+
+	user = models.User{
+		Username:     username,
+		Role:         role,
+		Email:        email,
+		AuthSource:   source,
+		ExternalName: username,
+	}
+
+	// Avoid clashing with accounts of the same name from other sources
+	for i := 1; usernameTaken(user.Username); i++ {
+		user.Username = username + "@" + source
+		if i > 1 {
+			user.Username += fmt.Sprintf("-%d", i)
+		}
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		return user, err
+	}
+	return user, JoinDefaultWorkspace(user.ID)
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// TestProvisionExternalUserNameTaken logs a directory user whose name a local
+// account has in twice; both logins must find the same account. It needs the
+// database of DB_HOST and friends and is skipped without one.
+func TestProvisionExternalUserNameTaken(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+	database.Connect()
+	if err := database.DB.AutoMigrate(&models.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := fmt.Sprintf("clash-%d", time.Now().UnixNano())
+	local := models.User{Username: name, Role: models.RoleViewer}
+	if err := database.DB.Create(&local).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.DB.Unscoped().Where("username LIKE ?", name+"%").Delete(&models.User{})
+
+	first, err := ProvisionExternalUser(SourceLDAP, name, "", models.RoleViewer)
+	if err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if first.Username != name+"@"+SourceLDAP {
+		t.Errorf("username = %q, want %q", first.Username, name+"@"+SourceLDAP)
+	}
+	second, err := ProvisionExternalUser(SourceLDAP, name, "", models.RoleEditor)
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second login got account %d, want %d", second.ID, first.ID)
+	}
+	if second.Role != models.RoleEditor {
+		t.Errorf("role = %q, want %q", second.Role, models.RoleEditor)
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"pdfsrv/src/models"
+)
+
+// LDAPBackend authenticates against an LDAP directory such as Active Directory.
+// It binds with a service account to find the user's DN, then binds as the user
+// to verify the password.
+type LDAPBackend struct {
+	URL                string
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	UsernameAttribute  string
+	EmailAttribute     string
+	GroupAttribute     string
+	StartTLS           bool
+	InsecureSkipVerify bool
+}
+
+// NewLDAPBackendFromEnv configures the backend from LDAP_* env vars
+func NewLDAPBackendFromEnv() (*LDAPBackend, error) {
+	backend := &LDAPBackend{
+		URL:                os.Getenv("LDAP_URL"),
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         os.Getenv("LDAP_USER_FILTER"),
+		UsernameAttribute:  os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		EmailAttribute:     os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		GroupAttribute:     os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+	}
+
+	if backend.URL == "" || backend.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required for the ldap auth backend")
+	}
+	if backend.UserFilter == "" {
+		backend.UserFilter = "(&(objectClass=user)(sAMAccountName=%s))"
+	}
+	if backend.UsernameAttribute == "" {
+		backend.UsernameAttribute = "sAMAccountName"
+	}
+	if backend.EmailAttribute == "" {
+		backend.EmailAttribute = "mail"
+	}
+	if backend.GroupAttribute == "" {
+		backend.GroupAttribute = "memberOf"
+	}
+	return backend, nil
+}
+
+func (b *LDAPBackend) Name() string {
+	return SourceLDAP
+}
+
+func (b *LDAPBackend) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: b.InsecureSkipVerify}
+	conn, err := ldap.DialURL(b.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", b.URL, err)
+	}
+
+	if b.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+func (b *LDAPBackend) Authenticate(username, password string) (models.User, error) {
+	// An empty password would make the user bind anonymous and succeed
+	if username == "" || password == "" {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	conn, err := b.connect()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer conn.Close()
+
+	if b.BindDN != "" {
+		if err := conn.Bind(b.BindDN, b.BindPassword); err != nil {
+			return models.User{}, fmt.Errorf("service account bind failed: %v", err)
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		b.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(b.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", b.UsernameAttribute, b.EmailAttribute, b.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return models.User{}, fmt.Errorf("user search failed: %v", err)
+	}
+	if len(result.Entries) != 1 {
+		return models.User{}, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return models.User{}, ErrInvalidCredentials
+		}
+		return models.User{}, fmt.Errorf("user bind failed: %v", err)
+	}
+
+	// Groups can be mapped by full DN or by common name
+	var groups []string
+	for _, groupDN := range entry.GetAttributeValues(b.GroupAttribute) {
+		groups = append(groups, groupDN)
+		if parsed, err := ldap.ParseDN(groupDN); err == nil && len(parsed.RDNs) > 0 {
+			for _, attr := range parsed.RDNs[0].Attributes {
+				if strings.EqualFold(attr.Type, "cn") {
+					groups = append(groups, attr.Value)
+				}
+			}
+		}
+	}
+
+	name := entry.GetAttributeValue(b.UsernameAttribute)
+	if name == "" {
+		name = username
+	}
+
+	return ProvisionExternalUser(SourceLDAP, name, entry.GetAttributeValue(b.EmailAttribute), RoleForGroups("LDAP", groups))
+}
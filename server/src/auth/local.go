@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"golang.org/x/crypto/bcrypt"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// LocalBackend checks passwords stored in the users table
+type LocalBackend struct{}
+
+func (LocalBackend) Name() string {
+	return SourceLocal
+}
+
+func (LocalBackend) Authenticate(username, password string) (models.User, error) {
+	var user models.User
+	result := database.DB.Where("username = ? AND auth_source = ?", username, SourceLocal).First(&user)
+	if result.Error != nil {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return models.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
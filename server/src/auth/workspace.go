@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// JoinDefaultWorkspace adds a new user to the default workspace, if there is one,
+// so single-tenant installs keep working without managing memberships
+func JoinDefaultWorkspace(userID uint) error {
+	var workspace models.Workspace
+	if err := database.DB.Where("slug = ?", models.DefaultWorkspaceSlug).First(&workspace).Error; err != nil {
+		return nil
+	}
+	return database.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: userID}).Error
+}
+
+// IsWorkspaceMember reports whether the user belongs to the workspace
+func IsWorkspaceMember(userID, workspaceID uint) bool {
+	var count int64
+	database.DB.Model(&models.WorkspaceMember{}).
+		Where("user_id = ? AND workspace_id = ?", userID, workspaceID).
+		Count(&count)
+	return count > 0
+}
+
+// PrimaryWorkspaceID returns the workspace a user works in when none was chosen,
+// the oldest one they joined, or 0 if they belong to none
+func PrimaryWorkspaceID(userID uint) uint {
+	var member models.WorkspaceMember
+	if err := database.DB.Where("user_id = ?", userID).Order("id").First(&member).Error; err != nil {
+		return 0
+	}
+	return member.WorkspaceID
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+// AppVersion is reported by the diagnostics endpoint, set at build time with
+// -ldflags "-X pdfsrv/src/controllers.AppVersion=..."
+var AppVersion = "dev"
+
+var startedAt = time.Now()
+
+// uploadsUsage returns the number of stored files and their total size in bytes
+func uploadsUsage() (int64, int64, error) {
+	var count, size int64
+	err := storage.Store.Walk("", func(object storage.Object) error {
+		count++
+		size += object.Size
+		return nil
+	})
+	return count, size, err
+}
+
+// GetDiagnostics - Report storage, database and process health details
+func GetDiagnostics(c *fiber.Ctx) error {
+	fmt.Println("GetDiagnostics")
+
+	store := fiber.Map{"driver": storage.Store.Name()}
+	// Free space is only meaningful when files live on a local disk
+	if local, ok := storage.Store.(*storage.Local); ok {
+		if free, err := freeDiskSpace(local.Root); err != nil {
+			store["freeBytesError"] = err.Error()
+		} else {
+			store["freeBytes"] = free
+		}
+	}
+	if count, size, err := uploadsUsage(); err != nil {
+		store["usageError"] = err.Error()
+	} else {
+		store["storedFiles"] = count
+		store["usedBytes"] = size
+	}
+	if report, err := storage.CollectGarbage(true); err != nil {
+		store["orphanedDirsError"] = err.Error()
+	} else {
+		store["orphanedDirs"] = len(report.OrphanedHashes)
+		store["missingBlobs"] = len(report.MissingHashes)
+	}
+
+	db := fiber.Map{}
+	if sqlDB, err := database.DB.DB(); err != nil {
+		db["error"] = err.Error()
+	} else {
+		stats := sqlDB.Stats()
+		db["pool"] = fiber.Map{
+			"maxOpenConnections": stats.MaxOpenConnections,
+			"openConnections":    stats.OpenConnections,
+			"inUse":              stats.InUse,
+			"idle":               stats.Idle,
+			"waitCount":          stats.WaitCount,
+			"waitDuration":       stats.WaitDuration.String(),
+		}
+		if err := sqlDB.Ping(); err != nil {
+			db["pingError"] = err.Error()
+		}
+	}
+
+	var fileCount, drawingCount int64
+	database.DB.Model(&models.File{}).Count(&fileCount)
+	database.DB.Model(&models.Drawing{}).Count(&drawingCount)
+	db["files"] = fileCount
+	db["drawings"] = drawingCount
+
+	return c.JSON(fiber.Map{
+		"version":  AppVersion,
+		"uptime":   time.Since(startedAt).Round(time.Second).String(),
+		"pid":      os.Getpid(),
+		"storage":  store,
+		"database": db,
+	})
+}
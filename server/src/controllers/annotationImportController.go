@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+// ImportAnnotations - Create drawings from the annotations of an XFDF file or
+// a PDF marked up in another reader, sent as the "file" part of a multipart
+// request. Annotations exported from drawings the file still has are left
+// out, so a document can make the round trip without doubling its drawings.
+func ImportAnnotations(c *fiber.Ctx) error {
+	fmt.Println("ImportAnnotations")
+
+	upload, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "An XFDF or PDF file is required",
+		})
+	}
+	if upload.Size > maxFileSize(fileTypePDF) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": errFileTooLarge.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), outputVersion)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	// Annotations are placed on the pages of the file, not the uploaded PDF
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare import",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	uploadPath := filepath.Join(workDir, "annotations")
+	if err := c.SaveFile(upload, uploadPath); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save upload",
+		})
+	}
+
+	isPDF, err := pdf.IsPDF(uploadPath)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read upload",
+		})
+	}
+	var imported []pdf.ImportedDrawing
+	var skipped int
+	if isPDF {
+		imported, skipped, err = pdf.ReadAnnotations(uploadPath, info.PageSizes)
+		if err != nil {
+			fmt.Printf("ERROR reading annotations for file %d: %v\n", file.ID, err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Failed to read annotations from the PDF",
+			})
+		}
+	} else {
+		data, err := os.ReadFile(uploadPath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to read upload",
+			})
+		}
+		if bytes.HasPrefix(data, []byte("%FDF-")) {
+			return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+				"error": "FDF files are not supported, export the annotations as XFDF instead",
+			})
+		}
+		imported, skipped, err = pdf.ReadXFDF(data, info.PageSizes)
+		if err == io.EOF {
+			err = fmt.Errorf("the file is empty")
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// Drawings that were exported and are still on the file aren't created twice
+	var existing []uint
+	database.DB.Model(&models.Drawing{}).Where("file_id = ?", file.ID).Pluck("id", &existing)
+	present := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		present[id] = true
+	}
+
+	drawings := make([]models.Drawing, 0, len(imported))
+	duplicates := 0
+	for _, annotation := range imported {
+		if id, ok := annotation.DrawingID(); ok && present[id] {
+			duplicates++
+			continue
+		}
+		drawings = append(drawings, models.Drawing{
+			FileID:     file.ID,
+			Type:       annotation.Type,
+			PageNumber: annotation.Page,
+			Data:       annotation.Data,
+			BoundingBox: models.BoundingBox{
+				Left:   annotation.Left,
+				Top:    annotation.Top,
+				Right:  annotation.Right,
+				Bottom: annotation.Bottom,
+			},
+		})
+	}
+
+	if len(drawings) > 0 {
+		for i := range drawings {
+			setDrawingCreator(c, &drawings[i])
+		}
+		if err := database.DB.Create(&drawings).Error; err != nil {
+			fmt.Printf("ERROR creating imported drawings for file %d: %v\n", file.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save drawings",
+			})
+		}
+	}
+	for _, drawing := range drawings {
+		audit.Record(c, audit.DrawingCreate, audit.EntityDrawing, drawing.ID, nil, drawing)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"drawings":   drawings,
+		"skipped":    skipped,
+		"duplicates": duplicates,
+	})
+}
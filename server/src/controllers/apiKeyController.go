@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+const apiKeyPrefix = "pdfk_"
+
+type createApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// rejectApiKeyCaller stops API keys from managing API keys
+func rejectApiKeyCaller(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "API keys can't be managed with an API key",
+	})
+}
+
+// CreateApiKey - Create an API key for the current user. The key is only returned once.
+func CreateApiKey(c *fiber.Ctx) error {
+	fmt.Println("CreateApiKey")
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return rejectApiKeyCaller(c)
+	}
+
+	var request createApiKeyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse API key data",
+		})
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "API key name is required",
+		})
+	}
+
+	if len(request.Scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one scope is required",
+		})
+	}
+	for _, scope := range request.Scopes {
+		if !models.ValidApiKeyScope(scope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Unknown scope %q", scope),
+			})
+		}
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate API key",
+		})
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := models.ApiKey{
+		UserID:  claims.UserID(),
+		Name:    request.Name,
+		Prefix:  key[:len(apiKeyPrefix)+8],
+		KeyHash: token.Hash(key),
+		Scopes:  request.Scopes,
+	}
+	if result := database.DB.Create(&apiKey); result.Error != nil {
+		fmt.Printf("ERROR creating API key: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save API key",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":    key,
+		"apiKey": apiKey,
+	})
+}
+
+// GetApiKeys - List the current user's API keys
+func GetApiKeys(c *fiber.Ctx) error {
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return rejectApiKeyCaller(c)
+	}
+
+	var apiKeys []models.ApiKey
+	database.DB.Where("user_id = ?", claims.UserID()).Order("created_at DESC").Find(&apiKeys)
+	return c.JSON(apiKeys)
+}
+
+// RevokeApiKey - Revoke one of the current user's API keys
+func RevokeApiKey(c *fiber.Ctx) error {
+	fmt.Println("RevokeApiKey")
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return rejectApiKeyCaller(c)
+	}
+
+	var apiKey models.ApiKey
+	result := database.DB.Where("user_id = ?", claims.UserID()).First(&apiKey, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "API key not found",
+		})
+	}
+
+	if apiKey.RevokedAt == nil {
+		now := time.Now()
+		apiKey.RevokedAt = &now
+		database.DB.Model(&apiKey).Update("revoked_at", now)
+	}
+
+	return c.JSON(apiKey)
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+// maxArchiveFiles bounds how many files one archive may contain
+const maxArchiveFiles = 500
+
+type archiveRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// archiveName returns a name for the file that no earlier entry has taken,
+// numbering duplicates like "plan (2).pdf"
+func archiveName(filename string, taken map[string]bool) string {
+	name := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; taken[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
+
+// writeArchive streams the files' content into a ZIP. Errors can't be reported
+// once the response has started, so a failing file ends the archive early.
+func writeArchive(w *bufio.Writer, files []models.File) {
+	zw := zip.NewWriter(w)
+	taken := make(map[string]bool, len(files))
+	for _, file := range files {
+		// PDFs are compressed already, so entries are stored as they are
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     archiveName(file.Filename, taken),
+			Method:   zip.Store,
+			Modified: file.UpdatedAt,
+		})
+		if err != nil {
+			fmt.Printf("ERROR writing archive entry of file %d: %v\n", file.ID, err)
+			return
+		}
+
+		r, _, err := storage.Store.Get(storedFileKey(file))
+		if err != nil {
+			fmt.Printf("ERROR reading file %d for archive: %v\n", file.ID, err)
+			return
+		}
+		_, err = io.Copy(entry, r)
+		r.Close()
+		if err != nil {
+			fmt.Printf("ERROR writing file %d to archive: %v\n", file.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		fmt.Printf("ERROR finishing archive: %v\n", err)
+		return
+	}
+	w.Flush()
+}
+
+// DownloadArchive - Download several files as a ZIP archive, which is built
+// while it is sent
+func DownloadArchive(c *fiber.Ctx) error {
+	var request archiveRequest
+	if err := c.BodyParser(&request); err != nil || len(request.IDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ids must list the files to archive",
+		})
+	}
+	if len(request.IDs) > maxArchiveFiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("An archive can contain at most %d files", maxArchiveFiles),
+		})
+	}
+
+	// Every file is checked before anything is sent
+	files := make([]models.File, 0, len(request.IDs))
+	seen := make(map[uint]bool, len(request.IDs))
+	for _, id := range request.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		file, status, err := findAccessibleFile(c, id, models.PermissionRead)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d: %s", id, err.Error()),
+			})
+		}
+		if file.Quarantined() {
+			return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d: %s", id, errQuarantined.Error()),
+			})
+		}
+		files = append(files, file)
+	}
+
+	c.Attachment("files.zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writeArchive(w, files)
+	})
+	return nil
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAuditEntries - Query the audit log, newest first. Supports the filters
+// actorId, workspaceId, action, entityType, entityId, since and until (RFC 3339)
+// and paging with limit and offset.
+func GetAuditEntries(c *fiber.Ctx) error {
+	query := database.DB.Model(&models.AuditEntry{})
+
+	for param, column := range map[string]string{
+		"actorId":     "actor_id",
+		"workspaceId": "workspace_id",
+		"entityId":    "entity_id",
+	} {
+		if value := c.Query(param); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + param,
+				})
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if entityType := c.Query("entityType"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+
+	for param, condition := range map[string]string{
+		"since": "created_at >= ?",
+		"until": "created_at < ?",
+	} {
+		if value := c.Query(param); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + param + ", expected RFC 3339 time",
+				})
+			}
+			query = query.Where(condition, at)
+		}
+	}
+
+	limit := c.QueryInt("limit", defaultAuditLimit)
+	if limit <= 0 || limit > maxAuditLimit {
+		limit = defaultAuditLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var entries []models.AuditEntry
+	query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&entries)
+
+	return c.JSON(fiber.Map{
+		"total":   total,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const (
+	minPasswordLength = 8
+	maxUsernameLength = 64
+)
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type registerRequest struct {
+	loginRequest
+	// Email is optional and used for password resets
+	Email string `json:"email"`
+}
+
+// newAccountCredentials validates the username and password of a new local
+// account and returns the password hash
+func newAccountCredentials(username, password string) (string, int, error) {
+	if username == "" || len(username) > maxUsernameLength {
+		return "", fiber.StatusBadRequest, fmt.Errorf("Username must be between 1 and %d characters", maxUsernameLength)
+	}
+
+	if len(password) < minPasswordLength {
+		return "", fiber.StatusBadRequest, fmt.Errorf("Password must be at least %d characters", minPasswordLength)
+	}
+
+	var count int64
+	database.DB.Model(&models.User{}).Where("username = ?", username).Count(&count)
+	if count > 0 {
+		return "", fiber.StatusConflict, fmt.Errorf("Username is already taken")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fiber.StatusInternalServerError, fmt.Errorf("Failed to hash password")
+	}
+	return string(hash), 0, nil
+}
+
+// Register - Create a new account with the viewer role
+func Register(c *fiber.Ctx) error {
+	fmt.Println("Register")
+
+	var request registerRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse registration data",
+		})
+	}
+
+	request.Email = strings.TrimSpace(request.Email)
+	if request.Email != "" {
+		if _, err := mail.ParseAddress(request.Email); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid email address",
+			})
+		}
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	hash, status, err := newAccountCredentials(request.Username, request.Password)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	user := models.User{
+		Username:     request.Username,
+		PasswordHash: hash,
+		Role:         models.RoleViewer,
+		Email:        request.Email,
+		AuthSource:   auth.SourceLocal,
+	}
+	if result := database.DB.Create(&user); result.Error != nil {
+		fmt.Printf("ERROR creating user: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create user",
+		})
+	}
+	if err := auth.JoinDefaultWorkspace(user.ID); err != nil {
+		fmt.Printf("ERROR adding user to default workspace: %v\n", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// Login - Exchange username and password for an access token
+func Login(c *fiber.Ctx) error {
+	fmt.Println("Login")
+
+	var request loginRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse login data",
+		})
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	if request.Username == "" || request.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username and password are required",
+		})
+	}
+
+	user, err := auth.Authenticate(request.Username, request.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid username or password",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Authentication backend is unavailable",
+		})
+	}
+	if user.DisabledAt != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account is disabled",
+		})
+	}
+
+	if user.TOTPEnabled {
+		return startTwoFactorChallenge(c, user)
+	}
+	return completeLogin(c, user)
+}
+
+// completeLogin starts a session for an authenticated user and responds with its tokens
+func completeLogin(c *fiber.Ctx, user models.User) error {
+	tokens, err := startSession(c, user)
+	if err != nil {
+		fmt.Printf("ERROR starting session: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"token":            tokens.Token,
+		"expiresAt":        tokens.ExpiresAt,
+		"refreshToken":     tokens.RefreshToken,
+		"refreshExpiresAt": tokens.RefreshExpiresAt,
+		"user":             user,
+	})
+}
+
+// GetCurrentUser - Get the authenticated user
+func GetCurrentUser(c *fiber.Ctx) error {
+	claims := middleware.CurrentClaims(c)
+	if claims.IsGuest() {
+		return c.JSON(fiber.Map{
+			"guest":  true,
+			"role":   claims.Role,
+			"fileId": claims.GuestFileID,
+		})
+	}
+
+	var user models.User
+	if result := database.DB.First(&user, claims.UserID()); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(user)
+}
+
+// GetCurrentUserStorage - Report how much file data the caller owns and may own
+func GetCurrentUserStorage(c *fiber.Ctx) error {
+	var user models.User
+	if result := database.DB.First(&user, middleware.CurrentClaims(c).UserID()); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(userStorageOf(user))
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package controllers
+
+import "errors"
+
+// freeDiskSpace isn't implemented on Windows
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space is not available on windows")
+}
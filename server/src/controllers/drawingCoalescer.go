@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// maxCoalesceWindow caps how long an update may be held back before it is written
+const maxCoalesceWindow = 5 * time.Second
+
+// pendingDrawingUpdate holds the latest state of a drawing until its window closes
+type pendingDrawingUpdate struct {
+	drawing models.Drawing
+	// base is the stored version the pending state replaces
+	base int
+	// revision keeps the stored state once the pending one is written
+	revision models.DrawingRevision
+	timer    *time.Timer
+}
+
+// Pending updates live in process memory, so coalescing is off in prefork
+// children, where the next update or read may land on another worker.
+var (
+	pendingUpdatesMu sync.Mutex
+	pendingUpdates   = make(map[uint]*pendingDrawingUpdate)
+)
+
+// coalesceWindow resolves the window for an update request. The coalesceMs query
+// parameter overrides the DRAWING_UPDATE_COALESCE_MS env var; zero disables coalescing,
+// as does running in a prefork child.
+func coalesceWindow(c *fiber.Ctx) (time.Duration, error) {
+	value := c.Query("coalesceMs")
+	if value == "" {
+		value = os.Getenv("DRAWING_UPDATE_COALESCE_MS")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid coalesce window %q", value)
+	}
+
+	if fiber.IsChild() {
+		return 0, nil
+	}
+	window := time.Duration(ms) * time.Millisecond
+	if window > maxCoalesceWindow {
+		window = maxCoalesceWindow
+	}
+	return window, nil
+}
+
+// scheduleDrawingUpdate stores the drawing as the pending state replacing the
+// stored one. The first update opens the window; later ones within it replace
+// the pending state, and only the latest one is written when the window
+// closes, along with one revision of the stored state.
+func scheduleDrawingUpdate(c *fiber.Ctx, drawing, stored models.Drawing, window time.Duration) {
+	pendingUpdatesMu.Lock()
+	defer pendingUpdatesMu.Unlock()
+
+	if pending, ok := pendingUpdates[drawing.ID]; ok {
+		pending.drawing = drawing
+		return
+	}
+
+	id := drawing.ID
+	pendingUpdates[id] = &pendingDrawingUpdate{
+		drawing:  drawing,
+		base:     stored.Version,
+		revision: drawingRevision(c, stored, models.DrawingRevisionUpdate),
+		timer:    time.AfterFunc(window, func() { flushDrawingUpdate(id) }),
+	}
+}
+
+// flushDrawingUpdate writes the pending state of a drawing to the database,
+// unless another process changed the drawing meanwhile
+func flushDrawingUpdate(id uint) {
+	pendingUpdatesMu.Lock()
+	pending, ok := pendingUpdates[id]
+	delete(pendingUpdates, id)
+	pendingUpdatesMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	saved, err := saveDrawingVersion(&pending.drawing, pending.base)
+	if err != nil {
+		fmt.Printf("ERROR flushing coalesced update for drawing %d: %v\n", id, err)
+	} else if !saved {
+		fmt.Printf("ERROR dropped coalesced update for drawing %d, it was changed meanwhile\n", id)
+	} else if err := database.DB.Create(&pending.revision).Error; err != nil {
+		fmt.Printf("ERROR keeping the revision of drawing %d: %v\n", id, err)
+	}
+}
+
+// pendingDrawing returns the not yet written state of a drawing, if any
+func pendingDrawing(id uint) (models.Drawing, bool) {
+	pendingUpdatesMu.Lock()
+	defer pendingUpdatesMu.Unlock()
+
+	if pending, ok := pendingUpdates[id]; ok {
+		return pending.drawing, true
+	}
+	return models.Drawing{}, false
+}
+
+// flushPendingDrawingUpdates writes matching pending updates right away, so
+// they can't later overwrite changes made to the drawings in bulk
+func flushPendingDrawingUpdates(match func(models.Drawing) bool) {
+	pendingUpdatesMu.Lock()
+	var ids []uint
+	for id, pending := range pendingUpdates {
+		if match(pending.drawing) {
+			pending.timer.Stop()
+			ids = append(ids, id)
+		}
+	}
+	pendingUpdatesMu.Unlock()
+
+	for _, id := range ids {
+		flushDrawingUpdate(id)
+	}
+}
+
+// cancelPendingDrawingUpdates drops pending updates so they can't resurrect deleted drawings
+func cancelPendingDrawingUpdates(match func(models.Drawing) bool) {
+	pendingUpdatesMu.Lock()
+	defer pendingUpdatesMu.Unlock()
+
+	for id, pending := range pendingUpdates {
+		if match(pending.drawing) {
+			pending.timer.Stop()
+			delete(pendingUpdates, id)
+		}
+	}
+}
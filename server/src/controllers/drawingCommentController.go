@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// findDrawingComment loads a comment of the drawing the caller may read, and
+// the drawing
+func findDrawingComment(c *fiber.Ctx) (models.DrawingComment, models.Drawing, int, error) {
+	var comment models.DrawingComment
+	drawing, status, err := findAccessibleDrawing(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return comment, drawing, status, err
+	}
+	if err := database.DB.Where("drawing_id = ?", drawing.ID).First(&comment, c.Params("commentId")).Error; err != nil {
+		return comment, drawing, fiber.StatusNotFound, errCommentNotFound
+	}
+	return comment, drawing, 0, nil
+}
+
+// drawingCommentCounts counts the comments on each of the drawings
+func drawingCommentCounts(drawings []models.Drawing) map[uint]int64 {
+	counts := make(map[uint]int64)
+	if len(drawings) == 0 {
+		return counts
+	}
+	ids := make([]uint, len(drawings))
+	for i, drawing := range drawings {
+		ids[i] = drawing.ID
+	}
+	var rows []struct {
+		DrawingID uint
+		Count     int64
+	}
+	database.DB.Model(&models.DrawingComment{}).
+		Select("drawing_id, COUNT(*) AS count").
+		Where("drawing_id IN ?", ids).
+		Group("drawing_id").
+		Scan(&rows)
+	for _, row := range rows {
+		counts[row.DrawingID] = row.Count
+	}
+	return counts
+}
+
+// GetDrawingComments - List the comments on a drawing, oldest first. Threads
+// are rebuilt from parentId.
+func GetDrawingComments(c *fiber.Ctx) error {
+	drawing, status, err := findAccessibleDrawing(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	comments := []models.DrawingComment{}
+	database.DB.Where("drawing_id = ?", drawing.ID).Order("created_at, id").Find(&comments)
+	return c.JSON(comments)
+}
+
+// CreateDrawingComment - Comment on a drawing, or reply to a comment with
+// parentId. Anyone who can see the drawing may comment, even when it is locked.
+func CreateDrawingComment(c *fiber.Ctx) error {
+	fmt.Println("CreateDrawingComment")
+
+	drawing, status, err := findAccessibleDrawing(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request commentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse comment",
+		})
+	}
+	body, err := validCommentBody(request.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if request.ParentID != nil {
+		var parent models.DrawingComment
+		if err := database.DB.Where("drawing_id = ?", drawing.ID).First(&parent, *request.ParentID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Parent comment not found",
+			})
+		}
+	}
+
+	claims := middleware.CurrentClaims(c)
+	comment := models.DrawingComment{
+		DrawingID:  drawing.ID,
+		ParentID:   request.ParentID,
+		AuthorID:   claims.UserID(),
+		AuthorName: claims.Username,
+		Body:       body,
+	}
+	if result := database.DB.Create(&comment); result.Error != nil {
+		fmt.Printf("ERROR creating comment on drawing %d: %v\n", drawing.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create comment",
+		})
+	}
+	audit.Record(c, audit.CommentCreate, audit.EntityDrawingComment, comment.ID, nil, comment)
+
+	return c.Status(fiber.StatusCreated).JSON(comment)
+}
+
+// UpdateDrawingComment - Edit the text of one of the caller's comments on a
+// drawing
+func UpdateDrawingComment(c *fiber.Ctx) error {
+	fmt.Println("UpdateDrawingComment")
+
+	comment, _, status, err := findDrawingComment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if comment.AuthorID != middleware.CurrentClaims(c).UserID() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit a comment",
+		})
+	}
+
+	var request commentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse comment",
+		})
+	}
+	body, err := validCommentBody(request.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before := comment
+	comment.Body = body
+	if result := database.DB.Save(&comment); result.Error != nil {
+		fmt.Printf("ERROR updating comment %d: %v\n", comment.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update comment",
+		})
+	}
+	audit.Record(c, audit.CommentUpdate, audit.EntityDrawingComment, comment.ID, before, comment)
+
+	return c.JSON(comment)
+}
+
+// DeleteDrawingComment - Delete a comment on a drawing with the replies to it.
+// Authors may delete their own comments, file owners and admins any comment
+// on the file's drawings.
+func DeleteDrawingComment(c *fiber.Ctx) error {
+	fmt.Println("DeleteDrawingComment")
+
+	comment, drawing, status, err := findDrawingComment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	claims := middleware.CurrentClaims(c)
+	if comment.AuthorID != claims.UserID() {
+		var file models.File
+		if database.DB.First(&file, drawing.FileID).Error != nil || !isFileOwner(claims, file) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the author or the file owner can delete a comment",
+			})
+		}
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Collect the thread below the comment a level at a time
+		ids := []uint{comment.ID}
+		for level := ids; len(level) > 0; {
+			var replies []uint
+			if err := tx.Model(&models.DrawingComment{}).Where("parent_id IN ?", level).Pluck("id", &replies).Error; err != nil {
+				return err
+			}
+			ids = append(ids, replies...)
+			level = replies
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.DrawingComment{}).Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR deleting comment %d: %v\n", comment.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete comment",
+		})
+	}
+	audit.Record(c, audit.CommentDelete, audit.EntityDrawingComment, comment.ID, comment, nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Comment deleted successfully",
+	})
+}
@@ -2,15 +2,33 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 
+	"pdfsrv/src/audit"
 	"pdfsrv/src/database"
 	"pdfsrv/src/models"
 )
 
+// maxDrawingLimit bounds the drawings listed at once when paging
+const maxDrawingLimit = 1000
+
+// listedDrawing is a drawing as listed, with the number of comments on it
+type listedDrawing struct {
+	models.Drawing
+	CommentCount int64 `json:"commentCount"`
+}
+
+var (
+	errDrawingVersionRequired = errors.New("version is required, the version of the drawing the update replaces")
+	errDrawingVersionConflict = errors.New("The drawing was changed by someone else")
+)
+
 // CreateDrawing - Create a new drawing
 func CreateDrawing(c *fiber.Ctx) error {
 	fmt.Println("CreateDrawing")
@@ -55,7 +73,19 @@ func CreateDrawing(c *fiber.Ctx) error {
 		})
 	}
 
+	if _, status, err := findEditableFile(c, drawing.FileID); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := checkDrawingPlacement(drawing); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Create drawing in database
+	setDrawingCreator(c, &drawing)
 	result := database.DB.Create(&drawing)
 	if result.Error != nil {
 		fmt.Printf("ERROR creating drawing in database: %v\n", result.Error)
@@ -63,34 +93,103 @@ func CreateDrawing(c *fiber.Ctx) error {
 			"error": fmt.Sprintf("Failed to save drawing: %v", result.Error),
 		})
 	}
+	audit.Record(c, audit.DrawingCreate, audit.EntityDrawing, drawing.ID, nil, drawing)
 
 	return c.Status(fiber.StatusCreated).JSON(drawing)
 }
 
-// GetDrawings - Get all drawings for a file
-func GetDrawings(c *fiber.Ctx) error {
-	fmt.Println("GetDrawings")
-
-	// Get file ID from query parameters
+// listedDrawingsQuery selects the drawings of the file named by the fileId
+// query parameter that the filters of GetDrawings keep: includeDeleted,
+// author, layerId and pageNumber
+func listedDrawingsQuery(c *fiber.Ctx) (*gorm.DB, int, error) {
 	fileIDStr := c.Query("fileId")
 	if fileIDStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "File ID is required",
-		})
+		return nil, fiber.StatusBadRequest, errors.New("File ID is required")
 	}
-
 	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid file ID",
+		return nil, fiber.StatusBadRequest, errors.New("Invalid file ID")
+	}
+
+	// Deleted drawings are listed to those who may restore them
+	includeDeleted := c.QueryBool("includeDeleted")
+	permission := models.PermissionRead
+	if includeDeleted {
+		permission = models.PermissionWrite
+	}
+	if _, status, err := findAccessibleFile(c, fileID, permission); err != nil {
+		return nil, status, err
+	}
+
+	query := database.DB.Model(&models.Drawing{}).Where("file_id = ?", fileID)
+	if includeDeleted {
+		query = query.Unscoped()
+	}
+	if author := strings.TrimSpace(c.Query("author")); author != "" {
+		query = query.Where("created_by_id IN (?)",
+			database.DB.Model(&models.User{}).Select("id").Where("username = ?", author))
+	}
+	query, err = filterDrawingLayer(c, query)
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	if c.Query("pageNumber") != "" {
+		page := c.QueryInt("pageNumber")
+		if page <= 0 {
+			return nil, fiber.StatusBadRequest, errors.New("Invalid page number")
+		}
+		query = query.Where("page_number = ?", page)
+	}
+	return query, 0, nil
+}
+
+// GetDrawings - Get the drawings of a file, oldest first. pageNumber keeps
+// those of one page; limit and offset page through them, with the total in the
+// X-Total-Count header. With light=true the heavy image and data fields are
+// left out, to be fetched per drawing. includeDeleted=true lists deleted
+// drawings too, with their deletedAt, so they can be restored. author keeps
+// the drawings a user, named by username, drew, and layerId those on a layer,
+// or on none with layerId=0. Each drawing has its commentCount.
+func GetDrawings(c *fiber.Ctx) error {
+	fmt.Println("GetDrawings")
+
+	query, status, err := listedDrawingsQuery(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	// Get all drawings for the file
-	var drawings []models.Drawing
-	database.DB.Where("file_id = ?", fileID).Find(&drawings)
+	var total int64
+	query.Count(&total)
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	// Without a limit every drawing is returned, as before paging
+	if c.Query("limit") != "" {
+		limit := c.QueryInt("limit")
+		if limit <= 0 || limit > maxDrawingLimit {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("limit must be between 1 and %d", maxDrawingLimit),
+			})
+		}
+		query = query.Limit(limit)
+	}
+	if offset := c.QueryInt("offset"); offset > 0 {
+		query = query.Offset(offset)
+	}
+	if c.QueryBool("light") {
+		query = query.Omit("image", "data")
+	}
 
-	return c.JSON(drawings)
+	drawings := []models.Drawing{}
+	query.Order("id").Find(&drawings)
+
+	counts := drawingCommentCounts(drawings)
+	listed := make([]listedDrawing, len(drawings))
+	for i, drawing := range drawings {
+		listed[i] = listedDrawing{Drawing: drawing, CommentCount: counts[drawing.ID]}
+	}
+	return c.JSON(listed)
 }
 
 // GetDrawing - Get a single drawing by ID
@@ -98,15 +197,18 @@ func GetDrawing(c *fiber.Ctx) error {
 	fmt.Println("GetDrawing")
 	id := c.Params("id")
 
-	var drawing models.Drawing
-	result := database.DB.First(&drawing, id)
-
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Drawing not found",
+	drawing, status, err := findAccessibleDrawing(c, id, models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
+	// Prefer a coalesced update that hasn't been written yet
+	if pending, ok := pendingDrawing(drawing.ID); ok {
+		return c.JSON(pending)
+	}
+
 	return c.JSON(drawing)
 }
 
@@ -116,12 +218,10 @@ func UpdateDrawing(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	// Check if drawing exists
-	var drawing models.Drawing
-	result := database.DB.First(&drawing, id)
-
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Drawing not found",
+	drawing, status, err := findAccessibleDrawing(c, id, models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
@@ -144,32 +244,342 @@ func UpdateDrawing(c *fiber.Ctx) error {
 		}
 	}
 
-	// Ensure ID is preserved
-	updatedDrawing.ID = drawing.ID
+	// Moving a drawing to another file requires write access there as well
+	if updatedDrawing.FileID != drawing.FileID {
+		if _, status, err := findEditableFile(c, updatedDrawing.FileID); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err := checkDrawingPlacement(updatedDrawing); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The update must replace the latest version, including a coalesced
+	// update that hasn't been written yet
+	current := latestDrawing(drawing)
+	if updatedDrawing.Version == 0 {
+		return c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+			"error": errDrawingVersionRequired.Error(),
+		})
+	}
+	if updatedDrawing.Version != current.Version {
+		return sendDrawingConflict(c, current)
+	}
+
+	// Ensure ID is preserved, along with the client's ID for it
+	updatedDrawing.GormModel = current.GormModel
+	updatedDrawing.ClientID = drawing.ClientID
+	updatedDrawing.Version = current.Version + 1
+	keepDrawingCreator(&updatedDrawing, current)
+	keepDrawingLock(&updatedDrawing, current)
+	keepDrawingOrphaned(&updatedDrawing, current)
+	setDrawingEditor(c, &updatedDrawing)
+
+	// Rapid updates may be coalesced so only the latest state within the window is written
+	window, err := coalesceWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if window > 0 {
+		scheduleDrawingUpdate(c, updatedDrawing, drawing, window)
+		audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, current, updatedDrawing)
+		return c.Status(fiber.StatusAccepted).JSON(updatedDrawing)
+	}
+
+	// Update the drawing; a pending update is superseded by it
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
+	recordDrawingRevisions(c, models.DrawingRevisionUpdate, drawing)
+	saved, err := saveDrawingVersion(&updatedDrawing, drawing.Version)
+	if err != nil {
+		fmt.Printf("ERROR updating drawing %d: %v\n", drawing.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawing",
+		})
+	}
+	if !saved {
+		return sendStoredDrawingConflict(c, drawing.ID)
+	}
+	audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, current, updatedDrawing)
+
+	return c.JSON(updatedDrawing)
+}
+
+// latestDrawing returns the drawing with a coalesced update that hasn't been
+// written yet
+func latestDrawing(drawing models.Drawing) models.Drawing {
+	if pending, ok := pendingDrawing(drawing.ID); ok {
+		return pending
+	}
+	return drawing
+}
+
+// saveDrawingVersion writes the drawing if the stored one still has the
+// version it replaces, and reports whether it did
+func saveDrawingVersion(drawing *models.Drawing, replaced int) (bool, error) {
+	return saveDrawingVersionTx(database.DB, drawing, replaced)
+}
+
+// saveDrawingVersionTx is saveDrawingVersion inside tx
+func saveDrawingVersionTx(tx *gorm.DB, drawing *models.Drawing, replaced int) (bool, error) {
+	result := tx.Model(drawing).
+		Where("version = ?", replaced).
+		Select("*").Omit("id", "created_at", "deleted_at").
+		Updates(drawing)
+	return result.RowsAffected == 1, result.Error
+}
+
+// sendDrawingConflict responds that the drawing changed since the version an
+// update named, with the drawing as it is now
+func sendDrawingConflict(c *fiber.Ctx, drawing models.Drawing) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":   errDrawingVersionConflict.Error(),
+		"drawing": drawing,
+	})
+}
+
+// sendStoredDrawingConflict responds to an update that lost a race with
+// another one, with the drawing as that left it
+func sendStoredDrawingConflict(c *fiber.Ctx, id uint) error {
+	var drawing models.Drawing
+	if err := database.DB.First(&drawing, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": errDrawingNotFound.Error(),
+		})
+	}
+	return sendDrawingConflict(c, latestDrawing(drawing))
+}
+
+// validateDrawing checks the fields every drawing needs, and that its layer and
+// group are its file's
+func validateDrawing(drawing models.Drawing) error {
+	if drawing.FileID == 0 {
+		return errors.New("File ID is required")
+	}
+	if drawing.PageNumber <= 0 {
+		return errors.New("Valid page number is required")
+	}
+	if drawing.Type == "" {
+		return errors.New("Drawing type is required")
+	}
+	if drawing.Data != "" {
+		var jsonData interface{}
+		if err := json.Unmarshal([]byte(drawing.Data), &jsonData); err != nil {
+			return errors.New("Invalid JSON in data field")
+		}
+	}
+	return checkDrawingPlacement(drawing)
+}
+
+// checkDrawingPlacement checks the layer and group of a drawing are its file's
+func checkDrawingPlacement(drawing models.Drawing) error {
+	if err := checkDrawingLayer(drawing); err != nil {
+		return err
+	}
+	return checkDrawingGroup(drawing)
+}
+
+// keepDrawingOrphaned carries the orphaned flag of a drawing over to a new
+// state of it, unless that places it on another page its file has
+func keepDrawingOrphaned(updated *models.Drawing, drawing models.Drawing) {
+	updated.Orphaned = drawing.Orphaned
+	if !drawing.Orphaned || (updated.FileID == drawing.FileID && updated.PageNumber == drawing.PageNumber) {
+		return
+	}
+	// Files whose page count is unknown take the page as given
+	var file models.File
+	if database.DB.Select("page_count").First(&file, updated.FileID).Error == nil &&
+		(file.PageCount == 0 || updated.PageNumber <= file.PageCount) {
+		updated.Orphaned = false
+	}
+}
+
+// patchableDrawingFields are the fields of a drawing a patch may set
+var patchableDrawingFields = map[string]bool{
+	"fileId":      true,
+	"type":        true,
+	"pageNumber":  true,
+	"image":       true,
+	"boundingBox": true,
+	"data":        true,
+	"layerId":     true,
+	"groupId":     true,
+}
+
+// mergePatch applies a JSON merge patch (RFC 7396) to target: objects are
+// merged member by member and null removes a member
+func mergePatch(target, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		patchObject, isObject := value.(map[string]interface{})
+		targetObject, hasObject := target[key].(map[string]interface{})
+		if isObject && hasObject {
+			mergePatch(targetObject, patchObject)
+			continue
+		}
+		target[key] = value
+	}
+}
+
+// PatchDrawing - Update the fields of a drawing the body names and keep the
+// others, as a JSON merge patch. Moving the drawing to another page places an
+// orphaned drawing again.
+func PatchDrawing(c *fiber.Ctx) error {
+	fmt.Println("PatchDrawing")
+	id := c.Params("id")
+
+	drawing, status, err := findAccessibleDrawing(c, id, models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var patch map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &patch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse drawing patch",
+		})
+	}
+	// The version names the one the patch applies to, it isn't patched
+	expected, _ := patch["version"].(float64)
+	delete(patch, "version")
+	if expected == 0 {
+		return c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+			"error": errDrawingVersionRequired.Error(),
+		})
+	}
+	for key := range patch {
+		if !patchableDrawingFields[key] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Field %q can't be patched", key),
+			})
+		}
+	}
+
+	// The patch applies to the latest state, including a coalesced update
+	// that hasn't been written yet
+	current := latestDrawing(drawing)
+	if int(expected) != current.Version {
+		return sendDrawingConflict(c, current)
+	}
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawing",
+		})
+	}
+	var merged map[string]interface{}
+	json.Unmarshal(encoded, &merged)
+	mergePatch(merged, patch)
+	if encoded, err = json.Marshal(merged); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawing",
+		})
+	}
+	updatedDrawing := current
+	if err := json.Unmarshal(encoded, &updatedDrawing); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	updatedDrawing.GormModel = current.GormModel
+	updatedDrawing.Version = current.Version + 1
+	setDrawingEditor(c, &updatedDrawing)
+	keepDrawingOrphaned(&updatedDrawing, current)
+
+	// The result must be as valid as a new drawing
+	if err := validateDrawing(updatedDrawing); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Moving a drawing to another file requires write access there as well
+	if updatedDrawing.FileID != drawing.FileID {
+		if _, status, err := findEditableFile(c, updatedDrawing.FileID); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	window, err := coalesceWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if window > 0 {
+		scheduleDrawingUpdate(c, updatedDrawing, drawing, window)
+		audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, current, updatedDrawing)
+		return c.Status(fiber.StatusAccepted).JSON(updatedDrawing)
+	}
 
-	// Update the drawing
-	database.DB.Save(&updatedDrawing)
+	// A pending update is superseded by the patch, which includes it
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
+	recordDrawingRevisions(c, models.DrawingRevisionUpdate, drawing)
+	saved, err := saveDrawingVersion(&updatedDrawing, drawing.Version)
+	if err != nil {
+		fmt.Printf("ERROR patching drawing %d: %v\n", drawing.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawing",
+		})
+	}
+	if !saved {
+		return sendStoredDrawingConflict(c, drawing.ID)
+	}
+	audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, current, updatedDrawing)
 
 	return c.JSON(updatedDrawing)
 }
 
-// DeleteDrawing - Delete a drawing
+// DeleteDrawing - Delete a drawing, with the others of its group if it is in
+// one; with ?version= only if no one changed it since that version
 func DeleteDrawing(c *fiber.Ctx) error {
 	fmt.Println("DeleteDrawing")
 	id := c.Params("id")
 
 	// Check if drawing exists
-	var drawing models.Drawing
-	result := database.DB.First(&drawing, id)
+	drawing, status, err := findAccessibleDrawing(c, id, models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Drawing not found",
+	// ?version= deletes the drawing only if it is still that version
+	deleted := latestDrawing(drawing)
+	if version := c.QueryInt("version"); version != 0 && version != deleted.Version {
+		return sendDrawingConflict(c, deleted)
+	}
+
+	// Grouped drawings are deleted as a unit
+	if drawing.GroupID != nil {
+		group := models.DrawingGroup{GormModel: models.GormModel{ID: *drawing.GroupID}, FileID: drawing.FileID}
+		if _, status, err := deleteGroupDrawings(c, group); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(fiber.Map{
+			"message": "Drawing deleted successfully",
 		})
 	}
 
-	// Delete the drawing
+	// Delete the drawing, keeping its latest state in its history
+	recordDrawingRevisions(c, models.DrawingRevisionDelete, deleted)
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
 	database.DB.Delete(&drawing)
+	audit.Record(c, audit.DrawingDelete, audit.EntityDrawing, drawing.ID, drawing, nil)
 
 	return c.JSON(fiber.Map{
 		"message": "Drawing deleted successfully",
@@ -195,8 +605,29 @@ func DeleteDrawingsByFile(c *fiber.Ctx) error {
 		})
 	}
 
-	// Delete all drawings for this file
+	if _, status, err := findEditableFile(c, fileID); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Delete all drawings for this file, keeping their latest states in their
+	// history
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return uint64(d.FileID) == fileID })
+	var deleted []models.Drawing
+	database.DB.Where("file_id = ?", fileID).Find(&deleted)
+	// Drawings locked by someone else keep the others too
+	if status, err := checkDrawingLocks(c, deleted); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	recordDrawingRevisions(c, models.DrawingRevisionDelete, deleted...)
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return uint64(d.FileID) == fileID })
 	database.DB.Where("file_id = ?", fileID).Delete(&models.Drawing{})
+	for _, drawing := range deleted {
+		audit.Record(c, audit.DrawingDelete, audit.EntityDrawing, drawing.ID, drawing, nil)
+	}
 
 	return c.JSON(fiber.Map{
 		"message": "All drawings for file deleted successfully",
@@ -262,7 +693,41 @@ func BulkCreateDrawings(c *fiber.Ctx) error {
 		}
 	}
 
+	// Check write access once per referenced file
+	checked := make(map[uint]bool)
+	for _, drawing := range drawings {
+		if checked[drawing.FileID] {
+			continue
+		}
+		if _, status, err := findEditableFile(c, drawing.FileID); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		checked[drawing.FileID] = true
+	}
+
+	// Layers and groups must be the file's, each looked up once
+	layerFiles := referencedFiles(&models.Layer{}, drawings, func(d models.Drawing) *uint { return d.LayerID })
+	groupFiles := referencedFiles(&models.DrawingGroup{}, drawings, func(d models.Drawing) *uint { return d.GroupID })
+	for i, drawing := range drawings {
+		var err error
+		if drawing.LayerID != nil && layerFiles[*drawing.LayerID] != drawing.FileID {
+			err = errLayerNotOnFile
+		} else if drawing.GroupID != nil && groupFiles[*drawing.GroupID] != drawing.FileID {
+			err = errDrawingGroupNotOnFile
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Drawing at index %d: %v", i, err),
+			})
+		}
+	}
+
 	// Create all drawings
+	for i := range drawings {
+		setDrawingCreator(c, &drawings[i])
+	}
 	result := database.DB.Create(&drawings)
 	if result.Error != nil {
 		fmt.Printf("ERROR creating bulk drawings in database: %v\n", result.Error)
@@ -270,6 +735,74 @@ func BulkCreateDrawings(c *fiber.Ctx) error {
 			"error": fmt.Sprintf("Failed to save drawings: %v", result.Error),
 		})
 	}
+	for _, drawing := range drawings {
+		audit.Record(c, audit.DrawingCreate, audit.EntityDrawing, drawing.ID, nil, drawing)
+	}
 
 	return c.Status(fiber.StatusCreated).JSON(drawings)
 }
+
+// referencedFiles maps the layers or groups the drawings reference to the
+// files they belong to
+func referencedFiles(model interface{}, drawings []models.Drawing, reference func(models.Drawing) *uint) map[uint]uint {
+	files := make(map[uint]uint)
+	var ids []uint
+	for _, drawing := range drawings {
+		if id := reference(drawing); id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	if len(ids) == 0 {
+		return files
+	}
+	var rows []struct {
+		ID     uint
+		FileID uint
+	}
+	database.DB.Model(model).Select("id", "file_id").Where("id IN ?", ids).Scan(&rows)
+	for _, row := range rows {
+		files[row.ID] = row.FileID
+	}
+	return files
+}
+
+// GetDrawingsBounds - Get the union bounding box of the drawings of a file,
+// filtered as GetDrawings filters them; without pageNumber it spans the whole
+// file
+func GetDrawingsBounds(c *fiber.Ctx) error {
+	fmt.Println("GetDrawingsBounds")
+
+	query, status, err := listedDrawingsQuery(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Aggregates are NULL when no drawings match
+	var bounds struct {
+		Top    *float64
+		Left   *float64
+		Right  *float64
+		Bottom *float64
+	}
+	result := query.Select(`MIN("top") AS top, MIN("left") AS "left", MAX("right") AS "right", MAX("bottom") AS bottom`).
+		Scan(&bounds)
+	if result.Error != nil {
+		fmt.Printf("ERROR calculating drawing bounds: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate drawing bounds",
+		})
+	}
+
+	if bounds.Top == nil {
+		return c.JSON(nil)
+	}
+
+	return c.JSON(models.BoundingBox{
+		Top:    *bounds.Top,
+		Left:   *bounds.Left,
+		Right:  *bounds.Right,
+		Bottom: *bounds.Bottom,
+	})
+}
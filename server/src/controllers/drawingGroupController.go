@@ -0,0 +1,516 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const maxDrawingGroupNameLength = 100
+
+var (
+	errDrawingGroupNotFound    = errors.New("Drawing group not found")
+	errDrawingGroupNotOnFile   = errors.New("The group isn't one of the file's drawing groups")
+	errDrawingGroupNameInvalid = fmt.Errorf("Group name must be 1 to %d characters", maxDrawingGroupNameLength)
+	errDrawingGroupMembers     = errors.New("drawingIds must name at least one drawing of the file")
+	errDrawingGroupIncomplete  = errors.New("Every drawing of the group must be updated, and only those")
+	errDeleteGroupDrawings     = errors.New("Only admins can delete the drawings of a group")
+)
+
+// drawingGroupRequest creates a group or changes what it names
+type drawingGroupRequest struct {
+	Name *string `json:"name"`
+	// DrawingIDs are the drawings in the group, replacing those it had
+	DrawingIDs []uint `json:"drawingIds"`
+}
+
+// drawingGroupResponse is a group with the drawings in it
+type drawingGroupResponse struct {
+	models.DrawingGroup
+	DrawingIDs []uint `json:"drawingIds"`
+}
+
+// checkDrawingGroup checks a drawing in a group is in one of its file's
+func checkDrawingGroup(drawing models.Drawing) error {
+	if drawing.GroupID == nil {
+		return nil
+	}
+	var count int64
+	database.DB.Model(&models.DrawingGroup{}).Where("id = ? AND file_id = ?", *drawing.GroupID, drawing.FileID).Count(&count)
+	if count == 0 {
+		return errDrawingGroupNotOnFile
+	}
+	return nil
+}
+
+// findEditableDrawingGroup loads a drawing group of a file the caller may edit
+func findEditableDrawingGroup(c *fiber.Ctx) (models.DrawingGroup, int, error) {
+	var group models.DrawingGroup
+	file, status, err := findEditableFile(c, c.Params("id"))
+	if err != nil {
+		return group, status, err
+	}
+	if err := database.DB.Where("file_id = ?", file.ID).First(&group, c.Params("groupId")).Error; err != nil {
+		return group, fiber.StatusNotFound, errDrawingGroupNotFound
+	}
+	return group, 0, nil
+}
+
+// groupDrawings loads the drawings of a group, writing the coalesced updates of
+// the file's drawings first so none is left to undo a change to the group
+func groupDrawings(group models.DrawingGroup) []models.Drawing {
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.FileID == group.FileID })
+	drawings := []models.Drawing{}
+	database.DB.Where("group_id = ?", group.ID).Order("id").Find(&drawings)
+	return drawings
+}
+
+// validGroupName trims a group name and checks its length
+func validGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxDrawingGroupNameLength {
+		return "", errDrawingGroupNameInvalid
+	}
+	return name, nil
+}
+
+// checkGroupMembers checks the drawings exist on the file
+func checkGroupMembers(fileID uint, ids []uint) error {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	var count int64
+	database.DB.Model(&models.Drawing{}).Where("id IN ? AND file_id = ?", ids, fileID).Count(&count)
+	if len(ids) == 0 || int(count) != len(unique) {
+		return errDrawingGroupMembers
+	}
+	return nil
+}
+
+// groupColumns set the group of drawings, each changed by the caller as its
+// next version
+func groupColumns(c *fiber.Ctx, groupID *uint) map[string]interface{} {
+	var editor models.Drawing
+	setDrawingEditor(c, &editor)
+	return map[string]interface{}{
+		"group_id":        groupID,
+		"version":         gorm.Expr("version + 1"),
+		"updated_by_id":   editor.UpdatedByID,
+		"updated_by_name": editor.UpdatedByName,
+	}
+}
+
+// checkGroupChangeLocks checks none of the drawings joining or leaving the
+// group is locked against the caller
+func checkGroupChangeLocks(c *fiber.Ctx, groupID uint, ids []uint) (int, error) {
+	var changed []models.Drawing
+	database.DB.Where("group_id = ? AND id NOT IN ?", groupID, ids).
+		Or("id IN ? AND (group_id IS NULL OR group_id <> ?)", ids, groupID).
+		Find(&changed)
+	return checkDrawingLocks(c, changed)
+}
+
+// setGroupDrawings makes the drawings the ones in the group inside tx, taking
+// them from any group they were in
+func setGroupDrawings(c *fiber.Ctx, tx *gorm.DB, group models.DrawingGroup, ids []uint) error {
+	if err := tx.Model(&models.Drawing{}).
+		Where("group_id = ? AND id NOT IN ?", group.ID, ids).
+		UpdateColumns(groupColumns(c, nil)).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Drawing{}).
+		Where("id IN ? AND (group_id IS NULL OR group_id <> ?)", ids, group.ID).
+		UpdateColumns(groupColumns(c, &group.ID)).Error
+}
+
+// deleteGroupDrawings deletes the drawings of a group at once, keeping their
+// latest states in their history, and returns them. A drawing locked by
+// someone else keeps the whole group.
+func deleteGroupDrawings(c *fiber.Ctx, group models.DrawingGroup) ([]models.Drawing, int, error) {
+	drawings := groupDrawings(group)
+	if status, err := checkDrawingLocks(c, drawings); err != nil {
+		return nil, status, err
+	}
+	if len(drawings) == 0 {
+		return drawings, 0, nil
+	}
+	recordDrawingRevisions(c, models.DrawingRevisionDelete, drawings...)
+	if err := database.DB.Where("group_id = ?", group.ID).Delete(&models.Drawing{}).Error; err != nil {
+		fmt.Printf("ERROR deleting drawings of group %d: %v\n", group.ID, err)
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to delete drawings")
+	}
+	for _, drawing := range drawings {
+		audit.Record(c, audit.DrawingDelete, audit.EntityDrawing, drawing.ID, drawing, nil)
+	}
+	return drawings, 0, nil
+}
+
+// groupFollowers returns the other drawings of the group a drawing moved to
+// another page is in, which follow it there. A drawing locked by someone else
+// keeps the whole group in place.
+func groupFollowers(c *fiber.Ctx, drawing, moved models.Drawing) ([]models.Drawing, error) {
+	if drawing.GroupID == nil || moved.GroupID == nil || *moved.GroupID != *drawing.GroupID ||
+		moved.PageNumber == drawing.PageNumber {
+		return nil, nil
+	}
+	group := models.DrawingGroup{GormModel: models.GormModel{ID: *drawing.GroupID}, FileID: drawing.FileID}
+	var followers []models.Drawing
+	for _, member := range groupDrawings(group) {
+		if member.ID != drawing.ID && member.PageNumber != moved.PageNumber {
+			followers = append(followers, member)
+		}
+	}
+	if _, err := checkDrawingLocks(c, followers); err != nil {
+		return nil, err
+	}
+	return followers, nil
+}
+
+// moveGroupFollowers moves drawings to the page the drawing of their group
+// was moved to, each as its next version
+func moveGroupFollowers(c *fiber.Ctx, followers []models.Drawing, page int) error {
+	if len(followers) == 0 {
+		return nil
+	}
+	recordDrawingRevisions(c, models.DrawingRevisionUpdate, followers...)
+	ids := make([]uint, len(followers))
+	for i, drawing := range followers {
+		ids[i] = drawing.ID
+	}
+	columns := groupColumns(c, nil)
+	delete(columns, "group_id")
+	columns["page_number"] = page
+	columns["orphaned"] = false
+	if err := database.DB.Model(&models.Drawing{}).Where("id IN ?", ids).UpdateColumns(columns).Error; err != nil {
+		return err
+	}
+	var moved []models.Drawing
+	database.DB.Where("id IN ?", ids).Order("id").Find(&moved)
+	for i, drawing := range moved {
+		audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, followers[i], drawing)
+	}
+	return nil
+}
+
+// GetDrawingGroups - List the drawing groups of a file with the drawings in
+// each
+func GetDrawingGroups(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var groups []models.DrawingGroup
+	database.DB.Where("file_id = ?", file.ID).Order("id").Find(&groups)
+	var members []models.Drawing
+	database.DB.Select("id", "group_id").Where("file_id = ? AND group_id IS NOT NULL", file.ID).Order("id").Find(&members)
+	drawingIDs := make(map[uint][]uint)
+	for _, drawing := range members {
+		drawingIDs[*drawing.GroupID] = append(drawingIDs[*drawing.GroupID], drawing.ID)
+	}
+
+	response := make([]drawingGroupResponse, len(groups))
+	for i, group := range groups {
+		response[i] = drawingGroupResponse{DrawingGroup: group, DrawingIDs: drawingIDs[group.ID]}
+		if response[i].DrawingIDs == nil {
+			response[i].DrawingIDs = []uint{}
+		}
+	}
+	return c.JSON(response)
+}
+
+// CreateDrawingGroup - Group drawings of a file under a name. Drawings already
+// in a group leave it for the new one.
+func CreateDrawingGroup(c *fiber.Ctx) error {
+	fmt.Println("CreateDrawingGroup")
+
+	file, status, err := findEditableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request drawingGroupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse drawing group",
+		})
+	}
+	if request.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errDrawingGroupNameInvalid.Error(),
+		})
+	}
+	name, err := validGroupName(*request.Name)
+	if err == nil {
+		err = checkGroupMembers(file.ID, request.DrawingIDs)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if status, err := checkGroupChangeLocks(c, 0, request.DrawingIDs); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	group := models.DrawingGroup{FileID: file.ID, Name: name}
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.FileID == file.ID })
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return setGroupDrawings(c, tx, group, request.DrawingIDs)
+	})
+	if err != nil {
+		fmt.Printf("ERROR creating drawing group of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create drawing group",
+		})
+	}
+	response := drawingGroupResponse{DrawingGroup: group, DrawingIDs: request.DrawingIDs}
+	audit.Record(c, audit.DrawingGroupCreate, audit.EntityDrawingGroup, group.ID, nil, response)
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// UpdateDrawingGroup - Rename a group or change the drawings in it; fields
+// left out keep their values
+func UpdateDrawingGroup(c *fiber.Ctx) error {
+	fmt.Println("UpdateDrawingGroup")
+
+	group, status, err := findEditableDrawingGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request drawingGroupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse drawing group",
+		})
+	}
+	members := groupDrawings(group)
+	before := drawingGroupResponse{DrawingGroup: group, DrawingIDs: make([]uint, len(members))}
+	for i, drawing := range members {
+		before.DrawingIDs[i] = drawing.ID
+	}
+	if request.Name != nil {
+		if group.Name, err = validGroupName(*request.Name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	drawingIDs := before.DrawingIDs
+	if request.DrawingIDs != nil {
+		if err := checkGroupMembers(group.FileID, request.DrawingIDs); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if status, err := checkGroupChangeLocks(c, group.ID, request.DrawingIDs); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		drawingIDs = request.DrawingIDs
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if request.DrawingIDs == nil {
+			return nil
+		}
+		return setGroupDrawings(c, tx, group, drawingIDs)
+	})
+	if err != nil {
+		fmt.Printf("ERROR updating drawing group %d: %v\n", group.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawing group",
+		})
+	}
+	response := drawingGroupResponse{DrawingGroup: group, DrawingIDs: drawingIDs}
+	audit.Record(c, audit.DrawingGroupUpdate, audit.EntityDrawingGroup, group.ID, before, response)
+
+	return c.JSON(response)
+}
+
+// UpdateGroupDrawings - Move a group by replacing the states of all of its
+// drawings at once. Each state names the version of the drawing it replaces;
+// if any drawing changed since, none is updated.
+func UpdateGroupDrawings(c *fiber.Ctx) error {
+	fmt.Println("UpdateGroupDrawings")
+
+	group, status, err := findEditableDrawingGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var states []models.Drawing
+	if err := c.BodyParser(&states); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to parse drawings data: %v", err),
+		})
+	}
+	members := groupDrawings(group)
+	if status, err := checkDrawingLocks(c, members); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	current := make(map[uint]models.Drawing, len(members))
+	for _, drawing := range members {
+		current[drawing.ID] = drawing
+	}
+	if len(states) != len(members) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errDrawingGroupIncomplete.Error(),
+		})
+	}
+
+	updated := make([]models.Drawing, len(states))
+	replaced := make([]models.Drawing, len(states))
+	for i, state := range states {
+		drawing, ok := current[state.ID]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": errDrawingGroupIncomplete.Error(),
+			})
+		}
+		// Each drawing is updated once
+		delete(current, state.ID)
+		replaced[i] = drawing
+		if state.Version == 0 {
+			return c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+				"error": errDrawingVersionRequired.Error(),
+			})
+		}
+		if state.Version != drawing.Version {
+			return sendDrawingConflict(c, drawing)
+		}
+		// Groups move within their file and keep their drawings
+		if state.FileID != 0 && state.FileID != group.FileID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Drawing at index %d: a group can't move to another file", i),
+			})
+		}
+		state.GormModel = drawing.GormModel
+		state.FileID = drawing.FileID
+		state.GroupID = drawing.GroupID
+		state.ClientID = drawing.ClientID
+		state.Version = drawing.Version + 1
+		keepDrawingCreator(&state, drawing)
+		keepDrawingLock(&state, drawing)
+		setDrawingEditor(c, &state)
+		if err := validateDrawing(state); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Drawing at index %d: %v", i, err),
+			})
+		}
+		updated[i] = state
+	}
+
+	recordDrawingRevisions(c, models.DrawingRevisionUpdate, replaced...)
+	var conflicted uint
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range updated {
+			saved, err := saveDrawingVersionTx(tx, &updated[i], replaced[i].Version)
+			if err != nil {
+				return err
+			}
+			if !saved {
+				conflicted = updated[i].ID
+				return errDrawingVersionConflict
+			}
+		}
+		return nil
+	})
+	if conflicted != 0 {
+		return sendStoredDrawingConflict(c, conflicted)
+	}
+	if err != nil {
+		fmt.Printf("ERROR updating drawings of group %d: %v\n", group.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update drawings",
+		})
+	}
+	for i, drawing := range updated {
+		audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, replaced[i], drawing)
+	}
+
+	return c.JSON(updated)
+}
+
+// DeleteDrawingGroup - Ungroup a group's drawings, or with ?drawings=true
+// delete them along with the group. Deleting drawings takes an admin, as it
+// does one at a time.
+func DeleteDrawingGroup(c *fiber.Ctx) error {
+	fmt.Println("DeleteDrawingGroup")
+
+	group, status, err := findEditableDrawingGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var deleted []models.Drawing
+	if c.QueryBool("drawings") {
+		if !models.RoleAtLeast(middleware.CurrentClaims(c).Role, models.RoleAdmin) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": errDeleteGroupDrawings.Error(),
+			})
+		}
+		if deleted, status, err = deleteGroupDrawings(c, group); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	} else if status, err := checkDrawingLocks(c, groupDrawings(group)); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Deleted drawings leave the group too, to be restored on their own
+		if err := tx.Unscoped().Model(&models.Drawing{}).Where("group_id = ?", group.ID).
+			UpdateColumns(groupColumns(c, nil)).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&group).Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR deleting drawing group %d: %v\n", group.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete drawing group",
+		})
+	}
+	audit.Record(c, audit.DrawingGroupDelete, audit.EntityDrawingGroup, group.ID, group, nil)
+
+	return c.JSON(fiber.Map{
+		"message":  "Drawing group deleted successfully",
+		"drawings": len(deleted),
+	})
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// drawingRevision returns the state of a drawing as a revision superseded by
+// the caller's action
+func drawingRevision(c *fiber.Ctx, drawing models.Drawing, action string) models.DrawingRevision {
+	revision := models.DrawingRevision{
+		DrawingID:   drawing.ID,
+		FileID:      drawing.FileID,
+		Type:        drawing.Type,
+		PageNumber:  drawing.PageNumber,
+		Image:       drawing.Image,
+		BoundingBox: drawing.BoundingBox,
+		Data:        drawing.Data,
+		LayerID:     drawing.LayerID,
+		GroupID:     drawing.GroupID,
+		Orphaned:    drawing.Orphaned,
+		Action:      action,
+	}
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		if id := claims.UserID(); id != 0 {
+			revision.ChangedByID = &id
+		}
+		revision.ChangedByName = claims.Username
+	}
+	return revision
+}
+
+// recordDrawingRevisions keeps the states of drawings the caller's action is
+// about to supersede. A failure is logged, the action still goes ahead.
+func recordDrawingRevisions(c *fiber.Ctx, action string, drawings ...models.Drawing) {
+	if len(drawings) == 0 {
+		return
+	}
+	revisions := make([]models.DrawingRevision, len(drawings))
+	for i, drawing := range drawings {
+		revisions[i] = drawingRevision(c, drawing, action)
+	}
+	if err := database.DB.Create(&revisions).Error; err != nil {
+		fmt.Printf("ERROR keeping revisions of %d drawings: %v\n", len(drawings), err)
+	}
+}
+
+// findDrawingWithHistory loads a drawing, deleted or not, and checks the
+// caller's permission on its file
+func findDrawingWithHistory(c *fiber.Ctx, id interface{}, permission string) (models.Drawing, int, error) {
+	var drawing models.Drawing
+	if result := database.DB.Unscoped().First(&drawing, id); result.Error != nil {
+		return drawing, fiber.StatusNotFound, errDrawingNotFound
+	}
+
+	file, status, err := findAccessibleFile(c, drawing.FileID, permission)
+	if err == nil && permission == models.PermissionWrite {
+		status, err = checkFileLock(c, file)
+	}
+	if err == nil && permission == models.PermissionWrite {
+		status, err = checkDrawingLock(c, drawing)
+	}
+	if err != nil {
+		if status == fiber.StatusNotFound {
+			err = errDrawingNotFound
+		}
+		return drawing, status, err
+	}
+	return drawing, 0, nil
+}
+
+// GetDrawingHistory - List the earlier states of a drawing, newest first,
+// each with the action that superseded it. Deleted drawings keep their
+// history, so they can be restored.
+func GetDrawingHistory(c *fiber.Ctx) error {
+	drawing, status, err := findDrawingWithHistory(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	revisions := []models.DrawingRevision{}
+	database.DB.Where("drawing_id = ?", drawing.ID).Order("id DESC").Find(&revisions)
+
+	// Prefer a coalesced update that hasn't been written yet
+	drawing = latestDrawing(drawing)
+	return c.JSON(fiber.Map{
+		"drawing":   drawing,
+		"deleted":   drawing.DeletedAt.Valid,
+		"revisions": revisions,
+	})
+}
+
+// RevertDrawing - Return a drawing to an earlier state from its history,
+// restoring it if it was deleted. The state replaced is kept as a revision
+// too, so a revert can be undone. With ?version= the drawing is only reverted
+// if no one changed it since that version.
+func RevertDrawing(c *fiber.Ctx) error {
+	fmt.Println("RevertDrawing")
+
+	drawing, status, err := findDrawingWithHistory(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var revision models.DrawingRevision
+	if err := database.DB.Where("drawing_id = ?", drawing.ID).First(&revision, c.Params("revision")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Revision not found",
+		})
+	}
+
+	// Drawings keep to their file; one moved since goes back only if the
+	// caller may edit the file it was on
+	if revision.FileID != drawing.FileID {
+		if _, status, err := findEditableFile(c, revision.FileID); err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// A pending coalesced update is the state the revert replaces
+	cancelled := latestDrawing(drawing)
+	if version := c.QueryInt("version"); version != 0 && version != cancelled.Version {
+		return sendDrawingConflict(c, cancelled)
+	}
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
+
+	reverted := cancelled
+	reverted.FileID = revision.FileID
+	reverted.Type = revision.Type
+	reverted.PageNumber = revision.PageNumber
+	reverted.Image = revision.Image
+	reverted.BoundingBox = revision.BoundingBox
+	reverted.Data = revision.Data
+	reverted.Orphaned = revision.Orphaned
+	reverted.LayerID = revision.LayerID
+	reverted.GroupID = revision.GroupID
+	// A layer or group deleted since leaves the drawing on none
+	if checkDrawingLayer(reverted) != nil {
+		reverted.LayerID = nil
+	}
+	if checkDrawingGroup(reverted) != nil {
+		reverted.GroupID = nil
+	}
+	reverted.CreatedAt = drawing.CreatedAt
+	reverted.DeletedAt = gorm.DeletedAt{}
+	reverted.Version = cancelled.Version + 1
+	setDrawingEditor(c, &reverted)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if !cancelled.DeletedAt.Valid {
+			replaced := drawingRevision(c, cancelled, models.DrawingRevisionRevert)
+			if err := tx.Create(&replaced).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Save(&reverted).Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR reverting drawing %d to revision %d: %v\n", drawing.ID, revision.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revert drawing",
+		})
+	}
+	audit.Record(c, audit.DrawingRevert, audit.EntityDrawing, drawing.ID, cancelled, reverted)
+
+	return c.JSON(reverted)
+}
+
+// setDrawingCreator records the caller as the creator and last editor of a
+// new drawing
+func setDrawingCreator(c *fiber.Ctx, drawing *models.Drawing) {
+	setDrawingEditor(c, drawing)
+	drawing.CreatedByID, drawing.CreatedByName = drawing.UpdatedByID, drawing.UpdatedByName
+}
+
+// setDrawingEditor records the caller as the last editor of a drawing
+func setDrawingEditor(c *fiber.Ctx, drawing *models.Drawing) {
+	drawing.UpdatedByID, drawing.UpdatedByName = nil, ""
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		if id := claims.UserID(); id != 0 {
+			drawing.UpdatedByID = &id
+		}
+		drawing.UpdatedByName = claims.Username
+	}
+}
+
+// keepDrawingCreator carries the creator of a drawing over to a new state of it
+func keepDrawingCreator(updated *models.Drawing, drawing models.Drawing) {
+	updated.CreatedByID, updated.CreatedByName = drawing.CreatedByID, drawing.CreatedByName
+}
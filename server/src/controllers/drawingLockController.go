@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+var errDrawingNotLocked = errors.New("Drawing is not locked")
+
+// drawingLockedError names who locked a drawing
+func drawingLockedError(drawing models.Drawing) error {
+	holder := "another user"
+	var user models.User
+	if drawing.LockedByID != nil && database.DB.First(&user, *drawing.LockedByID).Error == nil {
+		holder = user.Username
+	}
+	if drawing.LockedAt == nil {
+		return fmt.Errorf("Drawing is locked by %s", holder)
+	}
+	return fmt.Errorf("Drawing is locked by %s since %s", holder, drawing.LockedAt.UTC().Format(time.RFC3339))
+}
+
+// checkDrawingLock reports 403 Forbidden when someone other than the caller
+// locked the drawing, unless the caller is an admin
+func checkDrawingLock(c *fiber.Ctx, drawing models.Drawing) (int, error) {
+	if drawing.LockedByID == nil {
+		return 0, nil
+	}
+	claims := middleware.CurrentClaims(c)
+	if *drawing.LockedByID == claims.UserID() || models.RoleAtLeast(claims.Role, models.RoleAdmin) {
+		return 0, nil
+	}
+	return fiber.StatusForbidden, drawingLockedError(drawing)
+}
+
+// checkDrawingLocks checks none of the drawings is locked against the caller
+func checkDrawingLocks(c *fiber.Ctx, drawings []models.Drawing) (int, error) {
+	for _, drawing := range drawings {
+		if status, err := checkDrawingLock(c, drawing); err != nil {
+			return status, err
+		}
+	}
+	return 0, nil
+}
+
+// keepDrawingLock carries the lock on a drawing over to a new state of it
+func keepDrawingLock(updated *models.Drawing, drawing models.Drawing) {
+	updated.LockedByID, updated.LockedAt = drawing.LockedByID, drawing.LockedAt
+}
+
+// setDrawingLock locks the drawing for the user, or unlocks it for nil, as its
+// next version so updates based on the earlier one can't undo it
+func setDrawingLock(drawing *models.Drawing, userID *uint) error {
+	// A coalesced update is written first, as it would otherwise carry the
+	// earlier lock
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
+	var lockedAt *time.Time
+	if userID != nil {
+		now := time.Now()
+		lockedAt = &now
+	}
+	err := database.DB.Model(&models.Drawing{}).Where("id = ?", drawing.ID).UpdateColumns(map[string]interface{}{
+		"locked_by_id": userID,
+		"locked_at":    lockedAt,
+		"version":      gorm.Expr("version + 1"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return database.DB.First(drawing, drawing.ID).Error
+}
+
+// LockDrawing - Lock a drawing, such as an issued or approved markup, so only
+// the caller and admins can change or delete it until it is unlocked
+func LockDrawing(c *fiber.Ctx) error {
+	fmt.Println("LockDrawing")
+
+	// Drawings locked by someone else can't be locked again
+	drawing, status, err := findAccessibleDrawing(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before := drawing
+	userID := middleware.CurrentClaims(c).UserID()
+	if err := setDrawingLock(&drawing, &userID); err != nil {
+		fmt.Printf("ERROR locking drawing %d: %v\n", drawing.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to lock drawing",
+		})
+	}
+	audit.Record(c, audit.DrawingLock, audit.EntityDrawing, drawing.ID, before, drawing)
+
+	return c.JSON(drawing)
+}
+
+// UnlockDrawing - Unlock a drawing. The one who locked it may, and admins may
+// unlock anyone's.
+func UnlockDrawing(c *fiber.Ctx) error {
+	fmt.Println("UnlockDrawing")
+
+	drawing, status, err := findAccessibleDrawing(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if drawing.LockedByID == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": errDrawingNotLocked.Error(),
+		})
+	}
+
+	before := drawing
+	if err := setDrawingLock(&drawing, nil); err != nil {
+		fmt.Printf("ERROR unlocking drawing %d: %v\n", drawing.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock drawing",
+		})
+	}
+	audit.Record(c, audit.DrawingUnlock, audit.EntityDrawing, drawing.ID, before, drawing)
+
+	return c.JSON(drawing)
+}
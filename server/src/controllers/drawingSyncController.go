@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const (
+	maxSyncChanges    = 1000
+	maxClientIDLength = 64
+)
+
+// Outcomes of a synced change
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict"
+	syncRejected = "rejected"
+)
+
+var (
+	errDrawingChanged = errors.New("The drawing was changed on the server")
+	errDrawingDeleted = errors.New("The drawing was deleted on the server")
+	errSyncDelete     = errors.New("Insufficient permissions to delete drawings")
+)
+
+// syncChange is a change an offline client made to a drawing
+type syncChange struct {
+	// Op is create, update or delete
+	Op string `json:"op"`
+	// ID or ClientID name the drawing; creates need a ClientID
+	ID       uint   `json:"id"`
+	ClientID string `json:"clientId"`
+	// BaseVersion is the version of the drawing the client changed, or
+	// BaseUpdatedAt its updatedAt. A drawing changed on the server since is a
+	// conflict; without either the change applies regardless.
+	BaseVersion   int        `json:"baseVersion"`
+	BaseUpdatedAt *time.Time `json:"baseUpdatedAt"`
+	// Drawing is the state the client created or updated
+	Drawing models.Drawing `json:"drawing"`
+}
+
+type syncRequest struct {
+	Changes []syncChange `json:"changes"`
+	// Force applies changes to drawings changed on the server since
+	Force bool `json:"force"`
+}
+
+// syncResult tells how a change was reconciled
+type syncResult struct {
+	Index    int    `json:"index"`
+	Op       string `json:"op"`
+	ClientID string `json:"clientId,omitempty"`
+	// ID is the server's ID of the drawing
+	ID uint `json:"id,omitempty"`
+	// Status is applied, conflict or rejected
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Drawing is the drawing as the server has it now, missing once deleted
+	Drawing *models.Drawing `json:"drawing,omitempty"`
+}
+
+// drawingSync reconciles the changes of a sync request in order
+type drawingSync struct {
+	c     *fiber.Ctx
+	force bool
+	// editable remembers per file whether the caller may change its drawings
+	editable map[uint]error
+}
+
+// SyncDrawings - Reconcile a batch of drawing changes an offline client made,
+// in order. Drawings are named by server ID or by the UUID the client created
+// them under, so retried batches don't create them twice. Each change is
+// applied, rejected, or reported as a conflict with the server's drawing
+// when the drawing changed on the server after the client last saw it.
+func SyncDrawings(c *fiber.Ctx) error {
+	fmt.Println("SyncDrawings")
+
+	var request syncRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to parse changes: %v", err),
+		})
+	}
+	if len(request.Changes) == 0 || len(request.Changes) > maxSyncChanges {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("changes must list between 1 and %d changes", maxSyncChanges),
+		})
+	}
+
+	// Coalesced updates of the drawings are written first, so conflicts are
+	// checked against them
+	ids := make(map[uint]bool)
+	clientIDs := make(map[string]bool)
+	for _, change := range request.Changes {
+		ids[change.ID] = change.ID != 0
+		clientIDs[change.ClientID] = change.ClientID != ""
+	}
+	flushPendingDrawingUpdates(func(d models.Drawing) bool {
+		return ids[d.ID] || d.ClientID != nil && clientIDs[*d.ClientID]
+	})
+
+	sync := drawingSync{c: c, force: request.Force, editable: make(map[uint]error)}
+	results := make([]syncResult, len(request.Changes))
+	for i, change := range request.Changes {
+		result := syncResult{Index: i, Op: change.Op, ClientID: change.ClientID}
+		drawing, err := sync.apply(change)
+		switch {
+		case errors.Is(err, errDrawingChanged) || errors.Is(err, errDrawingDeleted):
+			result.Status = syncConflict
+			result.Error = err.Error()
+		case err != nil:
+			result.Status = syncRejected
+			result.Error = err.Error()
+		default:
+			result.Status = syncApplied
+		}
+		if drawing.ID != 0 {
+			result.ID = drawing.ID
+			if !drawing.DeletedAt.Valid {
+				result.Drawing = &drawing
+			}
+		}
+		results[i] = result
+	}
+
+	return c.JSON(fiber.Map{
+		"results": results,
+	})
+}
+
+// apply reconciles a change and returns the drawing it concerns as the
+// server has it afterwards
+func (s *drawingSync) apply(change syncChange) (models.Drawing, error) {
+	if len(change.ClientID) > maxClientIDLength {
+		return models.Drawing{}, fmt.Errorf("clientId must be at most %d characters", maxClientIDLength)
+	}
+	switch change.Op {
+	case "create":
+		return s.create(change)
+	case "update", "delete":
+		drawing, err := s.target(change)
+		if err != nil {
+			return drawing, err
+		}
+		if change.Op == "delete" {
+			return s.delete(change, drawing)
+		}
+		return s.update(change, drawing)
+	}
+	return models.Drawing{}, errors.New("op must be create, update or delete")
+}
+
+// fileEditable checks once per file that the caller may change its drawings
+func (s *drawingSync) fileEditable(fileID uint) error {
+	err, checked := s.editable[fileID]
+	if !checked {
+		_, _, err = findEditableFile(s.c, fileID)
+		s.editable[fileID] = err
+	}
+	return err
+}
+
+// target loads the drawing a change names, including deleted drawings, and
+// checks the caller may change it
+func (s *drawingSync) target(change syncChange) (models.Drawing, error) {
+	var drawing models.Drawing
+	query := database.DB.Unscoped()
+	var err error
+	switch {
+	case change.ID != 0:
+		err = query.First(&drawing, change.ID).Error
+	case change.ClientID != "":
+		err = query.Where("client_id = ?", change.ClientID).First(&drawing).Error
+	default:
+		return drawing, errors.New("id or clientId is required")
+	}
+	if err != nil {
+		return models.Drawing{}, errDrawingNotFound
+	}
+	if err := s.fileEditable(drawing.FileID); err != nil {
+		if errors.Is(err, errFileNotFound) {
+			err = errDrawingNotFound
+		}
+		return models.Drawing{}, err
+	}
+	return drawing, nil
+}
+
+// create adds the drawing, unless a retry finds it created before
+func (s *drawingSync) create(change syncChange) (models.Drawing, error) {
+	if change.ClientID == "" {
+		return models.Drawing{}, errors.New("clientId is required to create a drawing")
+	}
+	// Server IDs don't exist yet for the client to send
+	change.ID = 0
+	if existing, err := s.target(change); err == nil {
+		if existing.DeletedAt.Valid {
+			return existing, errDrawingDeleted
+		}
+		return existing, nil
+	} else if !errors.Is(err, errDrawingNotFound) {
+		return models.Drawing{}, err
+	}
+
+	drawing := change.Drawing
+	drawing.GormModel = models.GormModel{}
+	drawing.ClientID = &change.ClientID
+	setDrawingCreator(s.c, &drawing)
+	drawing.Orphaned = false
+	if err := validateDrawing(drawing); err != nil {
+		return models.Drawing{}, err
+	}
+	if err := s.fileEditable(drawing.FileID); err != nil {
+		return models.Drawing{}, err
+	}
+	if err := database.DB.Create(&drawing).Error; err != nil {
+		fmt.Printf("ERROR creating synced drawing %s: %v\n", change.ClientID, err)
+		return models.Drawing{}, errors.New("Failed to save drawing")
+	}
+	audit.Record(s.c, audit.DrawingCreate, audit.EntityDrawing, drawing.ID, nil, drawing)
+	return drawing, nil
+}
+
+// changedSince reports a conflict when the drawing changed after the state
+// the client based its change on
+func (s *drawingSync) changedSince(change syncChange, drawing models.Drawing) error {
+	if drawing.DeletedAt.Valid {
+		return errDrawingDeleted
+	}
+	if s.force {
+		return nil
+	}
+	if change.BaseVersion != 0 && change.BaseVersion != drawing.Version ||
+		change.BaseUpdatedAt != nil && drawing.UpdatedAt.After(*change.BaseUpdatedAt) {
+		return errDrawingChanged
+	}
+	return nil
+}
+
+// update replaces the drawing with the client's state. A grouped drawing
+// moved to another page takes the rest of its group along.
+func (s *drawingSync) update(change syncChange, drawing models.Drawing) (models.Drawing, error) {
+	if err := s.changedSince(change, drawing); err != nil {
+		return drawing, err
+	}
+	if _, err := checkDrawingLock(s.c, drawing); err != nil {
+		return drawing, err
+	}
+
+	updated := change.Drawing
+	updated.GormModel = drawing.GormModel
+	updated.ClientID = drawing.ClientID
+	updated.Version = drawing.Version + 1
+	keepDrawingCreator(&updated, drawing)
+	keepDrawingLock(&updated, drawing)
+	setDrawingEditor(s.c, &updated)
+	if updated.FileID == 0 {
+		updated.FileID = drawing.FileID
+	}
+	keepDrawingOrphaned(&updated, drawing)
+	if err := validateDrawing(updated); err != nil {
+		return drawing, err
+	}
+	// Moving a drawing to another file requires write access there as well
+	if err := s.fileEditable(updated.FileID); err != nil {
+		return drawing, err
+	}
+	followers, err := groupFollowers(s.c, drawing, updated)
+	if err != nil {
+		return drawing, err
+	}
+	recordDrawingRevisions(s.c, models.DrawingRevisionUpdate, drawing)
+	saved, err := saveDrawingVersion(&updated, drawing.Version)
+	if err != nil {
+		fmt.Printf("ERROR updating synced drawing %d: %v\n", drawing.ID, err)
+		return drawing, errors.New("Failed to save drawing")
+	}
+	if !saved {
+		// Another request changed the drawing since it was loaded
+		database.DB.First(&drawing, drawing.ID)
+		return drawing, errDrawingChanged
+	}
+	audit.Record(s.c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, drawing, updated)
+	if err := moveGroupFollowers(s.c, followers, updated.PageNumber); err != nil {
+		fmt.Printf("ERROR moving the group of synced drawing %d: %v\n", drawing.ID, err)
+	}
+	return updated, nil
+}
+
+// delete removes the drawing, with the rest of its group if it is in one, for
+// callers the policy lets delete drawings; deleting it again changes nothing
+func (s *drawingSync) delete(change syncChange, drawing models.Drawing) (models.Drawing, error) {
+	if drawing.DeletedAt.Valid {
+		return drawing, nil
+	}
+	if err := s.changedSince(change, drawing); err != nil {
+		return drawing, err
+	}
+	// Deleting needs the role DeleteDrawing does, which the policy sets
+	if !middleware.Allows(s.c, fiber.MethodDelete, fmt.Sprintf("/api/drawings/%d", drawing.ID)) {
+		return drawing, errSyncDelete
+	}
+	if _, err := checkDrawingLock(s.c, drawing); err != nil {
+		return drawing, err
+	}
+	if drawing.GroupID != nil {
+		group := models.DrawingGroup{GormModel: models.GormModel{ID: *drawing.GroupID}, FileID: drawing.FileID}
+		if _, _, err := deleteGroupDrawings(s.c, group); err != nil {
+			return drawing, err
+		}
+		drawing.DeletedAt.Valid = true
+		return drawing, nil
+	}
+	recordDrawingRevisions(s.c, models.DrawingRevisionDelete, drawing)
+	cancelPendingDrawingUpdates(func(d models.Drawing) bool { return d.ID == drawing.ID })
+	if err := database.DB.Delete(&drawing).Error; err != nil {
+		fmt.Printf("ERROR deleting synced drawing %d: %v\n", drawing.ID, err)
+		return drawing, errors.New("Failed to delete drawing")
+	}
+	audit.Record(s.c, audit.DrawingDelete, audit.EntityDrawing, drawing.ID, drawing, nil)
+	drawing.DeletedAt.Valid = true
+	return drawing, nil
+}
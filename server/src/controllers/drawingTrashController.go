@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// restoreColumns bring deleted drawings back as their next version, restored
+// by the caller
+func restoreColumns(c *fiber.Ctx) map[string]interface{} {
+	var editor models.Drawing
+	setDrawingEditor(c, &editor)
+	return map[string]interface{}{
+		"deleted_at":      nil,
+		"version":         gorm.Expr("version + 1"),
+		"updated_by_id":   editor.UpdatedByID,
+		"updated_by_name": editor.UpdatedByName,
+	}
+}
+
+// RestoreDrawing - Bring back a deleted drawing as it was when deleted, with
+// the drawings of its group deleted along with it. Deleted drawings can be
+// restored for TRASH_RETENTION.
+func RestoreDrawing(c *fiber.Ctx) error {
+	fmt.Println("RestoreDrawing")
+
+	drawing, status, err := findDrawingWithHistory(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !drawing.DeletedAt.Valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The drawing isn't deleted",
+		})
+	}
+
+	// A group deleted as a unit was deleted at once
+	ids := []uint{drawing.ID}
+	if drawing.GroupID != nil {
+		database.DB.Unscoped().Model(&models.Drawing{}).
+			Where("group_id = ? AND deleted_at = ?", *drawing.GroupID, drawing.DeletedAt).
+			Pluck("id", &ids)
+	}
+	if err := database.DB.Unscoped().Model(&models.Drawing{}).Where("id IN ?", ids).UpdateColumns(restoreColumns(c)).Error; err != nil {
+		fmt.Printf("ERROR restoring drawing %d: %v\n", drawing.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore drawing",
+		})
+	}
+	var restored []models.Drawing
+	database.DB.Where("id IN ?", ids).Order("id").Find(&restored)
+	for _, other := range restored {
+		audit.Record(c, audit.DrawingRestore, audit.EntityDrawing, other.ID, nil, other)
+		if other.ID == drawing.ID {
+			drawing = other
+		}
+	}
+
+	return c.JSON(drawing)
+}
+
+type restoreDrawingsRequest struct {
+	FileID uint `json:"fileId"`
+	// PageNumber restores only the drawings of a page
+	PageNumber int `json:"pageNumber"`
+	// DeletedSince restores only drawings deleted at or after it, like those
+	// a page was just cleared of
+	DeletedSince *time.Time `json:"deletedSince"`
+}
+
+// RestoreDrawings - Bring back the deleted drawings of a file, to undo
+// clearing a page or the whole file
+func RestoreDrawings(c *fiber.Ctx) error {
+	fmt.Println("RestoreDrawings")
+
+	var request restoreDrawingsRequest
+	if err := c.BodyParser(&request); err != nil || request.FileID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File ID is required",
+		})
+	}
+	if _, status, err := findEditableFile(c, request.FileID); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.DB.Unscoped().Where("file_id = ? AND deleted_at IS NOT NULL", request.FileID)
+	if request.PageNumber > 0 {
+		query = query.Where("page_number = ?", request.PageNumber)
+	}
+	if request.DeletedSince != nil {
+		query = query.Where("deleted_at >= ?", *request.DeletedSince)
+	}
+	var ids []uint
+	query.Model(&models.Drawing{}).Pluck("id", &ids)
+
+	restored := []models.Drawing{}
+	if len(ids) > 0 {
+		if err := database.DB.Unscoped().Model(&models.Drawing{}).Where("id IN ?", ids).UpdateColumns(restoreColumns(c)).Error; err != nil {
+			fmt.Printf("ERROR restoring drawings of file %d: %v\n", request.FileID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to restore drawings",
+			})
+		}
+		database.DB.Where("id IN ?", ids).Order("id").Find(&restored)
+	}
+	for _, drawing := range restored {
+		audit.Record(c, audit.DrawingRestore, audit.EntityDrawing, drawing.ID, nil, drawing)
+	}
+
+	return c.JSON(fiber.Map{
+		"restored": len(restored),
+		"drawings": restored,
+	})
+}
+
+// purgeDeletedDrawings permanently deletes drawings deleted longer than the
+// trash retention ago, with their history and comments
+func purgeDeletedDrawings() {
+	cutoff := time.Now().Add(-trashRetention())
+	var purged int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Unscoped().Model(&models.Drawing{}).Select("id").Where("deleted_at < ?", cutoff)
+		for _, model := range []interface{}{&models.DrawingRevision{}, &models.DrawingComment{}} {
+			if err := tx.Unscoped().Where("drawing_id IN (?)", expired).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&models.Drawing{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR purging deleted drawings: %v\n", err)
+		return
+	}
+	if purged > 0 {
+		fmt.Printf("Purged %d deleted drawings\n", purged)
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+var (
+	errDrawingNotFound  = errors.New("Drawing not found")
+	errFileNotFound     = errors.New("File not found")
+	errFileAccessDenied = errors.New("You don't have write access to this file")
+)
+
+// accessibleFiles limits a files query to the files of the current workspace
+// the caller may read. Admins see everything in the workspace, other users see
+// files they own, files shared with them or their groups and files that predate
+// ownership tracking.
+func accessibleFiles(c *fiber.Ctx) func(db *gorm.DB) *gorm.DB {
+	claims := middleware.CurrentClaims(c)
+	workspaceID := middleware.CurrentWorkspace(c).ID
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("files.workspace_id = ?", workspaceID)
+		if claims.IsGuest() {
+			return db.Where("files.id = ?", claims.GuestFileID)
+		}
+		if models.RoleAtLeast(claims.Role, models.RoleAdmin) {
+			return db
+		}
+		userID := claims.UserID()
+		return db.Where(
+			"files.owner_id IS NULL OR files.owner_id = ? OR files.id IN (?) OR files.id IN (?)",
+			userID,
+			database.DB.Model(&models.FilePermission{}).Select("file_id").Where("user_id = ?", userID),
+			database.DB.Model(&models.FileGroupPermission{}).Select("file_id").Where("group_id IN (?)", userGroupIDs(userID)),
+		)
+	}
+}
+
+// hasFileAccess reports whether the caller holds the permission on the file
+func hasFileAccess(claims *token.Claims, file models.File, permission string) bool {
+	if claims.IsGuest() {
+		return file.ID == claims.GuestFileID && permission == models.PermissionRead
+	}
+	if models.RoleAtLeast(claims.Role, models.RoleAdmin) || file.OwnerID == nil || *file.OwnerID == claims.UserID() {
+		return true
+	}
+
+	// Direct grants and grants to any of the user's groups add up
+	var grants []string
+	database.DB.Model(&models.FilePermission{}).
+		Where("file_id = ? AND user_id = ?", file.ID, claims.UserID()).
+		Pluck("permission", &grants)
+	var groupGrants []string
+	database.DB.Model(&models.FileGroupPermission{}).
+		Where("file_id = ? AND group_id IN (?)", file.ID, userGroupIDs(claims.UserID())).
+		Pluck("permission", &groupGrants)
+	grants = append(grants, groupGrants...)
+
+	for _, grant := range grants {
+		if permission == models.PermissionRead || grant == models.PermissionWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// userGroupIDs is a subquery selecting the groups the user belongs to
+func userGroupIDs(userID uint) *gorm.DB {
+	return database.DB.Model(&models.GroupMember{}).Select("group_id").Where("user_id = ?", userID)
+}
+
+// isFileOwner reports whether the caller may manage the file's permissions
+func isFileOwner(claims *token.Claims, file models.File) bool {
+	return models.RoleAtLeast(claims.Role, models.RoleAdmin) || (file.OwnerID != nil && *file.OwnerID == claims.UserID())
+}
+
+// findAccessibleFile loads a file of the current workspace and checks the
+// caller's permission on it. Files the caller can't read are reported as not found.
+func findAccessibleFile(c *fiber.Ctx, id interface{}, permission string) (models.File, int, error) {
+	claims := middleware.CurrentClaims(c)
+
+	var file models.File
+	result := database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).First(&file, id)
+	if result.Error != nil {
+		return file, fiber.StatusNotFound, errFileNotFound
+	}
+
+	if !hasFileAccess(claims, file, models.PermissionRead) {
+		return file, fiber.StatusNotFound, errFileNotFound
+	}
+	if permission == models.PermissionWrite && !hasFileAccess(claims, file, models.PermissionWrite) {
+		return file, fiber.StatusForbidden, errFileAccessDenied
+	}
+	return file, 0, nil
+}
+
+// findAccessibleDrawing loads a drawing and checks the caller's permission on its
+// file, which also keeps drawings inside their file's workspace. Drawings of a
+// file checked out by someone else, or locked by someone else, can't be
+// written.
+func findAccessibleDrawing(c *fiber.Ctx, id interface{}, permission string) (models.Drawing, int, error) {
+	var drawing models.Drawing
+	if result := database.DB.First(&drawing, id); result.Error != nil {
+		return drawing, fiber.StatusNotFound, errDrawingNotFound
+	}
+
+	file, status, err := findAccessibleFile(c, drawing.FileID, permission)
+	if err == nil && permission == models.PermissionWrite {
+		status, err = checkFileLock(c, file)
+	}
+	if err == nil && permission == models.PermissionWrite {
+		status, err = checkDrawingLock(c, drawing)
+	}
+	if err != nil {
+		if status == fiber.StatusNotFound {
+			err = errDrawingNotFound
+		}
+		return drawing, status, err
+	}
+	return drawing, 0, nil
+}
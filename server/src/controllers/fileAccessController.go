@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const (
+	defaultFileAccessLimit = 100
+	maxFileAccessLimit     = 1000
+	// fileViewWindow is how long page views by the same viewer count as one view
+	fileViewWindow = 30 * time.Minute
+	maxUserAgent   = 255
+)
+
+// newFileAccess describes an access to the file by the current caller
+func newFileAccess(c *fiber.Ctx, file models.File, kind string, version int) models.FileAccess {
+	access := models.FileAccess{
+		FileID:    file.ID,
+		Kind:      kind,
+		Version:   version,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+	if len(access.UserAgent) > maxUserAgent {
+		access.UserAgent = access.UserAgent[:maxUserAgent]
+	}
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		access.Username = claims.Username
+		if claims.IsGuest() {
+			guestTokenID := claims.GuestTokenID
+			access.GuestTokenID = &guestTokenID
+		} else {
+			userID := claims.UserID()
+			access.UserID = &userID
+		}
+	}
+	return access
+}
+
+// saveFileAccess stores an access; failures are logged as they must not break
+// the download
+func saveFileAccess(access models.FileAccess) {
+	if err := database.DB.Create(&access).Error; err != nil {
+		fmt.Printf("ERROR recording access to file %d: %v\n", access.FileID, err)
+	}
+}
+
+// contentServed reports whether the response carries the content, so failed
+// and revalidated downloads aren't recorded
+func contentServed(c *fiber.Ctx) bool {
+	status := c.Response().StatusCode()
+	return status == fiber.StatusOK || status == fiber.StatusPartialContent
+}
+
+// downloadFromStart reports whether the request asks for the file from its
+// beginning. Viewers fetch PDFs in ranges, and only the first one is a download.
+func downloadFromStart(c *fiber.Ctx) bool {
+	header := c.Get(fiber.HeaderRange)
+	return header == "" || strings.HasPrefix(strings.TrimSpace(header), "bytes=0-")
+}
+
+// recordFileDownload records a download of a file revision. Viewers fetch PDFs
+// in ranges, so only requests starting at the beginning of the file count.
+func recordFileDownload(c *fiber.Ctx, file models.File, version int) {
+	if !contentServed(c) || !downloadFromStart(c) {
+		return
+	}
+	saveFileAccess(newFileAccess(c, file, models.FileAccessDownload, version))
+}
+
+// recordSharedDownload records a download through a share link, counting
+// ranges as recordFileDownload does
+func recordSharedDownload(c *fiber.Ctx, link models.ShareLink) {
+	if !contentServed(c) || !downloadFromStart(c) {
+		return
+	}
+	access := newFileAccess(c, link.File, models.FileAccessDownload, link.File.Version)
+	access.ShareLinkID = &link.ID
+	saveFileAccess(access)
+}
+
+// recordFileView records that the caller looked at the file's pages, at most
+// once per fileViewWindow
+func recordFileView(c *fiber.Ctx, file models.File) {
+	access := newFileAccess(c, file, models.FileAccessView, file.Version)
+	query := database.DB.Model(&models.FileAccess{}).
+		Where("file_id = ? AND kind = ? AND created_at > ?", file.ID, models.FileAccessView, time.Now().Add(-fileViewWindow))
+	if access.GuestTokenID != nil {
+		query = query.Where("guest_token_id = ?", *access.GuestTokenID)
+	} else {
+		query = query.Where("user_id = ?", access.UserID)
+	}
+	var recent int64
+	if query.Count(&recent); recent > 0 {
+		return
+	}
+	saveFileAccess(access)
+}
+
+// GetFileAccesses - List who downloaded or viewed a file and when, newest
+// first. Only the owner and admins may see it. Supports the filter kind
+// (download or view) and paging with limit and offset.
+func GetFileAccesses(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !isFileOwner(middleware.CurrentClaims(c), file) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the file owner can see its access history",
+		})
+	}
+
+	query := database.DB.Model(&models.FileAccess{}).Where("file_id = ?", file.ID)
+	if kind := c.Query("kind"); kind != "" {
+		if kind != models.FileAccessDownload && kind != models.FileAccessView {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid kind, expected download or view",
+			})
+		}
+		query = query.Where("kind = ?", kind)
+	}
+
+	limit := c.QueryInt("limit", defaultFileAccessLimit)
+	if limit <= 0 || limit > maxFileAccessLimit {
+		limit = defaultFileAccessLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var accesses []models.FileAccess
+	query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&accesses)
+
+	return c.JSON(fiber.Map{
+		"total":    total,
+		"accesses": accesses,
+	})
+}
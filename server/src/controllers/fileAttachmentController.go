@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+type importAttachmentRequest struct {
+	// FolderID places the file, 0 at the top level; the PDF's folder by default
+	FolderID *uint `json:"folderId"`
+}
+
+// attachmentFilename makes an embedded file's name safe to save under
+func attachmentFilename(attachment pdf.Attachment) string {
+	name := filepath.Base(strings.ReplaceAll(attachment.Name, "\\", "/"))
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		return "attachment-" + strconv.Itoa(attachment.Index)
+	}
+	return name
+}
+
+// extractAttachment saves the attachment named by the :index parameter of a
+// file the caller may read into a new work directory. The caller must remove
+// the directory when err is nil.
+func extractAttachment(c *fiber.Ctx) (models.File, pdf.Attachment, string, string, int, error) {
+	var attachment pdf.Attachment
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return file, attachment, "", "", status, err
+	}
+	defer cleanup()
+
+	index, err := strconv.Atoi(c.Params("index"))
+	if err != nil {
+		return file, attachment, "", "", fiber.StatusBadRequest, errors.New("Invalid attachment")
+	}
+	attachments, err := pdf.Attachments(path)
+	if err != nil {
+		fmt.Printf("ERROR listing attachments of file %d: %v\n", file.ID, err)
+		return file, attachment, "", "", fiber.StatusUnprocessableEntity, errors.New("Failed to read the attachments")
+	}
+	found := false
+	for _, a := range attachments {
+		if a.Index == index {
+			attachment, found = a, true
+		}
+	}
+	if !found {
+		return file, attachment, "", "", fiber.StatusNotFound, errors.New("Attachment not found")
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return file, attachment, "", "", fiber.StatusInternalServerError, errors.New("Failed to extract the attachment")
+	}
+	extracted := filepath.Join(workDir, "attachment")
+	if err := pdf.SaveAttachment(path, index, extracted); err != nil {
+		os.RemoveAll(workDir)
+		fmt.Printf("ERROR extracting attachment %d of file %d: %v\n", index, file.ID, err)
+		return file, attachment, "", "", fiber.StatusUnprocessableEntity, errors.New("Failed to extract the attachment")
+	}
+	return file, attachment, workDir, extracted, 0, nil
+}
+
+// GetAttachments - List the files embedded in a file, like drawings or
+// spreadsheets vendors attach to their PDFs
+func GetAttachments(c *fiber.Ctx) error {
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	attachments, err := pdf.Attachments(path)
+	if err != nil {
+		fmt.Printf("ERROR listing attachments of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the attachments",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"attachments": attachments,
+	})
+}
+
+// DownloadAttachment - Download a file embedded in a file
+func DownloadAttachment(c *fiber.Ctx) error {
+	_, attachment, workDir, extracted, status, err := extractAttachment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// The work directory is removed once the response is sent
+	return sendWorkDirFile(c, workDir, extracted, attachmentFilename(attachment))
+}
+
+// ImportAttachment - Save a file embedded in a file as a file of its own.
+// PDFs are saved as they are and images converted to PDF; office documents
+// are converted in the background like uploads, and the response is then the
+// conversion. Other files can only be downloaded.
+func ImportAttachment(c *fiber.Ctx) error {
+	fmt.Println("ImportAttachment")
+
+	var request importAttachmentRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse import options",
+			})
+		}
+	}
+
+	file, attachment, workDir, extracted, status, err := extractAttachment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	folder := folderByID(file.FolderID)
+	if request.FolderID != nil {
+		if folder, err = findOptionalFolder(c, *request.FolderID); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	filename := attachmentFilename(attachment)
+	office, err := pdf.IsOfficeDocument(extracted, filename)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read the attachment",
+		})
+	}
+	if office {
+		job, err := startConversion(c, extracted, filename, folder)
+		if err != nil {
+			fmt.Printf("ERROR starting conversion of %s: %v\n", filename, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start converting the document",
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":    "Document is being converted to PDF",
+			"conversion": job,
+		})
+	}
+
+	format, err := pdf.ImageFormat(extracted)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read the attachment",
+		})
+	}
+	if format != "" {
+		converted := filepath.Join(workDir, "image.pdf")
+		if err := pdf.ImagesToPDF([]string{extracted}, converted); err != nil {
+			fmt.Printf("ERROR converting attachment %s of file %d to PDF: %v\n", filename, file.ID, err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Failed to convert the image to PDF",
+			})
+		}
+		extracted = converted
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".pdf"
+	} else if isPDF, err := pdf.IsPDF(extracted); err != nil || !isPDF {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Only PDFs, images and office documents can be imported, download other attachments instead",
+		})
+	}
+
+	return saveOperationResult(c, extracted, filename, folder, fiber.Map{
+		"operation":  "importAttachment",
+		"source":     file.ID,
+		"attachment": attachment.Name,
+	})
+}
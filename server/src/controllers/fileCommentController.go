@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const maxCommentLength = 10000
+
+var errCommentNotFound = errors.New("Comment not found")
+
+type commentRequest struct {
+	Body     string `json:"body"`
+	ParentID *uint  `json:"parentId"`
+}
+
+// validCommentBody trims a comment and checks its length
+func validCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxCommentLength {
+		return "", fmt.Errorf("Comment must be 1 to %d bytes", maxCommentLength)
+	}
+	return body, nil
+}
+
+// findFileComment loads a comment of the file the caller may read, and the file
+func findFileComment(c *fiber.Ctx) (models.FileComment, models.File, int, error) {
+	var comment models.FileComment
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return comment, file, status, err
+	}
+	if err := database.DB.Where("file_id = ?", file.ID).First(&comment, c.Params("commentId")).Error; err != nil {
+		return comment, file, fiber.StatusNotFound, errCommentNotFound
+	}
+	return comment, file, 0, nil
+}
+
+// GetFileComments - List the comments of a file, oldest first. Threads are
+// rebuilt from parentId.
+func GetFileComments(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	comments := []models.FileComment{}
+	database.DB.Where("file_id = ?", file.ID).Order("created_at, id").Find(&comments)
+	return c.JSON(comments)
+}
+
+// CreateFileComment - Comment on a file, or reply to a comment with parentId
+func CreateFileComment(c *fiber.Ctx) error {
+	fmt.Println("CreateFileComment")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request commentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse comment",
+		})
+	}
+	body, err := validCommentBody(request.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if request.ParentID != nil {
+		var parent models.FileComment
+		if err := database.DB.Where("file_id = ?", file.ID).First(&parent, *request.ParentID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Parent comment not found",
+			})
+		}
+	}
+
+	claims := middleware.CurrentClaims(c)
+	comment := models.FileComment{
+		FileID:     file.ID,
+		ParentID:   request.ParentID,
+		AuthorID:   claims.UserID(),
+		AuthorName: claims.Username,
+		Body:       body,
+	}
+	if result := database.DB.Create(&comment); result.Error != nil {
+		fmt.Printf("ERROR creating comment on file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create comment",
+		})
+	}
+	audit.Record(c, audit.CommentCreate, audit.EntityFileComment, comment.ID, nil, comment)
+
+	return c.Status(fiber.StatusCreated).JSON(comment)
+}
+
+// UpdateFileComment - Edit the text of one of the caller's comments
+func UpdateFileComment(c *fiber.Ctx) error {
+	fmt.Println("UpdateFileComment")
+
+	comment, _, status, err := findFileComment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if comment.AuthorID != middleware.CurrentClaims(c).UserID() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit a comment",
+		})
+	}
+
+	var request commentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse comment",
+		})
+	}
+	body, err := validCommentBody(request.Body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before := comment
+	comment.Body = body
+	if result := database.DB.Save(&comment); result.Error != nil {
+		fmt.Printf("ERROR updating comment %d: %v\n", comment.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update comment",
+		})
+	}
+	audit.Record(c, audit.CommentUpdate, audit.EntityFileComment, comment.ID, before, comment)
+
+	return c.JSON(comment)
+}
+
+// DeleteFileComment - Delete a comment with the replies to it. Authors may
+// delete their own comments, file owners and admins any comment on the file.
+func DeleteFileComment(c *fiber.Ctx) error {
+	fmt.Println("DeleteFileComment")
+
+	comment, file, status, err := findFileComment(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	claims := middleware.CurrentClaims(c)
+	if comment.AuthorID != claims.UserID() && !isFileOwner(claims, file) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author or the file owner can delete a comment",
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Collect the thread below the comment a level at a time
+		ids := []uint{comment.ID}
+		for level := ids; len(level) > 0; {
+			var replies []uint
+			if err := tx.Model(&models.FileComment{}).Where("parent_id IN ?", level).Pluck("id", &replies).Error; err != nil {
+				return err
+			}
+			ids = append(ids, replies...)
+			level = replies
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.FileComment{}).Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR deleting comment %d: %v\n", comment.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete comment",
+		})
+	}
+	audit.Record(c, audit.CommentDelete, audit.EntityFileComment, comment.ID, comment, nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Comment deleted successfully",
+	})
+}
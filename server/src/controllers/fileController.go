@@ -1,136 +1,266 @@
 package controllers
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
 	"os"
+	"pdfsrv/src/audit"
 	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
 	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// UploadFile - Upload a PDF, or PNG, JPEG or TIFF images sent as several
+// "file" parts, which are converted to a PDF with a page for each. Office
+// documents like DOCX and XLSX are converted in the background; the response
+// is then the conversion, which names the file once it is done.
 func UploadFile(c *fiber.Ctx) error {
 	fmt.Println("UploadFile")
-	file, err := c.FormFile("file")
-	if err != nil {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
 		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to upload file",
 		})
 		return err
 	}
+	parts := form.File["file"]
+	var size int64
+	for _, part := range parts {
+		size += part.Size
+	}
+	if err := checkFileSize(middleware.CurrentClaims(c).UserID(), fileTypePDF, size); err != nil {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	folder, err := requestedFolder(c, c.FormValue("folderId"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	filePath := "./uploads/" + file.Filename
-	if err := c.SaveFile(file, filePath); err != nil {
+	// Receive the upload into a scratch directory before handing it to storage
+	workDir, err := newWorkDir()
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to save file",
 		})
 		return err
 	}
+	defer os.RemoveAll(workDir)
 
-	// Calculate hash for the saved file
-	fileContent, err := os.Open(filePath)
+	filePath, fileHash, filename, status, err := receiveDocument(parts, workDir)
 	if err != nil {
-		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read file for hashing",
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
-		return err
 	}
-	defer fileContent.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, fileContent); err != nil {
+	// Office documents are converted in the background and become files once done
+	office, err := pdf.IsOfficeDocument(filePath, filename)
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to calculate file hash",
+			"error": "Failed to save file",
 		})
 		return err
 	}
-	// Create a directory with the hash as its name
-	fileHash := fmt.Sprintf("%x", hasher.Sum(nil))
-	hashDir := "./uploads/" + fileHash
-
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(hashDir, 0755); err != nil {
-		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create directory for file",
+	if office {
+		job, err := startConversion(c, filePath, filename, folder)
+		if err != nil {
+			fmt.Printf("ERROR starting conversion of %s: %v\n", filename, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start converting the document",
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":    "Document is being converted to PDF",
+			"conversion": job,
+		})
+	}
+	// An encrypted PDF may come with its password, so it is stored decrypted
+	filePath, fileHash, status, err = decryptUpload(filePath, fileHash, c.FormValue("password"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
-		return err
 	}
 
-	// New path for the file in the hash-named directory
-	newFilePath := hashDir + "/" + file.Filename
-
-	// Move the file to the new directory
-	if err := os.Rename(filePath, newFilePath); err != nil {
+	record, err := saveLocalFile(c, filePath, filename, fileHash, folder)
+	if errors.Is(err, errNotPDF) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to move file to hash directory",
+			"error": "Failed to store file",
 		})
 		return err
 	}
-
-	database.DB.Create(&models.File{
-		Filename: file.Filename,
-		Hash:     fileHash,
-		Size:     file.Size,
-	})
+	audit.Record(c, audit.FileUpload, audit.EntityFile, record.ID, nil, record)
 
 	return c.JSON(fiber.Map{
 		"message": "File uploaded successfully",
-		"file":    file.Filename,
+		"file":    filename,
 	})
 }
 
+// GetFilesList - List the accessible files a page at a time, see sendFilePage.
+// starred=true lists only the caller's favorites.
 func GetFilesList(c *fiber.Ctx) error {
-	var files []models.File
-	database.DB.Find(&files)
-	return c.JSON(files)
+	return sendFilePage(c, database.DB.Model(&models.File{}).Scopes(accessibleFiles(c), starredFilter(c)))
 }
 
 func DeleteFile(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	// Find the file in the database first
-	var file models.File
-	result := database.DB.First(&file, id)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "File not found",
+	file, status, err := findAccessibleFile(c, id, models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The file goes to the trash; its content is kept until it's purged
+	if err := trashFile(database.DB, file, middleware.CurrentClaims(c).UserID()); err != nil {
+		fmt.Printf("ERROR deleting file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete file",
 		})
 	}
+	audit.Record(c, audit.FileDelete, audit.EntityFile, file.ID, file, nil)
 
-	// Delete the file from the uploads directory
-	hashDir := "./uploads/" + file.Hash
-	filePath := hashDir + "/" + file.Filename
+	return c.JSON(fiber.Map{
+		"message": "File deleted successfully",
+	})
 
-	// Check if the file exists before attempting to delete it
-	_, err := os.Stat(filePath)
-	os.IsNotExist(err)
+}
 
-	if err == nil {
-		if err := os.Remove(filePath); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to delete file from uploads",
+// trashFile moves a file to the trash
+func trashFile(tx *gorm.DB, file models.File, deletedByID uint) error {
+	if err := tx.Model(&file).UpdateColumn("deleted_by_id", deletedByID).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&file).Error
+}
+
+// maxBulkDeleteFiles bounds how many files one bulk delete may name
+const maxBulkDeleteFiles = 500
+
+type bulkDeleteRequest struct {
+	IDs []uint `json:"ids"`
+	// Permanent purges the files instead of moving them to the trash
+	Permanent bool `json:"permanent"`
+}
+
+type bulkDeleteResult struct {
+	ID      uint   `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteFiles - Delete several files, moving them to the trash or, with
+// permanent, deleting them with their drawings and content. Files are deleted
+// in one transaction, each on its own, and the result of each is reported.
+func DeleteFiles(c *fiber.Ctx) error {
+	fmt.Println("DeleteFiles")
+
+	var request bulkDeleteRequest
+	if err := c.BodyParser(&request); err != nil || len(request.IDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ids must list the files to delete",
+		})
+	}
+	if len(request.IDs) > maxBulkDeleteFiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("At most %d files can be deleted at once", maxBulkDeleteFiles),
+		})
+	}
+
+	results := make([]bulkDeleteResult, len(request.IDs))
+	var deleted []models.File
+	seen := make(map[uint]bool, len(request.IDs))
+	var hashes []string
+	deletedByID := middleware.CurrentClaims(c).UserID()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range request.IDs {
+			results[i].ID = id
+			if seen[id] {
+				results[i].Error = "File is listed more than once"
+				continue
+			}
+			seen[id] = true
+
+			file, _, err := findAccessibleFile(c, id, models.PermissionWrite)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+
+			// Each file is deleted in a savepoint, so a failure only undoes that file
+			var fileHashes []string
+			err = tx.Transaction(func(tx *gorm.DB) error {
+				if !request.Permanent {
+					return trashFile(tx, file, deletedByID)
+				}
+				var err error
+				fileHashes, err = purgeFileRecords(tx, file)
+				return err
 			})
+			if err != nil {
+				fmt.Printf("ERROR deleting file %d: %v\n", file.ID, err)
+				results[i].Error = "Failed to delete file"
+				continue
+			}
+			results[i].Success = true
+			deleted = append(deleted, file)
+			hashes = append(hashes, fileHashes...)
 		}
-		// Try to remove the directory if it's empty
-		os.Remove(hashDir) // Ignore error if directory is not empty
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR deleting files: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete files",
+		})
 	}
 
-	// Delete the file record from the database
-	database.DB.Delete(&file)
+	releaseContent(hashes)
+	action := audit.FileDelete
+	if request.Permanent {
+		action = audit.FilePurge
+	}
+	for _, file := range deleted {
+		audit.Record(c, action, audit.EntityFile, file.ID, file, nil)
+	}
 
 	return c.JSON(fiber.Map{
-		"message": "File deleted successfully",
+		"results": results,
 	})
-
 }
 
 func DownloadFile(c *fiber.Ctx) error {
 	id := c.Params("id")
-	var file models.File
-	database.DB.First(&file, id)
+	file, status, err := findAccessibleFile(c, id, models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	filePath := "./uploads/" + file.Hash + "/" + file.Filename
-	return c.Download(filePath, file.Filename)
+	if err := sendStoredFile(c, file); err != nil {
+		return err
+	}
+	recordFileDownload(c, file, file.Version)
+	return nil
 }
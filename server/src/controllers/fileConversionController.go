@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+type convertRequest struct {
+	Output string `json:"output"`
+}
+
+// ConvertToPDFA - Convert a file to PDF/A-2b for archiving and report how the
+// result conforms. The result is the next revision of the file, or with output
+// "file" a new file; nothing is saved when the result doesn't conform.
+func ConvertToPDFA(c *fiber.Ctx) error {
+	fmt.Println("ConvertToPDFA")
+
+	var request convertRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse conversion options",
+			})
+		}
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "PDF/A doesn't allow encryption, decrypt the file first",
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare conversion",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "pdfa.pdf")
+	if err := pdf.ConvertPDFA(path, outPath); err != nil {
+		fmt.Printf("ERROR converting file %d to PDF/A: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to convert file to PDF/A",
+		})
+	}
+	report, err := pdf.CheckPDFA(outPath)
+	if err != nil {
+		fmt.Printf("ERROR checking PDF/A conversion of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check the converted file",
+		})
+	}
+	if !report.Compliant {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":  "The converted file doesn't conform to PDF/A-2b",
+			"report": report,
+		})
+	}
+
+	result, status, err := storeOperationOutput(c, file, outPath, output, "pdfa", fiber.Map{
+		"operation":   "pdfa",
+		"part":        report.Part,
+		"conformance": report.Conformance,
+	})
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"file":   result,
+		"report": report,
+	})
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+type copyFileRequest struct {
+	// Filename defaults to the name of the original
+	Filename string `json:"filename"`
+	// WorkspaceID defaults to the current workspace
+	WorkspaceID  uint `json:"workspaceId"`
+	CopyDrawings bool `json:"copyDrawings"`
+}
+
+// targetWorkspace resolves the workspace a file is copied or moved to. Other
+// workspaces need the caller to be a member, or an admin.
+func targetWorkspace(c *fiber.Ctx, workspaceID uint) (uint, int, error) {
+	current := middleware.CurrentWorkspace(c).ID
+	if workspaceID == 0 || workspaceID == current {
+		return current, 0, nil
+	}
+
+	var workspace models.Workspace
+	if err := database.DB.First(&workspace, workspaceID).Error; err != nil {
+		return 0, fiber.StatusNotFound, errors.New("Workspace not found")
+	}
+	claims := middleware.CurrentClaims(c)
+	if !models.RoleAtLeast(claims.Role, models.RoleAdmin) && !auth.IsWorkspaceMember(claims.UserID(), workspace.ID) {
+		return 0, fiber.StatusForbidden, errors.New("You are not a member of this workspace")
+	}
+	return workspace.ID, 0, nil
+}
+
+// CopyFile - Create a new file sharing the content of an existing one, owned by
+// the caller, optionally in another workspace and with copies of its drawings
+func CopyFile(c *fiber.Ctx) error {
+	fmt.Println("CopyFile")
+
+	original, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// A copy of content that is still being scanned would never be released
+	if original.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	var request copyFileRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse copy options",
+			})
+		}
+	}
+	filename := original.Filename
+	if request.Filename != "" {
+		if filename, err = sanitizeFilename(request.Filename); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	workspaceID, status, err := targetWorkspace(c, request.WorkspaceID)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ownerID := middleware.CurrentClaims(c).UserID()
+	if err := checkFileSize(ownerID, fileTypePDF, original.Size); err != nil {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key, ok, err := storage.AddRef(original.Hash)
+	if err != nil || !ok {
+		fmt.Printf("ERROR referencing content of file %d: %v\n", original.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to copy file",
+		})
+	}
+
+	file := models.File{
+		Filename:     filename,
+		Hash:         original.Hash,
+		Size:         original.Size,
+		StorageKey:   key,
+		Tags:         original.Tags,
+		Description:  original.Description,
+		PageCount:    original.PageCount,
+		PageSizes:    original.PageSizes,
+		Title:        original.Title,
+		Author:       original.Author,
+		Producer:     original.Producer,
+		Encrypted:    original.Encrypted,
+		UploadedByID: &ownerID,
+		ScanStatus:   original.ScanStatus,
+		OwnerID:      &ownerID,
+		WorkspaceID:  workspaceID,
+	}
+	var drawingCount int
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&file).Error; err != nil {
+			return err
+		}
+		if !request.CopyDrawings {
+			return nil
+		}
+
+		// The copied drawings go on copies of their layers and groups
+		var layers []models.Layer
+		if err := tx.Where("file_id = ?", original.ID).Order("id").Find(&layers).Error; err != nil {
+			return err
+		}
+		copiedLayers := make(map[uint]uint, len(layers))
+		for _, layer := range layers {
+			originalID := layer.ID
+			layer.GormModel = models.GormModel{}
+			layer.FileID = file.ID
+			if err := tx.Create(&layer).Error; err != nil {
+				return err
+			}
+			copiedLayers[originalID] = layer.ID
+		}
+		var groups []models.DrawingGroup
+		if err := tx.Where("file_id = ?", original.ID).Order("id").Find(&groups).Error; err != nil {
+			return err
+		}
+		copiedGroups := make(map[uint]uint, len(groups))
+		for _, group := range groups {
+			originalID := group.ID
+			group.GormModel = models.GormModel{}
+			group.FileID = file.ID
+			if err := tx.Create(&group).Error; err != nil {
+				return err
+			}
+			copiedGroups[originalID] = group.ID
+		}
+
+		var drawings []models.Drawing
+		if err := tx.Where("file_id = ?", original.ID).Order("id").Find(&drawings).Error; err != nil {
+			return err
+		}
+		for i := range drawings {
+			drawings[i].GormModel = models.GormModel{}
+			drawings[i].FileID = file.ID
+			// The copies are new drawings, unknown to offline clients
+			drawings[i].ClientID = nil
+			drawings[i].Version = 1
+			// The copy is the caller's to change
+			drawings[i].LockedByID, drawings[i].LockedAt = nil, nil
+			if drawings[i].LayerID != nil {
+				layerID := copiedLayers[*drawings[i].LayerID]
+				drawings[i].LayerID = &layerID
+			}
+			if drawings[i].GroupID != nil {
+				groupID := copiedGroups[*drawings[i].GroupID]
+				drawings[i].GroupID = &groupID
+			}
+		}
+		drawingCount = len(drawings)
+		if drawingCount == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&drawings, 100).Error
+	})
+	if err != nil {
+		storage.Release(original.Hash)
+		fmt.Printf("ERROR copying file %d: %v\n", original.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to copy file",
+		})
+	}
+	audit.Record(c, audit.FileCreate, audit.EntityFile, file.ID, nil, fiber.Map{
+		"copiedFrom": original.ID,
+		"file":       file,
+		"drawings":   drawingCount,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(file)
+}
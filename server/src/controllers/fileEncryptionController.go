@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+// outputDownload sends the result of an operation without storing it
+const outputDownload = "download"
+
+type encryptRequest struct {
+	// UserPassword is needed to open the copy; empty lets anyone open it
+	UserPassword string `json:"userPassword"`
+	// OwnerPassword lifts the restrictions; a random one is used when empty,
+	// so they can't be lifted
+	OwnerPassword string `json:"ownerPassword"`
+	AllowPrinting *bool  `json:"allowPrinting"`
+	AllowCopying  *bool  `json:"allowCopying"`
+	// Output is file, storing the copy next to the original, or download
+	Output string `json:"output"`
+}
+
+// randomPassword makes an owner password nobody knows
+func randomPassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// EncryptFile - Make a password protected copy of a file for distribution,
+// encrypted with 256-bit AES. Printing and copying text can be forbidden to
+// readers without the owner password. The copy is stored as a new file or,
+// with output "download", sent back without storing it.
+func EncryptFile(c *fiber.Ctx) error {
+	fmt.Println("EncryptFile")
+
+	var request encryptRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse encryption settings",
+		})
+	}
+	if request.UserPassword == "" && request.OwnerPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "userPassword or ownerPassword is required",
+		})
+	}
+	if strings.ContainsAny(request.UserPassword+request.OwnerPassword, "\r\n") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Passwords must not contain line breaks",
+		})
+	}
+	if request.Output == "" {
+		request.Output = outputFile
+	}
+	if request.Output != outputFile && request.Output != outputDownload {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "output must be file or download",
+		})
+	}
+	allow := pdf.Permissions{Print: true, Copy: true}
+	if request.AllowPrinting != nil {
+		allow.Print = *request.AllowPrinting
+	}
+	if request.AllowCopying != nil {
+		allow.Copy = *request.AllowCopying
+	}
+	ownerPassword := request.OwnerPassword
+	if ownerPassword == "" {
+		var err error
+		if ownerPassword, err = randomPassword(); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to prepare encryption",
+			})
+		}
+	}
+
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare encryption",
+		})
+	}
+	filename := derivedFilename(file, "protected")
+	outPath := filepath.Join(workDir, filename)
+	if err := pdf.Encrypt(path, outPath, request.UserPassword, ownerPassword, allow); err != nil {
+		os.RemoveAll(workDir)
+		fmt.Printf("ERROR encrypting file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to encrypt file",
+		})
+	}
+
+	if request.Output == outputDownload {
+		return sendWorkDirFile(c, workDir, outPath, filename)
+	}
+	defer os.RemoveAll(workDir)
+	// The passwords themselves stay out of the audit log
+	return sendOperationOutput(c, file, outPath, outputFile, "protected", fiber.Map{
+		"operation":     "encrypt",
+		"userPassword":  request.UserPassword != "",
+		"allowPrinting": allow.Print,
+		"allowCopying":  allow.Copy,
+	})
+}
+
+// decryptUpload removes the encryption of an uploaded PDF when a password was
+// sent with it and returns the path and hash of the decrypted copy. Without a
+// password the upload is kept as it is, and can be decrypted later.
+func decryptUpload(path, fileHash, password string) (string, string, int, error) {
+	if password == "" {
+		return path, fileHash, 0, nil
+	}
+	encrypted, err := pdf.IsEncrypted(path)
+	if err != nil {
+		fmt.Printf("ERROR checking encryption of upload: %v\n", err)
+		return "", "", fiber.StatusInternalServerError, errors.New("Failed to read upload")
+	}
+	if !encrypted {
+		return path, fileHash, 0, nil
+	}
+	decrypted := filepath.Join(filepath.Dir(path), "decrypted.pdf")
+	if err := pdf.Decrypt(path, decrypted, password); err != nil {
+		if errors.Is(err, pdf.ErrWrongPassword) {
+			return "", "", fiber.StatusUnprocessableEntity, errors.New("Wrong password")
+		}
+		fmt.Printf("ERROR decrypting upload: %v\n", err)
+		return "", "", fiber.StatusUnprocessableEntity, errors.New("Failed to decrypt file")
+	}
+	fileHash, err = hashFile(decrypted)
+	if err != nil {
+		return "", "", fiber.StatusInternalServerError, errors.New("Failed to save file")
+	}
+	return decrypted, fileHash, 0, nil
+}
+
+type decryptRequest struct {
+	// Password is the user or owner password; files anyone can open, which
+	// only restrict what readers may do, need none
+	Password string `json:"password"`
+	Output   string `json:"output"`
+}
+
+// DecryptFile - Remove the encryption of a file, so it can be viewed and
+// processed. The result is the next revision of the file, or with output
+// "file" a new file.
+func DecryptFile(c *fiber.Ctx) error {
+	fmt.Println("DecryptFile")
+
+	var request decryptRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse password",
+		})
+	}
+	if strings.ContainsAny(request.Password, "\r\n") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Passwords must not contain line breaks",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	encrypted, err := pdf.IsEncrypted(path)
+	if err != nil {
+		fmt.Printf("ERROR checking encryption of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	if !encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is not encrypted",
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare decryption",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "unlocked.pdf")
+	if err := pdf.Decrypt(path, outPath, request.Password); err != nil {
+		if errors.Is(err, pdf.ErrWrongPassword) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Wrong password",
+			})
+		}
+		fmt.Printf("ERROR decrypting file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to decrypt file",
+		})
+	}
+
+	return sendOperationOutput(c, file, outPath, output, "unlocked", fiber.Map{
+		"operation": "decrypt",
+	})
+}
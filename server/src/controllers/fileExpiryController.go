@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/mailer"
+	"pdfsrv/src/models"
+)
+
+const (
+	defaultExpiryNotice = 72 * time.Hour
+	fileExpiryInterval  = time.Hour
+)
+
+type fileExpiryRequest struct {
+	// ExpiresAt null removes the expiry
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// expiryNotice reads FILE_EXPIRY_NOTICE, a Go duration for how long before
+// expiry owners are notified
+func expiryNotice() time.Duration {
+	value := os.Getenv("FILE_EXPIRY_NOTICE")
+	if value == "" {
+		return defaultExpiryNotice
+	}
+	notice, err := time.ParseDuration(value)
+	if err != nil || notice < 0 {
+		fmt.Printf("ERROR invalid FILE_EXPIRY_NOTICE %q, using %s\n", value, defaultExpiryNotice)
+		return defaultExpiryNotice
+	}
+	return notice
+}
+
+// SetFileExpiry - Set or remove the date a file is moved to the trash
+func SetFileExpiry(c *fiber.Ctx) error {
+	fmt.Println("SetFileExpiry")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request fileExpiryRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expiresAt must be an RFC 3339 date or null",
+		})
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expiresAt must be in the future",
+		})
+	}
+
+	before := file
+	// A new date gets a new notice
+	result := database.DB.Model(&file).Updates(map[string]interface{}{
+		"expires_at":            request.ExpiresAt,
+		"expiry_notice_sent_at": nil,
+	})
+	if result.Error != nil {
+		fmt.Printf("ERROR setting expiry of file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to set file expiry",
+		})
+	}
+	database.DB.First(&file, file.ID)
+	audit.Record(c, audit.FileUpdate, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
+
+// sendExpiryNotices tells owners about their files expiring within the notice
+// period. Each file gets one notice per expiry date.
+func sendExpiryNotices() {
+	var files []models.File
+	database.DB.
+		Where("expires_at IS NOT NULL AND expires_at <= ? AND expiry_notice_sent_at IS NULL AND owner_id IS NOT NULL",
+			time.Now().Add(expiryNotice())).
+		Find(&files)
+	for _, file := range files {
+		var owner models.User
+		if err := database.DB.First(&owner, *file.OwnerID).Error; err == nil && owner.Email != "" {
+			body := fmt.Sprintf("Your file %q expires on %s and will then be moved to the trash.\n"+
+				"Change or remove its expiry date to keep it.\n",
+				file.Filename, file.ExpiresAt.UTC().Format(time.RFC1123))
+			if err := mailer.New().Send(owner.Email, "File expiring: "+file.Filename, body); err != nil {
+				fmt.Printf("ERROR sending expiry notice for file %d: %v\n", file.ID, err)
+				continue
+			}
+		}
+		database.DB.Model(&file).UpdateColumn("expiry_notice_sent_at", time.Now())
+	}
+}
+
+// trashExpiredFiles moves files past their expiry date to the trash
+func trashExpiredFiles() {
+	var files []models.File
+	database.DB.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Find(&files)
+	for _, file := range files {
+		if err := database.DB.Delete(&file).Error; err != nil {
+			fmt.Printf("ERROR expiring file %d: %v\n", file.ID, err)
+			continue
+		}
+		audit.RecordSystem(audit.FileExpire, audit.EntityFile, file.ID, &file.WorkspaceID, file, nil)
+	}
+}
+
+// RunFileExpiry periodically notifies owners of files about to expire and moves
+// expired files to the trash
+func RunFileExpiry() {
+	for range time.Tick(fileExpiryInterval) {
+		sendExpiryNotices()
+		trashExpiredFiles()
+	}
+}
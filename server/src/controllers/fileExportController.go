@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+// fileOverlays loads the drawings of a file that are on its pages, including
+// updates still held back by coalescing
+func fileOverlays(file models.File) []pdf.Overlay {
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.FileID == file.ID })
+	var drawings []models.Drawing
+	database.DB.Where("file_id = ? AND NOT orphaned", file.ID).Order("id").Find(&drawings)
+	overlays := make([]pdf.Overlay, 0, len(drawings))
+	for _, drawing := range drawings {
+		overlays = append(overlays, pdf.Overlay{
+			ID:       drawing.ID,
+			Modified: drawing.UpdatedAt,
+			Page:     drawing.PageNumber,
+			Type:     drawing.Type,
+			Data:     drawing.Data,
+			Image:    drawing.Image,
+		})
+	}
+	return overlays
+}
+
+// flattenDrawings writes the file at path to dst with the drawings of file
+// drawn into its pages. workDir holds the drawings in between.
+func flattenDrawings(file models.File, path, workDir, dst string) error {
+	return drawOverlays(file, fileOverlays(file), path, workDir, dst)
+}
+
+// drawOverlays writes the file at path to dst with overlays, the drawings of
+// file, drawn into its pages
+func drawOverlays(file models.File, overlays []pdf.Overlay, path, workDir, dst string) error {
+	// Stored page sizes may predate recording page rotation
+	info, err := pdf.Info(path)
+	if err != nil {
+		return err
+	}
+
+	overlayPath := filepath.Join(workDir, "drawings.pdf")
+	skipped, err := pdf.WriteOverlays(overlayPath, info.PageSizes, overlays)
+	if skipped > 0 {
+		fmt.Printf("ERROR %d drawings of file %d have unreadable data and were left out of the export\n", skipped, file.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return pdf.StampPages(path, overlayPath, dst)
+}
+
+// ExportFlattened - Download a file with its drawings drawn into the page
+// content, so readers without the viewer see them. Orphaned drawings are left
+// out. Exports are cached until the drawings change. With ?async=true the
+// export is queued as a job, downloaded from the job once done.
+func ExportFlattened(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err == nil && file.Quarantined() {
+		status, err = fiber.StatusLocked, errQuarantined
+	}
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if c.QueryBool("async") {
+		return sendQueuedJob(c, "flatten", flattenJobParams{FileID: file.ID})
+	}
+
+	key, err := cachedFlattened(file)
+	if err != nil {
+		fmt.Printf("ERROR flattening drawings of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export file",
+		})
+	}
+	return sendStoredContent(c, key, derivedFilename(file, "flattened"))
+}
+
+// ExportXFDF - Download the drawings of a file as XFDF annotations, which
+// Acrobat and Bluebeam import into the PDF. Image drawings have no
+// equivalent and are left out; the X-Skipped-Drawings header counts them.
+func ExportXFDF(c *fiber.Ctx) error {
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	overlays := fileOverlays(file)
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+
+	var xfdf bytes.Buffer
+	skipped, err := pdf.WriteXFDF(&xfdf, file.Filename, info.PageSizes, overlays)
+	if err != nil {
+		fmt.Printf("ERROR exporting annotations of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export annotations",
+		})
+	}
+
+	c.Set("X-Skipped-Drawings", strconv.Itoa(skipped))
+	c.Attachment(strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)) + ".xfdf")
+	c.Set(fiber.HeaderContentType, "application/vnd.adobe.xfdf")
+	return c.Send(xfdf.Bytes())
+}
+
+type flattenJobParams struct {
+	FileID uint `json:"fileId"`
+}
+
+// runFlattenJob flattens the drawings of a file into a download kept with the
+// job
+func runFlattenJob(run *jobRun) (interface{}, error) {
+	var params flattenJobParams
+	if err := run.params(&params); err != nil {
+		return nil, err
+	}
+	var file models.File
+	if err := database.DB.Where("workspace_id = ?", run.job.WorkspaceID).First(&file, params.FileID).Error; err != nil {
+		return nil, errJobFileGone
+	}
+	run.progress(10)
+
+	cached, err := cachedFlattened(file)
+	if err != nil {
+		return nil, err
+	}
+	path, cleanup, err := storage.LocalPath(cached)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	// The job keeps a copy, as the cache may evict its own. It goes with the
+	// file's content.
+	key := storage.DerivedKey(file.Hash, fmt.Sprintf("job-%d.pdf", run.job.ID))
+	if err := run.saveDownload(path, key, derivedFilename(file, "flattened")); err != nil {
+		return nil, err
+	}
+	return fiber.Map{"source": file.ID}, nil
+}
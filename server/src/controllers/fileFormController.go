@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+// fillFormRequest is the body of a form filling request
+type fillFormRequest struct {
+	Values map[string]interface{} `json:"values"`
+	// Flatten bakes the values into the page content so they can't be edited
+	Flatten bool `json:"flatten"`
+	// SaveAsFile stores the result as a new file instead of streaming it back
+	SaveAsFile bool `json:"saveAsFile"`
+}
+
+// formFieldValue converts a JSON value into the string pdftk expects for the field
+func formFieldValue(field pdf.FormField, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		if field.Type != pdf.FieldTypeText {
+			return "", fmt.Errorf("field %q doesn't accept numbers", field.Name)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if field.Type != pdf.FieldTypeButton {
+			return "", fmt.Errorf("field %q doesn't accept booleans", field.Name)
+		}
+		if !v {
+			return "Off", nil
+		}
+		// A checked box takes its first non-Off state
+		for _, option := range field.Options {
+			if option != "Off" {
+				return option, nil
+			}
+		}
+		return "Yes", nil
+	default:
+		return "", fmt.Errorf("field %q has unsupported value type %T", field.Name, value)
+	}
+}
+
+// GetFormFields - List the AcroForm fields of a file
+func GetFormFields(c *fiber.Ctx) error {
+	fmt.Println("GetFormFields")
+
+	file, srcPath, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The form of an encrypted file can't be read, decrypt the file first",
+		})
+	}
+
+	fields, err := pdf.FormFields(srcPath)
+	if err != nil {
+		fmt.Printf("ERROR reading form fields: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read form fields",
+		})
+	}
+
+	if fields == nil {
+		fields = []pdf.FormField{}
+	}
+	return c.JSON(fields)
+}
+
+// FillFormFields - Fill the AcroForm fields of a file and return the filled PDF,
+// or with saveAsFile store it as a new file next to the original
+func FillFormFields(c *fiber.Ctx) error {
+	fmt.Println("FillFormFields")
+
+	var request fillFormRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to parse form data: %v", err),
+		})
+	}
+
+	if len(request.Values) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No field values provided",
+		})
+	}
+
+	file, srcPath, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The form of an encrypted file can't be filled, decrypt the file first",
+		})
+	}
+
+	fields, err := pdf.FormFields(srcPath)
+	if err != nil {
+		fmt.Printf("ERROR reading form fields: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read form fields",
+		})
+	}
+
+	fieldsByName := make(map[string]pdf.FormField, len(fields))
+	for _, field := range fields {
+		fieldsByName[field.Name] = field
+	}
+
+	// Validate names and value types before running the tool
+	values := make(map[string]string, len(request.Values))
+	for name, raw := range request.Values {
+		field, ok := fieldsByName[name]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Unknown form field %q", name),
+			})
+		}
+
+		value, err := formFieldValue(field, raw)
+		if err == nil {
+			err = pdf.ValidateFieldValue(field, value)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		values[name] = value
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare form filling",
+		})
+	}
+
+	filename := derivedFilename(file, "filled")
+	outPath := filepath.Join(workDir, filename)
+	if err := pdf.FillForm(srcPath, outPath, values, request.Flatten); err != nil {
+		os.RemoveAll(workDir)
+		fmt.Printf("ERROR filling form: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fill form",
+		})
+	}
+
+	if !request.SaveAsFile {
+		return sendWorkDirFile(c, workDir, outPath, filename)
+	}
+
+	defer os.RemoveAll(workDir)
+	return saveOperationResult(c, outPath, filename, folderByID(file.FolderID), fiber.Map{
+		"operation": "fillForm",
+		"source":    file.ID,
+		"fields":    len(values),
+		"flatten":   request.Flatten,
+	})
+}
@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/scanner"
+	"pdfsrv/src/storage"
+)
+
+// storedFileKey returns the storage key of a file's content
+func storedFileKey(file models.File) string {
+	if file.StorageKey != "" {
+		return file.StorageKey
+	}
+	return storage.Key(file.Hash, file.Filename)
+}
+
+// errQuarantined is returned when a file's content is withheld until it passes a virus scan
+var errQuarantined = errors.New("File is quarantined until it passes a virus scan")
+
+// localStoredFile returns a local path with the file's content for command-line
+// tools. The returned cleanup must always be called.
+func localStoredFile(file models.File) (string, func(), error) {
+	if file.Quarantined() {
+		return "", func() {}, errQuarantined
+	}
+	return storage.LocalPath(storedFileKey(file))
+}
+
+// storedFileError responds to a failure of localStoredFile
+func storedFileError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errQuarantined) {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	fmt.Printf("ERROR reading stored file: %v\n", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to read file",
+	})
+}
+
+// sendStoredFile streams a file's content as an attachment
+func sendStoredFile(c *fiber.Ctx, file models.File) error {
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+	return sendStoredContent(c, storedFileKey(file), file.Filename)
+}
+
+// sendStoredContent streams stored content as an attachment with the given
+// name. A single byte range can be requested with Range, so viewers can fetch
+// parts of large documents and interrupted downloads can resume.
+func sendStoredContent(c *fiber.Ctx, key, filename string) error {
+	// Content is stored by hash, which makes a strong validator; what was
+	// derived from it is told apart by name
+	etag := storage.HashOf(key)
+	if _, name, derived := strings.Cut(key, "/.derived/"); derived {
+		etag += "-" + strings.ReplaceAll(name, "/", "-")
+	}
+	etag = `"` + etag + `"`
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderETag, etag)
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	// A range of other content than the client has is answered in full
+	if byteRange := c.Get(fiber.HeaderRange); byteRange != "" {
+		if ifRange := c.Get(fiber.HeaderIfRange); ifRange == "" || ifRange == etag {
+			return sendStoredRange(c, key, filename, byteRange)
+		}
+	}
+
+	r, size, err := storage.Store.Get(key)
+	if err != nil {
+		return storedContentError(c, key, err)
+	}
+
+	c.Attachment(filename)
+	return c.SendStream(r, int(size))
+}
+
+// sendStoredRange streams a byte range of stored content. Ranges that can't be
+// parsed or name several parts are ignored and the whole content is sent.
+func sendStoredRange(c *fiber.Ctx, key, filename, byteRange string) error {
+	size, err := storage.Store.Size(key)
+	if err != nil {
+		return storedContentError(c, key, err)
+	}
+
+	start, length, err := parseByteRange(byteRange, size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+		return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		start, length = 0, size
+	}
+
+	r, err := storage.Store.GetRange(key, start, length)
+	if err != nil {
+		return storedContentError(c, key, err)
+	}
+
+	c.Attachment(filename)
+	if length < size {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		c.Status(fiber.StatusPartialContent)
+	}
+	return c.SendStream(r, int(length))
+}
+
+// storedContentError responds to a failure opening stored content
+func storedContentError(c *fiber.Ctx, key string, err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "File content is missing",
+		})
+	}
+	fmt.Printf("ERROR reading stored content %s: %v\n", key, err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to read file",
+	})
+}
+
+var (
+	errInvalidRange        = errors.New("Invalid range")
+	errRangeNotSatisfiable = errors.New("Range is outside the file")
+)
+
+// parseByteRange reads a single range of a Range header, bytes=first-last,
+// bytes=first- or bytes=-suffixLength, and returns its start and length
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, errInvalidRange
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errInvalidRange
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		requested, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || requested < start {
+			return 0, 0, errInvalidRange
+		}
+		end = min(end, requested)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// newWorkDir creates a scratch directory for intermediate files
+func newWorkDir() (string, error) {
+	return os.MkdirTemp("", "pdfsrv-")
+}
+
+// hashFile calculates the SHA-256 hash of a file on disk
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// errNotPDF is returned when stored content isn't a PDF document
+var errNotPDF = errors.New("Only PDF documents can be uploaded")
+
+// receiveUpload streams an uploaded file into dir and hashes it on the way, so
+// the content is read only once. It returns the path and SHA-256 of the copy.
+func receiveUpload(header *multipart.FileHeader, dir string) (string, string, error) {
+	src, err := header.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+	return receiveStream(src, dir)
+}
+
+// receiveStream copies content into dir like receiveUpload
+func receiveStream(src io.Reader, dir string) (string, string, error) {
+	path := filepath.Join(dir, "upload.pdf")
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hasher), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return path, fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// storeLocalFile inspects and stores a file from the server's disk whose hash
+// is known and returns a File with its content fields set. The caller owns the
+// reference to the stored content and must release it if the file isn't saved.
+func storeLocalFile(path, filename, fileHash string) (models.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	isPDF, err := pdf.IsPDF(path)
+	if err != nil {
+		return models.File{}, err
+	}
+	if !isPDF {
+		return models.File{}, errNotPDF
+	}
+
+	file := models.File{
+		Filename: filename,
+		Hash:     fileHash,
+		Size:     info.Size(),
+	}
+	readDocumentInfo(&file, path)
+	if scanner.Enabled() {
+		file.ScanStatus = models.ScanPending
+	}
+
+	file.StorageKey, err = storeContent(path, fileHash, filename, info.Size())
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to store file: %v", err)
+	}
+	return file, nil
+}
+
+// saveLocalFile stores a file from the server's disk whose hash is known and
+// creates its database record owned by the caller in the current workspace,
+// inside folder unless it is nil. The file must fit the size limit and the
+// caller's quota.
+func saveLocalFile(c *fiber.Ctx, path, filename, fileHash string, folder *models.Folder) (models.File, error) {
+	return saveOwnedFile(path, filename, fileHash, middleware.CurrentClaims(c).UserID(), middleware.CurrentWorkspace(c).ID, folder)
+}
+
+// saveOwnedFile is saveLocalFile for a given owner and workspace, for files
+// saved outside of a request
+func saveOwnedFile(path, filename, fileHash string, ownerID, workspaceID uint, folder *models.Folder) (models.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.File{}, err
+	}
+	if err := checkFileSize(ownerID, fileTypePDF, info.Size()); err != nil {
+		return models.File{}, err
+	}
+
+	file, err := storeLocalFile(path, filename, fileHash)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	file.OwnerID = &ownerID
+	file.UploadedByID = &ownerID
+	file.WorkspaceID = workspaceID
+	if folder != nil {
+		file.FolderID = &folder.ID
+		if folder.FileTTLDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, folder.FileTTLDays)
+			file.ExpiresAt = &expiresAt
+		}
+	}
+
+	if err := database.DB.Create(&file).Error; err != nil {
+		storage.Release(file.Hash)
+		return models.File{}, fmt.Errorf("failed to save file record: %v", err)
+	}
+	queueScan(file)
+	queueTextIndex(file)
+	return file, nil
+}
+
+// saveGeneratedFile stores a file produced on the server and creates its
+// database record owned by the caller in the current workspace and folder
+func saveGeneratedFile(c *fiber.Ctx, path, filename string, folder *models.Folder) (models.File, error) {
+	fileHash, err := hashFile(path)
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to calculate file hash: %v", err)
+	}
+	return saveLocalFile(c, path, filename, fileHash, folder)
+}
+
+// readDocumentInfo fills in the file's page count, page sizes, document
+// information and whether it is encrypted. Files pdftk can't read are stored
+// without the information.
+func readDocumentInfo(file *models.File, path string) {
+	encrypted, err := pdf.IsEncrypted(path)
+	if err != nil {
+		fmt.Printf("ERROR checking encryption of %s: %v\n", file.Filename, err)
+	}
+	file.Encrypted = encrypted
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading document info of %s: %v\n", file.Filename, err)
+		return
+	}
+	file.PageCount = info.PageCount
+	file.PageSizes = info.PageSizes
+	file.Title = info.Title
+	file.Author = info.Author
+	file.Producer = info.Producer
+}
+
+// storeContent adds a reference to content already stored with the same hash,
+// or stores it, and returns its key
+func storeContent(path, fileHash, filename string, size int64) (string, error) {
+	if key, ok, err := storage.AddRef(fileHash); err != nil || ok {
+		return key, err
+	}
+
+	key := storage.Key(fileHash, filename)
+	if err := storage.PutFile(key, path); err != nil {
+		return "", err
+	}
+	if err := storeThumbnail(path, fileHash); err != nil {
+		fmt.Printf("ERROR creating thumbnail of %s: %v\n", filename, err)
+	}
+	registered, err := storage.Register(fileHash, key, size)
+	if err != nil {
+		storage.Store.Delete(key)
+		return "", err
+	}
+	if registered != key {
+		// The same content was stored concurrently under another name
+		storage.Store.Delete(key)
+	}
+	return registered, nil
+}
+
+// workDirFile is a file inside a scratch directory that removes the whole
+// directory once the response has been streamed and the file is closed
+type workDirFile struct {
+	*os.File
+	dir string
+}
+
+func (f *workDirFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
+
+// sendWorkDirFile streams a file from a scratch directory as an attachment and
+// removes the directory afterwards
+func sendWorkDirFile(c *fiber.Ctx, dir, path, filename string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		os.RemoveAll(dir)
+		return err
+	}
+
+	c.Attachment(filepath.Base(filename))
+	return c.SendStream(&workDirFile{File: f, dir: dir}, int(info.Size()))
+}
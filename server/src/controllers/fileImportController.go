@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/middleware"
+)
+
+const (
+	importTimeout      = 2 * time.Minute
+	importMaxRedirects = 5
+)
+
+// importContentTypes are the content types a remote PDF may be served with;
+// the content itself is checked when it is stored
+var importContentTypes = map[string]bool{
+	"":                         true,
+	"application/pdf":          true,
+	"application/x-pdf":        true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+var errImportAddress = errors.New("URL points to a private or local address")
+
+type importFileRequest struct {
+	URL string `json:"url"`
+	// Filename defaults to the name the server suggests or the last path segment
+	Filename string `json:"filename"`
+	FolderID uint   `json:"folderId"`
+}
+
+// importDialControl refuses connections to loopback, private and link-local
+// addresses, so imports can't reach the server's own network. It runs after
+// name resolution, which also covers redirects and DNS rebinding.
+// IMPORT_ALLOW_PRIVATE=true lifts the restriction.
+func importDialControl(network, address string, _ syscall.RawConn) error {
+	if os.Getenv("IMPORT_ALLOW_PRIVATE") == "true" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errImportAddress
+	}
+	return nil
+}
+
+var importClient = &http.Client{
+	Timeout: importTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: importDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= importMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to an unsupported scheme")
+		}
+		return nil
+	},
+}
+
+// importFilename picks the name of an imported file
+func importFilename(requested string, resp *http.Response) (string, error) {
+	if requested != "" {
+		return sanitizeFilename(requested)
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentDisposition)); err == nil {
+		if name, err := sanitizeFilename(path.Base(params["filename"])); err == nil {
+			return name, nil
+		}
+	}
+	if name, err := sanitizeFilename(path.Base(resp.Request.URL.Path)); err == nil {
+		if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+			name += ".pdf"
+		}
+		return name, nil
+	}
+	return "download.pdf", nil
+}
+
+// ImportFile - Create a file from a PDF the server downloads from an http or
+// https URL. The download is bounded by the PDF size limit, the caller's quota
+// and a timeout.
+func ImportFile(c *fiber.Ctx) error {
+	fmt.Println("ImportFile")
+
+	var request importFileRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse import request",
+		})
+	}
+	source, err := url.Parse(strings.TrimSpace(request.URL))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "url must be an http or https URL",
+		})
+	}
+
+	folder, err := findOptionalFolder(c, request.FolderID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source.String(), nil)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "url must be an http or https URL",
+		})
+	}
+	req.Header.Set(fiber.HeaderAccept, "application/pdf")
+
+	resp, err := importClient.Do(req)
+	if err != nil {
+		fmt.Printf("ERROR importing %s: %v\n", source.Redacted(), err)
+		message := "Failed to download the file"
+		if errors.Is(err, errImportAddress) {
+			message = errImportAddress.Error()
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("The remote server responded with %s", resp.Status),
+		})
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentType))
+	if !importContentTypes[strings.ToLower(contentType)] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": errNotPDF.Error(),
+		})
+	}
+	filename, err := importFilename(request.Filename, resp)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The announced length is checked up front, the actual one while reading
+	ownerID := middleware.CurrentClaims(c).UserID()
+	if resp.ContentLength > 0 {
+		if err := checkFileSize(ownerID, fileTypePDF, resp.ContentLength); err != nil {
+			return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	limit := maxFileSize(fileTypePDF)
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save file",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	filePath, fileHash, err := receiveStream(io.LimitReader(resp.Body, limit+1), workDir)
+	if err != nil {
+		fmt.Printf("ERROR downloading %s: %v\n", source.Redacted(), err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to download the file",
+		})
+	}
+
+	record, err := saveLocalFile(c, filePath, filename, fileHash, folder)
+	if errors.Is(err, errNotPDF) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR storing imported file: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+	audit.Record(c, audit.FileUpload, audit.EntityFile, record.ID, nil, fiber.Map{
+		"importedFrom": source.Redacted(),
+		"file":         record,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(record)
+}
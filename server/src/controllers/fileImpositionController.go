@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+const (
+	defaultImposeMargin = 18
+	maxImposeMargin     = 144
+)
+
+type imposeRequest struct {
+	// Layout is booklet, 2up or 4up
+	Layout string `json:"layout"`
+	// Paper names a size like a4 or letter, a4 by default
+	Paper string `json:"paper"`
+	// Orientation is portrait or landscape; booklets and 2up print landscape
+	// and 4up portrait by default
+	Orientation string `json:"orientation"`
+	// Margin around and between the pages in points, 18 by default
+	Margin *float64 `json:"margin"`
+}
+
+// imposition checks the request and returns the layout it asks for
+func (r imposeRequest) imposition() (pdf.Imposition, error) {
+	imposition := pdf.Imposition{Layout: r.Layout, Margin: defaultImposeMargin}
+	if r.Layout != pdf.LayoutBooklet && r.Layout != pdf.LayoutTwoUp && r.Layout != pdf.LayoutFourUp {
+		return imposition, fmt.Errorf("layout must be %s, %s or %s", pdf.LayoutBooklet, pdf.LayoutTwoUp, pdf.LayoutFourUp)
+	}
+	if r.Paper == "" {
+		r.Paper = "a4"
+	}
+	paper, ok := pdf.PaperSizes[strings.ToLower(r.Paper)]
+	if !ok {
+		return imposition, fmt.Errorf("unknown paper size %q", r.Paper)
+	}
+	if r.Orientation == "" {
+		r.Orientation = "landscape"
+		if r.Layout == pdf.LayoutFourUp {
+			r.Orientation = "portrait"
+		}
+	}
+	switch r.Orientation {
+	case "portrait":
+		imposition.Width, imposition.Height = paper[0], paper[1]
+	case "landscape":
+		imposition.Width, imposition.Height = paper[1], paper[0]
+	default:
+		return imposition, errors.New("orientation must be portrait or landscape")
+	}
+	if r.Margin != nil {
+		if *r.Margin < 0 || *r.Margin > maxImposeMargin {
+			return imposition, fmt.Errorf("margin must be between 0 and %d points", maxImposeMargin)
+		}
+		imposition.Margin = *r.Margin
+	}
+	rows := 1.0
+	if r.Layout == pdf.LayoutFourUp {
+		rows = 2
+	}
+	// Pages should get at least an inch
+	if imposition.Width-3*imposition.Margin < 144 || imposition.Height-(rows+1)*imposition.Margin < 72*rows {
+		return imposition, errors.New("the margin leaves no room for the pages")
+	}
+	return imposition, nil
+}
+
+// ImposeFile - Lay the pages of a file out for printing as a new file: as a
+// booklet, folded in the middle of sheets printed on both sides, or with 2
+// or 4 pages to a sheet
+func ImposeFile(c *fiber.Ctx) error {
+	fmt.Println("ImposeFile")
+
+	var request imposeRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse imposition settings",
+		})
+	}
+	imposition, err := request.imposition()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Encrypted files can't be imposed, decrypt the file first",
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare imposition",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "imposed.pdf")
+	sheets, err := pdf.Impose(path, outPath, imposition)
+	if err != nil {
+		fmt.Printf("ERROR imposing file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to impose the file",
+		})
+	}
+
+	return saveOperationResult(c, outPath, derivedFilename(file, imposition.Layout), folderByID(file.FolderID), fiber.Map{
+		"operation": "impose",
+		"source":    file.ID,
+		"layout":    imposition.Layout,
+		"sheets":    sheets,
+	})
+}
+
+const (
+	defaultContactPages = 12
+	maxContactPages     = 100
+)
+
+type contactSheetRequest struct {
+	// PerSheet is how many pages each sheet shows, 12 by default
+	PerSheet int `json:"perSheet"`
+	// Paper names a size like a3, a4 by default
+	Paper string `json:"paper"`
+	// Orientation is portrait or landscape, landscape by default
+	Orientation string   `json:"orientation"`
+	Margin      *float64 `json:"margin"`
+	// Pages like "1,3-5" are shown; empty shows every page
+	Pages string `json:"pages"`
+	// Labels prints the page number under each page
+	Labels bool `json:"labels"`
+	// Drawings draws the file's drawings onto its pages
+	Drawings bool `json:"drawings"`
+}
+
+// ContactSheet - Lay many pages of a file out small on each sheet of a new
+// file, for reviewing a long drawing set at a glance
+func ContactSheet(c *fiber.Ctx) error {
+	fmt.Println("ContactSheet")
+
+	var request contactSheetRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse contact sheet settings",
+		})
+	}
+	if request.PerSheet == 0 {
+		request.PerSheet = defaultContactPages
+	}
+	if request.PerSheet < 1 || request.PerSheet > maxContactPages {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("perSheet must be between 1 and %d", maxContactPages),
+		})
+	}
+	if request.Orientation == "" {
+		request.Orientation = "landscape"
+	}
+	// The paper and margin are checked like a 2up imposition
+	imposition, err := imposeRequest{
+		Layout:      pdf.LayoutTwoUp,
+		Paper:       request.Paper,
+		Orientation: request.Orientation,
+		Margin:      request.Margin,
+	}.imposition()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// Cells come out about as wide as high on the sheet
+	columns := int(math.Ceil(math.Sqrt(float64(request.PerSheet) * imposition.Width / imposition.Height)))
+	columns = min(columns, request.PerSheet)
+	contact := pdf.ContactSheet{
+		Width:   imposition.Width,
+		Height:  imposition.Height,
+		Columns: columns,
+		Rows:    (request.PerSheet + columns - 1) / columns,
+		Margin:  imposition.Margin,
+		Labels:  request.Labels,
+	}
+
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Encrypted files can't be laid out, decrypt the file first",
+		})
+	}
+	if strings.TrimSpace(request.Pages) != "" {
+		ranges, err := pdf.ParsePageRanges(request.Pages, file.PageCount)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		contact.Pages = pdf.PageSet(ranges)
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare contact sheet",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	if request.Drawings {
+		drawn := filepath.Join(workDir, "drawn.pdf")
+		if err := flattenDrawings(file, path, workDir, drawn); err != nil {
+			fmt.Printf("ERROR flattening drawings of file %d: %v\n", file.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to draw the drawings",
+			})
+		}
+		path = drawn
+	}
+
+	outPath := filepath.Join(workDir, "contact-sheet.pdf")
+	sheets, err := pdf.WriteContactSheet(path, outPath, contact)
+	if err != nil {
+		fmt.Printf("ERROR writing contact sheet of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to lay out the contact sheet",
+		})
+	}
+
+	return saveOperationResult(c, outPath, derivedFilename(file, "contact-sheet"), folderByID(file.FolderID), fiber.Map{
+		"operation": "contactSheet",
+		"source":    file.ID,
+		"perSheet":  request.PerSheet,
+		"pages":     request.Pages,
+		"sheets":    sheets,
+	})
+}
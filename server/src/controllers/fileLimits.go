@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// File types with their own size limit
+const (
+	fileTypePDF = "pdf"
+	// fileTypeImage covers images uploaded to be put into PDFs
+	fileTypeImage = "image"
+)
+
+// fileSizeLimits names the variable setting each type's limit and its default
+var fileSizeLimits = map[string]struct {
+	env      string
+	fallback int64
+}{
+	fileTypePDF:   {env: "MAX_PDF_SIZE", fallback: 1000 << 20},
+	fileTypeImage: {env: "MAX_IMAGE_SIZE", fallback: 20 << 20},
+}
+
+var (
+	errFileTooLarge  = errors.New("File exceeds the maximum size")
+	errQuotaExceeded = errors.New("Storage quota exceeded")
+)
+
+// parseByteSize reads sizes like 1048576, 512KB, 100MB or 2GB (powers of 1024)
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if number, found := strings.CutSuffix(value, unit.suffix); found {
+			value, multiplier = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return number * multiplier, nil
+}
+
+// byteSizeEnv reads a size from an environment variable
+func byteSizeEnv(name string, fallback int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	size, err := parseByteSize(value)
+	if err != nil {
+		fmt.Printf("ERROR invalid %s %q, using %d\n", name, value, fallback)
+		return fallback
+	}
+	return size
+}
+
+// maxFileSize returns the size limit of a file type
+func maxFileSize(fileType string) int64 {
+	limit := fileSizeLimits[fileType]
+	return byteSizeEnv(limit.env, limit.fallback)
+}
+
+// userQuota returns how many bytes the user may store, 0 meaning no limit.
+// USER_QUOTA applies unless an admin set a quota for the user.
+func userQuota(user models.User) int64 {
+	if user.QuotaBytes != nil {
+		return *user.QuotaBytes
+	}
+	return byteSizeEnv("USER_QUOTA", 0)
+}
+
+// checkFileSize rejects content of the type that is too large to store or
+// doesn't fit the owner's quota
+func checkFileSize(ownerID uint, fileType string, size int64) error {
+	if size > maxFileSize(fileType) {
+		return errFileTooLarge
+	}
+
+	var owner models.User
+	if err := database.DB.First(&owner, ownerID).Error; err != nil {
+		return nil
+	}
+	quota := userQuota(owner)
+	if quota > 0 && storageByOwner([]uint{ownerID})[ownerID].UsedBytes+size > quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// fileSizeErrorStatus maps the errors of checkFileSize to a response status
+func fileSizeErrorStatus(err error) int {
+	if errors.Is(err, errQuotaExceeded) {
+		return fiber.StatusInsufficientStorage
+	}
+	return fiber.StatusRequestEntityTooLarge
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+var errFileNotLocked = errors.New("File is not checked out")
+
+// fileLockedError names who holds the lock on a file
+func fileLockedError(file models.File) error {
+	holder := "another user"
+	var user models.User
+	if file.LockedByID != nil && database.DB.First(&user, *file.LockedByID).Error == nil {
+		holder = user.Username
+	}
+	if file.LockedAt == nil {
+		return fmt.Errorf("File is checked out by %s", holder)
+	}
+	return fmt.Errorf("File is checked out by %s since %s", holder, file.LockedAt.UTC().Format(time.RFC3339))
+}
+
+// checkFileLock reports 423 Locked when someone other than the caller has
+// checked the file out
+func checkFileLock(c *fiber.Ctx, file models.File) (int, error) {
+	if file.LockedByID == nil || *file.LockedByID == middleware.CurrentClaims(c).UserID() {
+		return 0, nil
+	}
+	return fiber.StatusLocked, fileLockedError(file)
+}
+
+// findEditableFile loads a file the caller may write and revise, which it
+// can't while someone else has it checked out
+func findEditableFile(c *fiber.Ctx, id interface{}) (models.File, int, error) {
+	file, status, err := findAccessibleFile(c, id, models.PermissionWrite)
+	if err != nil {
+		return file, status, err
+	}
+	if status, err := checkFileLock(c, file); err != nil {
+		return file, status, err
+	}
+	return file, 0, nil
+}
+
+// LockFile - Check a file out, so only the caller can upload new versions and
+// edit its drawings until it is unlocked
+func LockFile(c *fiber.Ctx) error {
+	fmt.Println("LockFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Taking the lock and checking it's free is one statement, so two users
+	// can't both check the file out
+	before := file
+	userID := middleware.CurrentClaims(c).UserID()
+	result := database.DB.Model(&models.File{}).
+		Where("id = ? AND (locked_by_id IS NULL OR locked_by_id = ?)", file.ID, userID).
+		UpdateColumns(map[string]interface{}{
+			"locked_by_id": userID,
+			"locked_at":    time.Now(),
+		})
+	if result.Error != nil {
+		fmt.Printf("ERROR locking file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check out file",
+		})
+	}
+	database.DB.First(&file, file.ID)
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error":      fileLockedError(file).Error(),
+			"lockedById": file.LockedByID,
+			"lockedAt":   file.LockedAt,
+		})
+	}
+	audit.Record(c, audit.FileLock, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
+
+// UnlockFile - Check a file back in. The lock holder may release it, and the
+// file owner or an admin may break someone else's lock.
+func UnlockFile(c *fiber.Ctx) error {
+	fmt.Println("UnlockFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.LockedByID == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": errFileNotLocked.Error(),
+		})
+	}
+	claims := middleware.CurrentClaims(c)
+	if *file.LockedByID != claims.UserID() && !isFileOwner(claims, file) {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error":      fileLockedError(file).Error(),
+			"lockedById": file.LockedByID,
+			"lockedAt":   file.LockedAt,
+		})
+	}
+
+	before := file
+	result := database.DB.Model(&models.File{}).
+		Where("id = ? AND locked_by_id = ?", file.ID, *file.LockedByID).
+		UpdateColumns(map[string]interface{}{
+			"locked_by_id": nil,
+			"locked_at":    nil,
+		})
+	if result.Error != nil {
+		fmt.Printf("ERROR unlocking file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check in file",
+		})
+	}
+	database.DB.First(&file, file.ID)
+	audit.Record(c, audit.FileUnlock, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+const (
+	maxFilenameLength    = 255
+	maxDescriptionLength = 4000
+	maxTagLength         = 64
+	maxTagsPerFile       = 50
+	// Limits of the metadata written into documents
+	maxDocumentInfoLength = 4000
+	maxCustomProperties   = 50
+)
+
+// fileMetadataUpdate changes the metadata of one file, as a batch entry or the
+// body of an update request. Nil fields are left untouched.
+type fileMetadataUpdate struct {
+	ID          uint      `json:"id"`
+	Filename    *string   `json:"filename"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+// fileMetadataResult reports the outcome of a single batch entry
+type fileMetadataResult struct {
+	ID      uint         `json:"id"`
+	Success bool         `json:"success"`
+	Error   string       `json:"error,omitempty"`
+	File    *models.File `json:"file,omitempty"`
+}
+
+// sanitizeFilename trims the name and rejects anything that could escape the file's directory
+func sanitizeFilename(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("filename must not be empty")
+	}
+	if len(name) > maxFilenameLength {
+		return "", fmt.Errorf("filename must not exceed %d bytes", maxFilenameLength)
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("filename contains invalid path characters")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("filename contains control characters")
+		}
+	}
+	return name, nil
+}
+
+// sanitizeTags trims, lowercases and de-duplicates tags, dropping empty ones
+func sanitizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q exceeds %d bytes", tag, maxTagLength)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) > maxTagsPerFile {
+		return nil, fmt.Errorf("a file can have at most %d tags", maxTagsPerFile)
+	}
+	return result, nil
+}
+
+// sanitizeMetadataUpdate validates the fields an update sets and normalizes them in place
+func sanitizeMetadataUpdate(update *fileMetadataUpdate) error {
+	if update.Filename != nil {
+		name, err := sanitizeFilename(*update.Filename)
+		if err != nil {
+			return err
+		}
+		*update.Filename = name
+	}
+
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if len(description) > maxDescriptionLength {
+			return fmt.Errorf("description must not exceed %d bytes", maxDescriptionLength)
+		}
+		*update.Description = description
+	}
+
+	if update.Tags != nil {
+		tags, err := sanitizeTags(*update.Tags)
+		if err != nil {
+			return err
+		}
+		*update.Tags = tags
+	}
+	return nil
+}
+
+// applyMetadataUpdate copies the fields an update sets onto the file. Content is
+// stored by hash, so renaming only touches the record.
+func applyMetadataUpdate(file *models.File, update fileMetadataUpdate) {
+	if update.Filename != nil {
+		file.Filename = *update.Filename
+	}
+	if update.Description != nil {
+		file.Description = *update.Description
+	}
+	if update.Tags != nil {
+		file.Tags = *update.Tags
+	}
+}
+
+// UpdateFile - Rename a file and edit its description or tags
+func UpdateFile(c *fiber.Ctx) error {
+	fmt.Println("UpdateFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var update fileMetadataUpdate
+	if err := c.BodyParser(&update); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse file data",
+		})
+	}
+	if err := sanitizeMetadataUpdate(&update); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before := file
+	applyMetadataUpdate(&file, update)
+	if err := database.DB.Save(&file).Error; err != nil {
+		fmt.Printf("ERROR updating file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update file",
+		})
+	}
+	audit.Record(c, audit.FileUpdate, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
+
+// UpdateFilesMetadataBatch - Apply metadata updates to several files in one transaction
+func UpdateFilesMetadataBatch(c *fiber.Ctx) error {
+	fmt.Println("UpdateFilesMetadataBatch")
+
+	var updates []fileMetadataUpdate
+	if err := c.BodyParser(&updates); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to parse metadata updates: %v", err),
+		})
+	}
+
+	if len(updates) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No updates provided",
+		})
+	}
+
+	// Validate every entry before touching anything
+	results := make([]fileMetadataResult, len(updates))
+	files := make([]models.File, len(updates))
+	before := make([]models.File, len(updates))
+	seen := make(map[uint]bool)
+	valid := true
+	for i, update := range updates {
+		results[i].ID = update.ID
+
+		if update.ID == 0 {
+			results[i].Error = "File ID is required"
+			valid = false
+			continue
+		}
+		if seen[update.ID] {
+			results[i].Error = "Duplicate file ID in batch"
+			valid = false
+			continue
+		}
+		seen[update.ID] = true
+
+		file, _, err := findAccessibleFile(c, update.ID, models.PermissionWrite)
+		if err != nil {
+			results[i].Error = err.Error()
+			valid = false
+			continue
+		}
+		files[i] = file
+		before[i] = file
+
+		if err := sanitizeMetadataUpdate(&updates[i]); err != nil {
+			results[i].Error = err.Error()
+			valid = false
+		}
+	}
+
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "One or more updates are invalid, nothing was changed",
+			"results": results,
+		})
+	}
+
+	// Apply all updates atomically
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for i, update := range updates {
+			file := &files[i]
+
+			applyMetadataUpdate(file, update)
+			if err := tx.Save(file).Error; err != nil {
+				results[i].Error = "Failed to save file metadata"
+				return fmt.Errorf("file %d: %v", file.ID, err)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("ERROR applying metadata batch: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to apply metadata updates, nothing was changed",
+			"results": results,
+		})
+	}
+
+	for i := range results {
+		results[i].Success = true
+		results[i].File = &files[i]
+		audit.Record(c, audit.FileUpdate, audit.EntityFile, files[i].ID, before[i], files[i])
+	}
+
+	return c.JSON(results)
+}
+
+// documentMetadataRequest sets the metadata stored in a document. Nil fields
+// are left as they are and empty ones are removed.
+type documentMetadataRequest struct {
+	Title    *string `json:"title"`
+	Author   *string `json:"author"`
+	Subject  *string `json:"subject"`
+	Keywords *string `json:"keywords"`
+	// Custom properties, written to the document information and as XMP
+	// properties in Adobe's pdfx namespace; an empty value removes one
+	Custom map[string]string `json:"custom"`
+}
+
+// validate checks the lengths and custom property names of the request
+func (r documentMetadataRequest) validate() error {
+	for name, value := range map[string]*string{"title": r.Title, "author": r.Author, "subject": r.Subject, "keywords": r.Keywords} {
+		if value != nil && len(*value) > maxDocumentInfoLength {
+			return fmt.Errorf("%s must not exceed %d bytes", name, maxDocumentInfoLength)
+		}
+	}
+	if len(r.Custom) > maxCustomProperties {
+		return fmt.Errorf("at most %d custom properties can be set at once", maxCustomProperties)
+	}
+	for key, value := range r.Custom {
+		if !pdf.ValidCustomKey(key) {
+			return fmt.Errorf("custom property %q must start with a letter, hold only letters, digits and underscores and not be a standard property", key)
+		}
+		if len(value) > maxDocumentInfoLength {
+			return fmt.Errorf("custom property %q must not exceed %d bytes", key, maxDocumentInfoLength)
+		}
+	}
+	if r.Title == nil && r.Author == nil && r.Subject == nil && r.Keywords == nil && len(r.Custom) == 0 {
+		return fmt.Errorf("No metadata provided")
+	}
+	return nil
+}
+
+// UpdateDocumentMetadata - Set the title, author, subject, keywords and custom
+// properties stored in the document itself, both in its document information
+// and its XMP metadata. The result is stored as the next revision of the file.
+func UpdateDocumentMetadata(c *fiber.Ctx) error {
+	fmt.Println("UpdateDocumentMetadata")
+
+	var request documentMetadataRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse metadata",
+		})
+	}
+	if err := request.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), outputVersion)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The metadata of an encrypted file can't be changed, decrypt the file first",
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare metadata update",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "metadata.pdf")
+	err = pdf.WriteMetadata(path, outPath, pdf.MetadataUpdate{
+		Title:    request.Title,
+		Author:   request.Author,
+		Subject:  request.Subject,
+		Keywords: request.Keywords,
+		Custom:   request.Custom,
+	}, time.Now())
+	if err != nil {
+		fmt.Printf("ERROR writing metadata of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to update the document metadata",
+		})
+	}
+
+	return sendOperationOutput(c, file, outPath, outputVersion, "", fiber.Map{
+		"operation": "metadata",
+		"metadata":  request,
+	})
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+type moveFileRequest struct {
+	// FolderID 0 moves the file to the top level
+	FolderID uint `json:"folderId"`
+	// WorkspaceID defaults to the current workspace
+	WorkspaceID uint `json:"workspaceId"`
+}
+
+var errFileMoved = errors.New("The file was changed by another request")
+
+// MoveFile - Move a file into another folder, optionally of another workspace
+// the caller belongs to. Group permissions don't carry over to another
+// workspace, as groups belong to one.
+func MoveFile(c *fiber.Ctx) error {
+	fmt.Println("MoveFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request moveFileRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse move request",
+		})
+	}
+	workspaceID, status, err := targetWorkspace(c, request.WorkspaceID)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var folderID *uint
+	if request.FolderID != 0 {
+		var folder models.Folder
+		if err := database.DB.Where("workspace_id = ?", workspaceID).First(&folder, request.FolderID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": errFolderNotFound.Error(),
+			})
+		}
+		folderID = &folder.ID
+	}
+
+	before := file
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// The file must still be where it was checked
+		result := tx.Model(&models.File{}).
+			Where("id = ? AND workspace_id = ?", file.ID, file.WorkspaceID).
+			Updates(map[string]interface{}{
+				"workspace_id": workspaceID,
+				"folder_id":    folderID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errFileMoved
+		}
+		if workspaceID == file.WorkspaceID {
+			return nil
+		}
+		return tx.Unscoped().Where("file_id = ?", file.ID).Delete(&models.FileGroupPermission{}).Error
+	})
+	if errors.Is(err, errFileMoved) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR moving file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to move file",
+		})
+	}
+	database.DB.First(&file, file.ID)
+	audit.Record(c, audit.FileMove, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+const (
+	maxOutlineItems = 10000
+	maxOutlineDepth = 16
+	maxOutlineTitle = 500
+)
+
+type outlineRequest struct {
+	// Outline replaces the bookmark tree, empty removes it
+	Outline []pdf.OutlineItem `json:"outline"`
+	Output  string            `json:"output"`
+}
+
+// validateOutline checks the bookmarks go to pages of the document and
+// returns how many there are
+func validateOutline(items []pdf.OutlineItem, pageCount, depth int) (int, error) {
+	if depth > maxOutlineDepth {
+		return 0, fmt.Errorf("bookmarks can be nested at most %d levels deep", maxOutlineDepth)
+	}
+	count := len(items)
+	for _, item := range items {
+		if strings.TrimSpace(item.Title) == "" || len(item.Title) > maxOutlineTitle {
+			return 0, fmt.Errorf("bookmark titles must have 1 to %d characters", maxOutlineTitle)
+		}
+		if item.Page < 0 || item.Page > pageCount {
+			return 0, fmt.Errorf("bookmark %q goes to page %d, the document has %d pages", item.Title, item.Page, pageCount)
+		}
+		children, err := validateOutline(item.Children, pageCount, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		count += children
+		if count > maxOutlineItems {
+			return 0, fmt.Errorf("an outline can have at most %d bookmarks", maxOutlineItems)
+		}
+	}
+	return count, nil
+}
+
+// GetOutline - Get the bookmark tree of a file with the page each bookmark
+// goes to, for a navigation sidebar
+func GetOutline(c *fiber.Ctx) error {
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	outline, err := pdf.Outline(path)
+	if err != nil {
+		fmt.Printf("ERROR reading outline of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the outline",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"outline": outline,
+	})
+}
+
+// UpdateOutline - Replace the bookmark tree of a file, like one bookmark for
+// each sheet after merging a drawing package. Bookmarks go to the whole of
+// their page; page 0 makes a heading without a destination.
+func UpdateOutline(c *fiber.Ctx) error {
+	fmt.Println("UpdateOutline")
+
+	var request outlineRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse outline",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The outline of an encrypted file can't be changed, decrypt the file first",
+		})
+	}
+
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page count of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	bookmarks, err := validateOutline(request.Outline, len(info.PageSizes), 1)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare outline update",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "outline.pdf")
+	if err := pdf.WriteOutline(path, outPath, request.Outline); err != nil {
+		fmt.Printf("ERROR writing outline of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to update the outline",
+		})
+	}
+
+	return sendOperationOutput(c, file, outPath, output, "outline", fiber.Map{
+		"operation": "outline",
+		"bookmarks": bookmarks,
+	})
+}
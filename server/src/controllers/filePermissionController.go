@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+type filePermissionRequest struct {
+	UserID     uint   `json:"userId"`
+	Permission string `json:"permission"`
+}
+
+// findOwnedFile loads a file the caller owns, or any file for admins
+func findOwnedFile(c *fiber.Ctx) (models.File, int, error) {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return file, status, err
+	}
+	if !isFileOwner(middleware.CurrentClaims(c), file) {
+		return file, fiber.StatusForbidden, fmt.Errorf("Only the file owner can manage its permissions")
+	}
+	return file, 0, nil
+}
+
+// GetFilePermissions - List the users a file is shared with
+func GetFilePermissions(c *fiber.Ctx) error {
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var permissions []models.FilePermission
+	database.DB.Where("file_id = ?", file.ID).Find(&permissions)
+	return c.JSON(permissions)
+}
+
+// SetFilePermission - Grant or change a user's access to a file
+func SetFilePermission(c *fiber.Ctx) error {
+	fmt.Println("SetFilePermission")
+
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request filePermissionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse permission data",
+		})
+	}
+
+	if !models.ValidPermission(request.Permission) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Permission must be read or write",
+		})
+	}
+
+	var user models.User
+	if result := database.DB.First(&user, request.UserID); result.Error != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if !auth.IsWorkspaceMember(user.ID, file.WorkspaceID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User is not a member of this workspace",
+		})
+	}
+
+	if file.OwnerID != nil && *file.OwnerID == user.ID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The owner already has full access",
+		})
+	}
+
+	permission := models.FilePermission{
+		FileID:     file.ID,
+		UserID:     user.ID,
+		Permission: request.Permission,
+	}
+	result := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission", "updated_at"}),
+	}).Create(&permission)
+	if result.Error != nil {
+		fmt.Printf("ERROR saving file permission: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save permission",
+		})
+	}
+	audit.Record(c, audit.FilePermissionSet, audit.EntityFilePermission, permission.ID, nil, permission)
+
+	return c.JSON(permission)
+}
+
+// RevokeFilePermission - Remove a user's access to a file
+func RevokeFilePermission(c *fiber.Ctx) error {
+	fmt.Println("RevokeFilePermission")
+
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var revoked []models.FilePermission
+	result := database.DB.Unscoped().Clauses(clause.Returning{}).
+		Where("file_id = ? AND user_id = ?", file.ID, c.Params("userId")).
+		Delete(&revoked)
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Permission not found",
+		})
+	}
+	audit.Record(c, audit.FilePermissionRevoke, audit.EntityFilePermission, revoked[0].ID, revoked[0], nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Permission revoked successfully",
+	})
+}
+
+type fileGroupPermissionRequest struct {
+	GroupID    uint   `json:"groupId"`
+	Permission string `json:"permission"`
+}
+
+// GetFileGroupPermissions - List the groups a file is shared with
+func GetFileGroupPermissions(c *fiber.Ctx) error {
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var permissions []models.FileGroupPermission
+	database.DB.Where("file_id = ?", file.ID).Find(&permissions)
+	return c.JSON(permissions)
+}
+
+// SetFileGroupPermission - Grant or change a group's access to a file
+func SetFileGroupPermission(c *fiber.Ctx) error {
+	fmt.Println("SetFileGroupPermission")
+
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request fileGroupPermissionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse permission data",
+		})
+	}
+
+	if !models.ValidPermission(request.Permission) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Permission must be read or write",
+		})
+	}
+
+	group, err := findWorkspaceGroup(c, request.GroupID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Group not found",
+		})
+	}
+
+	permission := models.FileGroupPermission{
+		FileID:     file.ID,
+		GroupID:    group.ID,
+		Permission: request.Permission,
+	}
+	result := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "group_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission", "updated_at"}),
+	}).Create(&permission)
+	if result.Error != nil {
+		fmt.Printf("ERROR saving file group permission: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save permission",
+		})
+	}
+	audit.Record(c, audit.FilePermissionSet, audit.EntityFileGroupPermission, permission.ID, nil, permission)
+
+	return c.JSON(permission)
+}
+
+// RevokeFileGroupPermission - Remove a group's access to a file
+func RevokeFileGroupPermission(c *fiber.Ctx) error {
+	fmt.Println("RevokeFileGroupPermission")
+
+	file, status, err := findOwnedFile(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var revoked []models.FileGroupPermission
+	result := database.DB.Unscoped().Clauses(clause.Returning{}).
+		Where("file_id = ? AND group_id = ?", file.ID, c.Params("groupId")).
+		Delete(&revoked)
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Permission not found",
+		})
+	}
+	audit.Record(c, audit.FilePermissionRevoke, audit.EntityFileGroupPermission, revoked[0].ID, revoked[0], nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Permission revoked successfully",
+	})
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+// maxRedactions bounds how many areas one request may redact
+const maxRedactions = 10000
+
+type redactRequest struct {
+	// Areas are rectangles placed like drawings, in points from the top left
+	// corner of the unrotated page
+	Areas []pdf.Redaction `json:"areas"`
+	// Search redacts every occurrence of the words in the text
+	Search string `json:"search"`
+	Output string `json:"output"`
+}
+
+// RedactFile - Remove the content of areas of a file, given as rectangles or
+// found by searching the text. Pages with redactions are replaced by images
+// of them with the areas blacked out, so nothing under them can be recovered.
+// The result is the next revision of the file, or with output "file" a new
+// file; earlier revisions keep the content, so release a new file.
+func RedactFile(c *fiber.Ctx) error {
+	fmt.Println("RedactFile")
+
+	var request redactRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse redactions",
+		})
+	}
+	request.Search = strings.TrimSpace(request.Search)
+	if len(request.Areas) == 0 && request.Search == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Give areas or a search term to redact",
+		})
+	}
+	if len(request.Areas) > maxRedactions {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("At most %d areas can be redacted at once", maxRedactions),
+		})
+	}
+	for _, area := range request.Areas {
+		if area.Width <= 0 || area.Height <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Areas need a positive width and height",
+			})
+		}
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Encrypted files can't be redacted, decrypt the file first",
+		})
+	}
+
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	for _, area := range request.Areas {
+		if area.Page < 1 || area.Page > len(info.PageSizes) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("page must be between 1 and %d", len(info.PageSizes)),
+			})
+		}
+	}
+	redactions := request.Areas
+	if request.Search != "" {
+		found, err := pdf.FindRedactions(path, request.Search, info.PageSizes)
+		if err != nil {
+			fmt.Printf("ERROR searching the text of file %d: %v\n", file.ID, err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Failed to search the text",
+			})
+		}
+		redactions = append(redactions, found...)
+	}
+	if len(redactions) == 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "The search term doesn't occur in the file",
+		})
+	}
+	pages := make(map[int]bool)
+	for _, r := range redactions {
+		pages[r.Page] = true
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare redaction",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "redacted.pdf")
+	if err := pdf.Redact(path, outPath, info.PageSizes, redactions); err != nil {
+		fmt.Printf("ERROR redacting file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to redact file",
+		})
+	}
+
+	// The search term isn't recorded, it may be what was redacted
+	return sendOperationOutput(c, file, outPath, output, "redacted", fiber.Map{
+		"operation": "redact",
+		"areas":     len(redactions),
+		"pages":     len(pages),
+	})
+}
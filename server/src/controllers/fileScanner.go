@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/scanner"
+	"pdfsrv/src/storage"
+)
+
+const (
+	// maxConcurrentScans bounds how many files are streamed to clamd at once
+	maxConcurrentScans = 2
+	// scanAttempts is how often a scan is tried before the file is left pending
+	scanAttempts   = 3
+	scanRetryDelay = 30 * time.Second
+)
+
+var scanSlots = make(chan struct{}, maxConcurrentScans)
+
+// queueScan scans a pending file's current content in the background
+func queueScan(file models.File) {
+	if file.ScanStatus != models.ScanPending {
+		return
+	}
+	go scanFile(file.ID, file.Hash, storedFileKey(file))
+}
+
+// ResumeScans queues the files still pending from before a restart
+func ResumeScans() {
+	if !scanner.Enabled() {
+		return
+	}
+	var pending []models.File
+	database.DB.Unscoped().Where("scan_status = ?", models.ScanPending).Find(&pending)
+	for _, file := range pending {
+		queueScan(file)
+	}
+}
+
+// scanFile streams stored content to clamd and applies the verdict to the file,
+// unless a newer revision was uploaded in the meantime
+func scanFile(fileID uint, hash, key string) {
+	scanSlots <- struct{}{}
+	defer func() { <-scanSlots }()
+
+	var result scanner.Result
+	var err error
+	for attempt := 1; attempt <= scanAttempts; attempt++ {
+		result, err = scanContent(key)
+		if err == nil {
+			break
+		}
+		fmt.Printf("ERROR scanning file %d (attempt %d): %v\n", fileID, attempt, err)
+		if attempt < scanAttempts {
+			time.Sleep(scanRetryDelay)
+		}
+	}
+	if err != nil {
+		// The file stays quarantined until the scan is resumed on restart
+		return
+	}
+
+	if result.Infected {
+		rejectInfectedFile(fileID, hash, result.Signature)
+		return
+	}
+	update := database.DB.Unscoped().Model(&models.File{}).
+		Where("id = ? AND hash = ? AND scan_status = ?", fileID, hash, models.ScanPending).
+		UpdateColumn("scan_status", models.ScanClean)
+	var file models.File
+	if update.RowsAffected > 0 && database.DB.First(&file, fileID).Error == nil {
+		queueTextIndex(file)
+	}
+}
+
+func scanContent(key string) (scanner.Result, error) {
+	r, _, err := storage.Store.Get(key)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	defer r.Close()
+	return scanner.Scan(r)
+}
+
+// rejectInfectedFile removes infected content. A new file is deleted, a new
+// revision is rolled back to the previous one.
+func rejectInfectedFile(fileID uint, hash, signature string) {
+	var file, before models.File
+	released, rolledBack, purge := false, false, false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Files in the trash are handled too, so they can't be restored infected
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, fileID).Error; err != nil {
+			return err
+		}
+		before = file
+
+		if file.Hash != hash {
+			// A newer revision replaced the infected one, which only lives on in the history
+			result := tx.Unscoped().Where("file_id = ? AND hash = ?", file.ID, hash).Delete(&models.FileVersion{})
+			released = result.RowsAffected > 0
+			return result.Error
+		}
+
+		var previous models.FileVersion
+		err := tx.Where("file_id = ?", file.ID).Order("version DESC").First(&previous).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// A new file is deleted for good rather than moved to the trash
+			file.ScanStatus = models.ScanInfected
+			purge = true
+			return tx.Save(&file).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		file.Hash = previous.Hash
+		file.Size = previous.Size
+		file.StorageKey = previous.StorageKey
+		file.PageCount = previous.PageCount
+		file.PageSizes = previous.PageSizes
+		file.Title = previous.Title
+		file.Author = previous.Author
+		file.Producer = previous.Producer
+		file.Encrypted = previous.Encrypted
+		file.Version = previous.Version
+		file.UploadedByID = previous.UploadedByID
+		// The previous revision may have been replaced before its scan finished
+		file.ScanStatus = models.ScanPending
+		if err := tx.Save(&file).Error; err != nil {
+			return err
+		}
+		released, rolledBack = true, true
+		return tx.Unscoped().Delete(&previous).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The file was purged while it was scanned
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR rejecting infected file %d: %v\n", fileID, err)
+		return
+	}
+	if rolledBack {
+		queueScan(file)
+	}
+	if purge {
+		if err := purgeFile(file); err != nil {
+			fmt.Printf("ERROR deleting infected file %d: %v\n", fileID, err)
+		}
+	}
+	if released {
+		if err := storage.Release(hash); err != nil {
+			fmt.Printf("ERROR deleting infected content of file %d: %v\n", fileID, err)
+		}
+	}
+	fmt.Printf("Rejected file %d: %s found\n", fileID, signature)
+	audit.RecordSystem(audit.FileInfected, audit.EntityFile, file.ID, &file.WorkspaceID, before, fiber.Map{
+		"signature": signature,
+		"file":      file,
+	})
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+const (
+	defaultFileLimit = 50
+	maxFileLimit     = 500
+)
+
+// fileSortColumns maps the sort query parameter to columns
+var fileSortColumns = map[string]string{
+	"filename":  "files.filename",
+	"size":      "files.size",
+	"pageCount": "files.page_count",
+	"createdAt": "files.created_at",
+	"updatedAt": "files.updated_at",
+	"deletedAt": "files.deleted_at",
+}
+
+// filePaging reads the limit and offset query parameters of a file list
+func filePaging(c *fiber.Ctx) (int, int, error) {
+	limit, offset := defaultFileLimit, 0
+	if param := c.Query("limit"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 || value > maxFileLimit {
+			return 0, 0, fmt.Errorf("Invalid limit, expected 1 to %d", maxFileLimit)
+		}
+		limit = value
+	}
+	if param := c.Query("offset"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value < 0 {
+			return 0, 0, errors.New("Invalid offset, expected 0 or more")
+		}
+		offset = value
+	}
+	return limit, offset, nil
+}
+
+// sendFilePage responds with one page of a files query and the total number of
+// matches. Supports limit, offset, sort (see fileSortColumns, default createdAt)
+// and order (asc or desc, default desc).
+func sendFilePage(c *fiber.Ctx, query *gorm.DB) error {
+	sort := c.Query("sort", "createdAt")
+	column, ok := fileSortColumns[sort]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid sort, expected one of filename, size, pageCount, createdAt, updatedAt or deletedAt",
+		})
+	}
+	order := strings.ToLower(c.Query("order", "desc"))
+	if order != "asc" && order != "desc" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order, expected asc or desc",
+		})
+	}
+
+	limit, offset, err := filePaging(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var total int64
+	query.Count(&total)
+
+	// The id breaks ties so pages don't overlap
+	var files []models.File
+	query.Order(column + " " + order + ", files.id " + order).Limit(limit).Offset(offset).Find(&files)
+
+	return c.JSON(fiber.Map{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"files":  files,
+	})
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchFiles - Search the accessible files. Supports the filters q (filename
+// substring), tag, uploader (username), folderId (0 for the top level), starred,
+// since and until (RFC 3339 upload time) and the paging and sorting of
+// sendFilePage.
+func SearchFiles(c *fiber.Ctx) error {
+	query := database.DB.Model(&models.File{}).Scopes(accessibleFiles(c), starredFilter(c))
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("files.filename ILIKE ?", "%"+likeEscaper.Replace(q)+"%")
+	}
+	if tag := strings.ToLower(strings.TrimSpace(c.Query("tag"))); tag != "" {
+		encoded, _ := json.Marshal([]string{tag})
+		query = query.Where("files.tags @> ?::jsonb", string(encoded))
+	}
+	if uploader := strings.TrimSpace(c.Query("uploader")); uploader != "" {
+		query = query.Where("files.owner_id IN (?)",
+			database.DB.Model(&models.User{}).Select("id").Where("username = ?", uploader))
+	}
+	if folderID := strings.TrimSpace(c.Query("folderId")); folderID == "0" {
+		query = query.Where("files.folder_id IS NULL")
+	} else if folderID != "" {
+		query = query.Where("files.folder_id = ?", folderID)
+	}
+
+	for param, condition := range map[string]string{
+		"since": "files.created_at >= ?",
+		"until": "files.created_at < ?",
+	} {
+		if value := c.Query(param); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + param + ", expected RFC 3339 time",
+				})
+			}
+			query = query.Where(condition, at)
+		}
+	}
+
+	return sendFilePage(c, query)
+}
+
+// maxSearchMatches bounds how many matching pages are listed per file
+const maxSearchMatches = 20
+
+// searchMatch is a page matching a full-text search
+type searchMatch struct {
+	Page    int    `json:"page"`
+	Snippet string `json:"snippet"`
+}
+
+// searchResult is a file matching a full-text search with its best pages
+type searchResult struct {
+	File       models.File   `json:"file"`
+	MatchCount int           `json:"matchCount"`
+	Matches    []searchMatch `json:"matches"`
+}
+
+// SearchText - Search the text of the accessible files. q takes web search
+// syntax: words, "quoted phrases", or and -excluded words. Files are ranked by
+// their best page and listed with up to 20 matching pages each, whose snippets
+// mark the matches with <b>. Supports paging with limit and offset.
+func SearchText(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "q is required",
+		})
+	}
+	limit, offset, err := filePaging(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	matching := func() *gorm.DB {
+		return database.DB.Table("page_texts").
+			Joins("JOIN files ON files.hash = page_texts.hash AND files.deleted_at IS NULL").
+			Scopes(accessibleFiles(c)).
+			Where("page_texts.search @@ websearch_to_tsquery('simple', ?)", q)
+	}
+
+	var total int64
+	matching().Distinct("files.id").Count(&total)
+
+	var ranked []struct {
+		ID   uint
+		Rank float64
+	}
+	matching().
+		Select("files.id, MAX(ts_rank(page_texts.search, websearch_to_tsquery('simple', ?))) AS rank", q).
+		Group("files.id").Order("rank DESC, files.id").Limit(limit).Offset(offset).
+		Scan(&ranked)
+
+	results := make([]searchResult, 0, len(ranked))
+	for _, match := range ranked {
+		var file models.File
+		if err := database.DB.First(&file, match.ID).Error; err != nil {
+			continue
+		}
+		var pages []searchMatch
+		database.DB.Table("page_texts").
+			Select("page, ts_headline('simple', text, websearch_to_tsquery('simple', ?), 'MaxFragments=2, MaxWords=20, MinWords=5') AS snippet", q).
+			Where("hash = ? AND search @@ websearch_to_tsquery('simple', ?)", file.Hash, q).
+			Order("page").Scan(&pages)
+
+		result := searchResult{File: file, MatchCount: len(pages), Matches: pages}
+		if len(result.Matches) > maxSearchMatches {
+			result.Matches = result.Matches[:maxSearchMatches]
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(fiber.Map{
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": results,
+	})
+}
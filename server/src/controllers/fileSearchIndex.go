@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// maxConcurrentTextIndexing bounds how many files have their text extracted at once
+const maxConcurrentTextIndexing = 2
+
+var textIndexSlots = make(chan struct{}, maxConcurrentTextIndexing)
+
+// queueTextIndex indexes the text of a file's content in the background.
+// Quarantined content is indexed once its scan passes.
+func queueTextIndex(file models.File) {
+	if file.Quarantined() {
+		return
+	}
+	go indexFileText(file)
+}
+
+// indexFileText stores the text of each page of the file's content for
+// full-text search, unless the content was indexed already
+func indexFileText(file models.File) {
+	textIndexSlots <- struct{}{}
+	defer func() { <-textIndexSlots }()
+
+	var indexed int64
+	database.DB.Model(&models.PageText{}).Where("hash = ?", file.Hash).Count(&indexed)
+	if indexed > 0 {
+		return
+	}
+
+	pages, err := fileText(file)
+	if err != nil {
+		fmt.Printf("ERROR extracting text of file %d for search: %v\n", file.ID, err)
+		return
+	}
+	rows := make([]models.PageText, 0, len(pages))
+	for i, text := range pages {
+		rows = append(rows, models.PageText{Hash: file.Hash, Page: i + 1, Text: text})
+	}
+	if len(rows) == 0 {
+		return
+	}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, 100).Error; err != nil {
+		fmt.Printf("ERROR indexing text of file %d: %v\n", file.ID, err)
+		return
+	}
+
+	// Scans have no text layer; OCR replaces the empty pages once it's done
+	if ocrAutomatic() && !hasText(pages) {
+		if _, err := startOCR(file, ocrLanguage(), false, nil); err != nil {
+			fmt.Printf("ERROR starting OCR of file %d: %v\n", file.ID, err)
+		}
+	}
+}
+
+// hasText reports whether any page has text
+func hasText(pages []string) bool {
+	for _, text := range pages {
+		if strings.TrimSpace(text) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexMissingText indexes the files whose content has no text indexed yet,
+// such as files uploaded before search existed
+func IndexMissingText() {
+	var files []models.File
+	database.DB.
+		Where("hash NOT IN (?)", database.DB.Model(&models.PageText{}).Distinct("hash")).
+		Where("scan_status IN ?", []string{"", models.ScanClean}).
+		Find(&files)
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if seen[file.Hash] {
+			continue
+		}
+		seen[file.Hash] = true
+		indexFileText(file)
+	}
+}
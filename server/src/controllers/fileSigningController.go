@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/signing"
+)
+
+type signRequest struct {
+	// Page shows the signature on a page, counted from 1; 0 or leaving it
+	// out signs without showing anything
+	Page int `json:"page"`
+	// X, Y, Width and Height place the signature like drawings, in points
+	// from the top left corner of the unrotated page
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Width    float64 `json:"width"`
+	Height   float64 `json:"height"`
+	Reason   string  `json:"reason"`
+	Location string  `json:"location"`
+	Output   string  `json:"output"`
+}
+
+// SignFile - Sign a file with a PAdES signature made with the configured
+// certificate, either from a PKCS#12 file or held by a remote signing
+// service. With a page the signature is shown in a box on that page. The
+// result is the next revision of the file, or with output "file" a new file.
+func SignFile(c *fiber.Ctx) error {
+	fmt.Println("SignFile")
+
+	if !signing.Enabled() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Signing is not configured",
+		})
+	}
+	var request signRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse signature settings",
+		})
+	}
+	if request.Page < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "page must not be negative",
+		})
+	}
+	if request.Page > 0 && (request.Width <= 0 || request.Height <= 0 || request.X < 0 || request.Y < 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A visible signature needs a positive width and height and a position on the page",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+	if file.Encrypted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Encrypted files can't be signed, decrypt the file first",
+		})
+	}
+
+	if request.Page > 0 {
+		info, err := pdf.Info(path)
+		if err != nil {
+			fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Failed to read the document",
+			})
+		}
+		if request.Page > len(info.PageSizes) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("page must be between 1 and %d", len(info.PageSizes)),
+			})
+		}
+		width, height := info.PageSizes[request.Page-1].Unrotated()
+		if request.X+request.Width > width || request.Y+request.Height > height {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The signature must fit on the page",
+			})
+		}
+	}
+
+	signer, chain, err := signing.Load()
+	if err != nil {
+		fmt.Printf("ERROR loading signing certificate: %v\n", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Signing certificate is not available",
+		})
+	}
+	name := chain[0].Subject.CommonName
+	if name == "" {
+		name = chain[0].Subject.String()
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare signing",
+		})
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "signed.pdf")
+	err = pdf.Sign(path, outPath, signer, chain, pdf.Signature{
+		Page:     request.Page,
+		X:        request.X,
+		Y:        request.Y,
+		Width:    request.Width,
+		Height:   request.Height,
+		Name:     name,
+		Reason:   request.Reason,
+		Location: request.Location,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("ERROR signing file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to sign file",
+		})
+	}
+
+	return sendOperationOutput(c, file, outPath, output, "signed", fiber.Map{
+		"operation": "sign",
+		"signer":    name,
+		"page":      request.Page,
+		"reason":    request.Reason,
+		"location":  request.Location,
+	})
+}
+
+// GetSignatures - List the digital signatures of a file with their signers
+// and whether they are still valid. intact is set when every signature is
+// valid and the last one covers the whole document, so nothing was changed
+// after signing.
+func GetSignatures(c *fiber.Ctx) error {
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	signatures, err := pdf.Signatures(path)
+	if err != nil {
+		fmt.Printf("ERROR reading signatures of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the signatures",
+		})
+	}
+	intact := len(signatures) > 0
+	for _, signature := range signatures {
+		intact = intact && signature.SignatureStatus == "valid"
+	}
+	if intact {
+		intact = signatures[len(signatures)-1].WholeDocument
+	}
+
+	return c.JSON(fiber.Map{
+		"signatures": signatures,
+		"intact":     intact,
+	})
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// starredFilter limits a files query to the caller's starred files when the
+// starred query parameter is true
+func starredFilter(c *fiber.Ctx) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !c.QueryBool("starred") {
+			return db
+		}
+		return db.Where("files.id IN (?)", database.DB.Model(&models.FileStar{}).
+			Select("file_id").Where("user_id = ?", middleware.CurrentClaims(c).UserID()))
+	}
+}
+
+// StarFile - Add a file to the caller's favorites
+func StarFile(c *fiber.Ctx) error {
+	fmt.Println("StarFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	star := models.FileStar{FileID: file.ID, UserID: middleware.CurrentClaims(c).UserID()}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&star)
+	if result.Error != nil {
+		fmt.Printf("ERROR starring file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to star file",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "File starred successfully",
+	})
+}
+
+// UnstarFile - Remove a file from the caller's favorites
+func UnstarFile(c *fiber.Ctx) error {
+	fmt.Println("UnstarFile")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	result := database.DB.Unscoped().
+		Where("file_id = ? AND user_id = ?", file.ID, middleware.CurrentClaims(c).UserID()).
+		Delete(&models.FileStar{})
+	if result.Error != nil {
+		fmt.Printf("ERROR unstarring file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unstar file",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "File unstarred successfully",
+	})
+}
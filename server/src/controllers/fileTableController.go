@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+type tableRequest struct {
+	// Area limits detection to part of the page, like a schedule, placed like
+	// drawings; the whole page by default
+	Area *pdf.Area `json:"area"`
+	// Format is "json" or "csv"
+	Format string `json:"format"`
+}
+
+// FindTables - Detect the tables of a page of a file, like quantity
+// schedules of a drawing, and return their rows. With format "csv" the tables
+// are downloaded one after another, separated by an empty line.
+func FindTables(c *fiber.Ctx) error {
+	var request tableRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse table options",
+			})
+		}
+	}
+	if request.Format == "" {
+		request.Format = "json"
+	}
+	if request.Format != "json" && request.Format != "csv" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "format must be json or csv",
+		})
+	}
+	if request.Area != nil && (request.Area.Width <= 0 || request.Area.Height <= 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The area needs a positive width and height",
+		})
+	}
+
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	page, err := c.ParamsInt("page")
+	if err != nil || page < 1 || page > len(info.PageSizes) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page number",
+		})
+	}
+
+	tables, err := pdf.FindTables(path, page, info.PageSizes[page-1], request.Area)
+	if err != nil {
+		fmt.Printf("ERROR finding tables on page %d of file %d: %v\n", page, file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the text of the page",
+		})
+	}
+
+	if request.Format == "json" {
+		return c.JSON(fiber.Map{
+			"page":   page,
+			"tables": tables,
+		})
+	}
+	var out bytes.Buffer
+	w := csv.NewWriter(&out)
+	for i, table := range tables {
+		if i > 0 {
+			w.Write(nil)
+		}
+		w.WriteAll(table.Rows)
+	}
+	if err := w.Error(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to write the tables",
+		})
+	}
+	c.Attachment(fmt.Sprintf("%s-page-%d-tables.csv", strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)), page))
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	return c.Send(out.Bytes())
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+func textKey(hash string) string {
+	return storage.DerivedKey(hash, "text.json")
+}
+
+// fileText returns the text of each page of a file. The extracted text is
+// cached per content.
+func fileText(file models.File) ([]string, error) {
+	var pages []string
+	if r, _, err := storage.Store.Get(textKey(file.Hash)); err == nil {
+		err = json.NewDecoder(r).Decode(&pages)
+		r.Close()
+		if err == nil {
+			return pages, nil
+		}
+	}
+
+	path, cleanup, err := localStoredFile(file)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	if pages, err = pdf.ExtractText(path); err != nil {
+		return nil, err
+	}
+
+	cached, err := json.Marshal(pages)
+	if err == nil {
+		err = storage.Store.Put(textKey(file.Hash), bytes.NewReader(cached), int64(len(cached)))
+	}
+	if err != nil {
+		fmt.Printf("ERROR caching text of file %d: %v\n", file.ID, err)
+	}
+	return pages, nil
+}
+
+// findFileText loads the text of a file the caller may read
+func findFileText(c *fiber.Ctx) ([]string, int, error) {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return nil, status, err
+	}
+	if file.Quarantined() {
+		return nil, fiber.StatusLocked, errQuarantined
+	}
+
+	pages, err := fileText(file)
+	if err != nil {
+		fmt.Printf("ERROR extracting text of file %d: %v\n", file.ID, err)
+		return nil, fiber.StatusUnprocessableEntity, errors.New("Failed to extract text")
+	}
+	return pages, 0, nil
+}
+
+// GetFileText - Get the text of every page of a file
+func GetFileText(c *fiber.Ctx) error {
+	pages, status, err := findFileText(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"pages": pages,
+	})
+}
+
+// GetPageText - Get the text of one page of a file
+func GetPageText(c *fiber.Ctx) error {
+	pages, status, err := findFileText(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	page, err := c.ParamsInt("page")
+	if err != nil || page < 1 || page > len(pages) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page number",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"page": page,
+		"text": pages[page-1],
+	})
+}
+
+// GetPageWords - Get the words of one page of a file with their boxes, in
+// points from the top left corner of the unrotated page like drawings, for
+// selecting and highlighting text over the page image
+func GetPageWords(c *fiber.Ctx) error {
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	page, err := c.ParamsInt("page")
+	if err != nil || page < 1 || page > len(info.PageSizes) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page number",
+		})
+	}
+
+	words, err := pdf.PageWords(path, page, info.PageSizes[page-1])
+	if err != nil {
+		fmt.Printf("ERROR reading words of page %d of file %d: %v\n", page, file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the text of the page",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"page":  page,
+		"words": words,
+	})
+}
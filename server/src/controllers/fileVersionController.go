@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+// UploadFileVersion - Upload a new revision of a file. The current revision is
+// kept in the version history. Images are converted to a PDF like in
+// UploadFile.
+func UploadFileVersion(c *fiber.Ctx) error {
+	fmt.Println("UploadFileVersion")
+
+	file, status, err := findEditableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to upload file",
+		})
+	}
+	parts := form.File["file"]
+	var size int64
+	for _, part := range parts {
+		size += part.Size
+	}
+	// Revisions count against the quota of the file's owner
+	var ownerID uint
+	if file.OwnerID != nil {
+		ownerID = *file.OwnerID
+	}
+	if err := checkFileSize(ownerID, fileTypePDF, size); err != nil {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save file",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	// The file keeps its name, even when the revision is made from images
+	path, revisionHash, _, status, err := receiveDocument(parts, workDir)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// An encrypted revision may come with its password
+	path, revisionHash, status, err = decryptUpload(path, revisionHash, c.FormValue("password"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before, file, err := saveFileRevision(c, file, path, revisionHash, nil)
+	if errors.Is(err, errNotPDF) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR saving revision of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save new version",
+		})
+	}
+	audit.Record(c, audit.FileVersionUpload, audit.EntityFile, file.ID, before, file)
+
+	return c.Status(fiber.StatusCreated).JSON(file)
+}
+
+// saveFileRevision stores a PDF from the server's disk whose hash is known as
+// the next revision of file, keeping the current one in the version history.
+// apply, if not nil, makes further changes in the same transaction. It returns
+// the file before and after.
+func saveFileRevision(c *fiber.Ctx, file models.File, path, revisionHash string, apply func(tx *gorm.DB, file models.File) error) (models.File, models.File, error) {
+	// The file keeps its name across revisions
+	revision, err := storeLocalFile(path, file.Filename, revisionHash)
+	if err != nil {
+		return file, file, err
+	}
+
+	uploaderID := middleware.CurrentClaims(c).UserID()
+	var before models.File
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the file so concurrent revisions get consecutive numbers
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, file.ID).Error; err != nil {
+			return err
+		}
+		before = file
+
+		previous := models.FileVersion{
+			FileID:       file.ID,
+			Version:      file.Version,
+			Filename:     file.Filename,
+			Hash:         file.Hash,
+			Size:         file.Size,
+			StorageKey:   storedFileKey(file),
+			UploadedByID: file.UploadedByID,
+			PageCount:    file.PageCount,
+			PageSizes:    file.PageSizes,
+			Title:        file.Title,
+			Author:       file.Author,
+			Producer:     file.Producer,
+			Encrypted:    file.Encrypted,
+		}
+		if err := tx.Create(&previous).Error; err != nil {
+			return err
+		}
+
+		file.Hash = revision.Hash
+		file.Size = revision.Size
+		file.StorageKey = revision.StorageKey
+		file.PageCount = revision.PageCount
+		file.PageSizes = revision.PageSizes
+		file.Title = revision.Title
+		file.Author = revision.Author
+		file.Producer = revision.Producer
+		file.Encrypted = revision.Encrypted
+		file.ScanStatus = revision.ScanStatus
+		file.Version++
+		file.UploadedByID = &uploaderID
+		if err := tx.Save(&file).Error; err != nil {
+			return err
+		}
+		if apply != nil {
+			return apply(tx, file)
+		}
+		return nil
+	})
+	if err != nil {
+		storage.Release(revision.Hash)
+		return before, file, err
+	}
+	queueScan(file)
+	queueTextIndex(file)
+	go dropRenders(before.Hash)
+	return before, file, nil
+}
+
+// GetFileVersions - List the current revision of a file and its previous ones, newest first
+func GetFileVersions(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var versions []models.FileVersion
+	database.DB.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions)
+
+	return c.JSON(fiber.Map{
+		"current":  file,
+		"versions": versions,
+	})
+}
+
+// DownloadFileVersion - Download a specific revision of a file
+func DownloadFileVersion(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	number, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid version",
+		})
+	}
+	if number == file.Version {
+		if err := sendStoredFile(c, file); err != nil {
+			return err
+		}
+		recordFileDownload(c, file, number)
+		return nil
+	}
+
+	var version models.FileVersion
+	if result := database.DB.Where("file_id = ? AND version = ?", file.ID, number).First(&version); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Version not found",
+		})
+	}
+
+	if err := sendStoredContent(c, version.StorageKey, version.Filename); err != nil {
+		return err
+	}
+	recordFileDownload(c, file, number)
+	return nil
+}
+
+// revisionContent returns the content of a revision of file, as a File so it
+// can be read like the current one
+func revisionContent(file models.File, number int) (models.File, error) {
+	if number == file.Version {
+		return file, nil
+	}
+	var version models.FileVersion
+	if err := database.DB.Where("file_id = ? AND version = ?", file.ID, number).First(&version).Error; err != nil {
+		return file, err
+	}
+	content := file
+	content.Filename = version.Filename
+	content.Hash = version.Hash
+	content.StorageKey = version.StorageKey
+	return content, nil
+}
+
+// CompareFileVersions - Compare the text of two revisions of a file, from and
+// to, which default to the revision before the current one and the current
+// one. Pages are compared by number and the passages of lines removed and
+// added on each changed page are listed.
+func CompareFileVersions(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	to := c.QueryInt("to", file.Version)
+	from := c.QueryInt("from", to-1)
+	if from < 1 || to < 1 || from > file.Version || to > file.Version || from == to {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("from and to must be two different versions between 1 and %d", file.Version),
+		})
+	}
+
+	texts := make([][]string, 2)
+	for i, number := range []int{from, to} {
+		content, err := revisionContent(file, number)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": fmt.Sprintf("Version %d not found", number),
+			})
+		}
+		if texts[i], err = fileText(content); err != nil {
+			fmt.Printf("ERROR extracting text of file %d version %d: %v\n", file.ID, number, err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to read the text of version %d", number),
+			})
+		}
+	}
+
+	pages := pdf.DiffText(texts[0], texts[1])
+	added, removed := 0, 0
+	for _, page := range pages {
+		for _, change := range page.Changes {
+			if change.Type == "added" {
+				added++
+			} else {
+				removed++
+			}
+		}
+	}
+	return c.JSON(fiber.Map{
+		"from":    from,
+		"to":      to,
+		"added":   added,
+		"removed": removed,
+		"pages":   pages,
+	})
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const (
+	maxFolderNameLength = 255
+	maxFolderTTLDays    = 36500
+)
+
+var errFolderNotFound = errors.New("Folder not found")
+
+type folderRequest struct {
+	Name *string `json:"name"`
+	// ParentID 0 places the folder at the top level
+	ParentID    *uint `json:"parentId"`
+	FileTTLDays *int  `json:"fileTtlDays"`
+}
+
+// findFolder loads a folder of the current workspace
+func findFolder(c *fiber.Ctx, id interface{}) (models.Folder, error) {
+	var folder models.Folder
+	result := database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).First(&folder, id)
+	if result.Error != nil {
+		return folder, errFolderNotFound
+	}
+	return folder, nil
+}
+
+// findManagedFolder loads the folder named in the route if the caller created
+// it or is an admin
+func findManagedFolder(c *fiber.Ctx) (models.Folder, int, error) {
+	folder, err := findFolder(c, c.Params("id"))
+	if err != nil {
+		return folder, fiber.StatusNotFound, err
+	}
+	claims := middleware.CurrentClaims(c)
+	if folder.CreatedByID != claims.UserID() && !models.RoleAtLeast(claims.Role, models.RoleAdmin) {
+		return folder, fiber.StatusForbidden, errors.New("Only the folder's creator or an admin can change it")
+	}
+	return folder, 0, nil
+}
+
+// requestedFolder resolves the optional folderId of an upload, nil meaning the
+// top level
+func requestedFolder(c *fiber.Ctx, value string) (*models.Folder, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, errFolderNotFound
+	}
+	return findOptionalFolder(c, uint(id))
+}
+
+// findOptionalFolder loads a folder named in a request body, nil for 0
+func findOptionalFolder(c *fiber.Ctx, id uint) (*models.Folder, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	folder, err := findFolder(c, id)
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// folderByID loads the folder a file or upload is placed in, or returns nil
+func folderByID(id *uint) *models.Folder {
+	if id == nil {
+		return nil
+	}
+	var folder models.Folder
+	if err := database.DB.First(&folder, *id).Error; err != nil {
+		return nil
+	}
+	return &folder
+}
+
+// topLevelAsNil turns the folder ID 0, which clients send for the top level, into nil
+func topLevelAsNil(id *uint) *uint {
+	if id == nil || *id == 0 {
+		return nil
+	}
+	return id
+}
+
+// validateFolderRequest checks the fields that are set
+func validateFolderRequest(c *fiber.Ctx, request folderRequest, folder models.Folder) error {
+	if request.Name != nil {
+		*request.Name = strings.TrimSpace(*request.Name)
+		if *request.Name == "" || len(*request.Name) > maxFolderNameLength || strings.ContainsAny(*request.Name, `/\`) {
+			return fmt.Errorf("Folder name must be 1 to %d bytes without slashes", maxFolderNameLength)
+		}
+	}
+	if request.FileTTLDays != nil && (*request.FileTTLDays < 0 || *request.FileTTLDays > maxFolderTTLDays) {
+		return fmt.Errorf("fileTtlDays must be between 0 and %d", maxFolderTTLDays)
+	}
+	if request.ParentID != nil && *request.ParentID != 0 {
+		// Walk up from the new parent to make sure the folder doesn't end up inside itself
+		for id := request.ParentID; id != nil; {
+			if folder.ID != 0 && *id == folder.ID {
+				return errors.New("A folder can't be moved into itself")
+			}
+			parent, err := findFolder(c, *id)
+			if err != nil {
+				return errors.New("Parent folder not found")
+			}
+			id = parent.ParentID
+		}
+	}
+	return nil
+}
+
+// GetFolders - List the folders of the current workspace
+func GetFolders(c *fiber.Ctx) error {
+	folders := []models.Folder{}
+	database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).Order("name").Find(&folders)
+	return c.JSON(folders)
+}
+
+// CreateFolder - Create a folder, optionally inside another one
+func CreateFolder(c *fiber.Ctx) error {
+	fmt.Println("CreateFolder")
+
+	var request folderRequest
+	if err := c.BodyParser(&request); err != nil || request.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse folder data",
+		})
+	}
+	if err := validateFolderRequest(c, request, models.Folder{}); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	folder := models.Folder{
+		WorkspaceID: middleware.CurrentWorkspace(c).ID,
+		ParentID:    topLevelAsNil(request.ParentID),
+		Name:        *request.Name,
+		CreatedByID: middleware.CurrentClaims(c).UserID(),
+	}
+	if request.FileTTLDays != nil {
+		folder.FileTTLDays = *request.FileTTLDays
+	}
+	if result := database.DB.Create(&folder); result.Error != nil {
+		fmt.Printf("ERROR creating folder: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create folder",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(folder)
+}
+
+// UpdateFolder - Rename a folder, move it or change the expiry of files
+// uploaded into it
+func UpdateFolder(c *fiber.Ctx) error {
+	fmt.Println("UpdateFolder")
+
+	folder, status, err := findManagedFolder(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request folderRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse folder data",
+		})
+	}
+	if err := validateFolderRequest(c, request, folder); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if request.Name != nil {
+		folder.Name = *request.Name
+	}
+	if request.ParentID != nil {
+		folder.ParentID = topLevelAsNil(request.ParentID)
+	}
+	if request.FileTTLDays != nil {
+		folder.FileTTLDays = *request.FileTTLDays
+	}
+	if result := database.DB.Save(&folder); result.Error != nil {
+		fmt.Printf("ERROR updating folder %d: %v\n", folder.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update folder",
+		})
+	}
+
+	return c.JSON(folder)
+}
+
+// DeleteFolder - Delete an empty folder. Files in the trash that were in it
+// are restored to the top level.
+func DeleteFolder(c *fiber.Ctx) error {
+	fmt.Println("DeleteFolder")
+
+	folder, status, err := findManagedFolder(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var files, subfolders int64
+	database.DB.Model(&models.File{}).Where("folder_id = ?", folder.ID).Count(&files)
+	database.DB.Model(&models.Folder{}).Where("parent_id = ?", folder.ID).Count(&subfolders)
+	if files > 0 || subfolders > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Only empty folders can be deleted",
+		})
+	}
+
+	database.DB.Unscoped().Model(&models.File{}).Where("folder_id = ?", folder.ID).UpdateColumn("folder_id", nil)
+	if result := database.DB.Unscoped().Delete(&folder); result.Error != nil {
+		fmt.Printf("ERROR deleting folder %d: %v\n", folder.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete folder",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Folder deleted successfully",
+	})
+}
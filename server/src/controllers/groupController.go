@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+const maxGroupNameLength = 100
+
+type createGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type groupMemberRequest struct {
+	UserID uint `json:"userId"`
+}
+
+// findWorkspaceGroup loads a group of the current workspace
+func findWorkspaceGroup(c *fiber.Ctx, id interface{}) (models.Group, error) {
+	var group models.Group
+	err := database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).First(&group, id).Error
+	return group, err
+}
+
+// findManagedGroup loads a group the caller created, or any group of the workspace for admins
+func findManagedGroup(c *fiber.Ctx) (models.Group, int, error) {
+	group, err := findWorkspaceGroup(c, c.Params("id"))
+	if err != nil {
+		return group, fiber.StatusNotFound, fmt.Errorf("Group not found")
+	}
+	claims := middleware.CurrentClaims(c)
+	if !models.RoleAtLeast(claims.Role, models.RoleAdmin) && group.CreatedByID != claims.UserID() {
+		return group, fiber.StatusForbidden, fmt.Errorf("Only the group creator can manage its members")
+	}
+	return group, 0, nil
+}
+
+// GetGroups - List the groups of the current workspace
+func GetGroups(c *fiber.Ctx) error {
+	var groups []models.Group
+	database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).Order("name").Find(&groups)
+	return c.JSON(groups)
+}
+
+// CreateGroup - Create a group in the current workspace
+func CreateGroup(c *fiber.Ctx) error {
+	fmt.Println("CreateGroup")
+	claims := middleware.CurrentClaims(c)
+
+	var request createGroupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse group data",
+		})
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxGroupNameLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Group name must be 1 to %d bytes", maxGroupNameLength),
+		})
+	}
+
+	workspaceID := middleware.CurrentWorkspace(c).ID
+	var count int64
+	database.DB.Model(&models.Group{}).Unscoped().
+		Where("workspace_id = ? AND name = ?", workspaceID, request.Name).
+		Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A group with this name already exists",
+		})
+	}
+
+	group := models.Group{
+		WorkspaceID: workspaceID,
+		Name:        request.Name,
+		CreatedByID: claims.UserID(),
+	}
+	if result := database.DB.Create(&group); result.Error != nil {
+		fmt.Printf("ERROR creating group: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create group",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+// DeleteGroup - Delete a group along with its memberships and file grants
+func DeleteGroup(c *fiber.Ctx) error {
+	fmt.Println("DeleteGroup")
+
+	group, status, err := findManagedGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if result := database.DB.Unscoped().Delete(&group); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Group deleted successfully",
+	})
+}
+
+// GetGroupMembers - List the members of a group
+func GetGroupMembers(c *fiber.Ctx) error {
+	group, err := findWorkspaceGroup(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Group not found",
+		})
+	}
+
+	var users []models.User
+	database.DB.Where("id IN (?)",
+		database.DB.Model(&models.GroupMember{}).Select("user_id").Where("group_id = ?", group.ID)).
+		Order("username").
+		Find(&users)
+	return c.JSON(users)
+}
+
+// AddGroupMember - Add a workspace member to a group
+func AddGroupMember(c *fiber.Ctx) error {
+	fmt.Println("AddGroupMember")
+
+	group, status, err := findManagedGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request groupMemberRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse member data",
+		})
+	}
+
+	if !auth.IsWorkspaceMember(request.UserID, group.WorkspaceID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User is not a member of this workspace",
+		})
+	}
+
+	member := models.GroupMember{GroupID: group.ID, UserID: request.UserID}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add member",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member added successfully",
+	})
+}
+
+// RemoveGroupMember - Remove a user from a group
+func RemoveGroupMember(c *fiber.Ctx) error {
+	fmt.Println("RemoveGroupMember")
+
+	group, status, err := findManagedGroup(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	result := database.DB.Unscoped().
+		Where("group_id = ? AND user_id = ?", group.ID, c.Params("userId")).
+		Delete(&models.GroupMember{})
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member removed successfully",
+	})
+}
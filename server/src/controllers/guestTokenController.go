@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+const (
+	defaultGuestTokenTTL = 7 * 24 * time.Hour
+	maxGuestTokenTTL     = 90 * 24 * time.Hour
+)
+
+type createGuestTokenRequest struct {
+	// Label names the reviewer the token was given to
+	Label string `json:"label"`
+	// ExpiresInHours defaults to a week; guest tokens always expire
+	ExpiresInHours *float64 `json:"expiresInHours"`
+}
+
+// CreateGuestToken - Create a read-only token for an external reviewer of a
+// file. The token is only returned once.
+func CreateGuestToken(c *fiber.Ctx) error {
+	fmt.Println("CreateGuestToken")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request createGuestTokenRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse guest token data",
+			})
+		}
+	}
+
+	ttl := defaultGuestTokenTTL
+	if request.ExpiresInHours != nil {
+		ttl = time.Duration(*request.ExpiresInHours * float64(time.Hour))
+		if ttl <= 0 || ttl > maxGuestTokenTTL {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Expiry must be positive and at most %d hours", int(maxGuestTokenTTL.Hours())),
+			})
+		}
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate guest token",
+		})
+	}
+
+	guest := models.GuestToken{
+		FileID:      file.ID,
+		CreatedByID: middleware.CurrentClaims(c).UserID(),
+		Label:       strings.TrimSpace(request.Label),
+		TokenHash:   token.Hash(secret),
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	if result := database.DB.Create(&guest); result.Error != nil {
+		fmt.Printf("ERROR creating guest token: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save guest token",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token":      secret,
+		"guestToken": guest,
+	})
+}
+
+// GetGuestTokens - List the guest tokens of a file
+func GetGuestTokens(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var guests []models.GuestToken
+	database.DB.Where("file_id = ?", file.ID).Order("created_at DESC").Find(&guests)
+	return c.JSON(guests)
+}
+
+// RevokeGuestToken - Disable a guest token
+func RevokeGuestToken(c *fiber.Ctx) error {
+	fmt.Println("RevokeGuestToken")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var guest models.GuestToken
+	if result := database.DB.Where("file_id = ?", file.ID).First(&guest, c.Params("tokenId")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Guest token not found",
+		})
+	}
+
+	if guest.RevokedAt == nil {
+		now := time.Now()
+		guest.RevokedAt = &now
+		database.DB.Model(&guest).Update("revoked_at", now)
+	}
+
+	return c.JSON(guest)
+}
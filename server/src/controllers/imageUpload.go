@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+// maxUploadImages bounds how many images one upload may combine into a PDF
+const maxUploadImages = 100
+
+var errNotImage = errors.New("Several files can only be uploaded together when they are all PNG, JPEG or TIFF images")
+
+// receiveDocument receives the parts of an upload into dir. A single PDF is
+// kept as it is, while images are converted to a PDF with a page for each, in
+// the order they were sent, so photos are handled like any other document. It
+// returns the path and hash of the document and the filename it should get,
+// which for images is the first one's with a .pdf extension.
+func receiveDocument(headers []*multipart.FileHeader, dir string) (string, string, string, int, error) {
+	if len(headers) == 0 {
+		return "", "", "", fiber.StatusBadRequest, errors.New("Failed to upload file")
+	}
+	if len(headers) > maxUploadImages {
+		return "", "", "", fiber.StatusBadRequest, fmt.Errorf("At most %d images can be uploaded together", maxUploadImages)
+	}
+
+	paths := make([]string, len(headers))
+	for i, header := range headers {
+		// Each part gets its own directory as receiveUpload names its copy
+		partDir := filepath.Join(dir, "part-"+strconv.Itoa(i))
+		if err := os.Mkdir(partDir, 0o700); err != nil {
+			return "", "", "", fiber.StatusInternalServerError, errors.New("Failed to save file")
+		}
+		path, fileHash, err := receiveUpload(header, partDir)
+		if err != nil {
+			return "", "", "", fiber.StatusInternalServerError, errors.New("Failed to save file")
+		}
+		format, err := pdf.ImageFormat(path)
+		if err != nil {
+			return "", "", "", fiber.StatusInternalServerError, errors.New("Failed to read upload")
+		}
+		if format == "" {
+			if len(headers) > 1 {
+				return "", "", "", fiber.StatusUnsupportedMediaType, errNotImage
+			}
+			// Anything else is left to be checked as a PDF
+			return path, fileHash, header.Filename, 0, nil
+		}
+		if header.Size > maxFileSize(fileTypeImage) {
+			return "", "", "", fiber.StatusRequestEntityTooLarge, fmt.Errorf("%s: %v", header.Filename, errFileTooLarge)
+		}
+		paths[i] = path
+	}
+
+	converted := filepath.Join(dir, "images.pdf")
+	if err := pdf.ImagesToPDF(paths, converted); err != nil {
+		fmt.Printf("ERROR converting uploaded images to PDF: %v\n", err)
+		return "", "", "", fiber.StatusUnprocessableEntity, errors.New("Failed to convert the images to PDF")
+	}
+	fileHash, err := hashFile(converted)
+	if err != nil {
+		return "", "", "", fiber.StatusInternalServerError, errors.New("Failed to save file")
+	}
+	filename := filepath.Base(headers[0].Filename)
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".pdf"
+	return converted, fileHash, filename, 0, nil
+}
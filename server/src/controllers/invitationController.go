@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/mailer"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+// invitationTTL is how long a signup link stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
+type createInvitationRequest struct {
+	Email       string `json:"email"`
+	Role        string `json:"role"`
+	WorkspaceID uint   `json:"workspaceId"`
+	// GroupID optionally adds the new user to a group of the workspace
+	GroupID *uint `json:"groupId"`
+}
+
+type acceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// errInvitationUnavailable is returned for unknown, expired, revoked or used invitations
+var errInvitationUnavailable = errors.New("Invitation is invalid or has expired")
+
+// findPendingInvitation looks up an invitation that can still be accepted
+func findPendingInvitation(tx *gorm.DB, secret string) (models.Invitation, error) {
+	var invitation models.Invitation
+	result := tx.Preload("Workspace").
+		Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", token.Hash(secret), time.Now()).
+		First(&invitation)
+	if result.Error != nil || invitation.Workspace.ID == 0 {
+		return invitation, errInvitationUnavailable
+	}
+	return invitation, nil
+}
+
+// CreateInvitation - Invite someone by email to join a workspace
+func CreateInvitation(c *fiber.Ctx) error {
+	fmt.Println("CreateInvitation")
+
+	var request createInvitationRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse invitation data",
+		})
+	}
+
+	request.Email = strings.TrimSpace(request.Email)
+	if _, err := mail.ParseAddress(request.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email address",
+		})
+	}
+
+	if request.Role == "" {
+		request.Role = models.RoleViewer
+	}
+	if !models.ValidRole(request.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be viewer, editor or admin",
+		})
+	}
+
+	var workspace models.Workspace
+	if result := database.DB.First(&workspace, request.WorkspaceID); result.Error != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+
+	if request.GroupID != nil {
+		var group models.Group
+		if result := database.DB.Where("workspace_id = ?", workspace.ID).First(&group, *request.GroupID); result.Error != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Group not found in this workspace",
+			})
+		}
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate invitation token",
+		})
+	}
+
+	invitation := models.Invitation{
+		Email:       request.Email,
+		Role:        request.Role,
+		WorkspaceID: workspace.ID,
+		GroupID:     request.GroupID,
+		InvitedByID: middleware.CurrentClaims(c).UserID(),
+		TokenHash:   token.Hash(secret),
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	}
+	if result := database.DB.Create(&invitation); result.Error != nil {
+		fmt.Printf("ERROR creating invitation: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create invitation",
+		})
+	}
+
+	inviteURL := os.Getenv("INVITATION_URL")
+	if inviteURL == "" {
+		inviteURL = c.BaseURL() + "/accept-invitation?token="
+	}
+	body := fmt.Sprintf("Hello,\n\nYou have been invited to the %s workspace. Use the link below to create your account. It expires in %s.\n\n%s%s\n",
+		workspace.Name, invitationTTL, inviteURL, secret)
+
+	go func(to string) {
+		if err := mailer.New().Send(to, "You're invited", body); err != nil {
+			fmt.Printf("ERROR sending invitation email: %v\n", err)
+		}
+	}(invitation.Email)
+
+	return c.Status(fiber.StatusCreated).JSON(invitation)
+}
+
+// GetInvitations - List pending invitations
+func GetInvitations(c *fiber.Ctx) error {
+	var invitations []models.Invitation
+	database.DB.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Find(&invitations)
+	return c.JSON(invitations)
+}
+
+// RevokeInvitation - Cancel a pending invitation
+func RevokeInvitation(c *fiber.Ctx) error {
+	fmt.Println("RevokeInvitation")
+
+	result := database.DB.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", c.Params("id")).
+		Update("revoked_at", time.Now())
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Invitation revoked successfully",
+	})
+}
+
+// GetInvitation - Show who an invitation is for so the signup page can greet them
+func GetInvitation(c *fiber.Ctx) error {
+	invitation, err := findPendingInvitation(database.DB, c.Params("token"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"email":     invitation.Email,
+		"workspace": invitation.Workspace.Name,
+		"expiresAt": invitation.ExpiresAt,
+	})
+}
+
+// AcceptInvitation - Create the invited account, place it in the invitation's
+// workspace and group, and log it in
+func AcceptInvitation(c *fiber.Ctx) error {
+	fmt.Println("AcceptInvitation")
+
+	var request acceptInvitationRequest
+	if err := c.BodyParser(&request); err != nil || request.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invitation token is required",
+		})
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	hash, status, err := newAccountCredentials(request.Username, request.Password)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var user models.User
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		invitation, err := findPendingInvitation(tx, request.Token)
+		if err != nil {
+			return err
+		}
+
+		user = models.User{
+			Username:     request.Username,
+			PasswordHash: hash,
+			Role:         invitation.Role,
+			Email:        invitation.Email,
+			AuthSource:   auth.SourceLocal,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		// Claim the invitation atomically so it can only be used once
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Updates(map[string]interface{}{"accepted_at": time.Now(), "accepted_user_id": user.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvitationUnavailable
+		}
+
+		if err := tx.Create(&models.WorkspaceMember{WorkspaceID: invitation.WorkspaceID, UserID: user.ID}).Error; err != nil {
+			return err
+		}
+		if invitation.GroupID != nil {
+			return tx.Create(&models.GroupMember{GroupID: *invitation.GroupID, UserID: user.ID}).Error
+		}
+		return nil
+	})
+
+	if errors.Is(err, errInvitationUnavailable) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR accepting invitation: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create account",
+		})
+	}
+
+	return completeLogin(c, user)
+}
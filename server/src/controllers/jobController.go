@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+const (
+	// maxConcurrentJobs bounds how many jobs run at once, as each keeps a tool busy
+	maxConcurrentJobs = 2
+	jobPollInterval   = time.Second
+	jobPurgeInterval  = time.Hour
+	// jobRetention is how long finished jobs and their output are kept
+	jobRetention    = 7 * 24 * time.Hour
+	defaultJobLimit = 50
+	maxJobLimit     = 200
+)
+
+var errJobFileGone = errors.New("A file of the job was deleted while it was queued")
+
+// jobRun is a job being run, collecting what it produces
+type jobRun struct {
+	job     models.Job
+	workDir string
+	updates map[string]interface{}
+}
+
+// jobKinds run the operations jobs are queued for and return the job's result
+var jobKinds = map[string]func(run *jobRun) (interface{}, error){
+	"merge":   runMergeJob,
+	"flatten": runFlattenJob,
+	"ocr":     runOCRJob,
+	"convert": runConversionJob,
+}
+
+// enqueueJob queues a job of the kind for the caller, to be picked up by the
+// main process. params are stored as the job's settings.
+func enqueueJob(c *fiber.Ctx, kind string, params interface{}) (models.Job, error) {
+	return queueJob(database.DB, kind, middleware.CurrentClaims(c).UserID(), middleware.CurrentWorkspace(c).ID, params)
+}
+
+// queueJob queues a job of the kind for the owner in tx, for work started
+// outside of a request or along with records of its own
+func queueJob(tx *gorm.DB, kind string, ownerID, workspaceID uint, params interface{}) (models.Job, error) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return models.Job{}, err
+	}
+	job := models.Job{
+		Kind:        kind,
+		OwnerID:     ownerID,
+		WorkspaceID: workspaceID,
+		Status:      models.JobPending,
+		Params:      encoded,
+	}
+	err = tx.Create(&job).Error
+	return job, err
+}
+
+// sendQueuedJob queues a job and responds with it
+func sendQueuedJob(c *fiber.Ctx, kind string, params interface{}) error {
+	job, err := enqueueJob(c, kind, params)
+	if err != nil {
+		fmt.Printf("ERROR queueing %s job: %v\n", kind, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to queue the job",
+		})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// RunJobs runs queued jobs until the server stops. Requests are served by
+// prefork children, which only queue jobs; the main process alone runs them,
+// so the number running at once holds across children. Jobs left running by
+// a restart are queued again.
+func RunJobs() {
+	database.DB.Model(&models.Job{}).Where("status = ?", models.JobRunning).UpdateColumn("status", models.JobPending)
+
+	slots := make(chan struct{}, maxConcurrentJobs)
+	var purged time.Time
+	for range time.Tick(jobPollInterval) {
+		if time.Since(purged) > jobPurgeInterval {
+			purgeJobs()
+			purged = time.Now()
+		}
+		// Only this loop takes slots, so a free one stays free until taken
+		for len(slots) < cap(slots) {
+			id, ok := claimJob()
+			if !ok {
+				break
+			}
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				runJob(id)
+			}()
+		}
+	}
+}
+
+// claimJob marks the oldest pending job running and returns it
+func claimJob() (uint, bool) {
+	for {
+		var job models.Job
+		if err := database.DB.Select("id").Where("status = ?", models.JobPending).Order("id").First(&job).Error; err != nil {
+			return 0, false
+		}
+		// Claiming the job is one statement, so it runs once
+		claim := database.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ?", job.ID, models.JobPending).
+			Updates(map[string]interface{}{"status": models.JobRunning, "started_at": time.Now()})
+		if claim.Error != nil {
+			return 0, false
+		}
+		if claim.RowsAffected == 1 {
+			return job.ID, true
+		}
+	}
+}
+
+// runJob runs a claimed job and records how it ended
+func runJob(id uint) {
+	var job models.Job
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return
+	}
+	run := &jobRun{job: job, updates: map[string]interface{}{}}
+	result, err := run.execute()
+	if err != nil {
+		fmt.Printf("ERROR running %s job %d: %v\n", job.Kind, job.ID, err)
+		run.updates["status"] = models.JobFailed
+		run.updates["error"] = err.Error()
+	} else {
+		run.updates["status"] = models.JobDone
+		run.updates["progress"] = 100
+		// Updates from a map skip the column's serializer
+		if encoded, err := json.Marshal(result); err == nil {
+			run.updates["result"] = string(encoded)
+		}
+	}
+	run.updates["finished_at"] = time.Now()
+	if err := database.DB.Model(&job).Updates(run.updates).Error; err != nil {
+		fmt.Printf("ERROR recording the end of job %d: %v\n", job.ID, err)
+	}
+}
+
+// execute runs the job's operation in a work directory of its own. A panic
+// fails the job rather than the main process.
+func (r *jobRun) execute() (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("the job crashed: %v", recovered)
+		}
+	}()
+	kind, ok := jobKinds[r.job.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown job kind %q", r.job.Kind)
+	}
+	if r.workDir, err = newWorkDir(); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(r.workDir)
+	return kind(r)
+}
+
+// progress records the part of the job done, from 0 to 100
+func (r *jobRun) progress(percent int) {
+	database.DB.Model(&models.Job{}).Where("id = ?", r.job.ID).UpdateColumn("progress", percent)
+}
+
+// params decodes the job's settings
+func (r *jobRun) params(v interface{}) error {
+	return json.Unmarshal(r.job.Params, v)
+}
+
+// file loads a file of the job's workspace and a local path of its content.
+// Access was checked when the job was queued. cleanup must be called when err
+// is nil.
+func (r *jobRun) file(id uint) (models.File, string, func(), error) {
+	var file models.File
+	if err := database.DB.Where("workspace_id = ?", r.job.WorkspaceID).First(&file, id).Error; err != nil {
+		return file, "", nil, errJobFileGone
+	}
+	path, cleanup, err := localStoredFile(file)
+	if err != nil {
+		cleanup()
+		return file, "", nil, err
+	}
+	return file, path, cleanup, nil
+}
+
+// saveFile saves the output at path as a new file of the job's owner and
+// records it in the audit log with details
+func (r *jobRun) saveFile(path, filename string, folder *models.Folder, details map[string]interface{}) (models.File, error) {
+	fileHash, err := hashFile(path)
+	if err != nil {
+		return models.File{}, err
+	}
+	file, err := saveOwnedFile(path, filename, fileHash, r.job.OwnerID, r.job.WorkspaceID, folder)
+	if err != nil {
+		return file, err
+	}
+	details["file"] = file
+	details["job"] = r.job.ID
+	audit.RecordUser(r.job.OwnerID, audit.FileCreate, audit.EntityFile, file.ID, &r.job.WorkspaceID, nil, details)
+	r.updates["file_id"] = file.ID
+	return file, nil
+}
+
+// saveDownload keeps the output at path under key for its owner to download
+// as filename. key should be derived from a file's content, so the output is
+// kept as long as that content.
+func (r *jobRun) saveDownload(path, key, filename string) error {
+	if err := storage.PutFile(key, path); err != nil {
+		return err
+	}
+	r.updates["result_key"] = key
+	r.updates["result_filename"] = filename
+	return nil
+}
+
+// purgeJobs removes jobs that finished longer than the retention ago, along
+// with the output they kept for download
+func purgeJobs() {
+	var jobs []models.Job
+	database.DB.Where("finished_at < ?", time.Now().Add(-jobRetention)).Find(&jobs)
+	for _, job := range jobs {
+		if job.ResultKey != "" {
+			if err := storage.Store.Delete(job.ResultKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				fmt.Printf("ERROR removing the output of job %d: %v\n", job.ID, err)
+				continue
+			}
+		}
+		database.DB.Unscoped().Delete(&job)
+	}
+}
+
+// findOwnJob loads a job the caller queued in the current workspace
+func findOwnJob(c *fiber.Ctx) (models.Job, error) {
+	var job models.Job
+	err := database.DB.
+		Where("owner_id = ? AND workspace_id = ?", middleware.CurrentClaims(c).UserID(), middleware.CurrentWorkspace(c).ID).
+		First(&job, c.Params("id")).Error
+	return job, err
+}
+
+// GetJobs - List the caller's jobs in the current workspace, newest first.
+// status and kind filter them; limit and offset page through them.
+func GetJobs(c *fiber.Ctx) error {
+	query := database.DB.Model(&models.Job{}).
+		Where("owner_id = ? AND workspace_id = ?", middleware.CurrentClaims(c).UserID(), middleware.CurrentWorkspace(c).ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	limit := c.QueryInt("limit", defaultJobLimit)
+	if limit <= 0 || limit > maxJobLimit {
+		limit = defaultJobLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var jobs []models.Job
+	query.Order("id DESC").Limit(limit).Offset(offset).Find(&jobs)
+
+	return c.JSON(fiber.Map{
+		"total": total,
+		"jobs":  jobs,
+	})
+}
+
+// GetJob - Get a job the caller queued, with its progress, and once done its
+// result or error
+func GetJob(c *fiber.Ctx) error {
+	job, err := findOwnJob(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	return c.JSON(job)
+}
+
+// DownloadJobResult - Download the output of a finished job that produces a
+// download rather than a file, like a flattened export
+func DownloadJobResult(c *fiber.Ctx) error {
+	job, err := findOwnJob(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	if job.Status != models.JobDone || job.ResultKey == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "The job has no output to download",
+		})
+	}
+	return sendStoredContent(c, job.ResultKey, job.ResultFilename)
+}
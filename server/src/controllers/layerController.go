@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+const (
+	maxLayerNameLength  = 100
+	maxLayerColorLength = 32
+)
+
+var (
+	errLayerNotFound    = errors.New("Layer not found")
+	errLayerNameTaken   = errors.New("The file already has a layer of that name")
+	errLayerNotOnFile   = errors.New("The layer isn't one of the file's layers")
+	errLayerNameInvalid = fmt.Errorf("Layer name must be 1 to %d characters", maxLayerNameLength)
+)
+
+// layerRequest creates a layer or changes the fields it names
+type layerRequest struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+	// Visible defaults to true for new layers
+	Visible *bool `json:"visible"`
+	// Order defaults to above the file's other layers for new layers
+	Order *int `json:"order"`
+}
+
+// apply sets the fields the request names on the layer
+func (r layerRequest) apply(layer *models.Layer) error {
+	if r.Name != nil {
+		name := strings.TrimSpace(*r.Name)
+		if name == "" || len(name) > maxLayerNameLength {
+			return errLayerNameInvalid
+		}
+		layer.Name = name
+	}
+	if r.Color != nil {
+		color := strings.TrimSpace(*r.Color)
+		if len(color) > maxLayerColorLength {
+			return fmt.Errorf("Layer color must be at most %d characters", maxLayerColorLength)
+		}
+		layer.Color = color
+	}
+	if r.Visible != nil {
+		layer.Visible = *r.Visible
+	}
+	if r.Order != nil {
+		layer.Order = *r.Order
+	}
+	return nil
+}
+
+// layerNameTaken reports whether another layer of the file has the name
+func layerNameTaken(layer models.Layer) bool {
+	var count int64
+	database.DB.Model(&models.Layer{}).
+		Where("file_id = ? AND name = ? AND id <> ?", layer.FileID, layer.Name, layer.ID).
+		Count(&count)
+	return count > 0
+}
+
+// findEditableLayer loads a layer of a file the caller may edit
+func findEditableLayer(c *fiber.Ctx) (models.Layer, int, error) {
+	var layer models.Layer
+	file, status, err := findEditableFile(c, c.Params("id"))
+	if err != nil {
+		return layer, status, err
+	}
+	if err := database.DB.Where("file_id = ?", file.ID).First(&layer, c.Params("layerId")).Error; err != nil {
+		return layer, fiber.StatusNotFound, errLayerNotFound
+	}
+	return layer, 0, nil
+}
+
+// checkDrawingLayer checks a drawing on a layer is on one of its file's
+func checkDrawingLayer(drawing models.Drawing) error {
+	if drawing.LayerID == nil {
+		return nil
+	}
+	var count int64
+	database.DB.Model(&models.Layer{}).Where("id = ? AND file_id = ?", *drawing.LayerID, drawing.FileID).Count(&count)
+	if count == 0 {
+		return errLayerNotOnFile
+	}
+	return nil
+}
+
+// filterDrawingLayer keeps the drawings on the layer named by the layerId
+// query parameter, or those on no layer for layerId=0
+func filterDrawingLayer(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	param := c.Query("layerId")
+	if param == "" {
+		return query, nil
+	}
+	layerID, err := strconv.ParseUint(param, 10, 32)
+	if err != nil {
+		return query, errors.New("Invalid layer ID")
+	}
+	if layerID == 0 {
+		return query.Where("layer_id IS NULL"), nil
+	}
+	return query.Where("layer_id = ?", layerID), nil
+}
+
+// GetLayers - List the layers of a file, bottom first
+func GetLayers(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	layers := []models.Layer{}
+	database.DB.Where("file_id = ?", file.ID).Order("sort_order, id").Find(&layers)
+	return c.JSON(layers)
+}
+
+// CreateLayer - Add a layer to a file. Layer names are unique per file.
+func CreateLayer(c *fiber.Ctx) error {
+	fmt.Println("CreateLayer")
+
+	file, status, err := findEditableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request layerRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse layer",
+		})
+	}
+	if request.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errLayerNameInvalid.Error(),
+		})
+	}
+
+	layer := models.Layer{FileID: file.ID, Visible: true}
+	if request.Order == nil {
+		var top struct{ Order *int }
+		database.DB.Model(&models.Layer{}).Select(`MAX(sort_order) AS "order"`).Where("file_id = ?", file.ID).Scan(&top)
+		if top.Order != nil {
+			layer.Order = *top.Order + 1
+		}
+	}
+	if err := request.apply(&layer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if layerNameTaken(layer) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": errLayerNameTaken.Error(),
+		})
+	}
+
+	if err := database.DB.Create(&layer).Error; err != nil {
+		fmt.Printf("ERROR creating layer of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create layer",
+		})
+	}
+	audit.Record(c, audit.LayerCreate, audit.EntityLayer, layer.ID, nil, layer)
+
+	return c.Status(fiber.StatusCreated).JSON(layer)
+}
+
+// UpdateLayer - Rename, recolor, reorder a layer or change whether it is shown
+// by default; fields left out keep their values
+func UpdateLayer(c *fiber.Ctx) error {
+	fmt.Println("UpdateLayer")
+
+	layer, status, err := findEditableLayer(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request layerRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse layer",
+		})
+	}
+	before := layer
+	if err := request.apply(&layer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if layer.Name != before.Name && layerNameTaken(layer) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": errLayerNameTaken.Error(),
+		})
+	}
+
+	if err := database.DB.Save(&layer).Error; err != nil {
+		fmt.Printf("ERROR updating layer %d: %v\n", layer.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update layer",
+		})
+	}
+	audit.Record(c, audit.LayerUpdate, audit.EntityLayer, layer.ID, before, layer)
+
+	return c.JSON(layer)
+}
+
+// DeleteLayer - Remove a layer from a file. Its drawings are kept, on no
+// layer, as a new version; it is refused while any of them is locked against
+// the caller. Deleted drawings are taken off it as they are, so they are
+// restored on no layer.
+func DeleteLayer(c *fiber.Ctx) error {
+	fmt.Println("DeleteLayer")
+
+	layer, status, err := findEditableLayer(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Coalesced updates placing drawings on the layer are written first, so
+	// they are moved off it too
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.LayerID != nil && *d.LayerID == layer.ID })
+	var drawings []models.Drawing
+	database.DB.Where("layer_id = ?", layer.ID).Find(&drawings)
+	if status, err := checkDrawingLocks(c, drawings); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var moved int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		columns := groupColumns(c, nil)
+		delete(columns, "group_id")
+		columns["layer_id"] = nil
+		result := tx.Model(&models.Drawing{}).Where("layer_id = ?", layer.ID).UpdateColumns(columns)
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+		err := tx.Unscoped().Model(&models.Drawing{}).
+			Where("layer_id = ? AND deleted_at IS NOT NULL", layer.ID).
+			UpdateColumn("layer_id", nil).Error
+		if err != nil {
+			return err
+		}
+		// Deleted for good, so the name can be used again
+		return tx.Unscoped().Delete(&layer).Error
+	})
+	if err != nil {
+		fmt.Printf("ERROR deleting layer %d: %v\n", layer.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete layer",
+		})
+	}
+	recordDrawingChanges(c, drawings)
+	audit.Record(c, audit.LayerDelete, audit.EntityLayer, layer.ID, layer, nil)
+
+	return c.JSON(fiber.Map{
+		"message":  "Layer deleted successfully",
+		"drawings": moved,
+	})
+}
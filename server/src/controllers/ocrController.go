@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+const (
+	// maxConcurrentOCR bounds how many OCR jobs run at once, as each keeps the CPU busy
+	maxConcurrentOCR   = 1
+	defaultOCRLanguage = "eng"
+)
+
+var (
+	ocrSlots = make(chan struct{}, maxConcurrentOCR)
+	// ocrLanguagePattern matches tesseract language codes joined with "+"
+	ocrLanguagePattern = regexp.MustCompile(`^[a-z_]{3,}(\+[a-z_]{3,})*$`)
+	errOCRRevised      = errors.New("The file was revised while the job was queued")
+)
+
+type ocrRequest struct {
+	// Language defaults to OCR_LANGUAGE
+	Language   string `json:"language"`
+	Searchable bool   `json:"searchable"`
+}
+
+// ocrLanguage reads OCR_LANGUAGE, the tesseract languages used unless a job
+// names others
+func ocrLanguage() string {
+	if language := os.Getenv("OCR_LANGUAGE"); language != "" {
+		return language
+	}
+	return defaultOCRLanguage
+}
+
+// ocrAutomatic reports whether OCR_AUTO=true asks for files without any text
+// to be recognized when they are indexed
+func ocrAutomatic() bool {
+	return os.Getenv("OCR_AUTO") == "true"
+}
+
+func ocrPDFKey(hash string) string {
+	return storage.DerivedKey(hash, "ocr.pdf")
+}
+
+// ocrJobParams are the settings of a queued job running an OCR job
+type ocrJobParams struct {
+	OCRJobID uint `json:"ocrJobId"`
+}
+
+// startOCR creates a job for the file's current content and queues it. A job
+// already waiting for the same content is returned instead. Jobs nobody
+// requested are queued for no owner.
+func startOCR(file models.File, language string, searchable bool, requestedByID *uint) (models.OCRJob, error) {
+	var job models.OCRJob
+	err := database.DB.
+		Where("file_id = ? AND hash = ? AND status IN ?", file.ID, file.Hash, []string{models.OCRPending, models.OCRRunning}).
+		First(&job).Error
+	if err == nil {
+		return job, nil
+	}
+
+	job = models.OCRJob{
+		FileID:        file.ID,
+		Hash:          file.Hash,
+		Language:      language,
+		Searchable:    searchable,
+		Status:        models.OCRPending,
+		RequestedByID: requestedByID,
+	}
+	var ownerID uint
+	if requestedByID != nil {
+		ownerID = *requestedByID
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		return queueOCRJob(tx, &job, ownerID, file.WorkspaceID)
+	})
+	return job, err
+}
+
+// queueOCRJob queues the job that runs an OCR job
+func queueOCRJob(tx *gorm.DB, job *models.OCRJob, ownerID, workspaceID uint) error {
+	queued, err := queueJob(tx, "ocr", ownerID, workspaceID, ocrJobParams{OCRJobID: job.ID})
+	if err != nil {
+		return err
+	}
+	job.JobID = &queued.ID
+	return tx.Model(job).UpdateColumn("job_id", queued.ID).Error
+}
+
+// ResumeOCRJobs queues the unfinished jobs created before OCR ran in the job
+// queue; RunJobs resumes the others
+func ResumeOCRJobs() {
+	var jobs []models.OCRJob
+	database.DB.Preload("File").
+		Where("status IN ? AND job_id IS NULL", []string{models.OCRPending, models.OCRRunning}).
+		Find(&jobs)
+	for _, job := range jobs {
+		var ownerID uint
+		if job.RequestedByID != nil {
+			ownerID = *job.RequestedByID
+		}
+		database.DB.Model(&job).UpdateColumn("status", models.OCRPending)
+		if err := queueOCRJob(database.DB, &job, ownerID, job.File.WorkspaceID); err != nil {
+			fmt.Printf("ERROR queueing OCR job %d: %v\n", job.ID, err)
+		}
+	}
+}
+
+// runOCRJob runs the OCR job a queued job names and records how it ended.
+// Only the main process runs jobs, so ocrSlots bounds them across the server.
+func runOCRJob(run *jobRun) (interface{}, error) {
+	var params ocrJobParams
+	if err := run.params(&params); err != nil {
+		return nil, err
+	}
+	ocrSlots <- struct{}{}
+	defer func() { <-ocrSlots }()
+
+	// The queue runs each job once, including one left running by a restart
+	var job models.OCRJob
+	if err := database.DB.First(&job, params.OCRJobID).Error; err != nil {
+		return nil, err
+	}
+	database.DB.Model(&job).UpdateColumn("status", models.OCRRunning)
+
+	updates := map[string]interface{}{
+		"status":      models.OCRDone,
+		"finished_at": time.Now(),
+	}
+	err := recognizeText(job)
+	if err != nil {
+		updates["status"] = models.OCRFailed
+		updates["error"] = err.Error()
+	}
+	database.DB.Model(&job).Updates(updates)
+	return fiber.Map{"ocrJobId": job.ID, "fileId": job.FileID}, err
+}
+
+// recognizeText runs OCR over the job's content, replaces the text of its
+// scanned pages in the text cache and search index and keeps the searchable
+// PDF if the job asked for it
+func recognizeText(job models.OCRJob) error {
+	var file models.File
+	if err := database.DB.First(&file, job.FileID).Error; err != nil {
+		return err
+	}
+	if file.Hash != job.Hash {
+		return errOCRRevised
+	}
+	path, cleanup, err := localStoredFile(file)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	searchable := filepath.Join(workDir, "ocr.pdf")
+	recognized, err := pdf.OCR(path, searchable, filepath.Join(workDir, "ocr.txt"), job.Language)
+	if err != nil {
+		return err
+	}
+
+	// Pages OCR skipped keep the text they had
+	pages, err := fileText(file)
+	if err != nil {
+		return err
+	}
+	for i, text := range recognized {
+		if i < len(pages) && strings.TrimSpace(text) != "" {
+			pages[i] = text
+		}
+	}
+
+	cached, err := json.Marshal(pages)
+	if err != nil {
+		return err
+	}
+	if err := storage.Store.Put(textKey(file.Hash), bytes.NewReader(cached), int64(len(cached))); err != nil {
+		return err
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hash = ?", file.Hash).Delete(&models.PageText{}).Error; err != nil {
+			return err
+		}
+		rows := make([]models.PageText, 0, len(pages))
+		for i, text := range pages {
+			rows = append(rows, models.PageText{Hash: file.Hash, Page: i + 1, Text: text})
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&rows, 100).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if job.Searchable {
+		return storage.PutFile(ocrPDFKey(file.Hash), searchable)
+	}
+	return nil
+}
+
+// StartOCR - Recognize the text of a file's scanned pages in the background.
+// The text is served by the text endpoints and found by search once the job is
+// done; searchable also keeps a copy of the PDF with an invisible text layer.
+func StartOCR(c *fiber.Ctx) error {
+	fmt.Println("StartOCR")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	var request ocrRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse OCR options",
+			})
+		}
+	}
+	if request.Language == "" {
+		request.Language = ocrLanguage()
+	}
+	if !ocrLanguagePattern.MatchString(request.Language) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "language must be tesseract language codes joined with +, like eng+deu",
+		})
+	}
+
+	userID := middleware.CurrentClaims(c).UserID()
+	job, err := startOCR(file, request.Language, request.Searchable, &userID)
+	if err != nil {
+		fmt.Printf("ERROR creating OCR job for file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start OCR",
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// GetOCRStatus - Get the latest OCR job of a file
+func GetOCRStatus(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var job models.OCRJob
+	if err := database.DB.Where("file_id = ?", file.ID).Order("id DESC").First(&job).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "OCR was not run on this file",
+		})
+	}
+	return c.JSON(job)
+}
+
+// DownloadOCRPDF - Download the searchable copy of a file made by OCR
+func DownloadOCRPDF(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	key := ocrPDFKey(file.Hash)
+	if exists, err := storage.Store.Exists(key); err != nil || !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No searchable copy of this file, run OCR with searchable first",
+		})
+	}
+	filename := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)) + "-ocr.pdf"
+	return sendStoredContent(c, key, filename)
+}
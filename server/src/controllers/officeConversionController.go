@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+// conversionSourcePath is where a job's document waits to be converted. It
+// keeps the document's extension, which LibreOffice goes by.
+func conversionSourcePath(job models.ConversionJob) string {
+	return filepath.Join(uploadStagingDir(), fmt.Sprintf("conversion-%d%s", job.ID, strings.ToLower(filepath.Ext(job.Filename))))
+}
+
+// startConversion stages the uploaded office document at path and queues a job
+// converting it to a PDF saved for the caller in folder
+func startConversion(c *fiber.Ctx, path, filename string, folder *models.Folder) (models.ConversionJob, error) {
+	job := models.ConversionJob{
+		OwnerID:     middleware.CurrentClaims(c).UserID(),
+		WorkspaceID: middleware.CurrentWorkspace(c).ID,
+		Filename:    filepath.Base(filename),
+		Status:      models.ConversionPending,
+	}
+	if folder != nil {
+		job.FolderID = &folder.ID
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+	if err := stageDocument(path, conversionSourcePath(job)); err != nil {
+		database.DB.Unscoped().Delete(&job)
+		return job, err
+	}
+	if err := queueConversionJob(&job); err != nil {
+		os.Remove(conversionSourcePath(job))
+		database.DB.Unscoped().Delete(&job)
+		return job, err
+	}
+	return job, nil
+}
+
+// conversionJobParams are the settings of a queued job running a conversion
+type conversionJobParams struct {
+	ConversionJobID uint `json:"conversionJobId"`
+}
+
+// queueConversionJob queues the job that runs a conversion for its owner
+func queueConversionJob(job *models.ConversionJob) error {
+	queued, err := queueJob(database.DB, "convert", job.OwnerID, job.WorkspaceID, conversionJobParams{ConversionJobID: job.ID})
+	if err != nil {
+		return err
+	}
+	job.JobID = &queued.ID
+	return database.DB.Model(job).UpdateColumn("job_id", queued.ID).Error
+}
+
+// stageDocument copies an upload out of its scratch directory, which may be
+// on another file system than the staging directory
+func stageDocument(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// ResumeConversionJobs queues the unfinished jobs created before conversions
+// ran in the job queue; RunJobs resumes the others
+func ResumeConversionJobs() {
+	var jobs []models.ConversionJob
+	database.DB.Where("status IN ? AND job_id IS NULL", []string{models.ConversionPending, models.ConversionRunning}).Find(&jobs)
+	for _, job := range jobs {
+		database.DB.Model(&job).UpdateColumn("status", models.ConversionPending)
+		if err := queueConversionJob(&job); err != nil {
+			fmt.Printf("ERROR queueing conversion job %d: %v\n", job.ID, err)
+		}
+	}
+}
+
+// runConversionJob runs the conversion a queued job names and records how it
+// ended. The staged document is removed either way.
+func runConversionJob(run *jobRun) (interface{}, error) {
+	var params conversionJobParams
+	if err := run.params(&params); err != nil {
+		return nil, err
+	}
+	// The queue runs each job once, including one left running by a restart
+	var job models.ConversionJob
+	if err := database.DB.First(&job, params.ConversionJobID).Error; err != nil {
+		return nil, err
+	}
+	database.DB.Model(&job).UpdateColumn("status", models.ConversionRunning)
+	defer os.Remove(conversionSourcePath(job))
+
+	updates := map[string]interface{}{
+		"status":      models.ConversionDone,
+		"finished_at": time.Now(),
+	}
+	file, err := convertDocument(job)
+	if err != nil {
+		updates["status"] = models.ConversionFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["file_id"] = file.ID
+		run.updates["file_id"] = file.ID
+		audit.RecordSystem(audit.FileUpload, audit.EntityFile, file.ID, &job.WorkspaceID, nil, file)
+	}
+	database.DB.Model(&job).Updates(updates)
+	return fiber.Map{"conversionJobId": job.ID}, err
+}
+
+// convertDocument converts the job's document and saves the PDF as a file
+func convertDocument(job models.ConversionJob) (models.File, error) {
+	workDir, err := newWorkDir()
+	if err != nil {
+		return models.File{}, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// The source is named plainly, as LibreOffice names its output after it
+	source := filepath.Join(workDir, "document"+strings.ToLower(filepath.Ext(job.Filename)))
+	if err := stageDocument(conversionSourcePath(job), source); err != nil {
+		return models.File{}, err
+	}
+	converted := filepath.Join(workDir, "converted.pdf")
+	if err := pdf.ConvertOffice(source, converted); err != nil {
+		return models.File{}, err
+	}
+	fileHash, err := hashFile(converted)
+	if err != nil {
+		return models.File{}, err
+	}
+	filename := strings.TrimSuffix(job.Filename, filepath.Ext(job.Filename)) + ".pdf"
+	return saveOwnedFile(converted, filename, fileHash, job.OwnerID, job.WorkspaceID, folderByID(job.FolderID))
+}
+
+// GetConversionJob - Get a conversion of an uploaded office document. Once
+// its status is done, fileId is the converted file.
+func GetConversionJob(c *fiber.Ctx) error {
+	var job models.ConversionJob
+	err := database.DB.Where("id = ? AND owner_id = ?", c.Params("id"), middleware.CurrentClaims(c).UserID()).First(&job).Error
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Conversion not found",
+		})
+	}
+	return c.JSON(job)
+}
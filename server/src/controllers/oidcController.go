@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcNonceCookie = "oidc_nonce"
+	oidcCookieTTL   = 10 * time.Minute
+)
+
+// oidcClient holds the discovered provider configuration
+type oidcClient struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *oidcClient
+)
+
+// getOIDCClient discovers the provider on first use. Discovery is retried on
+// later calls if the provider was unreachable.
+func getOIDCClient(ctx context.Context) (*oidcClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if issuer == "" || clientID == "" {
+		return nil, errors.New("single sign-on is not configured")
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %v", err)
+	}
+
+	oidcCached = &oidcClient{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+	return oidcCached, nil
+}
+
+// oidcIdentity is the subset of ID token claims used for provisioning
+type oidcIdentity struct {
+	Subject           string
+	Email             string
+	PreferredUsername string
+	Groups            []string
+}
+
+func parseOIDCIdentity(idToken *oidc.IDToken) (oidcIdentity, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return oidcIdentity{}, err
+	}
+
+	identity := oidcIdentity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	groupsClaim := os.Getenv("OIDC_GROUPS_CLAIM")
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	if groups, ok := claims[groupsClaim].([]interface{}); ok {
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// provisionOIDCUser finds or creates the account linked to the identity and
+// refreshes its role from the IdP groups
+func provisionOIDCUser(identity oidcIdentity) (models.User, error) {
+	role := auth.RoleForGroups("OIDC", identity.Groups)
+
+	var user models.User
+	result := database.DB.Where("oidc_subject = ?", identity.Subject).First(&user)
+	if result.Error == nil {
+		user.Role = role
+		if identity.Email != "" {
+			user.Email = identity.Email
+		}
+		return user, database.DB.Save(&user).Error
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return user, result.Error
+	}
+
+	username := identity.PreferredUsername
+	if username == "" {
+		username = identity.Email
+	}
+	if username == "" {
+		username = identity.Subject
+	}
+
+	// Avoid clashing with local accounts of the same name
+	var count int64
+	database.DB.Model(&models.User{}).Where("username = ?", username).Count(&count)
+	if count > 0 {
+		username = username + "@sso"
+	}
+
+	subject := identity.Subject
+	user = models.User{
+		Username:    username,
+		Role:        role,
+		Email:       identity.Email,
+		AuthSource:  auth.SourceOIDC,
+		OIDCSubject: &subject,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		return user, err
+	}
+	return user, auth.JoinDefaultWorkspace(user.ID)
+}
+
+// OIDCLogin - Redirect to the identity provider's login page
+func OIDCLogin(c *fiber.Ctx) error {
+	fmt.Println("OIDCLogin")
+
+	client, err := getOIDCClient(c.Context())
+	if err != nil {
+		fmt.Printf("ERROR starting OIDC login: %v\n", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Single sign-on is unavailable",
+		})
+	}
+
+	state, err := token.Random(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+	nonce, err := token.Random(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+
+	// State and nonce live in cookies because Prefork workers don't share memory
+	for name, value := range map[string]string{oidcStateCookie: state, oidcNonceCookie: nonce} {
+		c.Cookie(&fiber.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     "/api/auth/oidc",
+			Expires:  time.Now().Add(oidcCookieTTL),
+			HTTPOnly: true,
+			Secure:   c.Protocol() == "https",
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+
+	return c.Redirect(client.config.AuthCodeURL(state, oidc.Nonce(nonce)), fiber.StatusFound)
+}
+
+// OIDCCallback - Complete the login, provision the user and hand a token to the client
+func OIDCCallback(c *fiber.Ctx) error {
+	fmt.Println("OIDCCallback")
+
+	client, err := getOIDCClient(c.Context())
+	if err != nil {
+		fmt.Printf("ERROR completing OIDC login: %v\n", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Single sign-on is unavailable",
+		})
+	}
+
+	state := c.Cookies(oidcStateCookie)
+	nonce := c.Cookies(oidcNonceCookie)
+	for _, name := range []string{oidcStateCookie, oidcNonceCookie} {
+		c.Cookie(&fiber.Cookie{Name: name, Path: "/api/auth/oidc", Expires: time.Unix(0, 0), HTTPOnly: true})
+	}
+
+	if state == "" || c.Query("state") != state {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid login state",
+		})
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": fmt.Sprintf("Identity provider returned an error: %s", errorCode),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	oauthToken, err := client.config.Exchange(ctx, c.Query("code"))
+	if err != nil {
+		fmt.Printf("ERROR exchanging OIDC code: %v\n", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to exchange authorization code",
+		})
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Identity provider didn't return an ID token",
+		})
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid ID token",
+		})
+	}
+
+	identity, err := parseOIDCIdentity(idToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to read ID token claims",
+		})
+	}
+
+	user, err := provisionOIDCUser(identity)
+	if err != nil {
+		fmt.Printf("ERROR provisioning OIDC user: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to provision user",
+		})
+	}
+	if user.DisabledAt != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account is disabled",
+		})
+	}
+
+	tokens, err := startSession(c, user)
+	if err != nil {
+		fmt.Printf("ERROR issuing token: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue token",
+		})
+	}
+
+	// Tokens travel in the fragment so they never reach server logs
+	redirect := os.Getenv("OIDC_POST_LOGIN_REDIRECT")
+	if redirect == "" {
+		redirect = "/"
+	}
+	fragment := url.Values{}
+	fragment.Set("token", tokens.Token)
+	fragment.Set("refreshToken", tokens.RefreshToken)
+	return c.Redirect(redirect+"#"+fragment.Encode(), fiber.StatusFound)
+}
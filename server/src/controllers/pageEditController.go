@@ -0,0 +1,500 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+// What happens to drawings on pages removed from a file
+const (
+	// removedDrawingsFlag keeps them, marked as orphaned
+	removedDrawingsFlag = "flag"
+	// removedDrawingsDelete deletes them
+	removedDrawingsDelete = "delete"
+)
+
+// remapDrawingPages moves the drawings of a file along with their pages, which
+// newPages maps from the old page numbers to the new ones. Drawings on pages
+// missing from newPages are flagged as orphaned, or deleted with
+// deleteRemoved. Deleted drawings are moved and flagged too, so they are
+// restored where they belong. It returns how many drawings were on removed
+// pages.
+func remapDrawingPages(c *fiber.Ctx, tx *gorm.DB, fileID uint, newPages map[int]int, deleteRemoved bool) (int64, error) {
+	// Each change is a new version by the caller
+	columns := groupColumns(c, nil)
+	delete(columns, "group_id")
+
+	kept := make([]int, 0, len(newPages))
+	var moved []int
+	var expr strings.Builder
+	var args []interface{}
+	expr.WriteString("CASE page_number")
+	for old, page := range newPages {
+		kept = append(kept, old)
+		if old != page {
+			moved = append(moved, old)
+			expr.WriteString(" WHEN ? THEN ?")
+			args = append(args, old, page)
+		}
+	}
+	expr.WriteString(" ELSE page_number END")
+
+	removed := func(query *gorm.DB) *gorm.DB {
+		query = query.Model(&models.Drawing{}).Where("file_id = ? AND NOT orphaned", fileID)
+		if len(kept) > 0 {
+			query = query.Where("page_number NOT IN ?", kept)
+		}
+		return query
+	}
+	// Deleted drawings first, so those the edit deletes are left as they were
+	columns["orphaned"] = true
+	if err := removed(tx.Unscoped()).Where("deleted_at IS NOT NULL").UpdateColumns(columns).Error; err != nil {
+		return 0, err
+	}
+	var result *gorm.DB
+	if deleteRemoved {
+		result = removed(tx).Delete(&models.Drawing{})
+	} else {
+		result = removed(tx).UpdateColumns(columns)
+	}
+	delete(columns, "orphaned")
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	if len(moved) > 0 {
+		columns["page_number"] = gorm.Expr(expr.String(), args...)
+		err := tx.Unscoped().Model(&models.Drawing{}).
+			Where("file_id = ? AND NOT orphaned AND page_number IN ?", fileID, moved).
+			UpdateColumns(columns).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return result.RowsAffected, nil
+}
+
+// pageEditDrawings loads the drawings of a file that a page edit moves or
+// removes, deleted or not, as remapDrawingPages finds them
+func pageEditDrawings(fileID uint, newPages map[int]int) ([]models.Drawing, error) {
+	var drawings []models.Drawing
+	if err := database.DB.Unscoped().Where("file_id = ? AND NOT orphaned", fileID).Find(&drawings).Error; err != nil {
+		return nil, err
+	}
+	changed := drawings[:0]
+	for _, drawing := range drawings {
+		if page, ok := newPages[drawing.PageNumber]; !ok || page != drawing.PageNumber {
+			changed = append(changed, drawing)
+		}
+	}
+	return changed, nil
+}
+
+// recordDrawingChanges keeps the earlier states of drawings changed in bulk,
+// like by a page edit, in their history and the audit log
+func recordDrawingChanges(c *fiber.Ctx, drawings []models.Drawing) {
+	if len(drawings) == 0 {
+		return
+	}
+	ids := make([]uint, len(drawings))
+	for i, drawing := range drawings {
+		ids[i] = drawing.ID
+	}
+	var changed []models.Drawing
+	database.DB.Unscoped().Where("id IN ?", ids).Find(&changed)
+	after := make(map[uint]models.Drawing, len(changed))
+	for _, drawing := range changed {
+		after[drawing.ID] = drawing
+	}
+
+	// Drawings deleted by the edit were there before it
+	deletedByEdit := func(drawing models.Drawing) bool {
+		return !drawing.DeletedAt.Valid && after[drawing.ID].DeletedAt.Valid
+	}
+	var updated, deleted []models.Drawing
+	for _, drawing := range drawings {
+		if deletedByEdit(drawing) {
+			deleted = append(deleted, drawing)
+		} else {
+			updated = append(updated, drawing)
+		}
+	}
+	recordDrawingRevisions(c, models.DrawingRevisionUpdate, updated...)
+	recordDrawingRevisions(c, models.DrawingRevisionDelete, deleted...)
+	for _, drawing := range drawings {
+		if deletedByEdit(drawing) {
+			audit.Record(c, audit.DrawingDelete, audit.EntityDrawing, drawing.ID, drawing, nil)
+		} else {
+			audit.Record(c, audit.DrawingUpdate, audit.EntityDrawing, drawing.ID, drawing, after[drawing.ID])
+		}
+	}
+}
+
+// saveRevisionWithDrawings saves the result of a page edit as the next revision
+// of file and moves its drawings along with their pages. It is refused when
+// any of the drawings it changes is locked against the caller.
+func saveRevisionWithDrawings(c *fiber.Ctx, file models.File, path string, newPages map[int]int, deleteRemoved bool, details fiber.Map) error {
+	// Coalesced updates written later would put drawings back on their old pages
+	flushPendingDrawingUpdates(func(d models.Drawing) bool { return d.FileID == file.ID })
+
+	drawings, err := pageEditDrawings(file.ID, newPages)
+	if err != nil {
+		fmt.Printf("ERROR loading drawings of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load drawings",
+		})
+	}
+	// Deleted drawings are only moved along, whoever locked them
+	var live []models.Drawing
+	for _, drawing := range drawings {
+		if !drawing.DeletedAt.Valid {
+			live = append(live, drawing)
+		}
+	}
+	if status, err := checkDrawingLocks(c, live); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, status, err := storeOperationRevision(c, file, path, details, func(tx *gorm.DB, file models.File) error {
+		removed, err := remapDrawingPages(c, tx, file.ID, newPages, deleteRemoved)
+		if err != nil {
+			return err
+		}
+		if deleteRemoved {
+			details["deletedDrawings"] = removed
+		} else {
+			details["orphanedDrawings"] = removed
+		}
+		return nil
+	})
+	if err == nil {
+		recordDrawingChanges(c, drawings)
+	}
+	return sendOperationFile(c, file, status, err)
+}
+
+type deletePagesRequest struct {
+	// Pages like "2,4-6"
+	Pages string `json:"pages"`
+	// Drawings on the deleted pages are flagged as orphaned, or deleted with "delete"
+	Drawings string `json:"drawings"`
+	Output   string `json:"output"`
+}
+
+// DeletePages - Remove pages from a file. In the new version its drawings move
+// with their pages, and those on removed pages are flagged as orphaned or
+// deleted; with output "file" the result is a new file without drawings.
+func DeletePages(c *fiber.Ctx) error {
+	fmt.Println("DeletePages")
+
+	var request deletePagesRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse page deletion",
+		})
+	}
+	if strings.TrimSpace(request.Pages) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "pages is required",
+		})
+	}
+	if request.Drawings == "" {
+		request.Drawings = removedDrawingsFlag
+	}
+	if request.Drawings != removedDrawingsFlag && request.Drawings != removedDrawingsDelete {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "drawings must be flag or delete",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	pageCount, err := documentPageCount(file, path)
+	if err != nil {
+		fmt.Printf("ERROR reading page count of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	ranges, err := pdf.ParsePageRanges(request.Pages, pageCount)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	deleted := pdf.PageSet(ranges)
+	if len(deleted) >= pageCount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one page must be kept",
+		})
+	}
+
+	kept := make([]int, 0, pageCount-len(deleted))
+	newPages := make(map[int]int, pageCount-len(deleted))
+	for page := 1; page <= pageCount; page++ {
+		if !deleted[page] {
+			kept = append(kept, page)
+			newPages[page] = len(kept)
+		}
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare page deletion",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "pages.pdf")
+	if err := pdf.SelectPages(path, outPath, kept); err != nil {
+		fmt.Printf("ERROR deleting pages of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to delete pages",
+		})
+	}
+
+	details := fiber.Map{
+		"operation": "deletePages",
+		"pages":     request.Pages,
+	}
+	if output == outputFile {
+		return sendOperationOutput(c, file, outPath, output, "pages", details)
+	}
+	return saveRevisionWithDrawings(c, file, outPath, newPages, request.Drawings == removedDrawingsDelete, details)
+}
+
+type reorderPagesRequest struct {
+	// Order lists every page once, in the new order
+	Order  []int  `json:"order"`
+	Output string `json:"output"`
+}
+
+// ReorderPages - Put the pages of a file in a new order. In the new version its
+// drawings move with their pages; with output "file" the result is a new file
+// without drawings.
+func ReorderPages(c *fiber.Ctx) error {
+	fmt.Println("ReorderPages")
+
+	var request reorderPagesRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse page order",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	pageCount, err := documentPageCount(file, path)
+	if err != nil {
+		fmt.Printf("ERROR reading page count of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	if len(request.Order) != pageCount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("order must list each of the %d pages once", pageCount),
+		})
+	}
+	newPages := make(map[int]int, pageCount)
+	for i, page := range request.Order {
+		if _, seen := newPages[page]; seen || page < 1 || page > pageCount {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("order must list each of the %d pages once", pageCount),
+			})
+		}
+		newPages[page] = i + 1
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare page reordering",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "reordered.pdf")
+	if err := pdf.SelectPages(path, outPath, request.Order); err != nil {
+		fmt.Printf("ERROR reordering pages of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to reorder pages",
+		})
+	}
+
+	details := fiber.Map{
+		"operation": "reorderPages",
+		"order":     request.Order,
+	}
+	if output == outputFile {
+		return sendOperationOutput(c, file, outPath, output, "reordered", details)
+	}
+	return saveRevisionWithDrawings(c, file, outPath, newPages, false, details)
+}
+
+type insertPagesRequest struct {
+	SourceFileID uint `json:"sourceFileId"`
+	// SourcePages like "1-3"; empty inserts every page of the source
+	SourcePages string `json:"sourcePages"`
+	// Position is the page the inserted ones follow, 0 to insert before the first
+	Position int    `json:"position"`
+	Output   string `json:"output"`
+}
+
+// InsertPages - Splice pages of another file into a file after a given page.
+// In the new version its drawings move with their pages; with output "file"
+// the result is a new file without drawings.
+func InsertPages(c *fiber.Ctx) error {
+	fmt.Println("InsertPages")
+
+	var request insertPagesRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse page insertion",
+		})
+	}
+	if request.SourceFileID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "sourceFileId is required",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	source, sourcePath, sourceCleanup, status, err := localReadableFile(c, request.SourceFileID)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer sourceCleanup()
+
+	pageCount, err := documentPageCount(file, path)
+	var sourcePageCount int
+	if err == nil {
+		sourcePageCount, err = documentPageCount(source, sourcePath)
+	}
+	if err != nil {
+		fmt.Printf("ERROR reading page counts of files %d and %d: %v\n", file.ID, source.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the documents",
+		})
+	}
+	if request.Position < 0 || request.Position > pageCount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("position must be between 0 and %d", pageCount),
+		})
+	}
+
+	var sourceRanges []pdf.PageRange
+	inserted := sourcePageCount
+	if strings.TrimSpace(request.SourcePages) != "" {
+		sourceRanges, err = pdf.ParsePageRanges(request.SourcePages, sourcePageCount)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		inserted = 0
+		for _, r := range sourceRanges {
+			if r.From <= r.To {
+				inserted += r.To - r.From + 1
+			} else {
+				inserted += r.From - r.To + 1
+			}
+		}
+	}
+
+	// The pages before the position, the inserted ones, then the rest
+	var inputs []pdf.MergeInput
+	if request.Position > 0 {
+		inputs = append(inputs, pdf.MergeInput{Path: path, Ranges: []pdf.PageRange{{From: 1, To: request.Position}}})
+	}
+	inputs = append(inputs, pdf.MergeInput{Path: sourcePath, Ranges: sourceRanges})
+	if request.Position < pageCount {
+		inputs = append(inputs, pdf.MergeInput{Path: path, Ranges: []pdf.PageRange{{From: request.Position + 1, To: pageCount}}})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare page insertion",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "inserted.pdf")
+	if err := pdf.Merge(inputs, outPath); err != nil {
+		fmt.Printf("ERROR inserting pages of file %d into file %d: %v\n", source.ID, file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to insert pages",
+		})
+	}
+
+	newPages := make(map[int]int, pageCount)
+	for page := 1; page <= pageCount; page++ {
+		newPages[page] = page
+		if page > request.Position {
+			newPages[page] += inserted
+		}
+	}
+	details := fiber.Map{
+		"operation":    "insertPages",
+		"sourceFileId": source.ID,
+		"sourcePages":  request.SourcePages,
+		"position":     request.Position,
+	}
+	if output == outputFile {
+		return sendOperationOutput(c, file, outPath, output, "inserted", details)
+	}
+	return saveRevisionWithDrawings(c, file, outPath, newPages, false, details)
+}
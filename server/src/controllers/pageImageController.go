@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+const (
+	defaultPageDPI = 96
+	minPageDPI     = 24
+	maxPageDPI     = 300
+)
+
+func pageImageKey(hash string, page, dpi int, format string) string {
+	return storage.CacheKey(hash, fmt.Sprintf("page-%d-%d%s", page, dpi, pdf.ImageExtension(format)))
+}
+
+// renderPageImage renders a page of the file and caches it in storage
+func renderPageImage(file models.File, page, dpi int, format string) error {
+	path, cleanup, err := localStoredFile(file)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	image := filepath.Join(workDir, "page"+pdf.ImageExtension(format))
+	if err := pdf.RenderPage(path, image, page, dpi, format); err != nil {
+		return err
+	}
+	return storage.PutFile(pageImageKey(file.Hash, page, dpi, format), image)
+}
+
+// sendPageImage responds with the page of the route rendered in format at
+// ?dpi= (default 96). Rendered pages are cached per content, page, resolution
+// and format.
+func sendPageImage(c *fiber.Ctx, format string) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	page, err := c.ParamsInt("page")
+	if err != nil || page < 1 || file.PageCount > 0 && page > file.PageCount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page number",
+		})
+	}
+	dpi := c.QueryInt("dpi", defaultPageDPI)
+	if dpi < minPageDPI || dpi > maxPageDPI {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("dpi must be between %d and %d", minPageDPI, maxPageDPI),
+		})
+	}
+
+	recordFileView(c, file)
+
+	// The content never changes for a hash, so the image can be revalidated by name
+	etag := fmt.Sprintf(`"%s-%d-%d-%s"`, file.Hash, page, dpi, format)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	key := pageImageKey(file.Hash, page, dpi, format)
+	exists, err := storage.Store.Exists(key)
+	if err == nil && !exists {
+		err = renderPageImage(file, page, dpi, format)
+	}
+	if err != nil {
+		fmt.Printf("ERROR rendering page %d of file %d: %v\n", page, file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to render page",
+		})
+	}
+
+	r, size, err := storage.Store.Get(key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read page image",
+		})
+	}
+	c.Type(format)
+	return c.SendStream(r, int(size))
+}
+
+// GetPageImage - Get a page of the file rendered as a PNG, see sendPageImage
+func GetPageImage(c *fiber.Ctx) error {
+	return sendPageImage(c, pdf.FormatPNG)
+}
+
+// RenderPage - Get a page of the file rendered as ?format=png (default) or
+// jpeg, for embedding page snapshots elsewhere. See sendPageImage.
+func RenderPage(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", pdf.FormatPNG))
+	if format == "jpg" {
+		format = pdf.FormatJPEG
+	}
+	if format != pdf.FormatPNG && format != pdf.FormatJPEG {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid format, expected png or jpeg",
+		})
+	}
+	return sendPageImage(c, format)
+}
+
+func pageGeometryKey(hash string) string {
+	return storage.DerivedKey(hash, "pages.json")
+}
+
+// storePageGeometry reads the page boxes of the file and caches them in storage
+func storePageGeometry(file models.File) error {
+	path, cleanup, err := localStoredFile(file)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	pageCount, err := documentPageCount(file, path)
+	if err != nil {
+		return err
+	}
+	pages, err := pdf.PageGeometries(path, pageCount)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fiber.Map{
+		"pageCount": len(pages),
+		"pages":     pages,
+	})
+	if err != nil {
+		return err
+	}
+	return storage.Store.Put(pageGeometryKey(file.Hash), bytes.NewReader(data), int64(len(data)))
+}
+
+// GetPageGeometry - Get the size, rotation and boxes of every page of the
+// file, for placing drawings without loading the document. The result is
+// cached per content.
+func GetPageGeometry(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	etag := fmt.Sprintf(`"%s-pages"`, file.Hash)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	key := pageGeometryKey(file.Hash)
+	exists, err := storage.Store.Exists(key)
+	if err == nil && !exists {
+		err = storePageGeometry(file)
+	}
+	if err != nil {
+		fmt.Printf("ERROR reading page geometry of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the pages",
+		})
+	}
+
+	r, size, err := storage.Store.Get(key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read the pages",
+		})
+	}
+	c.Type("json")
+	return c.SendStream(r, int(size))
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+const (
+	// maxStampFiles bounds how many files one request numbers
+	maxStampFiles      = 100
+	maxStampText       = 200
+	defaultStampSize   = 10
+	defaultStampColor  = "#000000"
+	defaultBatesDigits = 6
+	maxBatesDigits     = 12
+)
+
+// batesFormat describes Bates numbers like ABC000001: a prefix, the number
+// padded with zeros to digits and a suffix
+type batesFormat struct {
+	Prefix string `json:"prefix"`
+	Suffix string `json:"suffix"`
+	// Start is the number of the first stamped page, 1 by default
+	Start *int64 `json:"start"`
+	// Digits pads the number with zeros, 6 by default
+	Digits int `json:"digits"`
+}
+
+type stampRequest struct {
+	// FileIDs are numbered in order by the batch endpoint
+	FileIDs []uint `json:"fileIds"`
+	// Texts maps positions like bottom-right or top to the text stamped there.
+	// {bates}, {page}, {pages}, {date} and {filename} are replaced by the
+	// Bates number, the page number and count, the date and the file's name.
+	Texts map[string]string `json:"texts"`
+	Bates batesFormat       `json:"bates"`
+	// Pages like "1,3-5" are stamped and numbered; empty takes every page
+	Pages string `json:"pages"`
+	// Date replaces {date}, today's date like 2024-05-31 by default
+	Date     string  `json:"date"`
+	FontSize float64 `json:"fontSize"`
+	// Color of the text like "#ff0000"
+	Color  string `json:"color"`
+	Output string `json:"output"`
+}
+
+// validate checks the request and fills in defaults
+func (r *stampRequest) validate() error {
+	if len(r.Texts) == 0 {
+		return errors.New("texts must give the text of at least one position")
+	}
+	for position, text := range r.Texts {
+		if !pdf.ValidStampPosition(position) {
+			return fmt.Errorf("invalid position %q, use top, bottom or a corner like bottom-right", position)
+		}
+		if strings.TrimSpace(text) == "" || len(text) > maxStampText {
+			return fmt.Errorf("the text at %s must have 1 to %d characters", position, maxStampText)
+		}
+	}
+	if len(r.Bates.Prefix) > maxStampText || len(r.Bates.Suffix) > maxStampText {
+		return fmt.Errorf("bates prefix and suffix must be at most %d characters", maxStampText)
+	}
+	if r.Bates.Start == nil {
+		start := int64(1)
+		r.Bates.Start = &start
+	}
+	if *r.Bates.Start < 0 {
+		return errors.New("bates start must not be negative")
+	}
+	if r.Bates.Digits == 0 {
+		r.Bates.Digits = defaultBatesDigits
+	}
+	if r.Bates.Digits < 1 || r.Bates.Digits > maxBatesDigits {
+		return fmt.Errorf("bates digits must be between 1 and %d", maxBatesDigits)
+	}
+	if r.Date == "" {
+		r.Date = time.Now().Format("2006-01-02")
+	}
+	if r.FontSize == 0 {
+		r.FontSize = defaultStampSize
+	}
+	if r.FontSize < 1 || r.FontSize > 72 {
+		return errors.New("fontSize must be between 1 and 72")
+	}
+	if r.Color == "" {
+		r.Color = defaultStampColor
+	}
+	return nil
+}
+
+// batesNumber formats the Bates number n
+func (b batesFormat) batesNumber(n int64) string {
+	return fmt.Sprintf("%s%0*d%s", b.Prefix, b.Digits, n, b.Suffix)
+}
+
+// stampedFile is a file numbered by a stamp request, waiting to be saved
+type stampedFile struct {
+	file    models.File
+	path    string
+	cleanup func()
+	// first and last are the Bates numbers given, last < first when no page
+	// was stamped
+	first, last int64
+}
+
+// stampFile writes the texts of the request on the selected pages of the file
+// at path into workDir, numbering them from next. It returns the stamped
+// file's path and the next number.
+func stampFile(request stampRequest, file models.File, path, workDir string, color [3]float64, next int64) (string, int64, int, error) {
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return "", next, fiber.StatusUnprocessableEntity, errors.New("Failed to read the document")
+	}
+	sizes := info.PageSizes
+	var selected map[int]bool
+	if strings.TrimSpace(request.Pages) != "" {
+		ranges, err := pdf.ParsePageRanges(request.Pages, len(sizes))
+		if err != nil {
+			return "", next, fiber.StatusBadRequest, err
+		}
+		selected = pdf.PageSet(ranges)
+	}
+
+	texts := make([][]pdf.PageText, len(sizes))
+	pageCount := strconv.Itoa(len(sizes))
+	for i := range sizes {
+		if selected != nil && !selected[i+1] {
+			continue
+		}
+		replacer := strings.NewReplacer(
+			"{bates}", request.Bates.batesNumber(next),
+			"{page}", strconv.Itoa(i+1),
+			"{pages}", pageCount,
+			"{date}", request.Date,
+			"{filename}", file.Filename,
+		)
+		next++
+		for position, text := range request.Texts {
+			texts[i] = append(texts[i], pdf.PageText{Position: position, Text: replacer.Replace(text)})
+		}
+	}
+
+	stampPath := filepath.Join(workDir, "stamp.pdf")
+	if err := pdf.WritePageTexts(stampPath, sizes, texts, request.FontSize, color); err != nil {
+		return "", next, fiber.StatusBadRequest, err
+	}
+	outPath := filepath.Join(workDir, "stamped.pdf")
+	if err := pdf.StampPages(path, stampPath, outPath); err != nil {
+		fmt.Printf("ERROR stamping file %d: %v\n", file.ID, err)
+		return "", next, fiber.StatusUnprocessableEntity, errors.New("Failed to stamp file")
+	}
+	return outPath, next, 0, nil
+}
+
+// stampFiles stamps the files of the request in order, numbering their pages
+// on from one file to the next. Nothing is saved unless every file could be
+// stamped. It responds with the saved files.
+func stampFiles(c *fiber.Ctx, request stampRequest, ids []interface{}) error {
+	if err := request.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	color, err := parseHexColor(request.Color)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare stamping",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	stamped := make([]stampedFile, 0, len(ids))
+	defer func() {
+		for _, s := range stamped {
+			s.cleanup()
+		}
+	}()
+	next := *request.Bates.Start
+	for i, id := range ids {
+		file, path, cleanup, status, err := localOperationFile(c, id, output)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %v: %v", id, err),
+			})
+		}
+		stamped = append(stamped, stampedFile{file: file, cleanup: cleanup, first: next})
+		if file.Encrypted {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d is encrypted, decrypt it first", file.ID),
+			})
+		}
+		fileDir := filepath.Join(workDir, strconv.Itoa(i))
+		if err := os.Mkdir(fileDir, 0o700); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to prepare stamping",
+			})
+		}
+		stamped[i].path, next, status, err = stampFile(request, file, path, fileDir, color, next)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d: %v", file.ID, err),
+			})
+		}
+		stamped[i].last = next - 1
+	}
+
+	files := make([]models.File, 0, len(stamped))
+	for _, s := range stamped {
+		details := fiber.Map{"operation": "stamp", "pages": request.Pages}
+		if s.last >= s.first {
+			details["firstBates"] = request.Bates.batesNumber(s.first)
+			details["lastBates"] = request.Bates.batesNumber(s.last)
+		}
+		result, status, err := storeOperationOutput(c, s.file, s.path, output, "stamped", details)
+		if err != nil {
+			// Files saved before stay saved; the response tells which
+			return c.Status(status).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d: %v", s.file.ID, err),
+				"files": files,
+			})
+		}
+		files = append(files, result)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"files": files,
+		"next":  next,
+	})
+}
+
+// StampFile - Stamp Bates numbers or header and footer text like a sheet
+// number, revision or date on the pages of a file. The result is the next
+// revision of the file, or with output "file" a new file.
+func StampFile(c *fiber.Ctx) error {
+	fmt.Println("StampFile")
+
+	var request stampRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse stamp settings",
+		})
+	}
+	return stampFiles(c, request, []interface{}{c.Params("id")})
+}
+
+// StampFiles - Stamp several files like StampFile, numbering their pages on
+// from one file to the next in the order of fileIds. next is the number
+// following the last one given, to continue with another set.
+func StampFiles(c *fiber.Ctx) error {
+	fmt.Println("StampFiles")
+
+	var request stampRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse stamp settings",
+		})
+	}
+	if len(request.FileIDs) == 0 || len(request.FileIDs) > maxStampFiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("fileIds must name 1 to %d files", maxStampFiles),
+		})
+	}
+	ids := make([]interface{}, len(request.FileIDs))
+	seen := make(map[uint]bool)
+	for i, id := range request.FileIDs {
+		if seen[id] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("File %d is named twice", id),
+			})
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	return stampFiles(c, request, ids)
+}
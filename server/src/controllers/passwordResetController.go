@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/mailer"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+// passwordResetTTL is how long a reset link stays valid
+const passwordResetTTL = time.Hour
+
+type forgotPasswordRequest struct {
+	// Login is a username or email address
+	Login string `json:"login"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword - Email a password reset link. The response doesn't reveal whether the account exists.
+func ForgotPassword(c *fiber.Ctx) error {
+	fmt.Println("ForgotPassword")
+
+	var request forgotPasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request",
+		})
+	}
+
+	login := strings.TrimSpace(request.Login)
+	if login == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username or email is required",
+		})
+	}
+
+	response := fiber.Map{
+		"message": "If the account exists, a reset link has been sent",
+	}
+
+	// Only local accounts have passwords to reset
+	var user models.User
+	result := database.DB.
+		Where("(username = ? OR email = ?) AND auth_source = ? AND email <> ''", login, login, auth.SourceLocal).
+		First(&user)
+	if result.Error != nil {
+		return c.JSON(response)
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate reset token",
+		})
+	}
+
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: token.Hash(secret),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if result := database.DB.Create(&resetToken); result.Error != nil {
+		fmt.Printf("ERROR creating password reset token: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create reset token",
+		})
+	}
+
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = c.BaseURL() + "/reset-password?token="
+	}
+	body := fmt.Sprintf("Hello %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s%s\n\nIf you didn't request this, you can ignore this email.\n",
+		user.Username, passwordResetTTL, resetURL, secret)
+
+	// Send in the background so response times don't reveal whether the account exists
+	go func(to string) {
+		if err := mailer.New().Send(to, "Password reset", body); err != nil {
+			fmt.Printf("ERROR sending password reset email: %v\n", err)
+		}
+	}(user.Email)
+
+	return c.JSON(response)
+}
+
+// ResetPassword - Set a new password using a reset token and end all existing sessions
+func ResetPassword(c *fiber.Ctx) error {
+	fmt.Println("ResetPassword")
+
+	var request resetPasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request",
+		})
+	}
+
+	if len(request.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength),
+		})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	var resetToken models.PasswordResetToken
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Marking the token used in the same statement keeps it single-use under concurrency
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", token.Hash(request.Token), time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("token_hash = ?", token.Hash(request.Token)).First(&resetToken).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Update("password_hash", string(hash)).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Reset link is invalid or has expired",
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR resetting password: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	if err := revokeUserSessions(resetToken.UserID); err != nil {
+		fmt.Printf("ERROR revoking sessions after password reset: %v\n", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password has been reset",
+	})
+}
@@ -0,0 +1,609 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+// maxMergeFiles bounds how many inputs one merge may name
+const maxMergeFiles = 200
+
+// Where the result of an operation on one file goes
+const (
+	// outputVersion saves the result as the next revision of the file
+	outputVersion = "version"
+	// outputFile saves the result as a new file next to the original
+	outputFile = "file"
+)
+
+// operationOutput validates the output of an operation, outputVersion by default
+func operationOutput(value string) (string, error) {
+	switch value {
+	case "":
+		return outputVersion, nil
+	case outputVersion, outputFile:
+		return value, nil
+	}
+	return "", errors.New("output must be version or file")
+}
+
+// localReadableFile loads a file the caller may read and a local path of its
+// content. cleanup must be called when err is nil.
+func localReadableFile(c *fiber.Ctx, id interface{}) (models.File, string, func(), int, error) {
+	return localOperationFile(c, id, outputFile)
+}
+
+// localOperationFile loads the file an operation works on and a local path of
+// its content. Revising the file needs it to be editable, creating a new file
+// only to be readable. cleanup must be called when err is nil.
+func localOperationFile(c *fiber.Ctx, id interface{}, output string) (models.File, string, func(), int, error) {
+	var file models.File
+	var status int
+	var err error
+	if output == outputVersion {
+		file, status, err = findEditableFile(c, id)
+	} else {
+		file, status, err = findAccessibleFile(c, id, models.PermissionRead)
+	}
+	if err != nil {
+		return file, "", nil, status, err
+	}
+	path, cleanup, err := localStoredFile(file)
+	if errors.Is(err, errQuarantined) {
+		cleanup()
+		return file, "", nil, fiber.StatusLocked, err
+	}
+	if err != nil {
+		cleanup()
+		fmt.Printf("ERROR reading stored file %d: %v\n", file.ID, err)
+		return file, "", nil, fiber.StatusInternalServerError, errors.New("Failed to read file")
+	}
+	return file, path, cleanup, 0, nil
+}
+
+// operationFilename picks the name of a generated file, fallback unless one
+// was requested
+func operationFilename(requested, fallback string) (string, error) {
+	if requested == "" {
+		return fallback, nil
+	}
+	return sanitizeFilename(requested)
+}
+
+// derivedFilename names the output of an operation on one file, like
+// "plan-extract.pdf"
+func derivedFilename(file models.File, suffix string) string {
+	return strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)) + "-" + suffix + ".pdf"
+}
+
+// saveOperationResult stores the output of a PDF operation as a new file of
+// the caller and responds with it. details describe the operation in the
+// audit entry.
+func saveOperationResult(c *fiber.Ctx, path, filename string, folder *models.Folder, details fiber.Map) error {
+	file, status, err := storeOperationFile(c, path, filename, folder, details)
+	return sendOperationFile(c, file, status, err)
+}
+
+// sendOperationOutput saves the result of an operation on file as output says
+// and responds with the revised or created file. suffix names a new file.
+func sendOperationOutput(c *fiber.Ctx, file models.File, path, output, suffix string, details fiber.Map) error {
+	file, status, err := storeOperationOutput(c, file, path, output, suffix, details)
+	return sendOperationFile(c, file, status, err)
+}
+
+// saveOperationRevision saves the result of an operation as the next revision
+// of file, applying further changes with it like saveFileRevision, and
+// responds with the file
+func saveOperationRevision(c *fiber.Ctx, file models.File, path string, details fiber.Map, apply func(tx *gorm.DB, file models.File) error) error {
+	file, status, err := storeOperationRevision(c, file, path, details, apply)
+	return sendOperationFile(c, file, status, err)
+}
+
+// sendOperationFile responds with the file an operation saved or its error
+func sendOperationFile(c *fiber.Ctx, file models.File, status int, err error) error {
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(file)
+}
+
+// storeOperationFile stores the output of a PDF operation as a new file of the
+// caller and records it in the audit log with details
+func storeOperationFile(c *fiber.Ctx, path, filename string, folder *models.Folder, details fiber.Map) (models.File, int, error) {
+	file, err := saveGeneratedFile(c, path, filename, folder)
+	if errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+		return file, fileSizeErrorStatus(err), err
+	}
+	if err != nil {
+		fmt.Printf("ERROR saving %s: %v\n", filename, err)
+		return file, fiber.StatusInternalServerError, errors.New("Failed to save the result")
+	}
+
+	details["file"] = file
+	audit.Record(c, audit.FileCreate, audit.EntityFile, file.ID, nil, details)
+	return file, 0, nil
+}
+
+// storeOperationOutput stores the result of an operation on file as output says
+func storeOperationOutput(c *fiber.Ctx, file models.File, path, output, suffix string, details fiber.Map) (models.File, int, error) {
+	details["source"] = file.ID
+	if output == outputFile {
+		return storeOperationFile(c, path, derivedFilename(file, suffix), folderByID(file.FolderID), details)
+	}
+	return storeOperationRevision(c, file, path, details, nil)
+}
+
+// storeOperationRevision stores the result of an operation as the next
+// revision of file and records it in the audit log with details
+func storeOperationRevision(c *fiber.Ctx, file models.File, path string, details fiber.Map, apply func(tx *gorm.DB, file models.File) error) (models.File, int, error) {
+	details["source"] = file.ID
+
+	// Revisions count against the quota of the file's owner
+	var ownerID uint
+	if file.OwnerID != nil {
+		ownerID = *file.OwnerID
+	}
+	info, err := os.Stat(path)
+	if err == nil {
+		err = checkFileSize(ownerID, fileTypePDF, info.Size())
+	}
+	if errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+		return file, fileSizeErrorStatus(err), err
+	}
+	hash := ""
+	if err == nil {
+		hash, err = hashFile(path)
+	}
+	var before models.File
+	if err == nil {
+		before, file, err = saveFileRevision(c, file, path, hash, apply)
+	}
+	if err != nil {
+		fmt.Printf("ERROR saving revision of file %d: %v\n", file.ID, err)
+		return file, fiber.StatusInternalServerError, errors.New("Failed to save new version")
+	}
+
+	details["file"] = file
+	audit.Record(c, audit.FileVersionUpload, audit.EntityFile, file.ID, before, details)
+	return file, 0, nil
+}
+
+// documentPageCount returns the page count of a file, reading it from the
+// content when it wasn't known at upload
+func documentPageCount(file models.File, path string) (int, error) {
+	if file.PageCount > 0 {
+		return file.PageCount, nil
+	}
+	info, err := pdf.Info(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.PageCount, nil
+}
+
+type mergeFileRequest struct {
+	ID uint `json:"id"`
+	// Pages like "1-3,5,8-end"; empty takes every page
+	Pages string `json:"pages"`
+}
+
+type mergeRequest struct {
+	Files []mergeFileRequest `json:"files"`
+	// Filename defaults to merged.pdf
+	Filename string `json:"filename"`
+	FolderID uint   `json:"folderId"`
+	// Async queues the merge as a job and responds with the job
+	Async bool `json:"async"`
+}
+
+// mergeInputs loads the files of a merge with load, each once however often
+// it is named, and returns what to merge. cleanup must be called when err is
+// nil; status is the response status of an error.
+func mergeInputs(items []mergeFileRequest, load func(id uint) (models.File, string, func(), int, error)) ([]pdf.MergeInput, func(), int, error) {
+	type source struct {
+		file models.File
+		path string
+	}
+	sources := make(map[uint]source)
+	var cleanups []func()
+	cleanup := func() {
+		for _, f := range cleanups {
+			f()
+		}
+	}
+	inputs := make([]pdf.MergeInput, 0, len(items))
+	for _, item := range items {
+		src, ok := sources[item.ID]
+		if !ok {
+			file, path, fileCleanup, status, err := load(item.ID)
+			if err != nil {
+				cleanup()
+				return nil, nil, status, fmt.Errorf("File %d: %s", item.ID, err.Error())
+			}
+			cleanups = append(cleanups, fileCleanup)
+			src = source{file: file, path: path}
+			sources[item.ID] = src
+		}
+
+		input := pdf.MergeInput{Path: src.path}
+		if item.Pages != "" {
+			var err error
+			if input.Ranges, err = pdf.ParsePageRanges(item.Pages, src.file.PageCount); err != nil {
+				cleanup()
+				return nil, nil, fiber.StatusBadRequest, fmt.Errorf("File %d: %s", item.ID, err.Error())
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, cleanup, 0, nil
+}
+
+// MergeFiles - Merge the pages of several files, in the order given, into a
+// new file. The same file may appear more than once with different pages.
+// With async the merge is queued as a job, for long sets of files.
+func MergeFiles(c *fiber.Ctx) error {
+	fmt.Println("MergeFiles")
+
+	var request mergeRequest
+	if err := c.BodyParser(&request); err != nil || len(request.Files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "files must list the files to merge",
+		})
+	}
+	if len(request.Files) > maxMergeFiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("At most %d files can be merged at once", maxMergeFiles),
+		})
+	}
+	filename, err := operationFilename(request.Filename, "merged.pdf")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	folder, err := findOptionalFolder(c, request.FolderID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if request.Async {
+		// Access is checked now, the content is read when the job runs
+		for _, item := range request.Files {
+			file, status, err := findAccessibleFile(c, item.ID, models.PermissionRead)
+			if err == nil && file.Quarantined() {
+				status, err = fiber.StatusLocked, errQuarantined
+			}
+			if err == nil && item.Pages != "" {
+				status = fiber.StatusBadRequest
+				_, err = pdf.ParsePageRanges(item.Pages, file.PageCount)
+			}
+			if err != nil {
+				return c.Status(status).JSON(fiber.Map{
+					"error": fmt.Sprintf("File %d: %s", item.ID, err.Error()),
+				})
+			}
+		}
+		request.Filename = filename
+		return sendQueuedJob(c, "merge", request)
+	}
+
+	inputs, cleanup, status, err := mergeInputs(request.Files, func(id uint) (models.File, string, func(), int, error) {
+		return localReadableFile(c, id)
+	})
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare merge",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "merged.pdf")
+	if err := pdf.Merge(inputs, outPath); err != nil {
+		fmt.Printf("ERROR merging files: %v\n", err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to merge files",
+		})
+	}
+
+	return saveOperationResult(c, outPath, filename, folder, fiber.Map{
+		"operation": "merge",
+		"sources":   request.Files,
+	})
+}
+
+// runMergeJob merges the files of a queued merge into a new file
+func runMergeJob(run *jobRun) (interface{}, error) {
+	var request mergeRequest
+	if err := run.params(&request); err != nil {
+		return nil, err
+	}
+	loaded := 0
+	inputs, cleanup, _, err := mergeInputs(request.Files, func(id uint) (models.File, string, func(), int, error) {
+		file, path, cleanup, err := run.file(id)
+		loaded++
+		// Reading the files is about half of the work
+		run.progress(loaded * 50 / len(request.Files))
+		return file, path, cleanup, 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	outPath := filepath.Join(run.workDir, "merged.pdf")
+	if err := pdf.Merge(inputs, outPath); err != nil {
+		return nil, err
+	}
+	var folder *models.Folder
+	if request.FolderID != 0 {
+		folder = folderByID(&request.FolderID)
+	}
+	file, err := run.saveFile(outPath, request.Filename, folder, map[string]interface{}{
+		"operation": "merge",
+		"sources":   request.Files,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{"fileId": file.ID}, nil
+}
+
+type extractPagesRequest struct {
+	// Pages like "3-7,12"
+	Pages string `json:"pages"`
+	// Filename defaults to the original name with "-extract" appended
+	Filename string `json:"filename"`
+}
+
+// ExtractPages - Create a new file from some pages of a file. It is placed in
+// the folder of the original.
+func ExtractPages(c *fiber.Ctx) error {
+	fmt.Println("ExtractPages")
+
+	var request extractPagesRequest
+	if err := c.BodyParser(&request); err != nil || strings.TrimSpace(request.Pages) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "pages must name the pages to extract, like 3-7,12",
+		})
+	}
+
+	file, path, cleanup, status, err := localReadableFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	ranges, err := pdf.ParsePageRanges(request.Pages, file.PageCount)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	filename, err := operationFilename(request.Filename, derivedFilename(file, "extract"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare extraction",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "extract.pdf")
+	if err := pdf.Merge([]pdf.MergeInput{{Path: path, Ranges: ranges}}, outPath); err != nil {
+		fmt.Printf("ERROR extracting pages of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to extract pages",
+		})
+	}
+
+	return saveOperationResult(c, outPath, filename, folderByID(file.FolderID), fiber.Map{
+		"operation":     "extract",
+		"extractedFrom": file.ID,
+		"pages":         request.Pages,
+	})
+}
+
+type rotatePagesRequest struct {
+	// Pages like "1-3,5"; empty rotates every page
+	Pages string `json:"pages"`
+	// Angle turns the pages clockwise: 90, 180 or 270
+	Angle  int    `json:"angle"`
+	Output string `json:"output"`
+}
+
+// RotatePages - Turn pages of a file clockwise. The result is the next
+// revision of the file, or with output "file" a new file.
+func RotatePages(c *fiber.Ctx) error {
+	fmt.Println("RotatePages")
+
+	var request rotatePagesRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse rotation",
+		})
+	}
+	if !pdf.ValidRotation(request.Angle) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "angle must be 90, 180 or 270",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	pageCount, err := documentPageCount(file, path)
+	if err != nil {
+		fmt.Printf("ERROR reading page count of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	var selected map[int]bool
+	if strings.TrimSpace(request.Pages) != "" {
+		ranges, err := pdf.ParsePageRanges(request.Pages, pageCount)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		selected = pdf.PageSet(ranges)
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare rotation",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "rotated.pdf")
+	if err := pdf.Rotate(path, outPath, pageCount, selected, request.Angle); err != nil {
+		fmt.Printf("ERROR rotating pages of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to rotate pages",
+		})
+	}
+
+	return sendOperationOutput(c, file, outPath, output, "rotated", fiber.Map{
+		"operation": "rotate",
+		"pages":     request.Pages,
+		"angle":     request.Angle,
+	})
+}
+
+type optimizeRequest struct {
+	// Quality is low, medium (the default) or high
+	Quality string `json:"quality"`
+	Output  string `json:"output"`
+}
+
+// OptimizeFile - Shrink a file by downsampling its images and dropping unused
+// objects, and report the size before and after. The result is the next
+// revision of the file, or with output "file" a new file; nothing is saved
+// when it isn't smaller.
+func OptimizeFile(c *fiber.Ctx) error {
+	fmt.Println("OptimizeFile")
+
+	var request optimizeRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse optimization options",
+			})
+		}
+	}
+	if request.Quality == "" {
+		request.Quality = pdf.QualityMedium
+	}
+	if !pdf.ValidQuality(request.Quality) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "quality must be low, medium or high",
+		})
+	}
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare optimization",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "optimized.pdf")
+	if err := pdf.Optimize(path, outPath, request.Quality); err != nil {
+		fmt.Printf("ERROR optimizing file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to optimize file",
+		})
+	}
+	info, err := os.Stat(outPath)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to optimize file",
+		})
+	}
+
+	sizeBefore := file.Size
+	if info.Size() >= sizeBefore {
+		return c.JSON(fiber.Map{
+			"file":       file,
+			"optimized":  false,
+			"sizeBefore": sizeBefore,
+			"sizeAfter":  sizeBefore,
+		})
+	}
+
+	result, status, err := storeOperationOutput(c, file, outPath, output, "optimized", fiber.Map{
+		"operation":  "optimize",
+		"quality":    request.Quality,
+		"sizeBefore": sizeBefore,
+		"sizeAfter":  info.Size(),
+	})
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"file":       result,
+		"optimized":  true,
+		"sizeBefore": sizeBefore,
+		"sizeAfter":  info.Size(),
+	})
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+const (
+	defaultRenderCacheSize = 5 << 30
+	renderCacheInterval    = 10 * time.Minute
+)
+
+// renderCacheSize reads RENDER_CACHE_SIZE, how much storage rendered pages
+// and flattened exports may take before the oldest are evicted; 0 keeps them
+// all
+func renderCacheSize() int64 {
+	return byteSizeEnv("RENDER_CACHE_SIZE", defaultRenderCacheSize)
+}
+
+// RunRenderCacheEviction periodically evicts the oldest renderings once the
+// cache outgrows RENDER_CACHE_SIZE
+func RunRenderCacheEviction() {
+	for range time.Tick(renderCacheInterval) {
+		limit := renderCacheSize()
+		if limit <= 0 {
+			continue
+		}
+		report, err := storage.EvictCache(limit)
+		if err != nil {
+			fmt.Printf("ERROR evicting rendered content: %v\n", err)
+			continue
+		}
+		if report.EvictedObjects > 0 {
+			fmt.Printf("Evicted %d rendered objects (%d bytes)\n", report.EvictedObjects, report.FreedBytes)
+		}
+	}
+}
+
+// dropRenders removes what was rendered from content a file was revised
+// away from, unless another file still has that content
+func dropRenders(hash string) {
+	var count int64
+	database.DB.Model(&models.File{}).Where("hash = ?", hash).Count(&count)
+	if count > 0 {
+		return
+	}
+	if err := storage.DropCache(hash, ""); err != nil {
+		fmt.Printf("ERROR removing rendered content of %s: %v\n", hash, err)
+	}
+}
+
+// flattenedPrefix starts the names of a file's cached flattened exports
+func flattenedPrefix(file models.File) string {
+	return fmt.Sprintf("flattened-%d-", file.ID)
+}
+
+// flattenedKey names the flattened export of the file with the drawings, which
+// changes whenever a drawing is added, changed or removed
+func flattenedKey(file models.File, overlays []pdf.Overlay) string {
+	digest := sha256.New()
+	for _, overlay := range overlays {
+		fmt.Fprintf(digest, "%d:%d;", overlay.ID, overlay.Modified.UnixNano())
+	}
+	fingerprint := hex.EncodeToString(digest.Sum(nil))[:16]
+	return storage.CacheKey(file.Hash, flattenedPrefix(file)+fingerprint+".pdf")
+}
+
+// cachedFlattened returns the storage key of the file flattened with its
+// current drawings, flattening it unless that was cached. Exports for earlier
+// drawings are removed.
+func cachedFlattened(file models.File) (string, error) {
+	overlays := fileOverlays(file)
+	key := flattenedKey(file, overlays)
+	if exists, err := storage.Store.Exists(key); err != nil || exists {
+		return key, err
+	}
+
+	path, cleanup, err := localStoredFile(file)
+	defer cleanup()
+	if err != nil {
+		return key, err
+	}
+	workDir, err := newWorkDir()
+	if err != nil {
+		return key, err
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "flattened.pdf")
+	if err := drawOverlays(file, overlays, path, workDir, outPath); err != nil {
+		return key, err
+	}
+	if err := storage.DropCache(file.Hash, flattenedPrefix(file)); err != nil {
+		fmt.Printf("ERROR removing earlier exports of file %d: %v\n", file.ID, err)
+	}
+	return key, storage.PutFile(key, outPath)
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+// sessionTokens is what a client receives when logging in or refreshing
+type sessionTokens struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+// startSession records a new session for the user and issues its first token pair
+func startSession(c *fiber.Ctx, user models.User) (sessionTokens, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:      user.ID,
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+		IP:          c.IP(),
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(token.RefreshTTL()),
+		WorkspaceID: auth.PrimaryWorkspaceID(user.ID),
+	}
+
+	var tokens sessionTokens
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %v", err)
+		}
+		var err error
+		tokens, err = issueSessionTokens(tx, user, session)
+		return err
+	})
+	return tokens, err
+}
+
+// issueSessionTokens signs an access token and stores a new refresh token for the session
+func issueSessionTokens(tx *gorm.DB, user models.User, session models.Session) (sessionTokens, error) {
+	expiresAt := time.Now().Add(token.TTL())
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	signed, err := token.Issue(user.ID, user.Username, user.Role, session.ID, session.WorkspaceID, expiresAt)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return sessionTokens{}, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	refreshToken := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: token.Hash(secret),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return sessionTokens{}, fmt.Errorf("failed to save refresh token: %v", err)
+	}
+
+	return sessionTokens{
+		Token:            signed,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     secret,
+		RefreshExpiresAt: refreshToken.ExpiresAt,
+	}, nil
+}
+
+// revokeUserSessions ends every active session of a user
+func revokeUserSessions(userID uint) error {
+	return database.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// GetSessions - List the current user's active sessions
+func GetSessions(c *fiber.Ctx) error {
+	claims := middleware.CurrentClaims(c)
+
+	var sessions []models.Session
+	database.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.UserID(), time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, fiber.Map{
+			"session": session,
+			"current": !claims.ViaApiKey && session.ID == claims.SessionID(),
+		})
+	}
+	return c.JSON(result)
+}
+
+// RevokeSession - End one of the current user's sessions
+func RevokeSession(c *fiber.Ctx) error {
+	fmt.Println("RevokeSession")
+	claims := middleware.CurrentClaims(c)
+
+	result := database.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Params("id"), claims.UserID()).
+		Update("revoked_at", time.Now())
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Session revoked successfully",
+	})
+}
+
+// ForceLogoutUser - End every session of a user
+func ForceLogoutUser(c *fiber.Ctx) error {
+	fmt.Println("ForceLogoutUser")
+
+	var user models.User
+	if result := database.DB.First(&user, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if err := revokeUserSessions(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "User logged out of all sessions",
+	})
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// errRefreshTokenReused signals a replayed refresh token
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// RefreshSession - Exchange a refresh token for a new token pair. Each refresh
+// token works once; replaying a used one revokes the whole session.
+func RefreshSession(c *fiber.Ctx) error {
+	fmt.Println("RefreshSession")
+
+	var request refreshRequest
+	if err := c.BodyParser(&request); err != nil || request.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Refresh token is required",
+		})
+	}
+
+	var tokens sessionTokens
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var refreshToken models.RefreshToken
+		if err := tx.Preload("Session.User").Where("token_hash = ?", token.Hash(request.RefreshToken)).First(&refreshToken).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		session := refreshToken.Session
+		if session.RevokedAt != nil || now.After(refreshToken.ExpiresAt) || session.User.ID == 0 || session.User.DisabledAt != nil {
+			return gorm.ErrRecordNotFound
+		}
+
+		// Claim the token atomically; losing the race means it was already used
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", refreshToken.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		tx.Model(&session).UpdateColumn("last_seen_at", now)
+
+		var err error
+		tokens, err = issueSessionTokens(tx, session.User, session)
+		return err
+	})
+
+	if errors.Is(err, errRefreshTokenReused) {
+		// Revoke outside the rolled back transaction so the whole family stays dead
+		var refreshToken models.RefreshToken
+		if database.DB.Where("token_hash = ?", token.Hash(request.RefreshToken)).First(&refreshToken).Error == nil {
+			database.DB.Model(&models.Session{}).Where("id = ?", refreshToken.SessionID).Update("revoked_at", time.Now())
+			fmt.Printf("WARNING refresh token reuse detected, revoked session %d\n", refreshToken.SessionID)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Refresh token has already been used, session revoked",
+		})
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired refresh token",
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR refreshing session: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	return c.JSON(tokens)
+}
+
+// Logout - End the current session
+func Logout(c *fiber.Ctx) error {
+	fmt.Println("Logout")
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "API keys have no session to log out of",
+		})
+	}
+
+	database.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", claims.SessionID()).
+		Update("revoked_at", time.Now())
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out successfully",
+	})
+}
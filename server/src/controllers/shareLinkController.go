@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+type createShareLinkRequest struct {
+	// ExpiresInHours is optional; links without it never expire
+	ExpiresInHours *float64 `json:"expiresInHours"`
+	// MaxDownloads is optional; links without it can be used any number of times
+	MaxDownloads *int `json:"maxDownloads"`
+}
+
+// CreateShareLink - Create a public download link for a file. The token is only returned once.
+func CreateShareLink(c *fiber.Ctx) error {
+	fmt.Println("CreateShareLink")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request createShareLinkRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to parse share link data",
+			})
+		}
+	}
+
+	link := models.ShareLink{
+		FileID:       file.ID,
+		CreatedByID:  middleware.CurrentClaims(c).UserID(),
+		MaxDownloads: request.MaxDownloads,
+	}
+
+	if request.ExpiresInHours != nil {
+		if *request.ExpiresInHours <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Expiry must be positive",
+			})
+		}
+		expiresAt := time.Now().Add(time.Duration(*request.ExpiresInHours * float64(time.Hour)))
+		link.ExpiresAt = &expiresAt
+	}
+
+	if request.MaxDownloads != nil && *request.MaxDownloads <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Download limit must be positive",
+		})
+	}
+
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate share token",
+		})
+	}
+	link.TokenHash = token.Hash(secret)
+
+	if result := database.DB.Create(&link); result.Error != nil {
+		fmt.Printf("ERROR creating share link: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save share link",
+		})
+	}
+
+	audit.Record(c, audit.ShareLinkCreate, audit.EntityShareLink, link.ID, nil, link)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token": secret,
+		"url":   c.BaseURL() + "/api/share/" + secret,
+		"link":  link,
+	})
+}
+
+// GetShareLinks - List the share links of a file
+func GetShareLinks(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var links []models.ShareLink
+	database.DB.Where("file_id = ?", file.ID).Order("created_at DESC").Find(&links)
+	return c.JSON(links)
+}
+
+// RevokeShareLink - Disable a share link
+func RevokeShareLink(c *fiber.Ctx) error {
+	fmt.Println("RevokeShareLink")
+
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionWrite)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var link models.ShareLink
+	if result := database.DB.Where("file_id = ?", file.ID).First(&link, c.Params("linkId")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Share link not found",
+		})
+	}
+
+	if link.RevokedAt == nil {
+		before := link
+		now := time.Now()
+		link.RevokedAt = &now
+		database.DB.Model(&link).Update("revoked_at", now)
+		audit.Record(c, audit.ShareLinkRevoke, audit.EntityShareLink, link.ID, before, link)
+	}
+
+	return c.JSON(link)
+}
+
+// DownloadSharedFile - Download a file through a share link without logging in
+func DownloadSharedFile(c *fiber.Ctx) error {
+	fmt.Println("DownloadSharedFile")
+
+	var link models.ShareLink
+	result := database.DB.Preload("File").
+		Where("token_hash = ? AND revoked_at IS NULL", token.Hash(c.Params("token"))).
+		First(&link)
+	if result.Error != nil || link.File.ID == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Share link not found",
+		})
+	}
+
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Share link has expired",
+		})
+	}
+	// Checked before counting, so a quarantined file doesn't use up downloads
+	if link.File.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	// Only downloads from the start of the file count, not the further ranges
+	// a viewer fetches of one it has begun. They are counted atomically before
+	// sending, so concurrent requests can't exceed the limit, and handed back
+	// when nothing was sent, such as for a revalidation.
+	counted := downloadFromStart(c)
+	if counted {
+		update := database.DB.Model(&models.ShareLink{}).
+			Where("id = ? AND (max_downloads IS NULL OR download_count < max_downloads)", link.ID).
+			UpdateColumn("download_count", gorm.Expr("download_count + 1"))
+		if update.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to record download",
+			})
+		}
+		if update.RowsAffected == 0 {
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": "Share link download limit reached",
+			})
+		}
+	}
+
+	err := sendStoredFile(c, link.File)
+	if counted && (err != nil || !contentServed(c)) {
+		database.DB.Model(&models.ShareLink{}).Where("id = ?", link.ID).
+			UpdateColumn("download_count", gorm.Expr("download_count - 1"))
+	}
+	if err != nil {
+		return err
+	}
+	recordSharedDownload(c, link)
+	return nil
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+const defaultStorageGCInterval = 24 * time.Hour
+
+// storageGCInterval reads STORAGE_GC_INTERVAL, a Go duration; 0 turns the
+// background collection off
+func storageGCInterval() time.Duration {
+	value := os.Getenv("STORAGE_GC_INTERVAL")
+	if value == "" {
+		return defaultStorageGCInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("ERROR invalid STORAGE_GC_INTERVAL %q, using %s\n", value, defaultStorageGCInterval)
+		return defaultStorageGCInterval
+	}
+	return interval
+}
+
+// missingContent lists the files and previous revisions whose content is gone
+func missingContent(hashes []string) ([]models.File, []models.FileVersion) {
+	files := []models.File{}
+	versions := []models.FileVersion{}
+	if len(hashes) > 0 {
+		database.DB.Where("hash IN ?", hashes).Order("id").Find(&files)
+		database.DB.Where("hash IN ?", hashes).Order("file_id, version").Find(&versions)
+	}
+	return files, versions
+}
+
+// RunStorageGC periodically removes orphaned content and logs files whose
+// content is missing
+func RunStorageGC() {
+	interval := storageGCInterval()
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		report, err := storage.CollectGarbage(false)
+		if err != nil {
+			fmt.Printf("ERROR collecting storage garbage: %v\n", err)
+			continue
+		}
+		if len(report.OrphanedHashes) > 0 {
+			fmt.Printf("Removed %d orphaned objects (%d bytes)\n", report.RemovedObjects, report.FreedBytes)
+		}
+		files, versions := missingContent(report.MissingHashes)
+		for _, file := range files {
+			fmt.Printf("ERROR content of file %d (%s) is missing\n", file.ID, file.Filename)
+		}
+		for _, version := range versions {
+			fmt.Printf("ERROR content of version %d of file %d is missing\n", version.Version, version.FileID)
+		}
+	}
+}
+
+// CollectStorageGarbage - Remove stored content no file refers to and report
+// files whose content is missing. With ?dryRun=true nothing is removed.
+func CollectStorageGarbage(c *fiber.Ctx) error {
+	fmt.Println("CollectStorageGarbage")
+
+	report, err := storage.CollectGarbage(c.QueryBool("dryRun"))
+	if err != nil {
+		fmt.Printf("ERROR collecting storage garbage: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to collect storage garbage",
+		})
+	}
+	files, versions := missingContent(report.MissingHashes)
+
+	return c.JSON(fiber.Map{
+		"dryRun":          c.QueryBool("dryRun"),
+		"orphanedHashes":  report.OrphanedHashes,
+		"removedObjects":  report.RemovedObjects,
+		"freedBytes":      report.FreedBytes,
+		"missingHashes":   report.MissingHashes,
+		"missingFiles":    files,
+		"missingVersions": versions,
+	})
+}
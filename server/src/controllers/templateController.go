@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+)
+
+const (
+	maxTemplateNameLength = 100
+	// maxTemplateSize bounds a template's HTML, images included as data URLs
+	maxTemplateSize = 2 * 1024 * 1024
+	// maxGeneratedSize bounds the HTML a template renders to
+	maxGeneratedSize = 20 * 1024 * 1024
+)
+
+type templateRequest struct {
+	Name string `json:"name"`
+	HTML string `json:"html"`
+}
+
+type generateRequest struct {
+	TemplateID uint `json:"templateId"`
+	// Data fills in the template, like {{.project}} or {{range .items}}
+	Data interface{} `json:"data"`
+	// Filename defaults to the template's name
+	Filename string `json:"filename"`
+	FolderID uint   `json:"folderId"`
+}
+
+// validate checks the request and parses its HTML
+func (r *templateRequest) validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > maxTemplateNameLength {
+		return fmt.Errorf("Template name must be 1 to %d bytes", maxTemplateNameLength)
+	}
+	if strings.TrimSpace(r.HTML) == "" || len(r.HTML) > maxTemplateSize {
+		return fmt.Errorf("Template HTML must be 1 to %d bytes", maxTemplateSize)
+	}
+	_, err := parseTemplate(r.Name, r.HTML)
+	return err
+}
+
+// parseTemplate parses the HTML of a template
+func parseTemplate(name, html string) (*template.Template, error) {
+	t, err := template.New(name).Parse(html)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %v", err)
+	}
+	return t, nil
+}
+
+// limitedBuffer fails writes past its limit, so a template looping over its
+// data can't fill the memory
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("the document exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// findWorkspaceTemplate loads a template of the current workspace
+func findWorkspaceTemplate(c *fiber.Ctx, id interface{}) (models.Template, error) {
+	var t models.Template
+	err := database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).First(&t, id).Error
+	return t, err
+}
+
+// findManagedTemplate loads a template the caller created, or any template of
+// the workspace for admins
+func findManagedTemplate(c *fiber.Ctx) (models.Template, int, error) {
+	t, err := findWorkspaceTemplate(c, c.Params("id"))
+	if err != nil {
+		return t, fiber.StatusNotFound, errors.New("Template not found")
+	}
+	claims := middleware.CurrentClaims(c)
+	if !models.RoleAtLeast(claims.Role, models.RoleAdmin) && t.CreatedByID != claims.UserID() {
+		return t, fiber.StatusForbidden, errors.New("Only the template creator can change it")
+	}
+	return t, 0, nil
+}
+
+// templateNameTaken tells whether another template of the workspace has the name
+func templateNameTaken(workspaceID uint, name string, except uint) bool {
+	var count int64
+	database.DB.Model(&models.Template{}).Unscoped().
+		Where("workspace_id = ? AND name = ? AND id <> ?", workspaceID, name, except).
+		Count(&count)
+	return count > 0
+}
+
+// GetTemplates - List the HTML templates of the current workspace
+func GetTemplates(c *fiber.Ctx) error {
+	var templates []models.Template
+	database.DB.Where("workspace_id = ?", middleware.CurrentWorkspace(c).ID).Order("name").Find(&templates)
+	return c.JSON(templates)
+}
+
+// GetTemplate - Get an HTML template of the current workspace
+func GetTemplate(c *fiber.Ctx) error {
+	t, err := findWorkspaceTemplate(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Template not found",
+		})
+	}
+	return c.JSON(t)
+}
+
+// CreateTemplate - Store an HTML template in the current workspace
+func CreateTemplate(c *fiber.Ctx) error {
+	fmt.Println("CreateTemplate")
+
+	var request templateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse template data",
+		})
+	}
+	if err := request.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	workspaceID := middleware.CurrentWorkspace(c).ID
+	if templateNameTaken(workspaceID, request.Name, 0) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A template with this name already exists",
+		})
+	}
+
+	t := models.Template{
+		WorkspaceID: workspaceID,
+		Name:        request.Name,
+		HTML:        request.HTML,
+		CreatedByID: middleware.CurrentClaims(c).UserID(),
+	}
+	if result := database.DB.Create(&t); result.Error != nil {
+		fmt.Printf("ERROR creating template: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create template",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(t)
+}
+
+// UpdateTemplate - Change the name and HTML of a template
+func UpdateTemplate(c *fiber.Ctx) error {
+	fmt.Println("UpdateTemplate")
+
+	t, status, err := findManagedTemplate(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request templateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse template data",
+		})
+	}
+	if err := request.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if templateNameTaken(t.WorkspaceID, request.Name, t.ID) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A template with this name already exists",
+		})
+	}
+
+	t.Name, t.HTML = request.Name, request.HTML
+	if result := database.DB.Save(&t); result.Error != nil {
+		fmt.Printf("ERROR updating template %d: %v\n", t.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update template",
+		})
+	}
+
+	return c.JSON(t)
+}
+
+// DeleteTemplate - Delete a template; files generated from it stay
+func DeleteTemplate(c *fiber.Ctx) error {
+	fmt.Println("DeleteTemplate")
+
+	t, status, err := findManagedTemplate(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if result := database.DB.Unscoped().Delete(&t); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete template",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Template deleted successfully",
+	})
+}
+
+// GeneratePDF - Fill in an HTML template of the workspace with JSON data and
+// save the page printed to PDF as a new file, like a transmittal sheet
+func GeneratePDF(c *fiber.Ctx) error {
+	fmt.Println("GeneratePDF")
+
+	var request generateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse generation data",
+		})
+	}
+	t, err := findWorkspaceTemplate(c, request.TemplateID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Template not found",
+		})
+	}
+	fallback, err := sanitizeFilename(t.Name + ".pdf")
+	if err != nil {
+		fallback = "generated.pdf"
+	}
+	filename, err := operationFilename(request.Filename, fallback)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	folder, err := findOptionalFolder(c, request.FolderID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	parsed, err := parseTemplate(t.Name, t.HTML)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	document := limitedBuffer{limit: maxGeneratedSize}
+	if err := parsed.Execute(&document, request.Data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to fill in the template: %v", err),
+		})
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare generation",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	outPath := filepath.Join(workDir, "generated.pdf")
+	if err := pdf.RenderHTML(document.Bytes(), outPath); err != nil {
+		fmt.Printf("ERROR printing template %d: %v\n", t.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to generate the PDF",
+		})
+	}
+
+	return saveOperationResult(c, outPath, filename, folder, fiber.Map{
+		"operation": "generate",
+		"template":  t.ID,
+	})
+}
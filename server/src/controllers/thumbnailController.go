@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/storage"
+)
+
+// thumbnailSize is the longer side of thumbnails in pixels
+const thumbnailSize = 256
+
+func thumbnailKey(hash string) string {
+	return storage.DerivedKey(hash, "thumbnail.png")
+}
+
+// storeThumbnail renders and stores the thumbnail of PDF content on local disk
+func storeThumbnail(path, hash string) error {
+	workDir, err := newWorkDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	thumbnail := filepath.Join(workDir, "thumbnail.png")
+	if err := pdf.Thumbnail(path, thumbnail, thumbnailSize); err != nil {
+		return err
+	}
+	return storage.PutFile(thumbnailKey(hash), thumbnail)
+}
+
+// GetFileThumbnail - Get a PNG of the file's first page. Thumbnails missing for
+// files uploaded before they were generated are rendered on first request.
+func GetFileThumbnail(c *fiber.Ctx) error {
+	file, status, err := findAccessibleFile(c, c.Params("id"), models.PermissionRead)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if file.Quarantined() {
+		return storedFileError(c, errQuarantined)
+	}
+
+	exists, err := storage.Store.Exists(thumbnailKey(file.Hash))
+	if err == nil && !exists {
+		path, cleanup, localErr := localStoredFile(file)
+		err = localErr
+		if err == nil {
+			err = storeThumbnail(path, file.Hash)
+		}
+		cleanup()
+	}
+	if err != nil {
+		fmt.Printf("ERROR creating thumbnail of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to create thumbnail",
+		})
+	}
+
+	r, size, err := storage.Store.Get(thumbnailKey(file.Hash))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read thumbnail",
+		})
+	}
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	c.Type("png")
+	return c.SendStream(r, int(size))
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	trashPurgeInterval    = time.Hour
+)
+
+// trashRetention reads TRASH_RETENTION, a Go duration for how long deleted
+// files and drawings can be restored
+func trashRetention() time.Duration {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return defaultTrashRetention
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		fmt.Printf("ERROR invalid TRASH_RETENTION %q, using %s\n", value, defaultTrashRetention)
+		return defaultTrashRetention
+	}
+	return retention
+}
+
+// trashedFiles limits a files query to the deleted files the caller may read
+func trashedFiles(c *fiber.Ctx) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Scopes(accessibleFiles(c)).Where("files.deleted_at IS NOT NULL")
+	}
+}
+
+// findTrashedFile loads a deleted file of the current workspace the caller may write
+func findTrashedFile(c *fiber.Ctx, id string) (models.File, int, error) {
+	var file models.File
+	result := database.DB.Unscoped().
+		Where("workspace_id = ? AND deleted_at IS NOT NULL", middleware.CurrentWorkspace(c).ID).
+		First(&file, id)
+	if result.Error != nil {
+		return file, fiber.StatusNotFound, errFileNotFound
+	}
+
+	claims := middleware.CurrentClaims(c)
+	if !hasFileAccess(claims, file, models.PermissionRead) {
+		return file, fiber.StatusNotFound, errFileNotFound
+	}
+	if !hasFileAccess(claims, file, models.PermissionWrite) {
+		return file, fiber.StatusForbidden, errFileAccessDenied
+	}
+	return file, 0, nil
+}
+
+// purgeFileRecords permanently deletes a file with its drawings and their
+// comments, revisions, layers, drawing groups and sharing inside tx. It returns the hashes of the content to release once tx
+// has committed; share links and guest tokens go with the row.
+func purgeFileRecords(tx *gorm.DB, file models.File) ([]string, error) {
+	var hashes []string
+	if err := tx.Model(&models.FileVersion{}).Where("file_id = ?", file.ID).Pluck("hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+	drawings := tx.Unscoped().Model(&models.Drawing{}).Select("id").Where("file_id = ?", file.ID)
+	if err := tx.Unscoped().Where("drawing_id IN (?)", drawings).Delete(&models.DrawingComment{}).Error; err != nil {
+		return nil, err
+	}
+	for _, model := range []interface{}{
+		&models.Drawing{},
+		&models.DrawingRevision{},
+		&models.Layer{},
+		&models.DrawingGroup{},
+		&models.FileVersion{},
+		&models.FilePermission{},
+		&models.FileGroupPermission{},
+		&models.FileStar{},
+		&models.FileAccess{},
+		&models.FileComment{},
+		&models.OCRJob{},
+	} {
+		if err := tx.Unscoped().Where("file_id = ?", file.ID).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Model(&models.Upload{}).Where("file_id = ?", file.ID).Update("file_id", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.ConversionJob{}).Where("file_id = ?", file.ID).Update("file_id", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Unscoped().Delete(&file).Error; err != nil {
+		return nil, err
+	}
+	return append(hashes, file.Hash), nil
+}
+
+// releaseContent drops a reference to each content, deleting what no file shares
+func releaseContent(hashes []string) error {
+	var err error
+	for _, hash := range hashes {
+		if releaseErr := storage.Release(hash); releaseErr != nil {
+			fmt.Printf("ERROR releasing stored content %s: %v\n", hash, releaseErr)
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// purgeFile permanently deletes a file and releases its content
+func purgeFile(file models.File) error {
+	var hashes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		hashes, err = purgeFileRecords(tx, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return releaseContent(hashes)
+}
+
+// RunTrashPurge periodically purges files and drawings deleted longer than
+// the retention period ago
+func RunTrashPurge() {
+	for range time.Tick(trashPurgeInterval) {
+		purgeDeletedDrawings()
+		var expired []models.File
+		database.DB.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-trashRetention())).
+			Find(&expired)
+		for _, file := range expired {
+			if err := purgeFile(file); err != nil {
+				fmt.Printf("ERROR purging file %d: %v\n", file.ID, err)
+				continue
+			}
+			audit.RecordSystem(audit.FilePurge, audit.EntityFile, file.ID, &file.WorkspaceID, file, nil)
+		}
+	}
+}
+
+// GetTrash - List the deleted files the caller may read, with the paging and
+// sorting of sendFilePage. Files are purged TRASH_RETENTION after deletedAt.
+func GetTrash(c *fiber.Ctx) error {
+	return sendFilePage(c, database.DB.Model(&models.File{}).Scopes(trashedFiles(c)))
+}
+
+// RestoreFile - Move a deleted file out of the trash
+func RestoreFile(c *fiber.Ctx) error {
+	fmt.Println("RestoreFile")
+
+	file, status, err := findTrashedFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	before := file
+	updates := map[string]interface{}{
+		"deleted_at":    nil,
+		"deleted_by_id": nil,
+	}
+	// An expired file would go straight back to the trash
+	if file.ExpiresAt != nil && !file.ExpiresAt.After(time.Now()) {
+		updates["expires_at"] = nil
+		updates["expiry_notice_sent_at"] = nil
+	}
+	result := database.DB.Unscoped().Model(&file).Updates(updates)
+	if result.Error != nil {
+		fmt.Printf("ERROR restoring file %d: %v\n", file.ID, result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore file",
+		})
+	}
+	database.DB.First(&file, file.ID)
+	audit.Record(c, audit.FileRestore, audit.EntityFile, file.ID, before, file)
+
+	return c.JSON(file)
+}
+
+// PurgeFile - Permanently delete a file from the trash
+func PurgeFile(c *fiber.Ctx) error {
+	fmt.Println("PurgeFile")
+
+	file, status, err := findTrashedFile(c, c.Params("id"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := purgeFile(file); err != nil {
+		fmt.Printf("ERROR purging file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete file permanently",
+		})
+	}
+	audit.Record(c, audit.FilePurge, audit.EntityFile, file.ID, file, nil)
+
+	return c.JSON(fiber.Map{
+		"message": "File deleted permanently",
+	})
+}
@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+const (
+	// twoFactorChallengeTTL is how long the second login step may take
+	twoFactorChallengeTTL = 5 * time.Minute
+	// maxTwoFactorAttempts limits code guesses per challenge
+	maxTwoFactorAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type verifyTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// normalizeRecoveryCode makes recovery codes case and separator insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new ones
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		secret, err := token.Random(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = secret[:5] + "-" + secret[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: token.Hash(secret)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code for
+// the user, consuming whichever matched
+func checkSecondFactor(user *models.User, code string) bool {
+	code = strings.TrimSpace(code)
+	if step, ok := auth.VerifyTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		// Claim the step atomically so a code can't be replayed concurrently
+		result := database.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.RowsAffected == 0 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, token.Hash(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0
+}
+
+// startTwoFactorChallenge answers a correct password for an account with 2FA
+// with a challenge the client completes with a code
+func startTwoFactorChallenge(c *fiber.Ctx, user models.User) error {
+	secret, err := token.Random(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor login",
+		})
+	}
+
+	challenge := models.TwoFactorChallenge{
+		UserID:    user.ID,
+		TokenHash: token.Hash(secret),
+		ExpiresAt: time.Now().Add(twoFactorChallengeTTL),
+	}
+	if err := database.DB.Create(&challenge).Error; err != nil {
+		fmt.Printf("ERROR saving two-factor challenge: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor login",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"twoFactorRequired": true,
+		"challenge":         secret,
+		"expiresAt":         challenge.ExpiresAt,
+	})
+}
+
+// VerifyTwoFactor - Complete a login with the challenge and a TOTP or recovery code
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	fmt.Println("VerifyTwoFactor")
+
+	var request verifyTwoFactorRequest
+	if err := c.BodyParser(&request); err != nil || request.Challenge == "" || request.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Challenge and code are required",
+		})
+	}
+
+	var challenge models.TwoFactorChallenge
+	result := database.DB.Preload("User").
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", token.Hash(request.Challenge), time.Now()).
+		First(&challenge)
+	if result.Error != nil || challenge.Attempts >= maxTwoFactorAttempts || challenge.User.DisabledAt != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired challenge, log in again",
+		})
+	}
+
+	user := challenge.User
+	if !checkSecondFactor(&user, request.Code) {
+		database.DB.Model(&challenge).UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	// The challenge is single use; losing the race means it was already completed
+	claimed := database.DB.Model(&models.TwoFactorChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if claimed.RowsAffected == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired challenge, log in again",
+		})
+	}
+
+	return completeLogin(c, user)
+}
+
+// currentLocalUser loads the caller's account for 2FA management, which needs a
+// signed-in session rather than an API key
+func currentLocalUser(c *fiber.Ctx) (models.User, int, error) {
+	var user models.User
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return user, fiber.StatusForbidden, fmt.Errorf("Two-factor authentication can't be managed with an API key")
+	}
+	if result := database.DB.First(&user, claims.UserID()); result.Error != nil {
+		return user, fiber.StatusNotFound, fmt.Errorf("User not found")
+	}
+	return user, 0, nil
+}
+
+// SetupTwoFactor - Generate a new TOTP secret for the caller. It takes effect
+// once confirmed with a code through EnableTwoFactor.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	fmt.Println("SetupTwoFactor")
+
+	user, status, err := currentLocalUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate secret",
+		})
+	}
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save secret",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret": secret,
+		"uri":    auth.TOTPURI(user.Username, secret),
+	})
+}
+
+// EnableTwoFactor - Confirm enrollment with a code from the authenticator and
+// return the recovery codes. The codes are only shown once.
+func EnableTwoFactor(c *fiber.Ctx) error {
+	fmt.Println("EnableTwoFactor")
+
+	user, status, err := currentLocalUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Start two-factor setup first",
+		})
+	}
+
+	var request twoFactorCodeRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, strings.TrimSpace(request.Code), time.Now(), user.TOTPLastStep)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		fmt.Printf("ERROR enabling two-factor authentication: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to enable two-factor authentication",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor - Turn off 2FA after confirming with a TOTP or recovery code
+func DisableTwoFactor(c *fiber.Ctx) error {
+	fmt.Println("DisableTwoFactor")
+
+	user, status, err := currentLocalUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication isn't enabled",
+		})
+	}
+
+	var request twoFactorCodeRequest
+	if err := c.BodyParser(&request); err != nil || !checkSecondFactor(&user, request.Code) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to disable two-factor authentication",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes - Replace the caller's recovery codes after confirming with a code
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	fmt.Println("RegenerateRecoveryCodes")
+
+	user, status, err := currentLocalUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication isn't enabled",
+		})
+	}
+
+	var request twoFactorCodeRequest
+	if err := c.BodyParser(&request); err != nil || !checkSecondFactor(&user, request.Code) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	codes, err := generateRecoveryCodes(database.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate recovery codes",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
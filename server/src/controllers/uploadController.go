@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+	"pdfsrv/src/pdf"
+	"pdfsrv/src/token"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (https://tus.io) with the
+// creation, checksum, termination and expiration extensions.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,checksum,termination,expiration"
+	// tusChecksumMismatch is the status tus defines for a failed chunk checksum
+	tusChecksumMismatch = 460
+	// uploadTTL is how long an unfinished upload can be resumed
+	uploadTTL = 24 * time.Hour
+)
+
+// errUploadChecksum is returned when the assembled file doesn't match the
+// checksum announced at creation
+var errUploadChecksum = errors.New("Uploaded file doesn't match its checksum")
+
+// uploadStagingDir holds the partial content of resumable uploads
+func uploadStagingDir() string {
+	if dir := os.Getenv("UPLOAD_STAGING_DIR"); dir != "" {
+		return dir
+	}
+	return "./upload-staging"
+}
+
+func stagingPath(upload models.Upload) string {
+	return filepath.Join(uploadStagingDir(), upload.Token)
+}
+
+// setTusHeaders adds the headers every tus response carries
+func setTusHeaders(c *fiber.Ctx) {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Cache-Control", "no-store")
+}
+
+// checkTusVersion rejects clients speaking another protocol version
+func checkTusVersion(c *fiber.Ctx) error {
+	setTusHeaders(c)
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+			"error": "Unsupported tus version",
+		})
+	}
+	return nil
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, a comma separated
+// list of keys with optional base64 encoded values
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for metadata key %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// chunkHasher returns the hash for an Upload-Checksum algorithm
+func chunkHasher(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// findOwnUpload loads an unexpired upload the caller started in the current workspace
+func findOwnUpload(tx *gorm.DB, c *fiber.Ctx) (models.Upload, error) {
+	var upload models.Upload
+	err := tx.Where("token = ? AND owner_id = ? AND workspace_id = ? AND expires_at > ?",
+		c.Params("id"), middleware.CurrentClaims(c).UserID(), middleware.CurrentWorkspace(c).ID, time.Now()).
+		First(&upload).Error
+	return upload, err
+}
+
+// purgeExpiredUploads removes abandoned uploads and their staging files
+func purgeExpiredUploads() {
+	var expired []models.Upload
+	database.DB.Where("expires_at <= ?", time.Now()).Find(&expired)
+	for _, upload := range expired {
+		os.Remove(stagingPath(upload))
+		database.DB.Unscoped().Delete(&upload)
+	}
+}
+
+// GetUploadOptions - Describe the supported tus version and extensions
+func GetUploadOptions(c *fiber.Ctx) error {
+	setTusHeaders(c)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Checksum-Algorithm", "sha1,sha256")
+	c.Set("Tus-Max-Size", strconv.FormatInt(maxFileSize(fileTypePDF), 10))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// CreateUpload - Start a resumable upload. The Upload-Metadata header carries
+// the filename and optionally the SHA-256 of the whole file as hex and the
+// folderId to place the file in.
+func CreateUpload(c *fiber.Ctx) error {
+	fmt.Println("CreateUpload")
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Upload-Length must be a positive number",
+		})
+	}
+	if err := checkFileSize(middleware.CurrentClaims(c).UserID(), fileTypePDF, length); err != nil {
+		return c.Status(fileSizeErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	metadata, err := parseUploadMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	filename, err := sanitizeFilename(metadata["filename"])
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	checksum := strings.ToLower(metadata["sha256"])
+	if digest, err := hex.DecodeString(checksum); err != nil || checksum != "" && len(digest) != sha256.Size {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "sha256 metadata must be a hex encoded SHA-256 digest",
+		})
+	}
+	folder, err := requestedFolder(c, metadata["folderId"])
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	purgeExpiredUploads()
+
+	id, err := token.Random(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start upload",
+		})
+	}
+	upload := models.Upload{
+		Token:       id,
+		OwnerID:     middleware.CurrentClaims(c).UserID(),
+		WorkspaceID: middleware.CurrentWorkspace(c).ID,
+		Filename:    filename,
+		Length:      length,
+		Checksum:    checksum,
+		ExpiresAt:   time.Now().Add(uploadTTL),
+	}
+	if folder != nil {
+		upload.FolderID = &folder.ID
+	}
+
+	if err := os.MkdirAll(uploadStagingDir(), 0755); err != nil {
+		fmt.Printf("ERROR creating upload staging directory: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start upload",
+		})
+	}
+	staged, err := os.Create(stagingPath(upload))
+	if err != nil {
+		fmt.Printf("ERROR creating staging file: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start upload",
+		})
+	}
+	staged.Close()
+
+	if err := database.DB.Create(&upload).Error; err != nil {
+		os.Remove(stagingPath(upload))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start upload",
+		})
+	}
+
+	c.Set(fiber.HeaderLocation, "/api/uploads/"+upload.Token)
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// GetUploadOffset - Report how much of an upload the server has received
+func GetUploadOffset(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+
+	upload, err := findOwnUpload(database.DB, c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.FileID != nil {
+		c.Set("Upload-File-Id", strconv.FormatUint(uint64(*upload.FileID), 10))
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// PatchUpload - Append a chunk at the current offset. The chunk is verified
+// against the Upload-Checksum header when present, and the last chunk turns the
+// upload into a file.
+func PatchUpload(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Content-Type must be application/offset+octet-stream",
+		})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Upload-Offset is required",
+		})
+	}
+
+	chunk := c.Body()
+	if header := c.Get("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		hasher := chunkHasher(algorithm)
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if hasher == nil || err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unsupported checksum algorithm",
+			})
+		}
+		hasher.Write(chunk)
+		if string(hasher.Sum(nil)) != string(expected) {
+			return c.Status(tusChecksumMismatch).JSON(fiber.Map{
+				"error": "Chunk checksum mismatch",
+			})
+		}
+	}
+
+	// Turn away other content with the first chunk instead of after the whole upload
+	if offset == 0 && pdf.RulesOutPDF(chunk) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": errNotPDF.Error(),
+		})
+	}
+
+	// The row lock serializes chunks of the same upload across server processes
+	var upload models.Upload
+	var file *models.File
+	status := fiber.StatusInternalServerError
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		upload, err = findOwnUpload(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+		if err != nil {
+			status = fiber.StatusNotFound
+			return fmt.Errorf("Upload not found")
+		}
+		if upload.FileID != nil || offset != upload.Offset {
+			status = fiber.StatusConflict
+			return fmt.Errorf("Upload-Offset doesn't match the received length %d", upload.Offset)
+		}
+		if int64(len(chunk)) > upload.Length-upload.Offset {
+			status = fiber.StatusRequestEntityTooLarge
+			return fmt.Errorf("Chunk exceeds the announced Upload-Length")
+		}
+
+		staged, err := os.OpenFile(stagingPath(upload), os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = staged.WriteAt(chunk, offset)
+		if closeErr := staged.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		upload.Offset += int64(len(chunk))
+		if upload.Offset == upload.Length {
+			record, err := finishUpload(c, upload)
+			if errors.Is(err, errUploadChecksum) {
+				status = tusChecksumMismatch
+				return err
+			}
+			if errors.Is(err, errNotPDF) {
+				status = fiber.StatusUnsupportedMediaType
+				return err
+			}
+			if errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+				status = fileSizeErrorStatus(err)
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			file = &record
+			upload.FileID = &record.ID
+		}
+		return tx.Model(&upload).Updates(map[string]interface{}{"offset": upload.Offset, "file_id": upload.FileID}).Error
+	})
+
+	if errors.Is(err, errUploadChecksum) || errors.Is(err, errNotPDF) ||
+		errors.Is(err, errFileTooLarge) || errors.Is(err, errQuotaExceeded) {
+		// The assembled content can't be used, so the upload has to start over
+		os.Remove(stagingPath(upload))
+		database.DB.Unscoped().Delete(&upload)
+	}
+	if err != nil {
+		if status == fiber.StatusInternalServerError {
+			fmt.Printf("ERROR writing upload chunk: %v\n", err)
+			err = fmt.Errorf("Failed to save chunk")
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if file != nil {
+		os.Remove(stagingPath(upload))
+		audit.Record(c, audit.FileUpload, audit.EntityFile, file.ID, nil, *file)
+		c.Set("Upload-File-Id", strconv.FormatUint(uint64(file.ID), 10))
+	}
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// finishUpload verifies the assembled content and stores it as a file
+func finishUpload(c *fiber.Ctx, upload models.Upload) (models.File, error) {
+	path := stagingPath(upload)
+	fileHash, err := hashFile(path)
+	if err != nil {
+		return models.File{}, err
+	}
+	if upload.Checksum != "" && fileHash != upload.Checksum {
+		return models.File{}, errUploadChecksum
+	}
+
+	return saveLocalFile(c, path, upload.Filename, fileHash, folderByID(upload.FolderID))
+}
+
+// DeleteUpload - Abandon an unfinished upload
+func DeleteUpload(c *fiber.Ctx) error {
+	fmt.Println("DeleteUpload")
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+
+	upload, err := findOwnUpload(database.DB, c)
+	if err != nil || upload.FileID != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	os.Remove(stagingPath(upload))
+	database.DB.Unscoped().Delete(&upload)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"pdfsrv/src/audit"
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// userStorage is the amount of file data a user owns and may own, a quota of 0
+// meaning no limit
+type userStorage struct {
+	OwnerID    uint  `json:"-"`
+	FileCount  int64 `json:"fileCount"`
+	UsedBytes  int64 `json:"usedBytes"`
+	QuotaBytes int64 `json:"quotaBytes"`
+}
+
+// adminUser is a user as listed to admins
+type adminUser struct {
+	models.User
+	Storage userStorage `json:"storage"`
+}
+
+type changeRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type setPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// setQuotaRequest sets a user's quota in bytes, null returning to USER_QUOTA
+// and 0 meaning no limit
+type setQuotaRequest struct {
+	QuotaBytes *int64 `json:"quotaBytes"`
+}
+
+// storageByOwner sums the size of the files owned by the given users. Files in
+// the trash and previous revisions take up storage too and are included.
+func storageByOwner(userIDs []uint) map[uint]userStorage {
+	var rows []userStorage
+	database.DB.Unscoped().Model(&models.File{}).
+		Select("owner_id, COUNT(*) AS file_count, COALESCE(SUM(size), 0) AS used_bytes").
+		Where("owner_id IN ?", userIDs).
+		Group("owner_id").
+		Scan(&rows)
+	var versionRows []userStorage
+	database.DB.Model(&models.FileVersion{}).
+		Select("files.owner_id, COALESCE(SUM(file_versions.size), 0) AS used_bytes").
+		Joins("JOIN files ON files.id = file_versions.file_id").
+		Where("files.owner_id IN ?", userIDs).
+		Group("files.owner_id").
+		Scan(&versionRows)
+
+	usage := make(map[uint]userStorage, len(rows))
+	for _, row := range rows {
+		usage[row.OwnerID] = row
+	}
+	for _, row := range versionRows {
+		owned := usage[row.OwnerID]
+		owned.UsedBytes += row.UsedBytes
+		usage[row.OwnerID] = owned
+	}
+	return usage
+}
+
+// userStorageOf reports a user's usage along with their quota
+func userStorageOf(user models.User) userStorage {
+	owned := storageByOwner([]uint{user.ID})[user.ID]
+	owned.QuotaBytes = userQuota(user)
+	return owned
+}
+
+// findManagedUser loads the user named in the route. Admins may not manage
+// themselves so they can't lock themselves out.
+func findManagedUser(c *fiber.Ctx) (models.User, int, error) {
+	var user models.User
+	if result := database.DB.First(&user, c.Params("id")); result.Error != nil {
+		return user, fiber.StatusNotFound, fmt.Errorf("User not found")
+	}
+	if user.ID == middleware.CurrentClaims(c).UserID() {
+		return user, fiber.StatusBadRequest, fmt.Errorf("You can't change your own account here")
+	}
+	return user, 0, nil
+}
+
+// GetUsers - List all users with their storage usage
+func GetUsers(c *fiber.Ctx) error {
+	var users []models.User
+	database.DB.Order("username").Find(&users)
+
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	usage := storageByOwner(ids)
+
+	result := make([]adminUser, len(users))
+	for i, user := range users {
+		owned := usage[user.ID]
+		owned.QuotaBytes = userQuota(user)
+		result[i] = adminUser{User: user, Storage: owned}
+	}
+	return c.JSON(result)
+}
+
+// GetUser - Get a user with their storage usage
+func GetUser(c *fiber.Ctx) error {
+	var user models.User
+	if result := database.DB.First(&user, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(adminUser{User: user, Storage: userStorageOf(user)})
+}
+
+// ChangeUserRole - Change a user's role. The user's sessions are ended so the
+// new role applies immediately.
+func ChangeUserRole(c *fiber.Ctx) error {
+	fmt.Println("ChangeUserRole")
+
+	user, status, err := findManagedUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request changeRoleRequest
+	if err := c.BodyParser(&request); err != nil || !models.ValidRole(request.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be viewer, editor or admin",
+		})
+	}
+
+	if request.Role != user.Role {
+		before := user
+		user.Role = request.Role
+		if err := database.DB.Model(&user).Update("role", user.Role).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to change role",
+			})
+		}
+		revokeUserSessions(user.ID)
+		audit.Record(c, audit.UserUpdate, audit.EntityUser, user.ID, before, user)
+	}
+
+	return c.JSON(user)
+}
+
+// DisableUser - Lock an account and end its sessions
+func DisableUser(c *fiber.Ctx) error {
+	fmt.Println("DisableUser")
+
+	user, status, err := findManagedUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if user.DisabledAt == nil {
+		before := user
+		now := time.Now()
+		user.DisabledAt = &now
+		if err := database.DB.Model(&user).Update("disabled_at", now).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to disable user",
+			})
+		}
+		revokeUserSessions(user.ID)
+		audit.Record(c, audit.UserUpdate, audit.EntityUser, user.ID, before, user)
+	}
+
+	return c.JSON(user)
+}
+
+// EnableUser - Unlock a disabled account
+func EnableUser(c *fiber.Ctx) error {
+	fmt.Println("EnableUser")
+
+	user, status, err := findManagedUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if user.DisabledAt != nil {
+		before := user
+		user.DisabledAt = nil
+		if err := database.DB.Model(&user).Update("disabled_at", nil).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to enable user",
+			})
+		}
+		audit.Record(c, audit.UserUpdate, audit.EntityUser, user.ID, before, user)
+	}
+
+	return c.JSON(user)
+}
+
+// SetUserPassword - Set a new password for a local account and end its sessions
+func SetUserPassword(c *fiber.Ctx) error {
+	fmt.Println("SetUserPassword")
+
+	user, status, err := findManagedUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if user.AuthSource != auth.SourceLocal {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Passwords of external accounts are managed by their directory",
+		})
+	}
+
+	var request setPasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse password data",
+		})
+	}
+	if len(request.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength),
+		})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+	if err := database.DB.Model(&user).Update("password_hash", string(hash)).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to set password",
+		})
+	}
+	revokeUserSessions(user.ID)
+	audit.Record(c, audit.UserUpdate, audit.EntityUser, user.ID, nil, fiber.Map{"passwordReset": true})
+
+	return c.JSON(fiber.Map{
+		"message": "Password set successfully",
+	})
+}
+
+// SetUserQuota - Set how many bytes of files a user may own
+func SetUserQuota(c *fiber.Ctx) error {
+	fmt.Println("SetUserQuota")
+
+	user, status, err := findManagedUser(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var request setQuotaRequest
+	if err := c.BodyParser(&request); err != nil || request.QuotaBytes != nil && *request.QuotaBytes < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "quotaBytes must be a non-negative number or null",
+		})
+	}
+
+	before := user
+	if err := database.DB.Model(&user).Update("quota_bytes", request.QuotaBytes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to set quota",
+		})
+	}
+	user.QuotaBytes = request.QuotaBytes
+	audit.Record(c, audit.UserUpdate, audit.EntityUser, user.ID, before, user)
+
+	return c.JSON(adminUser{User: user, Storage: userStorageOf(user)})
+}
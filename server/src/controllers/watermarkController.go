@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/pdf"
+)
+
+const (
+	defaultWatermarkOpacity = 0.3
+	defaultWatermarkColor   = "#808080"
+	// textWatermarkRotation slants text in the center of the page
+	textWatermarkRotation = 45
+	maxWatermarkText      = 200
+)
+
+type watermarkRequest struct {
+	// Text like "DRAFT"; a multipart request may send an image instead
+	Text string `json:"text" form:"text"`
+	// Pages like "1,3-5"; empty marks every page
+	Pages string `json:"pages" form:"pages"`
+	// Position is center (the default), top, bottom, left, right or a corner
+	// like top-left
+	Position string   `json:"position" form:"position"`
+	Opacity  *float64 `json:"opacity" form:"opacity"`
+	// Rotation in degrees counterclockwise; centered text is slanted by default
+	Rotation *float64 `json:"rotation" form:"rotation"`
+	FontSize float64  `json:"fontSize" form:"fontSize"`
+	// Color of the text like "#ff0000"
+	Color string `json:"color" form:"color"`
+	// Width of the image in points
+	Width  float64 `json:"width" form:"width"`
+	Output string  `json:"output" form:"output"`
+}
+
+// parseHexColor reads colors like "#ff8800" as red, green and blue from 0 to 1
+func parseHexColor(value string) ([3]float64, error) {
+	var color [3]float64
+	hex, found := strings.CutPrefix(value, "#")
+	if !found || len(hex) != 6 {
+		return color, fmt.Errorf("invalid color %q, use #rrggbb", value)
+	}
+	for i := range color {
+		component, err := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return color, fmt.Errorf("invalid color %q, use #rrggbb", value)
+		}
+		color[i] = float64(component) / 255
+	}
+	return color, nil
+}
+
+// WatermarkFile - Stamp text like "DRAFT" or an image on pages of a file. An
+// image is sent as the "image" part of a multipart request, with the other
+// options as form fields. The result is the next revision of the file, or
+// with output "file" a new file.
+func WatermarkFile(c *fiber.Ctx) error {
+	fmt.Println("WatermarkFile")
+
+	var request watermarkRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse watermark",
+		})
+	}
+	image, _ := c.FormFile("image")
+	request.Text = strings.TrimSpace(request.Text)
+	if (request.Text == "") == (image == nil) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Either text or an image is required",
+		})
+	}
+	if len(request.Text) > maxWatermarkText {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("text must be at most %d characters", maxWatermarkText),
+		})
+	}
+	if image != nil && image.Size > maxFileSize(fileTypeImage) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": errFileTooLarge.Error(),
+		})
+	}
+
+	mark := pdf.Watermark{
+		Text:     request.Text,
+		FontSize: request.FontSize,
+		Width:    request.Width,
+		Position: request.Position,
+		Opacity:  defaultWatermarkOpacity,
+	}
+	if mark.Position == "" {
+		mark.Position = "center"
+	}
+	if !pdf.ValidStampPosition(mark.Position) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "position must be center, top, bottom, left, right, top-left, top-right, bottom-left or bottom-right",
+		})
+	}
+	if request.Opacity != nil {
+		mark.Opacity = *request.Opacity
+	}
+	if mark.Opacity <= 0 || mark.Opacity > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "opacity must be greater than 0 and at most 1",
+		})
+	}
+	if request.Rotation != nil {
+		mark.Rotation = *request.Rotation
+	} else if image == nil && mark.Position == "center" {
+		mark.Rotation = textWatermarkRotation
+	}
+	if request.FontSize < 0 || request.Width < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "fontSize and width must not be negative",
+		})
+	}
+	if request.Color == "" {
+		request.Color = defaultWatermarkColor
+	}
+	color, err := parseHexColor(request.Color)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	mark.Color = color
+	output, err := operationOutput(request.Output)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	file, path, cleanup, status, err := localOperationFile(c, c.Params("id"), output)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	defer cleanup()
+
+	// Stored page sizes may predate recording page rotation
+	info, err := pdf.Info(path)
+	if err != nil {
+		fmt.Printf("ERROR reading page sizes of file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read the document",
+		})
+	}
+	sizes := info.PageSizes
+	var selected map[int]bool
+	if strings.TrimSpace(request.Pages) != "" {
+		ranges, err := pdf.ParsePageRanges(request.Pages, len(sizes))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		selected = pdf.PageSet(ranges)
+	}
+
+	workDir, err := newWorkDir()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to prepare watermark",
+		})
+	}
+	defer os.RemoveAll(workDir)
+
+	if image != nil {
+		mark.Image = filepath.Join(workDir, "image")
+		if err := c.SaveFile(image, mark.Image); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save image",
+			})
+		}
+	}
+	stampPath := filepath.Join(workDir, "stamp.pdf")
+	if err := pdf.WriteStamp(stampPath, sizes, selected, mark); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	outPath := filepath.Join(workDir, "watermarked.pdf")
+	if err := pdf.StampPages(path, stampPath, outPath); err != nil {
+		fmt.Printf("ERROR watermarking file %d: %v\n", file.ID, err)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to apply watermark",
+		})
+	}
+
+	details := fiber.Map{
+		"operation": "watermark",
+		"pages":     request.Pages,
+		"position":  mark.Position,
+	}
+	if image != nil {
+		details["image"] = image.Filename
+	} else {
+		details["text"] = request.Text
+	}
+	return sendOperationOutput(c, file, outPath, output, "watermarked", details)
+}
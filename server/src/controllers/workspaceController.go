@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
+)
+
+// workspaceSlugPattern keeps slugs usable as subdomains
+var workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type createWorkspaceRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type workspaceMemberRequest struct {
+	UserID uint `json:"userId"`
+}
+
+// GetWorkspaces - List the workspaces the current user can work in
+func GetWorkspaces(c *fiber.Ctx) error {
+	claims := middleware.CurrentClaims(c)
+
+	query := database.DB.Order("name")
+	if !models.RoleAtLeast(claims.Role, models.RoleAdmin) {
+		query = query.Where("id IN (?)",
+			database.DB.Model(&models.WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", claims.UserID()))
+	}
+
+	var workspaces []models.Workspace
+	query.Find(&workspaces)
+	return c.JSON(workspaces)
+}
+
+// SwitchWorkspace - Move the current session to another workspace and issue
+// tokens for it
+func SwitchWorkspace(c *fiber.Ctx) error {
+	fmt.Println("SwitchWorkspace")
+	claims := middleware.CurrentClaims(c)
+	if claims.ViaApiKey {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "API keys have no session to switch",
+		})
+	}
+
+	var workspace models.Workspace
+	if result := database.DB.First(&workspace, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+	if !models.RoleAtLeast(claims.Role, models.RoleAdmin) && !auth.IsWorkspaceMember(claims.UserID(), workspace.ID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+
+	var session models.Session
+	if result := database.DB.Preload("User").First(&session, claims.SessionID()); result.Error != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	session.WorkspaceID = workspace.ID
+	if err := database.DB.Model(&session).UpdateColumn("workspace_id", workspace.ID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to switch workspace",
+		})
+	}
+
+	tokens, err := issueSessionTokens(database.DB, session.User, session)
+	if err != nil {
+		fmt.Printf("ERROR issuing tokens: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue tokens",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"workspace": workspace,
+		"tokens":    tokens,
+	})
+}
+
+// CreateWorkspace - Create a new workspace
+func CreateWorkspace(c *fiber.Ctx) error {
+	fmt.Println("CreateWorkspace")
+
+	var request createWorkspaceRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse workspace data",
+		})
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	request.Slug = strings.ToLower(strings.TrimSpace(request.Slug))
+	if request.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Workspace name is required",
+		})
+	}
+	if !workspaceSlugPattern.MatchString(request.Slug) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Slug must be lowercase letters, digits and dashes",
+		})
+	}
+
+	var count int64
+	database.DB.Model(&models.Workspace{}).Unscoped().Where("slug = ?", request.Slug).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Slug already taken",
+		})
+	}
+
+	workspace := models.Workspace{Name: request.Name, Slug: request.Slug}
+	if result := database.DB.Create(&workspace); result.Error != nil {
+		fmt.Printf("ERROR creating workspace: %v\n", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create workspace",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(workspace)
+}
+
+// GetWorkspaceMembers - List the members of a workspace
+func GetWorkspaceMembers(c *fiber.Ctx) error {
+	var workspace models.Workspace
+	if result := database.DB.First(&workspace, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+
+	var users []models.User
+	database.DB.Where("id IN (?)",
+		database.DB.Model(&models.WorkspaceMember{}).Select("user_id").Where("workspace_id = ?", workspace.ID)).
+		Order("username").
+		Find(&users)
+	return c.JSON(users)
+}
+
+// AddWorkspaceMember - Give a user access to a workspace
+func AddWorkspaceMember(c *fiber.Ctx) error {
+	fmt.Println("AddWorkspaceMember")
+
+	var workspace models.Workspace
+	if result := database.DB.First(&workspace, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Workspace not found",
+		})
+	}
+
+	var request workspaceMemberRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse member data",
+		})
+	}
+
+	var user models.User
+	if result := database.DB.First(&user, request.UserID); result.Error != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	member := models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: user.ID}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add member",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member added successfully",
+	})
+}
+
+// RemoveWorkspaceMember - Revoke a user's access to a workspace. The user's
+// file grants and group memberships in the workspace are removed with it.
+func RemoveWorkspaceMember(c *fiber.Ctx) error {
+	fmt.Println("RemoveWorkspaceMember")
+	workspaceID := c.Params("id")
+	userID := c.Params("userId")
+
+	result := database.DB.Unscoped().
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		Delete(&models.WorkspaceMember{})
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+
+	database.DB.Unscoped().
+		Where("user_id = ? AND file_id IN (?)", userID,
+			database.DB.Model(&models.File{}).Select("id").Where("workspace_id = ?", workspaceID)).
+		Delete(&models.FilePermission{})
+	database.DB.Unscoped().
+		Where("user_id = ? AND group_id IN (?)", userID,
+			database.DB.Model(&models.Group{}).Select("id").Where("workspace_id = ?", workspaceID)).
+		Delete(&models.GroupMember{})
+
+	return c.JSON(fiber.Map{
+		"message": "Member removed successfully",
+	})
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+	"pdfsrv/src/token"
+)
+
+const claimsKey = "claims"
+
+// sessionTouchInterval limits how often a session's last seen time is written
+const sessionTouchInterval = time.Minute
+
+// RequireAuth rejects requests without a valid bearer token, X-Api-Key header
+// or guest token (X-Guest-Token header or guestToken query parameter)
+func RequireAuth(c *fiber.Ctx) error {
+	if key := c.Get("X-Api-Key"); key != "" {
+		return authenticateApiKey(c, key)
+	}
+	if guest := c.Get("X-Guest-Token", c.Query("guestToken")); guest != "" {
+		return authenticateGuestToken(c, guest)
+	}
+
+	header := c.Get(fiber.HeaderAuthorization)
+	signed, found := strings.CutPrefix(header, "Bearer ")
+	if !found || signed == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	claims, err := token.Parse(signed)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
+
+	// Tokens are only valid while their session hasn't been revoked
+	var session models.Session
+	result := database.DB.
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", claims.SessionID(), claims.UserID()).
+		First(&session)
+	if result.Error != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Session has been revoked",
+		})
+	}
+
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		database.DB.Model(&session).UpdateColumn("last_seen_at", now)
+	}
+
+	c.Locals(claimsKey, claims)
+	return c.Next()
+}
+
+// authenticateApiKey resolves the key's owner and enforces the key's scopes
+func authenticateApiKey(c *fiber.Ctx, key string) error {
+	var apiKey models.ApiKey
+	result := database.DB.Preload("User").
+		Where("key_hash = ? AND revoked_at IS NULL", token.Hash(key)).
+		First(&apiKey)
+	if result.Error != nil || apiKey.User.DisabledAt != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid API key",
+		})
+	}
+
+	if !apiKeyAllows(apiKey.Scopes, c.Method(), c.Path()) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "API key scope doesn't allow this request",
+		})
+	}
+
+	now := time.Now()
+	database.DB.Model(&apiKey).UpdateColumn("last_used_at", now)
+
+	c.Locals(claimsKey, &token.Claims{
+		Username:     apiKey.User.Username,
+		Role:         apiKey.User.Role,
+		ApiKeyScopes: apiKey.Scopes,
+		ViaApiKey:    true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.FormatUint(uint64(apiKey.UserID), 10),
+		},
+	})
+	return c.Next()
+}
+
+// authenticateGuestToken limits the caller to reading the token's file
+func authenticateGuestToken(c *fiber.Ctx, secret string) error {
+	var guest models.GuestToken
+	result := database.DB.Preload("File").
+		Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", token.Hash(secret), time.Now()).
+		First(&guest)
+	if result.Error != nil || guest.File.ID == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired guest token",
+		})
+	}
+
+	if !guestAllows(c.Method(), c.Path()) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Guest tokens only allow viewing the shared file",
+		})
+	}
+
+	if now := time.Now(); guest.LastUsedAt == nil || now.Sub(*guest.LastUsedAt) > sessionTouchInterval {
+		database.DB.Model(&guest).UpdateColumn("last_used_at", now)
+	}
+
+	c.Locals(claimsKey, &token.Claims{
+		Username:     "guest",
+		Role:         models.RoleViewer,
+		WorkspaceID:  guest.File.WorkspaceID,
+		GuestFileID:  guest.FileID,
+		GuestTokenID: guest.ID,
+	})
+	return c.Next()
+}
+
+// guestAllows reports whether a guest may call the route. Which file is read is
+// checked by the handlers.
+func guestAllows(method, path string) bool {
+	if method != fiber.MethodGet && method != fiber.MethodHead {
+		return false
+	}
+	switch path {
+	case "/api/auth/me", "/api/drawings", "/api/drawings/bounds":
+		return true
+	}
+	if rest, found := strings.CutPrefix(path, "/api/files/"); found {
+		_, action, _ := strings.Cut(rest, "/")
+		return action == "download" || action == "thumbnail" || action == "text" || action == "layers" || action == "drawing-groups" || strings.HasPrefix(action, "pages/")
+	}
+	if rest, found := strings.CutPrefix(path, "/api/drawings/"); found {
+		return !strings.Contains(rest, "/")
+	}
+	return false
+}
+
+// apiKeyAllows reports whether any of the scopes permits the request
+func apiKeyAllows(scopes []string, method, path string) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case models.ApiKeyScopeRead:
+			if method == fiber.MethodGet || method == fiber.MethodHead {
+				return true
+			}
+		case models.ApiKeyScopeUpload:
+			if method == fiber.MethodPost && path == "/api/upload" {
+				return true
+			}
+			// Resumable uploads go through several requests
+			if path == "/api/uploads" || strings.HasPrefix(path, "/api/uploads/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CurrentClaims returns the claims of the authenticated caller, or nil
+func CurrentClaims(c *fiber.Ctx) *token.Claims {
+	claims, _ := c.Locals(claimsKey).(*token.Claims)
+	return claims
+}
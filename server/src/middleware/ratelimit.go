@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// rateLimitPolicy allows max requests per window
+type rateLimitPolicy struct {
+	max    int
+	window time.Duration
+}
+
+// parseRateLimitPolicy reads policies written as "<max>/<window>", e.g. "20/1m".
+// "off" or "0" disables the limit.
+func parseRateLimitPolicy(value string) (rateLimitPolicy, bool, error) {
+	if value == "off" || value == "0" {
+		return rateLimitPolicy{}, false, nil
+	}
+
+	maxStr, windowStr, found := strings.Cut(value, "/")
+	if !found {
+		return rateLimitPolicy{}, false, fmt.Errorf("expected <max>/<window>, got %q", value)
+	}
+
+	max, err := strconv.Atoi(maxStr)
+	if err != nil || max <= 0 {
+		return rateLimitPolicy{}, false, fmt.Errorf("invalid request count %q", maxStr)
+	}
+
+	window, err := time.ParseDuration(windowStr)
+	if err != nil || window <= 0 {
+		return rateLimitPolicy{}, false, fmt.Errorf("invalid window %q", windowStr)
+	}
+
+	return rateLimitPolicy{max: max, window: window}, true, nil
+}
+
+// rateLimitKey identifies authenticated callers by user and everyone else by IP
+func rateLimitKey(c *fiber.Ctx) string {
+	// Guests have no user, so they are counted by address
+	if claims := CurrentClaims(c); claims != nil && !claims.IsGuest() {
+		return "user:" + claims.Subject
+	}
+	return "ip:" + c.IP()
+}
+
+// RateLimit limits requests for a route group. The policy is read from the
+// RATE_LIMIT_<GROUP> env var and falls back to defaultPolicy. Counters are kept
+// in the database, so the limit holds across prefork workers.
+func RateLimit(group, defaultPolicy string) fiber.Handler {
+	envVar := "RATE_LIMIT_" + strings.ToUpper(group)
+	value := os.Getenv(envVar)
+	if value == "" {
+		value = defaultPolicy
+	}
+
+	policy, enabled, err := parseRateLimitPolicy(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid %s: %v", envVar, err))
+	}
+	if !enabled {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	return limiter.New(limiter.Config{
+		Max:          policy.max,
+		Expiration:   policy.window,
+		KeyGenerator: rateLimitKey,
+		Storage:      rateLimitStorage{group: group},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many requests, please retry later",
+			})
+		},
+	})
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// rateLimitPurgeInterval is how often expired counters are removed
+const rateLimitPurgeInterval = time.Minute
+
+// rateLimitPurgedAt is when expired counters were last removed, in Unix seconds
+var rateLimitPurgedAt atomic.Int64
+
+// rateLimitStorage keeps the counters of a rate limit in the database, shared
+// by the prefork workers. Keys are prefixed with the limit's group, as all
+// groups share the table. A worker reads and writes a counter in two steps, so
+// requests racing on several workers may each count once less.
+type rateLimitStorage struct {
+	group string
+}
+
+func (s rateLimitStorage) key(key string) string {
+	return s.group + ":" + key
+}
+
+// Get returns the counter stored under key, or nil when there is none
+func (s rateLimitStorage) Get(key string) ([]byte, error) {
+	var counter models.RateLimitCounter
+	result := database.DB.Where("key = ? AND expires_at > ?", s.key(key), time.Now()).Limit(1).Find(&counter)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return counter.Value, nil
+}
+
+// Set stores the counter under key until exp has passed, or for good for 0
+func (s rateLimitStorage) Set(key string, val []byte, exp time.Duration) error {
+	s.purge()
+	expiresAt := time.Now().Add(exp)
+	if exp <= 0 {
+		expiresAt = time.Now().AddDate(100, 0, 0)
+	}
+	counter := models.RateLimitCounter{Key: s.key(key), Value: val, ExpiresAt: expiresAt}
+	return database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "expires_at"}),
+	}).Create(&counter).Error
+}
+
+// Delete removes the counter stored under key
+func (s rateLimitStorage) Delete(key string) error {
+	return database.DB.Where("key = ?", s.key(key)).Delete(&models.RateLimitCounter{}).Error
+}
+
+// Reset removes the counters of the group
+func (s rateLimitStorage) Reset() error {
+	return database.DB.Where("key LIKE ?", s.group+":%").Delete(&models.RateLimitCounter{}).Error
+}
+
+// Close does nothing, the database outlives the limiter
+func (s rateLimitStorage) Close() error {
+	return nil
+}
+
+// purge removes expired counters of every group, at most once per
+// rateLimitPurgeInterval per worker
+func (s rateLimitStorage) purge() {
+	now := time.Now()
+	last := rateLimitPurgedAt.Load()
+	if now.Unix()-last < int64(rateLimitPurgeInterval.Seconds()) || !rateLimitPurgedAt.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	database.DB.Where("expires_at <= ?", now).Delete(&models.RateLimitCounter{})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/models"
+	"pdfsrv/src/policy"
+)
+
+// activePolicy is the policy Authorize enforces, for handlers that act on
+// behalf of other routes
+var activePolicy *policy.Policy
+
+// Authorize enforces the authorization policy loaded from AUTH_POLICY_FILE, or
+// the built-in default policy. It must run after RequireAuth and panics at
+// startup if the policy file is invalid.
+func Authorize() fiber.Handler {
+	p, err := policy.FromEnv()
+	if err != nil {
+		panic(err)
+	}
+	activePolicy = p
+
+	return func(c *fiber.Ctx) error {
+		claims := CurrentClaims(c)
+		if claims == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		if !p.Allows(claims.Role, c.Method(), c.Path()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// Allows reports whether the policy lets the caller make a request to the
+// route, for handlers that do the work of another route, like a batch of
+// changes
+func Allows(c *fiber.Ctx, method, path string) bool {
+	claims := CurrentClaims(c)
+	if claims == nil {
+		return false
+	}
+	p := activePolicy
+	if p == nil {
+		p = policy.Default()
+	}
+	return p.Allows(claims.Role, method, path)
+}
+
+// RequireRole only lets callers with at least the given role through, whatever
+// the policy says. It guards the routes no policy may open up, and must run
+// after RequireAuth.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := CurrentClaims(c)
+		if claims == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		if !models.RoleAtLeast(claims.Role, role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"pdfsrv/src/auth"
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+const workspaceKey = "workspace"
+
+// ResolveWorkspace determines the workspace a request operates in and rejects
+// callers that aren't members of it. The workspace comes from the subdomain
+// when WORKSPACE_BASE_DOMAIN is set and the host is <slug>.<base domain>, then
+// from the token, then from the caller's oldest membership. Admins may enter
+// any workspace. It must run after RequireAuth.
+func ResolveWorkspace(c *fiber.Ctx) error {
+	claims := CurrentClaims(c)
+	if claims == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var workspace models.Workspace
+	found := false
+	if slug := subdomainWorkspaceSlug(c.Hostname()); slug != "" {
+		found = database.DB.Where("slug = ?", slug).First(&workspace).Error == nil
+	} else {
+		workspaceID := claims.WorkspaceID
+		if workspaceID == 0 {
+			workspaceID = auth.PrimaryWorkspaceID(claims.UserID())
+		}
+		found = workspaceID != 0 && database.DB.First(&workspace, workspaceID).Error == nil
+	}
+	if !found {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No workspace available",
+		})
+	}
+
+	// Guests are bound to their file's workspace instead of a membership
+	member := claims.IsGuest() && workspace.ID == claims.WorkspaceID ||
+		models.RoleAtLeast(claims.Role, models.RoleAdmin) ||
+		auth.IsWorkspaceMember(claims.UserID(), workspace.ID)
+	if !member {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this workspace",
+		})
+	}
+
+	c.Locals(workspaceKey, &workspace)
+	return c.Next()
+}
+
+// subdomainWorkspaceSlug extracts the workspace slug from a host below
+// WORKSPACE_BASE_DOMAIN, or returns an empty string
+func subdomainWorkspaceSlug(host string) string {
+	base := strings.ToLower(strings.TrimPrefix(os.Getenv("WORKSPACE_BASE_DOMAIN"), "."))
+	if base == "" {
+		return ""
+	}
+	slug, found := strings.CutSuffix(strings.ToLower(host), "."+base)
+	if !found || slug == "" || strings.Contains(slug, ".") {
+		return ""
+	}
+	return slug
+}
+
+// CurrentWorkspace returns the workspace resolved for the request, or nil
+func CurrentWorkspace(c *fiber.Ctx) *models.Workspace {
+	workspace, _ := c.Locals(workspaceKey).(*models.Workspace)
+	return workspace
+}
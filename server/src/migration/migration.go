@@ -1,13 +1,153 @@
 package migration
 
 import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
 	"pdfsrv/src/database"
 	"pdfsrv/src/models"
+	"pdfsrv/src/storage"
 )
 
 func AutoMigrate() {
 	if database.DB == nil {
 		panic("DB is not initialized")
 	}
-	database.DB.AutoMigrate(models.File{}, models.Drawing{})
+	database.DB.AutoMigrate(
+		models.File{},
+		models.Drawing{},
+		models.User{},
+		models.ApiKey{},
+		models.FilePermission{},
+		models.ShareLink{},
+		models.Session{},
+		models.PasswordResetToken{},
+		models.RefreshToken{},
+		models.Workspace{},
+		models.WorkspaceMember{},
+		models.Group{},
+		models.GroupMember{},
+		models.FileGroupPermission{},
+		models.AuditEntry{},
+		models.RecoveryCode{},
+		models.TwoFactorChallenge{},
+		models.GuestToken{},
+		models.Invitation{},
+		models.Upload{},
+		models.Blob{},
+		models.FileVersion{},
+		models.Folder{},
+		models.FileStar{},
+		models.FileAccess{},
+		models.FileComment{},
+		models.PageText{},
+		models.OCRJob{},
+		models.ConversionJob{},
+		models.Template{},
+		models.Job{},
+		models.DrawingRevision{},
+		models.Layer{},
+		models.DrawingGroup{},
+		models.DrawingComment{},
+		models.RateLimitCounter{},
+	)
+	seedInitialUser()
+	seedDefaultWorkspace()
+	backfillBlobs()
+	backfillFileSizes()
+	backfillExternalNames()
+}
+
+// backfillExternalNames records the directory name of LDAP accounts
+// provisioned before it was kept. Those whose name was taken got the source
+// as suffix.
+func backfillExternalNames() {
+	database.DB.Unscoped().Model(&models.User{}).
+		Where("auth_source = 'ldap' AND external_name = ''").
+		UpdateColumn("external_name", gorm.Expr("regexp_replace(username, '@ldap$', '')"))
+}
+
+// backfillFileSizes records the size of files stored before it was tracked,
+// reading it from storage
+func backfillFileSizes() {
+	var files []models.File
+	database.DB.Unscoped().Where("size = 0").Order("id").Find(&files)
+	for _, file := range files {
+		key := file.StorageKey
+		if key == "" {
+			key = storage.Key(file.Hash, file.Filename)
+		}
+		r, size, err := storage.Store.Get(key)
+		if err != nil {
+			fmt.Printf("ERROR reading size of file %d: %v\n", file.ID, err)
+			continue
+		}
+		r.Close()
+		database.DB.Unscoped().Model(&file).UpdateColumn("size", size)
+		database.DB.Model(&models.Blob{}).Where("hash = ? AND size = 0", file.Hash).UpdateColumn("size", size)
+	}
+}
+
+// backfillBlobs registers the content of files stored before deduplication.
+// Files sharing a hash are pointed at the copy of the first of them.
+func backfillBlobs() {
+	var files []models.File
+	database.DB.Where("storage_key = ''").Order("id").Find(&files)
+	for _, file := range files {
+		key, err := storage.Register(file.Hash, storage.Key(file.Hash, file.Filename), file.Size)
+		if err != nil {
+			panic(fmt.Sprintf("failed to register stored content of file %d: %v", file.ID, err))
+		}
+		database.DB.Model(&file).UpdateColumn("storage_key", key)
+	}
+}
+
+// seedDefaultWorkspace creates the default workspace on first start and moves
+// existing files and users into it, so installs predating workspaces keep their data
+func seedDefaultWorkspace() {
+	var count int64
+	database.DB.Model(&models.Workspace{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	workspace := models.Workspace{Name: "Default", Slug: models.DefaultWorkspaceSlug}
+	if err := database.DB.Create(&workspace).Error; err != nil {
+		panic("failed to create default workspace")
+	}
+
+	database.DB.Model(&models.File{}).Unscoped().Where("workspace_id = 0").Update("workspace_id", workspace.ID)
+
+	var userIDs []uint
+	database.DB.Model(&models.User{}).Pluck("id", &userIDs)
+	for _, userID := range userIDs {
+		database.DB.Create(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: userID})
+	}
+	fmt.Println("Created default workspace")
+}
+
+// seedInitialUser creates the first admin account from INITIAL_USERNAME and
+// INITIAL_PASSWORD so a fresh install can log in
+func seedInitialUser() {
+	username := os.Getenv("INITIAL_USERNAME")
+	password := os.Getenv("INITIAL_PASSWORD")
+	if username == "" || password == "" {
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.User{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		panic("failed to hash initial user password")
+	}
+	database.DB.Create(&models.User{Username: username, PasswordHash: string(hash), Role: models.RoleAdmin})
+	fmt.Println("Created initial user", username)
 }
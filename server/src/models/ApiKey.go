@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// API key scopes
+const (
+	// ApiKeyScopeRead allows read-only requests
+	ApiKeyScopeRead = "read"
+	// ApiKeyScopeUpload allows uploading files
+	ApiKeyScopeUpload = "upload"
+)
+
+// ValidApiKeyScope reports whether scope is a known API key scope
+func ValidApiKeyScope(scope string) bool {
+	return scope == ApiKeyScopeRead || scope == ApiKeyScopeUpload
+}
+
+type ApiKey struct {
+	GormModel
+	UserID     uint       `json:"userId" gorm:"not null;index"`
+	User       User       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"type:jsonb;serializer:json"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
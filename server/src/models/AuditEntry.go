@@ -0,0 +1,17 @@
+package models
+
+// AuditEntry records a mutating action with the entity's state before and after it
+type AuditEntry struct {
+	GormModel
+	WorkspaceID *uint  `json:"workspaceId" gorm:"index"`
+	ActorID     *uint  `json:"actorId" gorm:"index"`
+	ActorName   string `json:"actorName"`
+	Action      string `json:"action" gorm:"not null;index"`
+	EntityType  string `json:"entityType" gorm:"not null;index:idx_audit_entity"`
+	EntityID    uint   `json:"entityId" gorm:"index:idx_audit_entity"`
+	// Before and After are JSON snapshots of the entity, null when it didn't
+	// exist. Those of drawings leave out the image.
+	Before interface{} `json:"before" gorm:"type:jsonb;serializer:json"`
+	After  interface{} `json:"after" gorm:"type:jsonb;serializer:json"`
+	IP     string      `json:"ip"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// Blob is stored file content. Files with the same hash share one blob, which
+// is removed from storage once RefCount drops to zero.
+type Blob struct {
+	GormModel
+	Hash     string `json:"hash" gorm:"uniqueIndex;not null"`
+	Key      string `json:"key" gorm:"not null"`
+	Size     int64  `json:"size"`
+	RefCount int64  `json:"refCount" gorm:"not null;default:0"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Conversion job states
+const (
+	ConversionPending = "pending"
+	ConversionRunning = "running"
+	ConversionDone    = "done"
+	ConversionFailed  = "failed"
+)
+
+// ConversionJob converts an uploaded office document, like a DOCX or XLSX, to
+// a PDF in the background. The document waits in the upload staging directory
+// until the job ends.
+type ConversionJob struct {
+	GormModel
+	OwnerID     uint `json:"ownerId" gorm:"not null;index"`
+	WorkspaceID uint `json:"workspaceId" gorm:"not null"`
+	// Filename is the uploaded document's; the file gets it with a .pdf extension
+	Filename string `json:"filename"`
+	// FolderID is the folder the file is placed in once converted
+	FolderID *uint  `json:"folderId"`
+	Status   string `json:"status" gorm:"not null;index"`
+	Error    string `json:"error,omitempty"`
+	FileID   *uint  `json:"fileId"`
+	// JobID is the queued job that runs it
+	JobID      *uint      `json:"jobId"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
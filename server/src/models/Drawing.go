@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 // BoundingBox represents the boundary of a drawing
@@ -24,6 +25,36 @@ type Drawing struct {
 	BoundingBox BoundingBox `json:"boundingBox" gorm:"embedded"`
 
 	Data string `json:"data" gorm:"type:text"`
+
+	// LayerID is the layer of the file the drawing is on, nil for none
+	LayerID *uint `json:"layerId" gorm:"index"`
+
+	// GroupID is the group the drawing is moved and deleted with, nil for none
+	GroupID *uint `json:"groupId" gorm:"index"`
+
+	// Orphaned drawings were on a page removed from the file and keep the
+	// number it had; an update that places the drawing again clears the flag
+	Orphaned bool `json:"orphaned" gorm:"not null;default:false"`
+
+	// Version counts the changes to the drawing from 1. Updates name the
+	// version they replace, so concurrent edits can't overwrite each other.
+	Version int `json:"version" gorm:"not null;default:1"`
+
+	// ClientID is the UUID an offline client created the drawing under, so
+	// its later changes and retries find it
+	ClientID *string `json:"clientId,omitempty" gorm:"uniqueIndex;size:64"`
+
+	// CreatedByID is who drew the drawing and UpdatedByID who changed it
+	// last, nil when unknown; the names are their usernames at the time
+	CreatedByID   *uint  `json:"createdById" gorm:"index"`
+	CreatedByName string `json:"createdByName"`
+	UpdatedByID   *uint  `json:"updatedById"`
+	UpdatedByName string `json:"updatedByName"`
+
+	// LockedByID is who locked the drawing; only they and admins may change
+	// or delete it meanwhile
+	LockedByID *uint      `json:"lockedById"`
+	LockedAt   *time.Time `json:"lockedAt"`
 }
 
 // Custom unmarshaler to handle string IDs
@@ -35,6 +66,10 @@ type drawingJSON struct {
 	Image       string      `json:"image,omitempty"`
 	BoundingBox BoundingBox `json:"boundingBox"`
 	Data        string      `json:"data"`
+	LayerID     *uint       `json:"layerId"`
+	GroupID     *uint       `json:"groupId"`
+	Version     int         `json:"version"`
+	ClientID    *string     `json:"clientId,omitempty"`
 	CreatedAt   string      `json:"createdAt,omitempty"`
 	UpdatedAt   string      `json:"updatedAt,omitempty"`
 	DeletedAt   string      `json:"deletedAt,omitempty"`
@@ -103,6 +138,10 @@ func (d *Drawing) UnmarshalJSON(data []byte) error {
 	d.Image = temp.Image
 	d.BoundingBox = temp.BoundingBox
 	d.Data = temp.Data
+	d.LayerID = temp.LayerID
+	d.GroupID = temp.GroupID
+	d.Version = temp.Version
+	d.ClientID = temp.ClientID
 
 	return nil
 }
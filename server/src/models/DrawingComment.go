@@ -0,0 +1,13 @@
+package models
+
+// DrawingComment is a remark on a drawing, to discuss a markup. Replies name
+// the comment they answer in ParentID.
+type DrawingComment struct {
+	GormModel
+	DrawingID uint  `json:"drawingId" gorm:"not null;index"`
+	ParentID  *uint `json:"parentId" gorm:"index"`
+	AuthorID  uint  `json:"authorId" gorm:"not null"`
+	// AuthorName is the author's username when the comment was written
+	AuthorName string `json:"authorName"`
+	Body       string `json:"body" gorm:"type:text;not null"`
+}
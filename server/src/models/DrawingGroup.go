@@ -0,0 +1,11 @@
+package models
+
+// DrawingGroup joins drawings of a file that make up one markup, such as a
+// leader line with its box and text, so they are moved and deleted together.
+// Drawings name theirs in GroupID.
+type DrawingGroup struct {
+	GormModel
+	FileID uint   `json:"fileId" gorm:"not null;index"`
+	File   File   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name   string `json:"name" gorm:"not null"`
+}
@@ -0,0 +1,29 @@
+package models
+
+// Actions superseding a drawing revision
+const (
+	DrawingRevisionUpdate = "update"
+	DrawingRevisionDelete = "delete"
+	DrawingRevisionRevert = "revert"
+)
+
+// DrawingRevision is a superseded state of a drawing, kept when the drawing is
+// updated, deleted or reverted so the change can be undone
+type DrawingRevision struct {
+	GormModel
+	DrawingID   uint        `json:"drawingId" gorm:"not null;index"`
+	FileID      uint        `json:"fileId" gorm:"not null;index"`
+	Type        string      `json:"type" gorm:"not null"`
+	PageNumber  int         `json:"pageNumber" gorm:"not null"`
+	Image       string      `json:"image,omitempty" gorm:"type:text"`
+	BoundingBox BoundingBox `json:"boundingBox" gorm:"embedded"`
+	Data        string      `json:"data" gorm:"type:text"`
+	LayerID     *uint       `json:"layerId"`
+	GroupID     *uint       `json:"groupId"`
+	Orphaned    bool        `json:"orphaned" gorm:"not null;default:false"`
+	// Action is the change that superseded this state: update, delete or revert
+	Action string `json:"action" gorm:"not null"`
+	// ChangedByID is who made that change, and ChangedByName their username then
+	ChangedByID   *uint  `json:"changedById"`
+	ChangedByName string `json:"changedByName"`
+}
@@ -1,8 +1,60 @@
 package models
 
+import (
+	"time"
+
+	"pdfsrv/src/pdf"
+)
+
 type File struct {
 	GormModel
 	Filename string `json:"filename" gorm:"not null"`
 	Hash     string `json:"hash" gorm:"not null"`
 	Size     int64  `json:"size"`
+	// StorageKey locates the content, which is shared with other files of the same hash
+	StorageKey  string   `json:"-"`
+	Tags        []string `json:"tags" gorm:"type:jsonb;serializer:json"`
+	Description string   `json:"description"`
+	// Document metadata read from the PDF at upload; PageCount is 0 when unknown
+	PageCount int            `json:"pageCount"`
+	PageSizes []pdf.PageSize `json:"pageSizes" gorm:"type:jsonb;serializer:json"`
+	Title     string         `json:"title"`
+	Author    string         `json:"author"`
+	Producer  string         `json:"producer"`
+	// Encrypted files can't be processed until their encryption is removed
+	Encrypted bool `json:"encrypted" gorm:"not null;default:false"`
+	// Version counts the revisions uploaded, starting at 1; earlier ones are FileVersions
+	Version int `json:"version" gorm:"not null;default:1"`
+	// UploadedByID is who uploaded the current revision
+	UploadedByID *uint `json:"uploadedById"`
+	// ScanStatus is the virus scan state, empty when the file wasn't scanned
+	ScanStatus string `json:"scanStatus"`
+	// FolderID is nil for files outside any folder
+	FolderID *uint `json:"folderId" gorm:"index"`
+	// ExpiresAt is when the file is moved to the trash automatically
+	ExpiresAt *time.Time `json:"expiresAt" gorm:"index"`
+	// ExpiryNoticeSentAt is set once the owner was told about the expiry
+	ExpiryNoticeSentAt *time.Time `json:"-"`
+	// LockedByID is who checked the file out; others can't revise it meanwhile
+	LockedByID *uint      `json:"lockedById"`
+	LockedAt   *time.Time `json:"lockedAt"`
+	// DeletedByID is who moved the file to the trash
+	DeletedByID *uint `json:"deletedById"`
+	// OwnerID is nil for files uploaded before ownership was tracked; those stay visible to everyone
+	OwnerID *uint `json:"ownerId" gorm:"index"`
+	// WorkspaceID scopes the file and its drawings to a tenant
+	WorkspaceID uint `json:"workspaceId" gorm:"not null;default:0;index"`
+}
+
+// Virus scan states. Pending and infected files are quarantined: their content
+// can't be read.
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// Quarantined reports whether the file's content is withheld until it passes a virus scan
+func (f File) Quarantined() bool {
+	return f.ScanStatus == ScanPending || f.ScanStatus == ScanInfected
 }
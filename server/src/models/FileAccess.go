@@ -0,0 +1,24 @@
+package models
+
+// File access kinds
+const (
+	FileAccessDownload = "download"
+	FileAccessView     = "view"
+)
+
+// FileAccess records one download or view of a file, by a user, a guest token
+// or through a share link
+type FileAccess struct {
+	GormModel
+	FileID uint   `json:"fileId" gorm:"not null;index"`
+	File   File   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Kind   string `json:"kind" gorm:"not null"`
+	// Version is the revision that was downloaded
+	Version      int    `json:"version"`
+	UserID       *uint  `json:"userId" gorm:"index"`
+	Username     string `json:"username"`
+	ShareLinkID  *uint  `json:"shareLinkId"`
+	GuestTokenID *uint  `json:"guestTokenId"`
+	IP           string `json:"ip"`
+	UserAgent    string `json:"userAgent"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// FileComment is a remark on a whole file. Replies name the comment they answer
+// in ParentID.
+type FileComment struct {
+	GormModel
+	FileID   uint  `json:"fileId" gorm:"not null;index"`
+	File     File  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	ParentID *uint `json:"parentId" gorm:"index"`
+	AuthorID uint  `json:"authorId" gorm:"not null"`
+	// AuthorName is the author's username when the comment was written
+	AuthorName string `json:"authorName"`
+	Body       string `json:"body" gorm:"type:text;not null"`
+}
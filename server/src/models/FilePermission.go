@@ -0,0 +1,22 @@
+package models
+
+// File permission levels; write implies read
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// ValidPermission reports whether permission is a known permission level
+func ValidPermission(permission string) bool {
+	return permission == PermissionRead || permission == PermissionWrite
+}
+
+// FilePermission grants a user access to a file they don't own
+type FilePermission struct {
+	GormModel
+	FileID     uint   `json:"fileId" gorm:"not null;uniqueIndex:idx_file_permission_user"`
+	File       File   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserID     uint   `json:"userId" gorm:"not null;uniqueIndex:idx_file_permission_user"`
+	User       User   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Permission string `json:"permission" gorm:"not null"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// FileStar marks a file as a favorite of one user
+type FileStar struct {
+	GormModel
+	FileID uint `json:"fileId" gorm:"not null;uniqueIndex:idx_file_star_user"`
+	File   File `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserID uint `json:"userId" gorm:"not null;uniqueIndex:idx_file_star_user;index"`
+	User   User `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "pdfsrv/src/pdf"
+
+// FileVersion is a superseded revision of a file. The file itself always holds
+// the current revision; each new upload moves the previous one here.
+type FileVersion struct {
+	GormModel
+	FileID     uint   `json:"fileId" gorm:"not null;uniqueIndex:idx_file_version"`
+	Version    int    `json:"version" gorm:"not null;uniqueIndex:idx_file_version"`
+	Filename   string `json:"filename"`
+	Hash       string `json:"hash" gorm:"not null"`
+	Size       int64  `json:"size"`
+	StorageKey string `json:"-"`
+	// UploadedByID is who uploaded this revision, nil when unknown
+	UploadedByID *uint          `json:"uploadedById"`
+	PageCount    int            `json:"pageCount"`
+	PageSizes    []pdf.PageSize `json:"pageSizes" gorm:"type:jsonb;serializer:json"`
+	Title        string         `json:"title"`
+	Author       string         `json:"author"`
+	Producer     string         `json:"producer"`
+	Encrypted    bool           `json:"encrypted" gorm:"not null;default:false"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// Folder organizes the files of a workspace. Folders can be nested.
+type Folder struct {
+	GormModel
+	WorkspaceID uint      `json:"workspaceId" gorm:"not null;index"`
+	Workspace   Workspace `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// ParentID is nil for folders at the top level
+	ParentID *uint  `json:"parentId" gorm:"index"`
+	Name     string `json:"name" gorm:"not null"`
+	// CreatedByID manages the folder alongside admins
+	CreatedByID uint `json:"createdById" gorm:"not null"`
+	// FileTTLDays sets the expiry of files uploaded into the folder; 0 keeps them
+	FileTTLDays int `json:"fileTtlDays" gorm:"not null;default:0"`
+}
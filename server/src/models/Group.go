@@ -0,0 +1,30 @@
+package models
+
+// Group is a team inside a workspace that files can be shared with as a whole
+type Group struct {
+	GormModel
+	WorkspaceID uint      `json:"workspaceId" gorm:"not null;uniqueIndex:idx_group_workspace_name"`
+	Workspace   Workspace `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex:idx_group_workspace_name"`
+	// CreatedByID manages the group's members alongside admins
+	CreatedByID uint `json:"createdById" gorm:"not null"`
+}
+
+// GroupMember adds a user to a group
+type GroupMember struct {
+	GormModel
+	GroupID uint  `json:"groupId" gorm:"not null;uniqueIndex:idx_group_member_user"`
+	Group   Group `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserID  uint  `json:"userId" gorm:"not null;uniqueIndex:idx_group_member_user"`
+	User    User  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// FileGroupPermission grants every member of a group access to a file
+type FileGroupPermission struct {
+	GormModel
+	FileID     uint   `json:"fileId" gorm:"not null;uniqueIndex:idx_file_permission_group"`
+	File       File   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	GroupID    uint   `json:"groupId" gorm:"not null;uniqueIndex:idx_file_permission_group"`
+	Group      Group  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Permission string `json:"permission" gorm:"not null"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// GuestToken lets an external reviewer open one file and its drawings read-only
+// without an account
+type GuestToken struct {
+	GormModel
+	FileID      uint       `json:"fileId" gorm:"not null;index"`
+	File        File       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedByID uint       `json:"createdById" gorm:"not null"`
+	Label       string     `json:"label"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+}
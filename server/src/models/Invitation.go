@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Invitation is a pending, single-use signup link sent by email. Accepting it
+// creates the account with the role, workspace and optional group chosen by the inviter.
+type Invitation struct {
+	GormModel
+	Email          string     `json:"email" gorm:"not null;index"`
+	Role           string     `json:"role" gorm:"not null"`
+	WorkspaceID    uint       `json:"workspaceId" gorm:"not null"`
+	Workspace      Workspace  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	GroupID        *uint      `json:"groupId"`
+	Group          *Group     `json:"-" gorm:"constraint:OnDelete:SET NULL"`
+	InvitedByID    uint       `json:"invitedById" gorm:"not null"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	AcceptedAt     *time.Time `json:"acceptedAt"`
+	AcceptedUserID *uint      `json:"acceptedUserId"`
+	RevokedAt      *time.Time `json:"revokedAt"`
+}
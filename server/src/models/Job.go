@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job states
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a heavy operation, like merging many files, queued by a request and
+// run in the background by the server's main process
+type Job struct {
+	GormModel
+	// Kind names the operation, like "merge"
+	Kind        string `json:"kind" gorm:"not null;index"`
+	OwnerID     uint   `json:"ownerId" gorm:"not null;index"`
+	WorkspaceID uint   `json:"workspaceId" gorm:"not null"`
+	Status      string `json:"status" gorm:"not null;index"`
+	// Progress is the part of the work done, from 0 to 100
+	Progress int `json:"progress"`
+	// Params are the operation's settings as the request gave them
+	Params json.RawMessage `json:"params" gorm:"type:jsonb;serializer:json"`
+	// Result describes the outcome; a file the job created is also FileID
+	Result interface{} `json:"result" gorm:"type:jsonb;serializer:json"`
+	Error  string      `json:"error,omitempty"`
+	FileID *uint       `json:"fileId"`
+	// ResultKey is the storage key of output to download rather than keep as a file
+	ResultKey      string     `json:"-"`
+	ResultFilename string     `json:"resultFilename,omitempty"`
+	StartedAt      *time.Time `json:"startedAt"`
+	FinishedAt     *time.Time `json:"finishedAt"`
+}
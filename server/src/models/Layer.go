@@ -0,0 +1,19 @@
+package models
+
+// Layer groups the drawings of a file, such as the markups of one trade, so
+// they can be shown and hidden together. Drawings name theirs in LayerID;
+// those without one are on no layer.
+type Layer struct {
+	GormModel
+	FileID uint   `json:"fileId" gorm:"not null;uniqueIndex:idx_layer_file_name"`
+	File   File   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name   string `json:"name" gorm:"not null;uniqueIndex:idx_layer_file_name"`
+	// Color is the CSS color the layer's drawings are shown in, empty to keep
+	// their own
+	Color string `json:"color"`
+	// Visible is whether clients show the layer when a file is opened;
+	// viewers may still toggle it
+	Visible bool `json:"visible" gorm:"not null"`
+	// Order stacks the layers of a file, lowest at the bottom
+	Order int `json:"order" gorm:"column:sort_order;not null"`
+}
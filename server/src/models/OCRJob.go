@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// OCR job states
+const (
+	OCRPending = "pending"
+	OCRRunning = "running"
+	OCRDone    = "done"
+	OCRFailed  = "failed"
+)
+
+// OCRJob recognizes the text of a file's scanned pages in the background
+type OCRJob struct {
+	GormModel
+	FileID uint `json:"fileId" gorm:"not null;index"`
+	File   File `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// Hash is the content the job reads; the file may be revised meanwhile
+	Hash     string `json:"hash" gorm:"not null"`
+	Language string `json:"language" gorm:"not null"`
+	// Searchable also keeps a copy of the PDF with an invisible text layer
+	Searchable    bool   `json:"searchable"`
+	Status        string `json:"status" gorm:"not null;index"`
+	Error         string `json:"error,omitempty"`
+	RequestedByID *uint  `json:"requestedById"`
+	// JobID is the queued job that runs it
+	JobID      *uint      `json:"jobId"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
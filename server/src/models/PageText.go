@@ -0,0 +1,11 @@
+package models
+
+// PageText is the text of one page of stored content, indexed for full-text
+// search. Files with the same hash share it.
+type PageText struct {
+	Hash string `json:"hash" gorm:"primaryKey"`
+	Page int    `json:"page" gorm:"primaryKey;autoIncrement:false"`
+	Text string `json:"text" gorm:"type:text;not null"`
+	// Search is maintained by the database from Text
+	Search string `json:"-" gorm:"->;type:tsvector GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED;index:idx_page_text_search,type:gin"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RateLimitCounter is the state of a rate limit for one caller, kept in the
+// database so the server's prefork workers count together
+type RateLimitCounter struct {
+	Key       string    `gorm:"primaryKey"`
+	Value     []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// RefreshToken is a single-use token that rotates on every refresh. All refresh
+// tokens of a session form one family; presenting a used token revokes the session.
+type RefreshToken struct {
+	GormModel
+	SessionID uint       `json:"sessionId" gorm:"not null;index"`
+	Session   Session    `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
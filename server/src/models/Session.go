@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is a server-side login that issued tokens belong to; revoking it
+// invalidates those tokens before they expire
+type Session struct {
+	GormModel
+	UserID     uint       `json:"userId" gorm:"not null;index"`
+	User       User       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserAgent  string     `json:"userAgent"`
+	IP         string     `json:"ip"`
+	LastSeenAt time.Time  `json:"lastSeenAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	// WorkspaceID is the workspace the session's tokens are issued for, 0 until one is chosen
+	WorkspaceID uint `json:"workspaceId"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ShareLink gives anyone holding its token download access to a file
+type ShareLink struct {
+	GormModel
+	FileID        uint       `json:"fileId" gorm:"not null;index"`
+	File          File       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedByID   uint       `json:"createdById" gorm:"not null"`
+	TokenHash     string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt     *time.Time `json:"expiresAt"`
+	MaxDownloads  *int       `json:"maxDownloads"`
+	DownloadCount int        `json:"downloadCount" gorm:"not null;default:0"`
+	RevokedAt     *time.Time `json:"revokedAt"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// Template is an HTML page of a workspace filled in with JSON data to
+// generate PDFs, like a transmittal sheet or an inspection report. HTML is a
+// Go html/template.
+type Template struct {
+	GormModel
+	WorkspaceID uint      `json:"workspaceId" gorm:"not null;uniqueIndex:idx_template_workspace_name"`
+	Workspace   Workspace `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex:idx_template_workspace_name"`
+	HTML        string    `json:"html" gorm:"type:text;not null"`
+	// CreatedByID may change the template alongside admins
+	CreatedByID uint `json:"createdById" gorm:"not null"`
+}
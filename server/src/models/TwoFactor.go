@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// user has lost their authenticator
+type RecoveryCode struct {
+	GormModel
+	UserID   uint       `json:"userId" gorm:"not null;index"`
+	User     User       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CodeHash string     `json:"-" gorm:"not null"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
+
+// TwoFactorChallenge is issued after a correct password for an account with
+// 2FA enabled and exchanged for tokens together with a TOTP or recovery code
+type TwoFactorChallenge struct {
+	GormModel
+	UserID    uint       `json:"userId" gorm:"not null;index"`
+	User      User       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	Attempts  int        `json:"attempts"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
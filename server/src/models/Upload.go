@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload in progress. Chunks are written to a staging file
+// until Offset reaches Length, then the content is stored as a File.
+type Upload struct {
+	GormModel
+	// Token identifies the upload in its URL
+	Token       string `json:"token" gorm:"uniqueIndex;not null"`
+	OwnerID     uint   `json:"ownerId" gorm:"not null;index"`
+	WorkspaceID uint   `json:"workspaceId" gorm:"not null"`
+	Filename    string `json:"filename"`
+	Length      int64  `json:"length"`
+	Offset      int64  `json:"offset"`
+	// Checksum is the SHA-256 the client announced for the whole file, if any
+	Checksum string `json:"checksum"`
+	// FolderID is the folder the file is placed in once the upload completes
+	FolderID  *uint     `json:"folderId"`
+	FileID    *uint     `json:"fileId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
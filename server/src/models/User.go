@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// User roles, from least to most privileged
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of required
+func RoleAtLeast(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required] && roleRanks[role] > 0
+}
+
+type User struct {
+	GormModel
+	Username     string `json:"username" gorm:"uniqueIndex;not null"`
+	PasswordHash string `json:"-" gorm:"not null"`
+	Role         string `json:"role" gorm:"not null;default:viewer"`
+	Email        string `json:"email"`
+	// AuthSource is the backend that owns the account's credentials: local, ldap or oidc
+	AuthSource string `json:"authSource" gorm:"not null;default:local"`
+	// ExternalName is the name of a directory account in its directory, which
+	// Username differs from when another account had the name
+	ExternalName string `json:"-" gorm:"index;not null;default:''"`
+	// OIDCSubject links accounts provisioned through single sign-on to the IdP identity
+	OIDCSubject *string `json:"-" gorm:"uniqueIndex"`
+	// DisabledAt is set while an admin has locked the account
+	DisabledAt *time.Time `json:"disabledAt"`
+	// TOTPSecret is set during 2FA enrollment and only enforced once TOTPEnabled is set
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totpEnabled" gorm:"not null;default:false"`
+	// TOTPLastStep is the last accepted time step, so a code can't be used twice
+	TOTPLastStep int64 `json:"-"`
+	// QuotaBytes overrides USER_QUOTA for the user; 0 means no limit
+	QuotaBytes *int64 `json:"quotaBytes"`
+}
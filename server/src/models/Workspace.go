@@ -0,0 +1,21 @@
+package models
+
+// DefaultWorkspaceSlug is the workspace existing data and new users are placed in
+const DefaultWorkspaceSlug = "default"
+
+// Workspace is a tenant; files and their drawings are only visible inside the
+// workspace they were created in
+type Workspace struct {
+	GormModel
+	Name string `json:"name" gorm:"not null"`
+	Slug string `json:"slug" gorm:"not null;uniqueIndex"`
+}
+
+// WorkspaceMember gives a user access to a workspace
+type WorkspaceMember struct {
+	GormModel
+	WorkspaceID uint      `json:"workspaceId" gorm:"not null;uniqueIndex:idx_workspace_member_user"`
+	Workspace   Workspace `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	UserID      uint      `json:"userId" gorm:"not null;uniqueIndex:idx_workspace_member_user"`
+	User        User      `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
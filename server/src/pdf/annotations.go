@@ -0,0 +1,427 @@
+package pdf
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ImportedDrawing is an annotation converted to a drawing of the viewer
+type ImportedDrawing struct {
+	// Name of the annotation, which for exported drawings starts with
+	// AnnotationPrefix
+	Name string
+	Page int
+	Type string
+	// Data holds the shapes of the drawing as JSON
+	Data string
+	// Bounds of the drawing in the viewer's space
+	Left, Top, Right, Bottom float64
+}
+
+// DrawingID returns the ID of the drawing an annotation was exported from
+func (d ImportedDrawing) DrawingID() (uint, bool) {
+	rest, found := strings.CutPrefix(d.Name, AnnotationPrefix)
+	if !found {
+		return 0, false
+	}
+	// Parts of a drawing carry a number after its ID
+	rest, _, _ = strings.Cut(rest, "-")
+	id, err := strconv.ParseUint(rest, 10, 32)
+	return uint(id), err == nil
+}
+
+// parseNumbers reads the numbers of attributes like "1,2;3,4" or "1 2 3 4"
+func parseNumbers(value string) []float64 {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+	numbers := make([]float64, 0, len(fields))
+	for _, field := range fields {
+		if number, err := strconv.ParseFloat(field, 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+// annotationStyle is the style of a drawing, leaving out full opacity
+type annotationStyle struct {
+	StrokeColor string   `json:"strokeColor"`
+	StrokeWidth float64  `json:"strokeWidth"`
+	Opacity     *float64 `json:"opacity,omitempty"`
+}
+
+// annotationReader converts annotations of a page to drawings
+type annotationReader struct {
+	height float64
+}
+
+// point converts a point from PDF space to the viewer's space
+func (r annotationReader) point(x, y float64) point {
+	return point{x, r.height - y}
+}
+
+// points converts pairs of numbers to points
+func (r annotationReader) points(numbers []float64) []point {
+	points := make([]point, 0, len(numbers)/2)
+	for i := 0; i+1 < len(numbers); i += 2 {
+		points = append(points, r.point(numbers[i], numbers[i+1]))
+	}
+	return points
+}
+
+func (a xfdfAnnot) style(fallbackColor string) annotationStyle {
+	style := annotationStyle{StrokeColor: a.Color, StrokeWidth: 1}
+	if style.StrokeColor == "" {
+		style.StrokeColor = fallbackColor
+	}
+	if width, err := strconv.ParseFloat(a.Width, 64); err == nil && width > 0 {
+		style.StrokeWidth = width
+	}
+	if opacity, err := strconv.ParseFloat(a.Opacity, 64); err == nil && opacity < 1 {
+		style.Opacity = &opacity
+	}
+	return style
+}
+
+// appearance reads the font size and text color of a default appearance like
+// "/Helv 12 Tf 1 0 0 rg"
+func appearance(da string) (float64, string) {
+	var fontSize float64
+	color := ""
+	fields := strings.Fields(da)
+	for i, field := range fields {
+		operands := func(n int) []float64 {
+			if i < n {
+				return nil
+			}
+			return parseNumbers(strings.Join(fields[i-n:i], " "))
+		}
+		switch field {
+		case "Tf":
+			if size := operands(1); len(size) == 1 {
+				fontSize = size[0]
+			}
+		case "rg":
+			if rgb := operands(3); len(rgb) == 3 {
+				color = colorFromComponents(rgb)
+			}
+		case "g":
+			if gray := operands(1); len(gray) == 1 {
+				color = colorFromComponents(gray)
+			}
+		}
+	}
+	return fontSize, color
+}
+
+// colorFromComponents writes a gray, RGB or CMYK color as #RRGGBB
+func colorFromComponents(components []float64) string {
+	var rgb [3]float64
+	switch len(components) {
+	case 1:
+		rgb = [3]float64{components[0], components[0], components[0]}
+	case 3:
+		copy(rgb[:], components)
+	case 4:
+		for i := range rgb {
+			rgb[i] = (1 - components[i]) * (1 - components[3])
+		}
+	default:
+		return ""
+	}
+	return fmt.Sprintf("#%02X%02X%02X", int(math.Round(math.Max(0, math.Min(rgb[0], 1))*255)),
+		int(math.Round(math.Max(0, math.Min(rgb[1], 1))*255)), int(math.Round(math.Max(0, math.Min(rgb[2], 1))*255)))
+}
+
+// read converts an annotation to the type and shapes of a drawing; ok is
+// false for annotations the viewer has no drawing for
+func (r annotationReader) read(a xfdfAnnot) (string, map[string]interface{}, bool) {
+	const defaultColor = "#FF0000"
+	rect := parseNumbers(a.Rect)
+	if len(rect) != 4 {
+		return "", nil, false
+	}
+	topLeft := r.point(math.Min(rect[0], rect[2]), math.Max(rect[1], rect[3]))
+	bottomRight := r.point(math.Max(rect[0], rect[2]), math.Min(rect[1], rect[3]))
+
+	switch a.XMLName.Local {
+	case "ink", "polyline", "polygon":
+		var strokes [][]point
+		if a.InkList != nil {
+			for _, gesture := range a.InkList.Gestures {
+				strokes = append(strokes, r.points(parseNumbers(gesture)))
+			}
+		} else {
+			stroke := r.points(parseNumbers(a.Vertices))
+			if a.XMLName.Local == "polygon" && len(stroke) > 0 {
+				stroke = append(stroke, stroke[0])
+			}
+			strokes = append(strokes, stroke)
+		}
+		paths := make([][]point, 0, len(strokes))
+		for _, stroke := range strokes {
+			if len(stroke) >= 2 {
+				paths = append(paths, stroke)
+			}
+		}
+		if len(paths) == 0 {
+			return "", nil, false
+		}
+		return "freehand", map[string]interface{}{"paths": paths, "style": a.style(defaultColor)}, true
+	case "line":
+		ends := append(parseNumbers(a.Start), parseNumbers(a.End)...)
+		if len(ends) != 4 {
+			return "", nil, false
+		}
+		start, end := r.point(ends[0], ends[1]), r.point(ends[2], ends[3])
+		if a.Intent == "LineDimension" {
+			angle := math.Atan2(end.Y-start.Y, end.X-start.X) * 180 / math.Pi
+			if angle < 0 {
+				angle += 360
+			}
+			color := a.Color
+			if color == "" {
+				color = defaultColor
+			}
+			return "rulers", map[string]interface{}{
+				"rulers": []map[string]interface{}{{
+					"startPoint": start,
+					"endPoint":   end,
+					"distance":   math.Hypot(end.X-start.X, end.Y-start.Y),
+					"angle":      angle,
+					"color":      color,
+				}},
+				"pixelsPerUnit": 1,
+				"units":         "px",
+			}, true
+		}
+		return "line", map[string]interface{}{
+			"lines": []map[string]point{{"startPoint": start, "endPoint": end}},
+			"style": a.style(defaultColor),
+		}, true
+	case "square":
+		return "rectangle", map[string]interface{}{
+			"startPoint": topLeft,
+			"endPoint":   bottomRight,
+			"style":      a.style(defaultColor),
+		}, true
+	case "highlight", "underline", "squiggly", "strikeout":
+		coords := parseNumbers(a.Coords)
+		if len(coords) < 8 {
+			return "", nil, false
+		}
+		var rects []map[string]float64
+		var lines []map[string]point
+		for i := 0; i+7 < len(coords); i += 8 {
+			quad := r.points(coords[i : i+8])
+			left, top := math.Inf(1), math.Inf(1)
+			right, bottom := math.Inf(-1), math.Inf(-1)
+			for _, p := range quad {
+				left, right = math.Min(left, p.X), math.Max(right, p.X)
+				top, bottom = math.Min(top, p.Y), math.Max(bottom, p.Y)
+			}
+			rects = append(rects, map[string]float64{"x": left, "y": top, "width": right - left, "height": bottom - top})
+			// Underlines run along the bottom of the text, cross-outs through
+			// its middle
+			y := bottom
+			if a.XMLName.Local == "strikeout" {
+				y = (top + bottom) / 2
+			}
+			lines = append(lines, map[string]point{"start": {left, y}, "end": {right, y}})
+		}
+		style := a.style("#FFFF00")
+		shapes := map[string]interface{}{"style": style}
+		if a.Contents != "" {
+			shapes["text"] = a.Contents
+		}
+		switch a.XMLName.Local {
+		case "highlight":
+			shapes["rects"] = rects
+			opacity := 0.5
+			if style.Opacity != nil {
+				opacity = *style.Opacity
+			}
+			shapes["opacity"] = opacity
+			return "textHighlight", shapes, true
+		case "strikeout":
+			shapes["lines"] = lines
+			return "textCrossedOut", shapes, true
+		default:
+			shapes["lines"] = lines
+			return "textUnderline", shapes, true
+		}
+	case "freetext":
+		fontSize, textColor := appearance(a.DefaultAppearance)
+		if callout := r.points(parseNumbers(a.Callout)); a.Intent == "FreeTextCallout" && len(callout) >= 2 {
+			color := a.Color
+			if color == "" {
+				color = textColor
+			}
+			if color == "" {
+				color = defaultColor
+			}
+			shapes := map[string]interface{}{"position": callout[0], "text": a.Contents, "color": color}
+			if len(callout) == 3 {
+				shapes["bendPoint"] = callout[1]
+			}
+			return "extensionLine", shapes, true
+		}
+		// The fringe insets the text box from the rect: left, bottom, right, top
+		if fringe := parseNumbers(a.Fringe); len(fringe) == 4 {
+			topLeft = point{topLeft.X + fringe[0], topLeft.Y + fringe[3]}
+			bottomRight = point{bottomRight.X - fringe[2], bottomRight.Y - fringe[1]}
+		}
+		if textColor == "" {
+			textColor = "#000000"
+		}
+		style := a.style(textColor)
+		style.StrokeColor = textColor
+		if a.Width == "" {
+			style.StrokeWidth = 1
+		}
+		shapes := map[string]interface{}{
+			"startPoint": topLeft,
+			"endPoint":   bottomRight,
+			"text":       a.Contents,
+			"style":      style,
+		}
+		if fontSize > 0 {
+			shapes["fontSize"] = fontSize
+		}
+		return "textArea", shapes, true
+	case "text":
+		// Pins stand on the bottom left corner of the note icon
+		color := a.Color
+		if color == "" {
+			color = defaultColor
+		}
+		return "pinSelection", map[string]interface{}{
+			"position": point{topLeft.X, bottomRight.Y},
+			"color":    color,
+		}, true
+	}
+	return "", nil, false
+}
+
+// convertAnnotations converts annotations to drawings, skipping and counting
+// those the viewer has no drawing for
+func convertAnnotations(annots []xfdfAnnot, sizes []PageSize) ([]ImportedDrawing, int) {
+	var drawings []ImportedDrawing
+	skipped := 0
+	for _, annot := range annots {
+		if annot.Page < 0 || annot.Page >= len(sizes) {
+			skipped++
+			continue
+		}
+		_, height := sizes[annot.Page].Unrotated()
+		reader := annotationReader{height: height}
+		drawingType, shapes, ok := reader.read(annot)
+		if !ok {
+			skipped++
+			continue
+		}
+		data, err := json.Marshal(shapes)
+		if err != nil {
+			skipped++
+			continue
+		}
+		rect := parseNumbers(annot.Rect)
+		drawings = append(drawings, ImportedDrawing{
+			Name:   annot.Name,
+			Page:   annot.Page + 1,
+			Type:   drawingType,
+			Data:   string(data),
+			Left:   math.Min(rect[0], rect[2]),
+			Right:  math.Max(rect[0], rect[2]),
+			Top:    height - math.Max(rect[1], rect[3]),
+			Bottom: height - math.Min(rect[1], rect[3]),
+		})
+	}
+	return drawings, skipped
+}
+
+// ReadXFDF converts the annotations of an XFDF document to drawings on pages
+// of the given sizes. Annotations the viewer has no drawing for, like
+// stamps and file attachments, are skipped and counted.
+func ReadXFDF(data []byte, sizes []PageSize) ([]ImportedDrawing, int, error) {
+	var doc xfdfDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("invalid XFDF: %v", err)
+	}
+	drawings, skipped := convertAnnotations(doc.Annots.Items, sizes)
+	return drawings, skipped, nil
+}
+
+func joinNumbers(numbers []float64, separator string) string {
+	parts := make([]string, len(numbers))
+	for i, number := range numbers {
+		parts[i] = formatNumber(number)
+	}
+	return strings.Join(parts, separator)
+}
+
+// annotation reads an annotation dictionary into the form of its XFDF element
+func (o qpdfObjects) annotation(page int, dict map[string]interface{}) xfdfAnnot {
+	annot := xfdfAnnot{
+		XMLName:  xml.Name{Local: strings.ToLower(o.name(dict["/Subtype"]))},
+		Page:     page,
+		Rect:     joinNumbers(o.numbers(dict["/Rect"]), ","),
+		Name:     o.text(dict["/NM"]),
+		Color:    colorFromComponents(o.numbers(dict["/C"])),
+		Intent:   o.name(dict["/IT"]),
+		Coords:   joinNumbers(o.numbers(dict["/QuadPoints"]), ","),
+		Callout:  joinNumbers(o.numbers(dict["/CL"]), ","),
+		Fringe:   joinNumbers(o.numbers(dict["/RD"]), ","),
+		Vertices: joinNumbers(o.numbers(dict["/Vertices"]), ","),
+		Contents: o.text(dict["/Contents"]),
+	}
+	annot.DefaultAppearance = o.text(dict["/DA"])
+	if opacity, ok := o.number(dict["/CA"]); ok {
+		annot.Opacity = formatNumber(opacity)
+	}
+	if width, ok := o.number(o.dict(dict["/BS"])["/W"]); ok {
+		annot.Width = formatNumber(width)
+	} else if border := o.numbers(dict["/Border"]); len(border) >= 3 {
+		annot.Width = formatNumber(border[2])
+	}
+	if line := o.numbers(dict["/L"]); len(line) == 4 {
+		annot.Start, annot.End = joinNumbers(line[:2], ","), joinNumbers(line[2:], ",")
+	}
+	if inkList := o.array(dict["/InkList"]); len(inkList) > 0 {
+		annot.InkList = &xfdfInkList{}
+		for _, stroke := range inkList {
+			annot.InkList.Gestures = append(annot.InkList.Gestures, joinNumbers(o.numbers(stroke), ","))
+		}
+	}
+	return annot
+}
+
+// ReadAnnotations converts the annotations of the PDF at path to drawings.
+// Links, form fields and popups are left alone; other annotations the viewer
+// has no drawing for are skipped and counted.
+func ReadAnnotations(path string, sizes []PageSize) ([]ImportedDrawing, int, error) {
+	doc, objects, err := loadObjects(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var annots []xfdfAnnot
+	for i, page := range doc.Pages {
+		for _, item := range objects.array(objects.dict(page.Object)["/Annots"]) {
+			dict := objects.dict(item)
+			switch objects.name(dict["/Subtype"]) {
+			case "Link", "Widget", "Popup", "":
+				continue
+			}
+			annots = append(annots, objects.annotation(i, dict))
+		}
+	}
+	drawings, skipped := convertAnnotations(annots, sizes)
+	return drawings, skipped, nil
+}
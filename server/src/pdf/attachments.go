@@ -0,0 +1,50 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// attachmentPattern matches a line of pdfdetach's list, like "1: drawing.dwg"
+var attachmentPattern = regexp.MustCompile(`^(\d+): (.*)$`)
+
+// Attachment is a file embedded in a PDF, in the document or in a file
+// attachment annotation
+type Attachment struct {
+	// Index counts the attachments from 1, as they are listed
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+}
+
+// Attachments lists the files embedded in a PDF
+func Attachments(path string) ([]Attachment, error) {
+	out, err := run("pdfdetach", "-enc", "UTF-8", "-list", path)
+	if err != nil {
+		return nil, err
+	}
+	attachments := []Attachment{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := attachmentPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		attachments = append(attachments, Attachment{Index: index, Name: m[2]})
+	}
+	return attachments, scanner.Err()
+}
+
+// SaveAttachment writes the embedded file with the index, counted from 1 as
+// Attachments lists them, to dst
+func SaveAttachment(path string, index int, dst string) error {
+	if index < 1 {
+		return fmt.Errorf("invalid attachment %d", index)
+	}
+	_, err := run("pdfdetach", "-save", strconv.Itoa(index), "-o", dst, path)
+	return err
+}
@@ -0,0 +1,139 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"sort"
+)
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA256WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// DER tags used to assemble the signature
+const (
+	tagSequence    = 0x30
+	tagSet         = 0x31
+	tagOctetString = 0x04
+	tagContext0    = 0xa0
+)
+
+// der encodes an element from its tag and the encoded elements it contains
+func der(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	n := len(content)
+	encoded := []byte{tag}
+	switch {
+	case n < 0x80:
+		encoded = append(encoded, byte(n))
+	case n < 0x100:
+		encoded = append(encoded, 0x81, byte(n))
+	case n < 0x10000:
+		encoded = append(encoded, 0x82, byte(n>>8), byte(n))
+	default:
+		encoded = append(encoded, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(encoded, content...)
+}
+
+// derSet encodes a SET OF, whose elements DER orders by their encoding
+func derSet(elements ...[]byte) []byte {
+	sorted := append([][]byte(nil), elements...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return der(tagSet, sorted...)
+}
+
+func mustMarshal(value interface{}) []byte {
+	encoded, err := asn1.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+// algorithm encodes an AlgorithmIdentifier without parameters
+func algorithm(oid asn1.ObjectIdentifier) []byte {
+	return der(tagSequence, mustMarshal(oid))
+}
+
+// signatureAlgorithm names how a key signs SHA-256 digests
+func signatureAlgorithm(key crypto.PublicKey) ([]byte, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		// RSA identifiers carry explicit NULL parameters
+		return der(tagSequence, mustMarshal(oidSHA256WithRSA), []byte{0x05, 0x00}), nil
+	case *ecdsa.PublicKey:
+		return algorithm(oidECDSAWithSHA256), nil
+	}
+	return nil, errors.New("only RSA and ECDSA keys can sign")
+}
+
+// signCMS makes a detached CAdES signature of a digest, the SignedData that
+// PAdES puts in a signature dictionary. chain starts with the signer's
+// certificate.
+func signCMS(digest []byte, signer crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("a signing certificate is required")
+	}
+	cert := chain[0]
+	sigAlgorithm, err := signatureAlgorithm(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signing certificate attribute binds the certificate to the
+	// signature, as PAdES requires
+	certHash := sha256.Sum256(cert.Raw)
+	signingCertificate := der(tagSequence, der(tagSequence, der(tagSequence, mustMarshal(certHash[:]))))
+	attributes := [][]byte{
+		der(tagSequence, mustMarshal(oidContentType), derSet(mustMarshal(oidData))),
+		der(tagSequence, mustMarshal(oidMessageDigest), derSet(der(tagOctetString, digest))),
+		der(tagSequence, mustMarshal(oidSigningCertificateV2), derSet(signingCertificate)),
+	}
+	// The signature covers the attributes encoded as a SET; the SignerInfo
+	// holds the same content tagged [0]
+	signedAttributes := derSet(attributes...)
+	attributesDigest := sha256.Sum256(signedAttributes)
+	signature, err := signer.Sign(rand.Reader, attributesDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfo := der(tagSequence,
+		mustMarshal(1),
+		der(tagSequence, cert.RawIssuer, mustMarshal(cert.SerialNumber)),
+		algorithm(oidSHA256),
+		append([]byte{tagContext0}, signedAttributes[1:]...),
+		sigAlgorithm,
+		der(tagOctetString, signature),
+	)
+	certificates := make([][]byte, len(chain))
+	for i, c := range chain {
+		certificates[i] = c.Raw
+	}
+	signedData := der(tagSequence,
+		mustMarshal(1),
+		derSet(algorithm(oidSHA256)),
+		der(tagSequence, mustMarshal(oidData)),
+		der(tagContext0, certificates...),
+		derSet(signerInfo),
+	)
+	return der(tagSequence, mustMarshal(oidSignedData), der(tagContext0, signedData)), nil
+}
@@ -0,0 +1,82 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContactSheet lays many pages out small on each sheet to review a set of
+// drawings at a glance
+type ContactSheet struct {
+	// Width and Height of the sheets, in points
+	Width, Height float64
+	Columns, Rows int
+	// Margin is the space around and between the pages, in points
+	Margin float64
+	// Labels prints the page number under each page
+	Labels bool
+	// Pages are the pages to show, counted from 1; none shows every page
+	Pages map[int]bool
+}
+
+// WriteContactSheet writes the pages of src laid out as a contact sheet to
+// dst, each page framed, and returns how many sheets it holds
+func WriteContactSheet(src, dst string, contact ContactSheet) (int, error) {
+	if contact.Columns < 1 || contact.Rows < 1 {
+		return 0, errors.New("a contact sheet needs at least one column and row")
+	}
+	cells := gridCells(contact.Width, contact.Height, contact.Columns, contact.Rows, contact.Margin)
+	if cells[0].Width < 1 || cells[0].Height < 1 {
+		return 0, errors.New("the margin leaves no room for the pages")
+	}
+	// Labels take a line under each page, sized to the cell
+	var fontSize float64
+	if contact.Labels {
+		fontSize = math.Max(6, math.Min(12, cells[0].Height*0.06))
+	}
+
+	count := 0
+	err := writeSheets(src, dst, func(pages []PageGeometry) ([]sheet, error) {
+		var selected []int
+		for page := 1; page <= len(pages); page++ {
+			if len(contact.Pages) == 0 || contact.Pages[page] {
+				selected = append(selected, page)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, errors.New("no pages to show")
+		}
+
+		var sheets []sheet
+		for first := 0; first < len(selected); first += len(cells) {
+			s := sheet{Width: contact.Width, Height: contact.Height}
+			var content strings.Builder
+			content.WriteString("q 0.7 G 0.5 w\n")
+			for i := first; i < first+len(cells) && i < len(selected); i++ {
+				cell := cells[i-first]
+				fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re S\n", cell.X, cell.Y, cell.Width, cell.Height)
+				cell.Page = selected[i]
+				if contact.Labels {
+					label, width := helvetica.encode(strconv.Itoa(cell.Page))
+					fmt.Fprintf(&content, "0 g BT /F1 %.2f Tf %.2f %.2f Td %s Tj ET 0.7 G\n",
+						fontSize, cell.X+(cell.Width-width*fontSize)/2, cell.Y+fontSize*0.5, pdfString(label))
+					cell.Y += fontSize * 1.6
+					cell.Height -= fontSize * 1.6
+				}
+				// Pages sit a little inside their frame
+				inset := math.Min(4, cell.Width/20)
+				cell.X, cell.Y, cell.Width, cell.Height = cell.X+inset, cell.Y+inset, cell.Width-2*inset, cell.Height-2*inset
+				s.Cells = append(s.Cells, cell)
+			}
+			content.WriteString("Q\n")
+			s.Content = content.String()
+			sheets = append(sheets, s)
+		}
+		count = len(sheets)
+		return sheets, nil
+	})
+	return count, err
+}
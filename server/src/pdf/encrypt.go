@@ -0,0 +1,80 @@
+package pdf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Permissions are what readers of an encrypted document may do without the
+// owner password
+type Permissions struct {
+	Print bool
+	Copy  bool
+}
+
+// ErrWrongPassword is returned when a password doesn't open a document
+var ErrWrongPassword = errors.New("wrong password")
+
+// runQPDF runs qpdf with arguments read from a file, so passwords don't show
+// up in the process list. The file is written to dir and removed afterwards.
+func runQPDF(dir string, args ...string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return errors.New("passwords must not contain line breaks")
+		}
+	}
+	argFile, err := os.CreateTemp(dir, "qpdf-args-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(argFile.Name())
+	_, err = argFile.WriteString(strings.Join(args, "\n"))
+	if closeErr := argFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = run("qpdf", "--warning-exit-0", "@"+argFile.Name())
+	return err
+}
+
+// Encrypt writes src to dst encrypted with 256-bit AES. Opening dst takes
+// the user password, which may be empty to let anyone open it; lifting the
+// restrictions of allow takes the owner password.
+func Encrypt(src, dst, userPassword, ownerPassword string, allow Permissions) error {
+	if ownerPassword == "" {
+		return errors.New("an owner password is required")
+	}
+	printing, extract := "--print=full", "--extract=y"
+	if !allow.Print {
+		printing = "--print=none"
+	}
+	if !allow.Copy {
+		extract = "--extract=n"
+	}
+	return runQPDF(filepath.Dir(dst), "--encrypt", userPassword, ownerPassword, "256", printing, extract, "--", src, dst)
+}
+
+// IsEncrypted reports whether the PDF at path is encrypted, even if only to
+// restrict what readers may do. Tools other than qpdf refuse such files.
+func IsEncrypted(path string) (bool, error) {
+	status, err := exitStatus("qpdf", "--is-encrypted", path)
+	if err != nil {
+		return false, err
+	}
+	// qpdf exits with 0 for encrypted files and 2 for others
+	return status == 0, nil
+}
+
+// Decrypt writes src to dst without its encryption. password is the user or
+// owner password, and may be empty for files anyone can open.
+func Decrypt(src, dst, password string) error {
+	err := runQPDF(filepath.Dir(dst), "--password="+password, "--decrypt", "--", src, dst)
+	if err != nil && strings.Contains(err.Error(), "invalid password") {
+		return ErrWrongPassword
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Form field types as reported by pdftk
+const (
+	FieldTypeText      = "Text"
+	FieldTypeButton    = "Button"
+	FieldTypeChoice    = "Choice"
+	FieldTypeSignature = "Signature"
+)
+
+// pushButtonFlag marks a button field as a push button which holds no value
+const pushButtonFlag = 1 << 16
+
+// FormField describes a single AcroForm field
+type FormField struct {
+	Name string `json:"name"`
+	// Label is the alternate name readers show, usually the question a
+	// field answers
+	Label     string   `json:"label,omitempty"`
+	Type      string   `json:"type"`
+	Value     string   `json:"value"`
+	Options   []string `json:"options,omitempty"`
+	Flags     int      `json:"flags"`
+	ReadOnly  bool     `json:"readOnly"`
+	Required  bool     `json:"required"`
+	MaxLength int      `json:"maxLength,omitempty"`
+}
+
+// FormFields lists the AcroForm fields of a PDF
+func FormFields(path string) ([]FormField, error) {
+	output, err := run("pdftk", path, "dump_data_fields_utf8")
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []FormField
+	var current *FormField
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "---" {
+			if current != nil {
+				fields = append(fields, *current)
+			}
+			current = &FormField{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ": ")
+		if !found {
+			continue
+		}
+		switch key {
+		case "FieldName":
+			current.Name = value
+		case "FieldNameAlt":
+			current.Label = value
+		case "FieldMaxLength":
+			current.MaxLength, _ = strconv.Atoi(value)
+		case "FieldType":
+			current.Type = value
+		case "FieldValue":
+			current.Value = value
+		case "FieldStateOption":
+			current.Options = append(current.Options, value)
+		case "FieldFlags":
+			flags, _ := strconv.Atoi(value)
+			current.Flags = flags
+			current.ReadOnly = flags&1 != 0
+			current.Required = flags&2 != 0
+		}
+	}
+	if current != nil {
+		fields = append(fields, *current)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read form fields: %v", err)
+	}
+
+	return fields, nil
+}
+
+// ValidateFieldValue checks that a value is acceptable for the field
+func ValidateFieldValue(field FormField, value string) error {
+	if field.ReadOnly {
+		return fmt.Errorf("field %q is read-only", field.Name)
+	}
+
+	switch field.Type {
+	case FieldTypeText:
+		if field.MaxLength > 0 && utf8.RuneCountInString(value) > field.MaxLength {
+			return fmt.Errorf("field %q takes at most %d characters", field.Name, field.MaxLength)
+		}
+		return nil
+	case FieldTypeButton, FieldTypeChoice:
+		if field.Type == FieldTypeButton && field.Flags&pushButtonFlag != 0 {
+			return fmt.Errorf("field %q is a push button and holds no value", field.Name)
+		}
+		if len(field.Options) == 0 {
+			return nil
+		}
+		for _, option := range field.Options {
+			if option == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("value %q is not a valid option for field %q", value, field.Name)
+	case FieldTypeSignature:
+		return fmt.Errorf("signature field %q can't be filled", field.Name)
+	default:
+		return fmt.Errorf("field %q has unsupported type %q", field.Name, field.Type)
+	}
+}
+
+// xfdf is the minimal XFDF document pdftk accepts for form filling
+type xfdf struct {
+	XMLName xml.Name    `xml:"xfdf"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Fields  []xfdfField `xml:"fields>field"`
+}
+
+type xfdfField struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// FillForm writes a copy of src to dst with the given field values applied
+func FillForm(src, dst string, values map[string]string, flatten bool) error {
+	data := xfdf{XMLNS: "http://ns.adobe.com/xfdf/"}
+	for name, value := range values {
+		data.Fields = append(data.Fields, xfdfField{Name: name, Value: value})
+	}
+
+	encoded, err := xml.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode form data: %v", err)
+	}
+
+	dataPath := filepath.Join(filepath.Dir(dst), "form-data.xfdf")
+	if err := os.WriteFile(dataPath, append([]byte(xml.Header), encoded...), 0644); err != nil {
+		return fmt.Errorf("failed to write form data: %v", err)
+	}
+	defer os.Remove(dataPath)
+
+	args := []string{src, "fill_form", dataPath, "output", dst}
+	if flatten {
+		args = append(args, "flatten")
+	}
+	_, err = run("pdftk", args...)
+	return err
+}
@@ -0,0 +1,65 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// htmlTimeout bounds printing a generated document
+const htmlTimeout = 2 * time.Minute
+
+// htmlPolicy keeps the page from running scripts or loading anything but what
+// it embeds, so a template can't read files of the server or reach the
+// network. Styles, images and fonts can be inline or data URLs.
+const htmlPolicy = `<meta http-equiv="Content-Security-Policy" content="default-src 'none'; style-src 'unsafe-inline' data:; img-src data:; font-src data:; base-uri 'none'; form-action 'none'">`
+
+// doctypePattern matches a doctype opening a document, which must stay first
+var doctypePattern = regexp.MustCompile(`(?i)^\s*<!doctype[^>]*>`)
+
+// httpEquivPattern matches the attribute of meta elements acting as headers.
+// The policy doesn't cover a refresh navigating the page, to another file of
+// the server say, so every occurrence is renamed whatever element it is on.
+var httpEquivPattern = regexp.MustCompile(`(?i)http-equiv`)
+
+// RenderHTML prints an HTML document to dst as a PDF with headless Chromium.
+// Page size and margins follow the document's @page rule. Nothing outside the
+// document is loaded, and it can't run scripts or navigate away.
+func RenderHTML(document []byte, dst string) error {
+	// The page and browser profile are kept apart from everything else
+	dir, err := os.MkdirTemp("", "pdf-html-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var page bytes.Buffer
+	doctype := doctypePattern.Find(document)
+	page.Write(doctype)
+	page.WriteString(htmlPolicy)
+	page.Write(httpEquivPattern.ReplaceAll(document[len(doctype):], []byte("data-http-equiv")))
+	src := filepath.Join(dir, "document.html")
+	if err := os.WriteFile(src, page.Bytes(), 0o600); err != nil {
+		return err
+	}
+
+	args := []string{"--headless", "--disable-gpu", "--disable-dev-shm-usage",
+		"--user-data-dir=" + filepath.Join(dir, "profile"), "--host-resolver-rules=MAP * ~NOTFOUND",
+		"--no-pdf-header-footer", "--print-to-pdf=" + dst, "file://" + filepath.ToSlash(src)}
+	// Chromium won't start its sandbox as root, which the server runs as in its
+	// container; the policy above is then what confines the page
+	if os.Geteuid() == 0 {
+		args = append([]string{"--no-sandbox"}, args...)
+	}
+	if _, err := runWithTimeout(htmlTimeout, "chromium", args...); err != nil {
+		return err
+	}
+	// Chromium exits successfully even when it couldn't print
+	if ok, err := IsPDF(dst); err != nil || !ok {
+		return fmt.Errorf("chromium could not print %s", filepath.Base(dst))
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Image formats uploads are converted from
+const (
+	ImagePNG  = "png"
+	ImageJPEG = "jpeg"
+	ImageTIFF = "tiff"
+)
+
+const (
+	// defaultImageDPI sizes pages of images that don't say their resolution
+	defaultImageDPI = 72
+	// maxPageSide is the largest page side readers accept, 200 inches
+	maxPageSide = 14400
+	// imageTimeout bounds converting a TIFF, which may have many pages
+	imageTimeout = 10 * time.Minute
+)
+
+// ImageFormat sniffs the format of an image file, returning "" for anything
+// that isn't a PNG, JPEG or TIFF
+func ImageFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return ImagePNG, nil
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return ImageJPEG, nil
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return ImageTIFF, nil
+	}
+	return "", nil
+}
+
+// jpegInfo is what the header segments of a JPEG tell about its display
+type jpegInfo struct {
+	dpi float64
+	// rotation turns the image clockwise for display, from its EXIF orientation
+	rotation int
+}
+
+// readJPEGInfo reads the JFIF density and EXIF orientation of a JPEG. Mirrored
+// orientations are shown unmirrored.
+func readJPEGInfo(data []byte) jpegInfo {
+	info := jpegInfo{}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		switch {
+		case marker == 0xe0 && len(segment) >= 12 && bytes.HasPrefix(segment, []byte("JFIF\x00")):
+			// Units are 1 for dots per inch and 2 for dots per centimeter
+			density := float64(binary.BigEndian.Uint16(segment[8:]))
+			switch segment[7] {
+			case 1:
+				info.dpi = density
+			case 2:
+				info.dpi = density * 2.54
+			}
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			info.rotation = exifRotation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return info
+}
+
+// exifRotation reads the orientation tag of the first IFD of EXIF data
+func exifRotation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if tiff[0] == 'I' {
+		order = binary.LittleEndian
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + 12*e
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) != 0x0112 {
+			continue
+		}
+		switch order.Uint16(tiff[entry+8:]) {
+		case 3, 4:
+			return 180
+		case 5, 6:
+			return 90
+		case 7, 8:
+			return 270
+		}
+		return 0
+	}
+	return 0
+}
+
+// pngDPI reads the resolution of a PNG from its pHYs chunk
+func pngDPI(data []byte) float64 {
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		if kind == "IDAT" || i+12+length > len(data) {
+			break
+		}
+		// The unit 1 is the meter
+		if kind == "pHYs" && length == 9 && data[i+16] == 1 {
+			return float64(binary.BigEndian.Uint32(data[i+8:])) * 0.0254
+		}
+		i += 12 + length
+	}
+	return 0
+}
+
+// jpegStream keeps the compressed data of JPEGs in gray or RGB, which PDF
+// reads as they are; others are decoded like PNGs
+func jpegStream(data []byte, config image.Config) (stampImage, bool) {
+	var colorSpace string
+	switch config.ColorModel {
+	case color.GrayModel:
+		colorSpace = "/DeviceGray"
+	case color.YCbCrModel:
+		colorSpace = "/DeviceRGB"
+	default:
+		return stampImage{}, false
+	}
+	return stampImage{width: config.Width, height: config.Height, rgb: data, dct: colorSpace}, true
+}
+
+// imagePage writes a PNG or JPEG to dst as a page of its size at its resolution
+func imagePage(path, dst, format string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unsupported image: %v", err)
+	}
+
+	dpi, rotation := pngDPI(data), 0
+	var img stampImage
+	kept := false
+	if format == ImageJPEG {
+		info := readJPEGInfo(data)
+		dpi, rotation = info.dpi, info.rotation
+		img, kept = jpegStream(data, config)
+	}
+	if !kept {
+		if img, err = decodeStampImage(data); err != nil {
+			return err
+		}
+	}
+	if dpi < 10 {
+		dpi = defaultImageDPI
+	}
+	scale := 72 / dpi
+	if longest := float64(max(img.width, img.height)) * scale; longest > maxPageSide {
+		scale *= maxPageSide / longest
+	}
+	width := math.Round(float64(img.width)*scale*100) / 100
+	height := math.Round(float64(img.height)*scale*100) / 100
+
+	d := newStampDocument()
+	name := d.image(img)
+	size := PageSize{Width: width, Height: height, Rotation: rotation}
+	if rotation%180 != 0 {
+		size.Width, size.Height = height, width
+	}
+	d.addPage(size, fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm %s Do Q", width, height, name))
+	return d.write(dst)
+}
+
+// ImagesToPDF writes the images at paths, in order, to dst with a page for
+// each, sized to the image at its resolution. JPEGs are kept as they are;
+// multi-page TIFFs add a page for each of theirs, converted by ocrmypdf.
+func ImagesToPDF(paths []string, dst string) error {
+	dir := filepath.Dir(dst)
+	inputs := make([]MergeInput, 0, len(paths))
+	for i, path := range paths {
+		format, err := ImageFormat(path)
+		if err != nil {
+			return err
+		}
+		page := filepath.Join(dir, "image-"+strconv.Itoa(i)+".pdf")
+		switch format {
+		case ImagePNG, ImageJPEG:
+			err = imagePage(path, page, format)
+		case ImageTIFF:
+			// Without OCR ocrmypdf only wraps the image, keeping its compression
+			_, err = runWithTimeout(imageTimeout, "ocrmypdf", "--tesseract-timeout", "0", "--optimize", "0",
+				"--output-type", "pdf", "--image-dpi", strconv.Itoa(defaultImageDPI), "--quiet", path, page)
+		default:
+			err = fmt.Errorf("%s is not a PNG, JPEG or TIFF image", filepath.Base(path))
+		}
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, MergeInput{Path: page})
+	}
+	if len(inputs) == 1 {
+		return os.Rename(inputs[0].Path, dst)
+	}
+	return Merge(inputs, dst)
+}
@@ -0,0 +1,125 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PaperSizes are the paper sizes documents are imposed on, portrait, in points
+var PaperSizes = map[string][2]float64{
+	"a0":      {2383.94, 3370.39},
+	"a1":      {1683.78, 2383.94},
+	"a2":      {1190.55, 1683.78},
+	"a3":      {841.89, 1190.55},
+	"a4":      {595.28, 841.89},
+	"a5":      {419.53, 595.28},
+	"letter":  {612, 792},
+	"legal":   {612, 1008},
+	"tabloid": {792, 1224},
+}
+
+// Imposition layouts
+const (
+	// LayoutBooklet prints two pages on each side of a sheet, ordered so the
+	// sheets folded in the middle read as a booklet
+	LayoutBooklet = "booklet"
+	LayoutTwoUp   = "2up"
+	LayoutFourUp  = "4up"
+)
+
+// Imposition lays the pages of a document out on sheets of paper to print
+type Imposition struct {
+	Layout string
+	// Width and Height of the sheets as printed, in points
+	Width, Height float64
+	// Margin is the space around and between the pages, in points
+	Margin float64
+}
+
+// gridCells divides a sheet into columns and rows of cells, left to right and
+// top to bottom, with margin around and between them
+func gridCells(width, height float64, columns, rows int, margin float64) []sheetCell {
+	cellWidth := (width - margin*float64(columns+1)) / float64(columns)
+	cellHeight := (height - margin*float64(rows+1)) / float64(rows)
+	cells := make([]sheetCell, 0, columns*rows)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			cells = append(cells, sheetCell{
+				X:      margin + float64(column)*(cellWidth+margin),
+				Y:      height - float64(row+1)*(cellHeight+margin),
+				Width:  cellWidth,
+				Height: cellHeight,
+			})
+		}
+	}
+	return cells
+}
+
+// bookletOrder lists the pages of each side of the sheets of a booklet, left
+// then right, 0 for the blank pages filling the last sheet. Sides alternate
+// front and back, to print on both sides flipping on the short edge.
+func bookletOrder(pageCount int) [][2]int {
+	padded := (pageCount + 3) / 4 * 4
+	number := func(index int) int {
+		if index < pageCount {
+			return index + 1
+		}
+		return 0
+	}
+	sides := make([][2]int, 0, padded/2)
+	for sheet := 0; sheet < padded/4; sheet++ {
+		sides = append(sides,
+			[2]int{number(padded - 1 - 2*sheet), number(2 * sheet)},
+			[2]int{number(2*sheet + 1), number(padded - 2 - 2*sheet)})
+	}
+	return sides
+}
+
+// Impose writes the pages of src laid out on sheets for printing to dst and
+// returns how many sheet sides it holds. Pages are scaled to fit, and turned
+// when they fit better that way.
+func Impose(src, dst string, imposition Imposition) (int, error) {
+	columns, rows := 2, 1
+	switch imposition.Layout {
+	case LayoutBooklet, LayoutTwoUp:
+	case LayoutFourUp:
+		rows = 2
+	default:
+		return 0, fmt.Errorf("unknown layout %q", imposition.Layout)
+	}
+	cells := gridCells(imposition.Width, imposition.Height, columns, rows, imposition.Margin)
+	if cells[0].Width < 1 || cells[0].Height < 1 {
+		return 0, errors.New("the margin leaves no room for the pages")
+	}
+
+	count := 0
+	err := writeSheets(src, dst, func(pages []PageGeometry) ([]sheet, error) {
+		var order [][]int
+		if imposition.Layout == LayoutBooklet {
+			for _, side := range bookletOrder(len(pages)) {
+				order = append(order, side[:])
+			}
+		} else {
+			for first := 1; first <= len(pages); first += len(cells) {
+				side := make([]int, 0, len(cells))
+				for page := first; page < first+len(cells) && page <= len(pages); page++ {
+					side = append(side, page)
+				}
+				order = append(order, side)
+			}
+		}
+
+		sheets := make([]sheet, len(order))
+		for i, side := range order {
+			sheets[i] = sheet{Width: imposition.Width, Height: imposition.Height}
+			for j, page := range side {
+				cell := cells[j]
+				cell.Page = page
+				sheets[i].Cells = append(sheets[i].Cells, cell)
+			}
+		}
+		count = len(sheets)
+		return sheets, nil
+	})
+	return count, err
+}
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageSize is the visible size of a page in PDF points, with the page
+// rotation applied
+type PageSize struct {
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// Rotation turns the page clockwise for display: 0, 90, 180 or 270
+	Rotation int `json:"rotation,omitempty"`
+}
+
+// Unrotated returns the width and height of the page before its rotation
+func (s PageSize) Unrotated() (float64, float64) {
+	if s.Rotation%180 != 0 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
+
+// DocumentInfo is the document metadata of a PDF
+type DocumentInfo struct {
+	PageCount int
+	PageSizes []PageSize
+	Title     string
+	Author    string
+	Producer  string
+}
+
+// parseNumber reads a number as pdftk prints it, which may include thousands separators
+func parseNumber(value string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+}
+
+// Info reads the page count, page sizes and document information of a PDF
+func Info(path string) (DocumentInfo, error) {
+	output, err := run("pdftk", path, "dump_data_utf8")
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+
+	var info DocumentInfo
+	var infoKey string
+	var rotation int
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ": ")
+		if !found {
+			continue
+		}
+		switch key {
+		case "InfoKey":
+			infoKey = value
+		case "InfoValue":
+			switch infoKey {
+			case "Title":
+				info.Title = value
+			case "Author":
+				info.Author = value
+			case "Producer":
+				info.Producer = value
+			}
+		case "NumberOfPages":
+			info.PageCount, _ = strconv.Atoi(value)
+		case "PageMediaRotation":
+			rotation, _ = strconv.Atoi(value)
+			rotation = (rotation%360 + 360) % 360
+		case "PageMediaRect":
+			// The rect is "llx lly urx ury"
+			corners := strings.Fields(value)
+			if len(corners) != 4 {
+				return DocumentInfo{}, fmt.Errorf("unexpected page rect %q", value)
+			}
+			var numbers [4]float64
+			for i, corner := range corners {
+				if numbers[i], err = parseNumber(corner); err != nil {
+					return DocumentInfo{}, fmt.Errorf("unexpected page rect %q", value)
+				}
+			}
+			size := PageSize{Width: numbers[2] - numbers[0], Height: numbers[3] - numbers[1], Rotation: rotation}
+			if rotation%180 != 0 {
+				size.Width, size.Height = size.Height, size.Width
+			}
+			info.PageSizes = append(info.PageSizes, size)
+			rotation = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return DocumentInfo{}, fmt.Errorf("failed to read document info: %v", err)
+	}
+
+	return info, nil
+}
+
+// PageGeometry describes the boundaries of a page. Boxes are [llx lly urx ury]
+// in points, before the rotation is applied.
+type PageGeometry struct {
+	Page int `json:"page"`
+	// Width and Height are the size of the media box with the rotation
+	// applied, like PageSize and the space drawings are placed in
+	Width    float64    `json:"width"`
+	Height   float64    `json:"height"`
+	Rotation int        `json:"rotation"`
+	MediaBox [4]float64 `json:"mediaBox"`
+	// CropBox is the part of the page viewers show and print
+	CropBox  [4]float64 `json:"cropBox"`
+	BleedBox [4]float64 `json:"bleedBox"`
+	TrimBox  [4]float64 `json:"trimBox"`
+	ArtBox   [4]float64 `json:"artBox"`
+}
+
+// PageGeometries reads the boxes and rotation of the first pageCount pages
+// of a PDF with pdfinfo, which resolves the values pages inherit
+func PageGeometries(path string, pageCount int) ([]PageGeometry, error) {
+	output, err := run("pdfinfo", "-box", "-f", "1", "-l", strconv.Itoa(pageCount), path)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]PageGeometry, 0, pageCount)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// Lines look like "Page    1 MediaBox:     0.00     0.00   595.28   841.89"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != "Page" {
+			continue
+		}
+		number, err := strconv.Atoi(fields[1])
+		if err != nil || number < 1 || number > pageCount {
+			continue
+		}
+		for len(pages) < number {
+			pages = append(pages, PageGeometry{Page: len(pages) + 1})
+		}
+		page := &pages[number-1]
+
+		var box *[4]float64
+		switch fields[2] {
+		case "rot:":
+			rotation, _ := strconv.Atoi(fields[3])
+			page.Rotation = (rotation%360 + 360) % 360
+			continue
+		case "MediaBox:":
+			box = &page.MediaBox
+		case "CropBox:":
+			box = &page.CropBox
+		case "BleedBox:":
+			box = &page.BleedBox
+		case "TrimBox:":
+			box = &page.TrimBox
+		case "ArtBox:":
+			box = &page.ArtBox
+		default:
+			continue
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("unexpected page box %q", scanner.Text())
+		}
+		for i, value := range fields[3:] {
+			if box[i], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("unexpected page box %q", scanner.Text())
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range pages {
+		page := &pages[i]
+		page.Width = page.MediaBox[2] - page.MediaBox[0]
+		page.Height = page.MediaBox[3] - page.MediaBox[1]
+		if page.Rotation%180 != 0 {
+			page.Width, page.Height = page.Height, page.Width
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,23 @@
+package pdf
+
+// MergeInput is a PDF to merge, with the pages to take from it; no ranges
+// take every page
+type MergeInput struct {
+	Path   string
+	Ranges []PageRange
+}
+
+// Merge writes the pages of the inputs, in order, to dst
+func Merge(inputs []MergeInput, dst string) error {
+	args := make([]string, 0, 2*len(inputs)+3)
+	for i, input := range inputs {
+		args = append(args, pdftkHandle(i)+"="+input.Path)
+	}
+	args = append(args, "cat")
+	for i, input := range inputs {
+		args = append(args, pdftkRanges(pdftkHandle(i), input.Ranges)...)
+	}
+	args = append(args, "output", dst)
+	_, err := run("pdftk", args...)
+	return err
+}
@@ -0,0 +1,197 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StandardInfoKeys are the entries of the document information dictionary
+// the PDF specification defines; others are custom properties
+var StandardInfoKeys = map[string]bool{
+	"Title": true, "Author": true, "Subject": true, "Keywords": true, "Creator": true,
+	"Producer": true, "CreationDate": true, "ModDate": true, "Trapped": true,
+}
+
+// customKeyPattern restricts custom property names to ones that are valid as
+// both PDF and XML names
+var customKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+var pdfDatePattern = regexp.MustCompile(`^D:(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?([Zz+-])?(\d{2})?'?(\d{2})?`)
+
+// MetadataUpdate changes the metadata of a document. Nil fields are left as
+// they are and empty ones are removed.
+type MetadataUpdate struct {
+	Title    *string
+	Author   *string
+	Subject  *string
+	Keywords *string
+	// Custom properties, which Acrobat shows as custom document properties;
+	// an empty value removes one
+	Custom map[string]string
+}
+
+// ValidCustomKey reports whether a custom property name can be written
+func ValidCustomKey(key string) bool {
+	return customKeyPattern.MatchString(key) && !StandardInfoKeys[key]
+}
+
+// xmpDate converts a PDF date to the form XMP uses, or returns "" when it
+// can't be read
+func xmpDate(value string) string {
+	m := pdfDatePattern.FindStringSubmatch(value)
+	if m == nil {
+		return ""
+	}
+	orDefault := func(part, fallback string) string {
+		if part == "" {
+			return fallback
+		}
+		return part
+	}
+	date := fmt.Sprintf("%s-%s-%sT%s:%s:%s", m[1], orDefault(m[2], "01"), orDefault(m[3], "01"),
+		orDefault(m[4], "00"), orDefault(m[5], "00"), orDefault(m[6], "00"))
+	switch m[7] {
+	case "+", "-":
+		return date + m[7] + orDefault(m[8], "00") + ":" + orDefault(m[9], "00")
+	case "Z", "z":
+		return date + "Z"
+	}
+	return date
+}
+
+func xmlText(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// xmpPacket writes the XMP metadata matching a document information
+// dictionary. PDF/A identification found in the original metadata is kept.
+func xmpPacket(info map[string]string, pdfaPart, pdfaConformance string) []byte {
+	var p strings.Builder
+	p.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	p.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	p.WriteString("<rdf:Description rdf:about=\"\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\"" +
+		" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\" xmlns:pdfx=\"http://ns.adobe.com/pdfx/1.3/\" xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">\n")
+	if title := info["Title"]; title != "" {
+		fmt.Fprintf(&p, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlText(title))
+	}
+	if author := info["Author"]; author != "" {
+		fmt.Fprintf(&p, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlText(author))
+	}
+	if subject := info["Subject"]; subject != "" {
+		fmt.Fprintf(&p, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", xmlText(subject))
+	}
+	simple := []struct{ key, property string }{
+		{"Keywords", "pdf:Keywords"},
+		{"Producer", "pdf:Producer"},
+		{"Creator", "xmp:CreatorTool"},
+	}
+	for _, entry := range simple {
+		if value := info[entry.key]; value != "" {
+			fmt.Fprintf(&p, "<%s>%s</%s>\n", entry.property, xmlText(value), entry.property)
+		}
+	}
+	if created := xmpDate(info["CreationDate"]); created != "" {
+		fmt.Fprintf(&p, "<xmp:CreateDate>%s</xmp:CreateDate>\n", created)
+	}
+	if modified := xmpDate(info["ModDate"]); modified != "" {
+		fmt.Fprintf(&p, "<xmp:ModifyDate>%s</xmp:ModifyDate>\n<xmp:MetadataDate>%s</xmp:MetadataDate>\n", modified, modified)
+	}
+	if pdfaPart != "" {
+		fmt.Fprintf(&p, "<pdfaid:part>%s</pdfaid:part>\n<pdfaid:conformance>%s</pdfaid:conformance>\n", pdfaPart, pdfaConformance)
+	}
+	custom := make([]string, 0, len(info))
+	for key := range info {
+		if !StandardInfoKeys[key] && customKeyPattern.MatchString(key) {
+			custom = append(custom, key)
+		}
+	}
+	sort.Strings(custom)
+	for _, key := range custom {
+		fmt.Fprintf(&p, "<pdfx:%s>%s</pdfx:%s>\n", key, xmlText(info[key]), key)
+	}
+	p.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	// Padding lets editors update the packet in place
+	p.WriteString(strings.Repeat(strings.Repeat(" ", 99)+"\n", 20))
+	p.WriteString("<?xpacket end=\"w\"?>")
+	return []byte(p.String())
+}
+
+// WriteMetadata writes src to dst with its document information dictionary
+// and XMP metadata updated. The changes are appended as an incremental update.
+func WriteMetadata(src, dst string, update MetadataUpdate, now time.Time) error {
+	u, _, objects, err := openUpdate(src)
+	if err != nil {
+		return err
+	}
+	data := u.buf.Bytes()
+
+	info := copyDict(objects.dict(u.trailer["/Info"]))
+	set := func(key, value string) {
+		if value == "" {
+			delete(info, "/"+key)
+		} else {
+			info["/"+key] = "u:" + value
+		}
+	}
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{"Title", update.Title}, {"Author", update.Author}, {"Subject", update.Subject}, {"Keywords", update.Keywords},
+	}
+	for _, field := range fields {
+		if field.value != nil {
+			set(field.key, *field.value)
+		}
+	}
+	for key, value := range update.Custom {
+		if !ValidCustomKey(key) {
+			return fmt.Errorf("invalid custom property name %q", key)
+		}
+		set(key, value)
+	}
+	set("ModDate", now.UTC().Format("D:20060102150405Z"))
+
+	// The XMP is built from the updated dictionary, so both agree
+	text := make(map[string]string, len(info))
+	for key, value := range info {
+		text[strings.TrimPrefix(key, "/")] = objects.text(value)
+	}
+	// Updates append metadata, so the last identification is the current one
+	var part, conformance string
+	if matches := pdfaPartPattern.FindAllSubmatch(data, -1); matches != nil {
+		part = string(matches[len(matches)-1][1])
+	}
+	if matches := pdfaConformancePattern.FindAllSubmatch(data, -1); part != "" && matches != nil {
+		conformance = strings.ToUpper(string(matches[len(matches)-1][1]))
+	}
+	packet := xmpPacket(text, part, conformance)
+
+	infoRef, isRef := u.trailer["/Info"].(string)
+	if !isRef || !referencePattern.MatchString(infoRef) {
+		infoRef = u.newRef()
+		u.trailer["/Info"] = infoRef
+	}
+	u.object(infoRef, pdfSyntax(info))
+
+	rootRef := u.trailer["/Root"].(string)
+	root := objects.dict(rootRef)
+	metadataRef, isRef := root["/Metadata"].(string)
+	if !isRef || !referencePattern.MatchString(metadataRef) {
+		metadataRef = u.newRef()
+		root = copyDict(root)
+		root["/Metadata"] = metadataRef
+		u.object(rootRef, pdfSyntax(root))
+	}
+	u.stream(metadataRef, "/Type /Metadata /Subtype /XML", packet)
+	u.finish()
+	return os.WriteFile(dst, u.buf.Bytes(), 0o644)
+}
@@ -0,0 +1,38 @@
+package pdf
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// ocrTimeout bounds an OCR run, which takes a few seconds per page
+const ocrTimeout = time.Hour
+
+// OCR recognizes the text of the pages of src that have none with ocrmypdf,
+// which uses tesseract, and writes a copy of src with an invisible text layer
+// to dst. language lists tesseract languages joined with "+". The recognized
+// text is returned per page; pages that already had text are empty strings.
+func OCR(src, dst, sidecar, language string) ([]string, error) {
+	_, err := runWithTimeout(ocrTimeout, "ocrmypdf", "--skip-text", "--quiet",
+		"--language", language, "--sidecar", sidecar, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	output, err := os.ReadFile(sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like pdftotext, the sidecar separates pages with form feeds
+	pages := strings.Split(string(output), "\f")
+	if len(pages) > 1 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	for i, text := range pages {
+		if strings.HasPrefix(strings.TrimSpace(text), "[OCR skipped on page") {
+			pages[i] = ""
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,74 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// officeTimeout bounds converting an office document, as LibreOffice lays out
+// every page of it
+const officeTimeout = 5 * time.Minute
+
+// officeSignatures are how the containers of office formats start: zip for
+// Office Open XML and OpenDocument, OLE for the older binary formats
+var officeSignatures = map[string][]byte{
+	".docx": []byte("PK\x03\x04"),
+	".xlsx": []byte("PK\x03\x04"),
+	".pptx": []byte("PK\x03\x04"),
+	".odt":  []byte("PK\x03\x04"),
+	".ods":  []byte("PK\x03\x04"),
+	".odp":  []byte("PK\x03\x04"),
+	".doc":  []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"),
+	".xls":  []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"),
+	".ppt":  []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"),
+	".rtf":  []byte("{\\rtf"),
+}
+
+// IsOfficeDocument reports whether the file at path is a document LibreOffice
+// converts, like DOCX or XLSX, going by the extension of its filename and
+// checking the content matches it
+func IsOfficeDocument(path, filename string) (bool, error) {
+	signature, ok := officeSignatures[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, len(signature))
+	if _, err := io.ReadFull(f, head); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(head, signature), nil
+}
+
+// ConvertOffice writes the office document at src to dst as a PDF with
+// LibreOffice. src must have the extension of its format. Each conversion gets
+// its own LibreOffice profile next to dst, so conversions can run at once.
+func ConvertOffice(src, dst string) error {
+	dir := filepath.Dir(dst)
+	outDir := filepath.Join(dir, "soffice-out")
+	profile := filepath.Join(dir, "soffice-profile")
+	_, err := runWithTimeout(officeTimeout, "soffice", "-env:UserInstallation=file://"+filepath.ToSlash(profile),
+		"--headless", "--norestore", "--convert-to", "pdf", "--outdir", outDir, src)
+	defer os.RemoveAll(profile)
+	if err != nil {
+		return err
+	}
+	// LibreOffice exits successfully even when it couldn't read the document
+	converted := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".pdf")
+	if _, err := os.Stat(converted); err != nil {
+		return fmt.Errorf("LibreOffice could not convert %s", filepath.Base(src))
+	}
+	return os.Rename(converted, dst)
+}
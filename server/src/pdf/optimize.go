@@ -0,0 +1,46 @@
+package pdf
+
+import (
+	"fmt"
+	"time"
+)
+
+// optimizeTimeout bounds a rewrite with ghostscript, which decodes every image
+const optimizeTimeout = 10 * time.Minute
+
+// Optimization presets, by the resolution images are downsampled to
+const (
+	// QualityLow downsamples images to 72 dpi, for the screen
+	QualityLow = "low"
+	// QualityMedium downsamples images to 150 dpi
+	QualityMedium = "medium"
+	// QualityHigh downsamples images to 300 dpi, for print
+	QualityHigh = "high"
+)
+
+// ghostscriptSettings maps presets to ghostscript's PDFSETTINGS
+var ghostscriptSettings = map[string]string{
+	QualityLow:    "/screen",
+	QualityMedium: "/ebook",
+	QualityHigh:   "/printer",
+}
+
+// ValidQuality reports whether quality names an optimization preset
+func ValidQuality(quality string) bool {
+	_, ok := ghostscriptSettings[quality]
+	return ok
+}
+
+// Optimize rewrites src to dst with ghostscript, downsampling and recompressing
+// images for the quality preset, merging duplicate images and dropping objects
+// no page uses
+func Optimize(src, dst, quality string) error {
+	settings, ok := ghostscriptSettings[quality]
+	if !ok {
+		return fmt.Errorf("invalid quality %q", quality)
+	}
+	_, err := runWithTimeout(optimizeTimeout, "gs", "-sDEVICE=pdfwrite", "-dCompatibilityLevel=1.5",
+		"-dPDFSETTINGS="+settings, "-dDetectDuplicateImages=true", "-dCompressFonts=true",
+		"-dSAFER", "-dNOPAUSE", "-dBATCH", "-dQUIET", "-sOutputFile="+dst, src)
+	return err
+}
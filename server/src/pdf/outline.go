@@ -0,0 +1,142 @@
+package pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// OutlineItem is a bookmark of a document's outline with the bookmarks
+// nested under it
+type OutlineItem struct {
+	Title string `json:"title"`
+	// Page is the target page counted from 1, 0 when the bookmark doesn't go
+	// to a page of the document, like a link to a website
+	Page int `json:"page"`
+	// Open bookmarks show their children when the document is opened
+	Open     bool          `json:"open"`
+	Children []OutlineItem `json:"children"`
+}
+
+// qpdfOutline is an outline item as qpdf's JSON describes it, with the
+// destination already resolved to a page
+type qpdfOutline struct {
+	Title string        `json:"title"`
+	Page  int           `json:"destpageposfrom1"`
+	Open  bool          `json:"open"`
+	Kids  []qpdfOutline `json:"kids"`
+}
+
+func (q qpdfOutline) item() OutlineItem {
+	item := OutlineItem{Title: q.Title, Page: q.Page, Open: q.Open, Children: make([]OutlineItem, len(q.Kids))}
+	for i, kid := range q.Kids {
+		item.Children[i] = kid.item()
+	}
+	return item
+}
+
+// Outline reads the bookmark tree of a PDF. Named destinations and go-to
+// actions are followed to their pages.
+func Outline(path string) ([]OutlineItem, error) {
+	out, err := run("qpdf", "--warning-exit-0", "--json=2", "--json-key=outlines", path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Outlines []qpdfOutline `json:"outlines"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("reading qpdf output: %v", err)
+	}
+	items := make([]OutlineItem, len(doc.Outlines))
+	for i, outline := range doc.Outlines {
+		items[i] = outline.item()
+	}
+	return items, nil
+}
+
+// visibleItems counts the bookmarks shown under items when their parents are
+// open, the magnitude of an outline item's /Count
+func visibleItems(items []OutlineItem) int {
+	count := len(items)
+	for _, item := range items {
+		if item.Open {
+			count += visibleItems(item.Children)
+		}
+	}
+	return count
+}
+
+// writeOutlineItems writes items as the children of parent, returning the
+// references of the first and last one
+func writeOutlineItems(u *incrementalUpdate, parent string, items []OutlineItem, pages []string) (string, string) {
+	refs := make([]string, len(items))
+	for i := range items {
+		refs[i] = u.newRef()
+	}
+	for i, item := range items {
+		dict := map[string]interface{}{
+			"/Title":  "u:" + item.Title,
+			"/Parent": parent,
+		}
+		if item.Page >= 1 && item.Page <= len(pages) {
+			dict["/Dest"] = []interface{}{pages[item.Page-1], "/Fit"}
+		}
+		if i > 0 {
+			dict["/Prev"] = refs[i-1]
+		}
+		if i < len(items)-1 {
+			dict["/Next"] = refs[i+1]
+		}
+		if len(item.Children) > 0 {
+			dict["/First"], dict["/Last"] = writeOutlineItems(u, refs[i], item.Children, pages)
+			count := visibleItems(item.Children)
+			if !item.Open {
+				count = -count
+			}
+			dict["/Count"] = float64(count)
+		}
+		u.object(refs[i], pdfSyntax(dict))
+	}
+	return refs[0], refs[len(items)-1]
+}
+
+// WriteOutline writes src to dst with its bookmark tree replaced by items,
+// each going to the whole of its page. No items remove the outline. The
+// changes are appended as an incremental update.
+func WriteOutline(src, dst string, items []OutlineItem) error {
+	u, doc, objects, err := openUpdate(src)
+	if err != nil {
+		return err
+	}
+	pages := make([]string, len(doc.Pages))
+	for i, page := range doc.Pages {
+		pages[i] = page.Object
+	}
+
+	rootRef := u.trailer["/Root"].(string)
+	root := copyDict(objects.dict(rootRef))
+	if len(items) == 0 {
+		delete(root, "/Outlines")
+		if root["/PageMode"] == "/UseOutlines" {
+			delete(root, "/PageMode")
+		}
+	} else {
+		// The old tree stays in the file but nothing refers to it anymore
+		outlinesRef := u.newRef()
+		first, last := writeOutlineItems(u, outlinesRef, items, pages)
+		u.object(outlinesRef, pdfSyntax(map[string]interface{}{
+			"/Type":  "/Outlines",
+			"/First": first,
+			"/Last":  last,
+			"/Count": float64(visibleItems(items)),
+		}))
+		root["/Outlines"] = outlinesRef
+		if _, ok := root["/PageMode"]; !ok {
+			root["/PageMode"] = "/UseOutlines"
+		}
+	}
+	u.object(rootRef, pdfSyntax(root))
+	u.finish()
+	return os.WriteFile(dst, u.buf.Bytes(), 0o644)
+}
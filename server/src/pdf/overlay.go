@@ -0,0 +1,582 @@
+package pdf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Overlay is a drawing made in the viewer. Its coordinates are PDF points
+// from the top left corner of the unrotated page, as the viewer stores them.
+type Overlay struct {
+	// ID and Modified identify the drawing in exported annotations
+	ID       uint
+	Modified time.Time
+	Page     int
+	Type     string
+	// Data holds the shapes of the drawing as JSON
+	Data string
+	// Image is a data URL, the content of image drawings
+	Image string
+}
+
+// Sizes used by the viewer, in points
+const (
+	textAreaFontSize    = 14
+	textAreaPadding     = 5
+	pinSize             = 20
+	pinStemHeight       = 10
+	extensionTailLength = 60
+	extensionMaxHead    = 15
+	extensionFontSize   = 11
+	extensionLineHeight = 14
+	extensionPadding    = 6
+	rulerLineWidth      = 2
+	rulerFontSize       = 12
+	rulerLabelOffset    = 15
+)
+
+type point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type drawingStyle struct {
+	StrokeColor string   `json:"strokeColor"`
+	StrokeWidth float64  `json:"strokeWidth"`
+	Opacity     *float64 `json:"opacity"`
+}
+
+func (s drawingStyle) opacity() float64 {
+	if s.Opacity == nil {
+		return 1
+	}
+	return *s.Opacity
+}
+
+// segment is a line of a line drawing, or of an underline or cross-out,
+// which name their ends differently
+type segment struct {
+	StartPoint *point `json:"startPoint"`
+	EndPoint   *point `json:"endPoint"`
+	Start      *point `json:"start"`
+	End        *point `json:"end"`
+}
+
+func (s segment) ends() (point, point, bool) {
+	if s.StartPoint != nil && s.EndPoint != nil {
+		return *s.StartPoint, *s.EndPoint, true
+	}
+	if s.Start != nil && s.End != nil {
+		return *s.Start, *s.End, true
+	}
+	return point{}, point{}, false
+}
+
+type ruler struct {
+	StartPoint point   `json:"startPoint"`
+	EndPoint   point   `json:"endPoint"`
+	Distance   float64 `json:"distance"`
+	Color      string  `json:"color"`
+}
+
+// drawingShapes holds the fields of every kind of drawing
+type drawingShapes struct {
+	Type       string         `json:"type"`
+	Image      string         `json:"image"`
+	Paths      [][]point      `json:"paths"`
+	PathStyles []drawingStyle `json:"pathStyles"`
+	Style      drawingStyle   `json:"style"`
+	StartPoint point          `json:"startPoint"`
+	EndPoint   point          `json:"endPoint"`
+	Position   point          `json:"position"`
+	BendPoint  *point         `json:"bendPoint"`
+	Text       string         `json:"text"`
+	Color      string         `json:"color"`
+	Lines      []segment      `json:"lines"`
+	LineStyles []drawingStyle `json:"lineStyles"`
+	Rects      []struct {
+		X      float64 `json:"x"`
+		Y      float64 `json:"y"`
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+	} `json:"rects"`
+	Opacity       *float64 `json:"opacity"`
+	FontSize      float64  `json:"fontSize"`
+	Rulers        []ruler  `json:"rulers"`
+	PixelsPerUnit float64  `json:"pixelsPerUnit"`
+	Units         string   `json:"units"`
+}
+
+// miscShapes holds the drawings a combined drawing is made of
+type miscShapes struct {
+	Pathes         []drawingShapes `json:"pathes"`
+	Rectangles     []drawingShapes `json:"rectangles"`
+	ExtensionLines []drawingShapes `json:"extensionLines"`
+	Lines          []drawingShapes `json:"lines"`
+	TextAreas      []drawingShapes `json:"textAreas"`
+	Images         []drawingShapes `json:"images"`
+	Rulers         []drawingShapes `json:"rulers"`
+}
+
+// parseColor reads CSS colors like "#f80", "#ff8800", "#ff880080" and
+// "rgba(255, 136, 0, 0.5)" as red, green and blue from 0 to 1. Others are black.
+func parseColor(value string) [3]float64 {
+	var color [3]float64
+	value = strings.TrimSpace(value)
+	if hex, found := strings.CutPrefix(value, "#"); found {
+		if len(hex) == 3 || len(hex) == 4 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) < 6 {
+			return color
+		}
+		for i := range color {
+			component, err := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+			if err != nil {
+				return [3]float64{}
+			}
+			color[i] = float64(component) / 255
+		}
+		return color
+	}
+	if open := strings.Index(value, "("); open > 0 && strings.HasSuffix(value, ")") {
+		parts := strings.Split(value[open+1:len(value)-1], ",")
+		for i := 0; i < 3 && i < len(parts); i++ {
+			component, _ := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			color[i] = math.Max(0, math.Min(component, 255)) / 255
+		}
+	}
+	return color
+}
+
+// overlayCanvas writes the content of a page in the viewer's coordinates,
+// y growing downwards
+type overlayCanvas struct {
+	doc *stampDocument
+	out strings.Builder
+}
+
+func (c *overlayCanvas) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&c.out, format, args...)
+	c.out.WriteByte('\n')
+}
+
+// begin starts a graphics state with the opacity and colors
+func (c *overlayCanvas) begin(opacity float64, color string) {
+	rgb := parseColor(color)
+	c.printf("q %s %.3f %.3f %.3f RG %.3f %.3f %.3f rg", c.doc.opacity(math.Max(0, math.Min(opacity, 1))),
+		rgb[0], rgb[1], rgb[2], rgb[0], rgb[1], rgb[2])
+}
+
+func (c *overlayCanvas) end() {
+	c.printf("Q")
+}
+
+// polyline strokes a path through the points with round ends
+func (c *overlayCanvas) polyline(points []point, style drawingStyle) {
+	if len(points) < 2 {
+		return
+	}
+	c.begin(style.opacity(), style.StrokeColor)
+	c.printf("%.2f w 1 J 1 j %.2f %.2f m", style.StrokeWidth, points[0].X, points[0].Y)
+	for _, p := range points[1:] {
+		c.printf("%.2f %.2f l", p.X, p.Y)
+	}
+	c.printf("S")
+	c.end()
+}
+
+// rect strokes and or fills the rectangle between two corners
+func (c *overlayCanvas) rect(from, to point, operator string) {
+	c.printf("%.2f %.2f %.2f %.2f re %s", math.Min(from.X, to.X), math.Min(from.Y, to.Y),
+		math.Abs(to.X-from.X), math.Abs(to.Y-from.Y), operator)
+}
+
+// text writes a line of text with its baseline starting at x, y, turned
+// clockwise by angle radians
+func (c *overlayCanvas) text(font stampFont, size float64, x, y, angle float64, text string) {
+	encoded, _ := font.encode(text)
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	// The viewer's y axis points down, so the text is flipped back upright
+	c.printf("BT %s %.2f Tf %.4f %.4f %.4f %.4f %.2f %.2f Tm %s Tj ET",
+		c.doc.font(font), size, cos, sin, sin, -cos, x, y, pdfString(encoded))
+}
+
+// wrapText breaks text into lines no wider than width, breaking words that
+// don't fit on a line of their own, like the viewer does
+func wrapText(font stampFont, size, width float64, text string) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		if strings.TrimSpace(paragraph) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		line := ""
+		for _, word := range strings.Split(paragraph, " ") {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.width(candidate)*size <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			if font.width(word)*size <= width {
+				line = word
+				continue
+			}
+			part := ""
+			for _, r := range word {
+				if part != "" && font.width(part+string(r))*size > width {
+					lines = append(lines, part)
+					part = ""
+				}
+				part += string(r)
+			}
+			line = part
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// draw renders one drawing
+func (c *overlayCanvas) draw(shapes drawingShapes, image string) {
+	switch shapes.Type {
+	case "freehand":
+		for i, path := range shapes.Paths {
+			style := shapes.Style
+			if i < len(shapes.PathStyles) {
+				style = shapes.PathStyles[i]
+			}
+			c.polyline(path, style)
+		}
+	case "line", "textUnderline", "textCrossedOut":
+		for i, line := range shapes.Lines {
+			start, end, ok := line.ends()
+			if !ok {
+				continue
+			}
+			style := shapes.Style
+			if i < len(shapes.LineStyles) {
+				style = shapes.LineStyles[i]
+			}
+			c.polyline([]point{start, end}, style)
+		}
+	case "rectangle":
+		c.begin(shapes.Style.opacity(), shapes.Style.StrokeColor)
+		c.printf("%.2f w", shapes.Style.StrokeWidth)
+		c.rect(shapes.StartPoint, shapes.EndPoint, "S")
+		c.end()
+	case "drawArea", "rectSelection":
+		c.begin(0.3, shapes.Style.StrokeColor)
+		c.rect(shapes.StartPoint, shapes.EndPoint, "f")
+		c.end()
+		c.begin(shapes.Style.opacity(), shapes.Style.StrokeColor)
+		c.printf("%.2f w", shapes.Style.StrokeWidth)
+		c.rect(shapes.StartPoint, shapes.EndPoint, "S")
+		c.end()
+	case "textHighlight":
+		opacity := 0.5
+		if shapes.Opacity != nil {
+			opacity = *shapes.Opacity
+		} else if shapes.Style.Opacity != nil {
+			opacity = *shapes.Style.Opacity
+		}
+		c.begin(opacity, shapes.Style.StrokeColor)
+		for _, r := range shapes.Rects {
+			c.rect(point{r.X, r.Y}, point{r.X + r.Width, r.Y + r.Height}, "f")
+		}
+		c.end()
+	case "textArea":
+		c.drawTextArea(shapes)
+	case "pinSelection":
+		c.drawPin(shapes)
+	case "extensionLine":
+		c.drawExtensionLine(shapes)
+	case "rulers":
+		c.drawRulers(shapes)
+	case "image":
+		c.drawImage(shapes, image)
+	}
+}
+
+func (c *overlayCanvas) drawTextArea(shapes drawingShapes) {
+	style := shapes.Style
+	left, top := math.Min(shapes.StartPoint.X, shapes.EndPoint.X), math.Min(shapes.StartPoint.Y, shapes.EndPoint.Y)
+	width, height := math.Abs(shapes.EndPoint.X-shapes.StartPoint.X), math.Abs(shapes.EndPoint.Y-shapes.StartPoint.Y)
+
+	c.begin(style.opacity(), style.StrokeColor)
+	c.printf("%.2f w", style.StrokeWidth)
+	c.rect(shapes.StartPoint, shapes.EndPoint, "S")
+	c.end()
+	// The background is the text color at about 8% opacity
+	c.begin(style.opacity()*0x15/0xff, style.StrokeColor)
+	c.rect(shapes.StartPoint, shapes.EndPoint, "f")
+	c.end()
+
+	fontSize := shapes.FontSize
+	if fontSize == 0 {
+		fontSize = textAreaFontSize
+	}
+	inset := style.StrokeWidth/2 + textAreaPadding
+	c.begin(style.opacity(), style.StrokeColor)
+	y := top + inset + fontSize*0.8
+	for _, line := range wrapText(helvetica, fontSize, width-2*inset, shapes.Text) {
+		if y > top+height-inset {
+			break
+		}
+		c.text(helvetica, fontSize, left+inset, y, 0, line)
+		y += fontSize
+	}
+	c.end()
+}
+
+// circle adds a circle to the path, made of four Bézier curves
+func (c *overlayCanvas) circle(cx, cy, r float64) {
+	k := r * 0.5523
+	c.printf("%.2f %.2f m", cx+r, cy)
+	c.printf("%.2f %.2f %.2f %.2f %.2f %.2f c", cx+r, cy+k, cx+k, cy+r, cx, cy+r)
+	c.printf("%.2f %.2f %.2f %.2f %.2f %.2f c", cx-k, cy+r, cx-r, cy+k, cx-r, cy)
+	c.printf("%.2f %.2f %.2f %.2f %.2f %.2f c", cx-r, cy-k, cx-k, cy-r, cx, cy-r)
+	c.printf("%.2f %.2f %.2f %.2f %.2f %.2f c h", cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+}
+
+func (c *overlayCanvas) drawPin(shapes drawingShapes) {
+	color := shapes.Color
+	if color == "" {
+		color = "#FF0000"
+	}
+	x, y := shapes.Position.X, shapes.Position.Y
+	c.begin(1, color)
+	c.printf("0 0 0 RG 1 w")
+	c.circle(x, y-pinStemHeight-pinSize/2, pinSize/2)
+	c.printf("B")
+	c.printf("%.2f %.2f m %.2f %.2f l %.2f %.2f l h B", x, y, x-pinSize/4, y-pinStemHeight, x+pinSize/4, y-pinStemHeight)
+	c.end()
+}
+
+// extensionLayout is where the parts of an extension line go: the arrow from
+// the bend point to the target, a horizontal tail and a box with the text
+// above the middle of the tail
+type extensionLayout struct {
+	bend, tailEnd point
+	lines         []string
+	// box is the top left and bottom right corners of the text box
+	box [2]point
+}
+
+func layoutExtensionLine(shapes drawingShapes) extensionLayout {
+	x, y := shapes.Position.X, shapes.Position.Y
+	// Without a bend point the arrow comes in at 45 degrees
+	layout := extensionLayout{bend: point{x - 36/math.Sqrt2, y - 36/math.Sqrt2}}
+	if shapes.BendPoint != nil {
+		layout.bend = *shapes.BendPoint
+	}
+	bend := layout.bend
+	// The tail runs horizontally away from the arrow
+	layout.tailEnd = point{bend.X - extensionTailLength, bend.Y}
+	if x-bend.X <= 0 && math.Hypot(x-bend.X, y-bend.Y) > 0 {
+		layout.tailEnd.X = bend.X + extensionTailLength
+	}
+
+	if strings.TrimSpace(shapes.Text) == "" {
+		return layout
+	}
+	line := ""
+	for _, word := range strings.Split(shapes.Text, " ") {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && helveticaBold.width(candidate)*extensionFontSize > extensionTailLength-2*extensionPadding {
+			layout.lines = append(layout.lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	layout.lines = append(layout.lines, line)
+	widest := 0.0
+	for _, line := range layout.lines {
+		widest = math.Max(widest, helveticaBold.width(line)*extensionFontSize)
+	}
+	boxWidth := widest + 2*extensionPadding
+	boxHeight := float64(len(layout.lines)*extensionLineHeight + 2*extensionPadding)
+	cx := (bend.X + layout.tailEnd.X) / 2
+	cy := bend.Y - boxHeight/2 - extensionPadding - 2
+	layout.box = [2]point{{cx - boxWidth/2, cy - boxHeight/2}, {cx + boxWidth/2, cy + boxHeight/2}}
+	return layout
+}
+
+func (c *overlayCanvas) drawExtensionLine(shapes drawingShapes) {
+	layout := layoutExtensionLine(shapes)
+	x, y := shapes.Position.X, shapes.Position.Y
+	bend, tailEnd := layout.bend, layout.tailEnd
+	dx, dy := x-bend.X, y-bend.Y
+	length := math.Hypot(dx, dy)
+
+	c.begin(1, shapes.Color)
+	c.printf("1.5 w 1 J 1 j %.2f %.2f m %.2f %.2f l", tailEnd.X, tailEnd.Y, bend.X, bend.Y)
+	if length > 0 {
+		head := math.Min(length*0.25, extensionMaxHead)
+		ux, uy := dx/length, dy/length
+		base := point{x - ux*head, y - uy*head}
+		if length > head {
+			c.printf("%.2f %.2f l", base.X, base.Y)
+		}
+		c.printf("S")
+		spread := head * 0.4
+		c.printf("%.2f %.2f m %.2f %.2f l %.2f %.2f l h f", x, y,
+			base.X-uy*spread, base.Y+ux*spread, base.X+uy*spread, base.Y-ux*spread)
+	} else {
+		c.printf("S")
+	}
+	c.end()
+
+	if len(layout.lines) == 0 {
+		return
+	}
+	// The text sits on a white box
+	box := layout.box
+	c.begin(1, "#ffffff")
+	c.rect(box[0], box[1], "f")
+	c.end()
+	c.begin(1, shapes.Color)
+	cx, cy := (box[0].X+box[1].X)/2, (box[0].Y+box[1].Y)/2
+	for i, line := range layout.lines {
+		lineY := cy + (float64(i)-float64(len(layout.lines)-1)/2)*extensionLineHeight
+		width := helveticaBold.width(line) * extensionFontSize
+		c.text(helveticaBold, extensionFontSize, cx-width/2, lineY+extensionFontSize*0.36, 0, line)
+	}
+	c.end()
+}
+
+// rulerLabel is the measured length of a ruler in the units of the drawing
+func rulerLabel(r ruler, shapes drawingShapes) string {
+	if shapes.PixelsPerUnit > 0 && !(shapes.PixelsPerUnit == 1 && shapes.Units == "px") {
+		return fmt.Sprintf("%.1f %s", r.Distance/shapes.PixelsPerUnit, shapes.Units)
+	}
+	return fmt.Sprintf("%d px", int(math.Round(r.Distance)))
+}
+
+func (c *overlayCanvas) drawRulers(shapes drawingShapes) {
+	for _, r := range shapes.Rulers {
+		dx, dy := r.EndPoint.X-r.StartPoint.X, r.EndPoint.Y-r.StartPoint.Y
+		angle := math.Atan2(dy, dx)
+		c.begin(1, r.Color)
+		c.printf("%d w 1 J 1 j %.2f %.2f m %.2f %.2f l S", rulerLineWidth, r.StartPoint.X, r.StartPoint.Y, r.EndPoint.X, r.EndPoint.Y)
+		// Open arrowheads point outwards at both ends
+		head := math.Max(8, math.Min(extensionMaxHead, rulerLineWidth*4))
+		spread := head * 0.4
+		for _, tip := range []struct {
+			p     point
+			angle float64
+		}{{r.StartPoint, angle + math.Pi}, {r.EndPoint, angle}} {
+			cos, sin := math.Cos(tip.angle), math.Sin(tip.angle)
+			base := point{tip.p.X - cos*head, tip.p.Y - sin*head}
+			c.printf("%.2f %.2f m %.2f %.2f l %.2f %.2f m %.2f %.2f l S",
+				base.X-sin*spread, base.Y+cos*spread, tip.p.X, tip.p.Y,
+				tip.p.X, tip.p.Y, base.X+sin*spread, base.Y-cos*spread)
+		}
+
+		label := rulerLabel(r, shapes)
+		// Labels are kept upright, above the middle of the ruler
+		textAngle := angle
+		if textAngle > math.Pi/2 || textAngle < -math.Pi/2 {
+			textAngle += math.Pi
+		}
+		mx := (r.StartPoint.X+r.EndPoint.X)/2 + math.Sin(angle)*rulerLabelOffset
+		my := (r.StartPoint.Y+r.EndPoint.Y)/2 - math.Cos(angle)*rulerLabelOffset
+		width := helveticaBold.width(label) * rulerFontSize
+		cos, sin := math.Cos(textAngle), math.Sin(textAngle)
+		// Center the label on its point, moving back along the text direction
+		ox, oy := -width/2, rulerFontSize*0.36
+		c.text(helveticaBold, rulerFontSize, mx+cos*ox-sin*oy, my+sin*ox+cos*oy, textAngle, label)
+		c.end()
+	}
+}
+
+func (c *overlayCanvas) drawImage(shapes drawingShapes, dataURL string) {
+	if dataURL == "" {
+		dataURL = shapes.Image
+	}
+	_, encoded, found := strings.Cut(dataURL, ";base64,")
+	if !found {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
+	img, err := decodeStampImage(data)
+	if err != nil {
+		return
+	}
+	name := c.doc.image(img)
+	left, top := math.Min(shapes.StartPoint.X, shapes.EndPoint.X), math.Min(shapes.StartPoint.Y, shapes.EndPoint.Y)
+	width, height := math.Abs(shapes.EndPoint.X-shapes.StartPoint.X), math.Abs(shapes.EndPoint.Y-shapes.StartPoint.Y)
+	// Images are drawn upside down in the flipped space, so flip them back
+	c.printf("q %.2f 0 0 %.2f %.2f %.2f cm %s Do Q", width, -height, left, top+height, name)
+}
+
+// drawOverlay renders a drawing and, for combined drawings, those it holds
+func (c *overlayCanvas) drawOverlay(overlay Overlay) error {
+	var shapes drawingShapes
+	if err := json.Unmarshal([]byte(overlay.Data), &shapes); err != nil {
+		return err
+	}
+	shapes.Type = overlay.Type
+	if overlay.Type != "misc" {
+		c.draw(shapes, overlay.Image)
+		return nil
+	}
+
+	var misc miscShapes
+	if err := json.Unmarshal([]byte(overlay.Data), &misc); err != nil {
+		return err
+	}
+	for _, group := range [][]drawingShapes{misc.Pathes, misc.Rectangles, misc.Lines, misc.TextAreas,
+		misc.Images, misc.ExtensionLines, misc.Rulers} {
+		for _, part := range group {
+			c.draw(part, "")
+		}
+	}
+	return nil
+}
+
+// WriteOverlays writes a PDF with one page for each of the sizes carrying
+// the drawings on that page, to be drawn over the document by StampPages.
+// Drawings whose data can't be read are skipped and counted.
+func WriteOverlays(dst string, sizes []PageSize, overlays []Overlay) (int, error) {
+	byPage := make(map[int][]Overlay)
+	for _, overlay := range overlays {
+		byPage[overlay.Page] = append(byPage[overlay.Page], overlay)
+	}
+
+	skipped := 0
+	doc := newStampDocument()
+	for i, size := range sizes {
+		canvas := &overlayCanvas{doc: doc}
+		_, height := size.Unrotated()
+		// The viewer measures from the top left corner
+		canvas.printf("1 0 0 -1 0 %.2f cm", height)
+		for _, overlay := range byPage[i+1] {
+			if err := canvas.drawOverlay(overlay); err != nil {
+				skipped++
+			}
+		}
+		doc.addPage(size, canvas.out.String())
+	}
+	return skipped, doc.write(dst)
+}
@@ -0,0 +1,118 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageRange is an inclusive range of pages counted from 1. From may be larger
+// than To to take the pages in reverse.
+type PageRange struct {
+	From int
+	To   int
+}
+
+// ParsePageRanges reads ranges like "1-3,5,8-end". "end" is the last page.
+// Pages must lie within pageCount, which is not checked when it is 0.
+func ParsePageRanges(spec string, pageCount int) ([]PageRange, error) {
+	page := func(value string) (int, error) {
+		value = strings.TrimSpace(value)
+		if value == "end" {
+			if pageCount == 0 {
+				return 0, fmt.Errorf("the page count is unknown, so %q can't be used", "end")
+			}
+			return pageCount, nil
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || pageCount > 0 && number > pageCount {
+			return 0, fmt.Errorf("invalid page %q", value)
+		}
+		return number, nil
+	}
+
+	var ranges []PageRange
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := page(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = page(to); err != nil {
+				return nil, err
+			}
+		}
+		ranges = append(ranges, PageRange{From: first, To: last})
+	}
+	return ranges, nil
+}
+
+// pdftkHandle names the nth input of a pdftk command: A to Z, then AA, AB...
+func pdftkHandle(n int) string {
+	handle := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		handle = string(rune('A'+(n-1)%26)) + handle
+	}
+	return handle
+}
+
+// pdftkRanges writes page ranges in pdftk's cat syntax for the input handle
+func pdftkRanges(handle string, ranges []PageRange) []string {
+	if len(ranges) == 0 {
+		return []string{handle}
+	}
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.From == r.To {
+			parts = append(parts, fmt.Sprintf("%s%d", handle, r.From))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s%d-%d", handle, r.From, r.To))
+		}
+	}
+	return parts
+}
+
+// PageSet lists the pages the ranges cover
+func PageSet(ranges []PageRange) map[int]bool {
+	pages := make(map[int]bool)
+	for _, r := range ranges {
+		step := 1
+		if r.From > r.To {
+			step = -1
+		}
+		for page := r.From; ; page += step {
+			pages[page] = true
+			if page == r.To {
+				break
+			}
+		}
+	}
+	return pages
+}
+
+// PageRuns groups pages into as few ranges as keep their order, joining runs of
+// consecutive pages going up or down
+func PageRuns(pages []int) []PageRange {
+	var runs []PageRange
+	for _, page := range pages {
+		if n := len(runs); n > 0 {
+			last := &runs[n-1]
+			if page == last.To+1 && last.From <= last.To || page == last.To-1 && last.From >= last.To {
+				last.To = page
+				continue
+			}
+		}
+		runs = append(runs, PageRange{From: page, To: page})
+	}
+	return runs
+}
+
+// SelectPages writes the listed pages of src, in their order, to dst
+func SelectPages(src, dst string, pages []int) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages selected")
+	}
+	return Merge([]MergeInput{{Path: src, Ranges: PageRuns(pages)}}, dst)
+}
@@ -0,0 +1,45 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PageText is a line of text stamped at a position of a page, like a Bates
+// number in the bottom right corner or a revision in the header
+type PageText struct {
+	// Position is one of top, bottom and the corners like bottom-right;
+	// center, left and right are allowed too
+	Position string
+	Text     string
+}
+
+// WritePageTexts writes a PDF with one page for each of the sizes carrying
+// the texts for that page, to be drawn over the document with StampPages.
+// Texts are written in Helvetica at fontSize points in color, shrunk to fit.
+func WritePageTexts(dst string, sizes []PageSize, texts [][]PageText, fontSize float64, color [3]float64) error {
+	doc := newStampDocument()
+	for i, size := range sizes {
+		if i >= len(texts) || len(texts[i]) == 0 {
+			doc.addPage(size, "")
+			continue
+		}
+		var content strings.Builder
+		for _, text := range texts[i] {
+			if _, ok := stampPositions[text.Position]; !ok {
+				return fmt.Errorf("invalid position %q", text.Position)
+			}
+			encoded, textWidth := helvetica.encode(text.Text)
+			textSize := fontSize
+			if room := size.Width - 2*stampMargin; textWidth*textSize > room && textWidth > 0 {
+				textSize = room / textWidth
+			}
+			// Capitals reach about 0.72 of the font size above the baseline
+			fmt.Fprintf(&content, " q %s BT %s %.2f Tf 0 0 Td %s Tj ET Q",
+				placement(size, textWidth*textSize, textSize*0.72, Watermark{Position: text.Position}),
+				doc.font(helvetica), textSize, pdfString(encoded))
+		}
+		doc.addPage(size, fmt.Sprintf("q %s %.3f %.3f %.3f rg%s Q", visibleSpace(size), color[0], color[1], color[2], content.String()))
+	}
+	return doc.write(dst)
+}
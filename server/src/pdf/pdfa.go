@@ -0,0 +1,132 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pdfaTimeout bounds a PDF/A conversion, which rewrites every page
+const pdfaTimeout = 10 * time.Minute
+
+var (
+	pdfaPartPattern        = regexp.MustCompile(`pdfaid:part(?:="|>)(\d)`)
+	pdfaConformancePattern = regexp.MustCompile(`pdfaid:conformance(?:="|>)([ABUabu])`)
+)
+
+// PDFACheck is one requirement of PDF/A checked in a converted file
+type PDFACheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PDFAReport describes how a file conforms to PDF/A
+type PDFAReport struct {
+	// Part and Conformance are what the file claims in its metadata, like 2
+	// and B for PDF/A-2b
+	Part        int         `json:"part"`
+	Conformance string      `json:"conformance"`
+	Compliant   bool        `json:"compliant"`
+	Checks      []PDFACheck `json:"checks"`
+}
+
+// ConvertPDFA writes src to dst as PDF/A-2b with ocrmypdf, which has
+// ghostscript embed fonts and convert colors and adds the metadata and output
+// intent PDF/A requires. No OCR is done.
+func ConvertPDFA(src, dst string) error {
+	_, err := runWithTimeout(pdfaTimeout, "ocrmypdf", "--skip-text", "--tesseract-timeout", "0",
+		"--optimize", "0", "--output-type", "pdfa-2", "--quiet", src, dst)
+	return err
+}
+
+// CheckPDFA checks the PDF at path for the PDF/A-2b requirements that can
+// go wrong in a conversion: the identification in its metadata, an output
+// intent, embedded fonts and no encryption. It is not a full validation.
+func CheckPDFA(path string) (PDFAReport, error) {
+	var report PDFAReport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+
+	// PDF/A keeps the XMP metadata uncompressed
+	identification := PDFACheck{Name: "identification"}
+	if match := pdfaPartPattern.FindSubmatch(data); match != nil {
+		report.Part, _ = strconv.Atoi(string(match[1]))
+	}
+	if match := pdfaConformancePattern.FindSubmatch(data); match != nil {
+		report.Conformance = strings.ToUpper(string(match[1]))
+	}
+	identification.Passed = report.Part == 2 && report.Conformance == "B"
+	if !identification.Passed {
+		identification.Detail = fmt.Sprintf("metadata claims part %d conformance %q", report.Part, report.Conformance)
+	}
+
+	_, objects, err := loadObjects(path)
+	if err != nil {
+		return report, err
+	}
+	trailer := objects.dict(objects["trailer"].Value)
+	root := objects.dict(trailer["/Root"])
+	outputIntent := PDFACheck{Name: "outputIntent", Detail: "no PDF/A output intent"}
+	for _, intent := range objects.array(root["/OutputIntents"]) {
+		if objects.name(objects.dict(intent)["/S"]) == "GTS_PDFA1" {
+			outputIntent.Passed, outputIntent.Detail = true, ""
+		}
+	}
+
+	encryption := PDFACheck{Name: "notEncrypted", Passed: trailer["/Encrypt"] == nil}
+	if !encryption.Passed {
+		encryption.Detail = "the file is encrypted"
+	}
+
+	fonts := PDFACheck{Name: "fontsEmbedded", Passed: true}
+	missing, err := unembeddedFonts(path)
+	if err != nil {
+		return report, err
+	}
+	if len(missing) > 0 {
+		fonts.Passed = false
+		fonts.Detail = "not embedded: " + strings.Join(missing, ", ")
+	}
+
+	report.Checks = []PDFACheck{identification, outputIntent, encryption, fonts}
+	report.Compliant = true
+	for _, check := range report.Checks {
+		report.Compliant = report.Compliant && check.Passed
+	}
+	return report, nil
+}
+
+// unembeddedFonts lists the fonts pdffonts reports as not embedded
+func unembeddedFonts(path string) ([]string, error) {
+	out, err := run("pdffonts", path)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	table := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") {
+			table = true
+			continue
+		}
+		// Rows end with the emb, sub and uni columns and the object number
+		fields := strings.Fields(line)
+		if !table || len(fields) < 6 {
+			continue
+		}
+		if fields[len(fields)-5] == "no" {
+			missing = append(missing, fields[0])
+		}
+	}
+	return missing, scanner.Err()
+}
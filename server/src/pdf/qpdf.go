@@ -0,0 +1,212 @@
+package pdf
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// referencePattern matches indirect references like "12 0 R"
+var referencePattern = regexp.MustCompile(`^\d+ \d+ R$`)
+
+// qpdfDocument is the part of qpdf's JSON output listing pages and objects
+type qpdfDocument struct {
+	Pages []struct {
+		Object string `json:"object"`
+	} `json:"pages"`
+	// QPDF holds a header and the objects by keys like "obj:3 0 R"
+	QPDF   []json.RawMessage `json:"qpdf"`
+	Header struct {
+		MaxObjectID int `json:"maxobjectid"`
+	} `json:"-"`
+}
+
+type qpdfObject struct {
+	Value  interface{} `json:"value"`
+	Stream *struct {
+		Dict interface{} `json:"dict"`
+		// Data is the decoded content, when asked for inline
+		Data []byte `json:"data"`
+	} `json:"stream"`
+}
+
+// qpdfObjects resolves indirect references in qpdf's JSON
+type qpdfObjects map[string]qpdfObject
+
+func (o qpdfObjects) resolve(value interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := value.(string)
+		if !ok || !referencePattern.MatchString(ref) {
+			return value
+		}
+		object, found := o["obj:"+ref]
+		if !found {
+			return nil
+		}
+		value = object.Value
+		if object.Stream != nil {
+			value = object.Stream.Dict
+		}
+	}
+	return nil
+}
+
+func (o qpdfObjects) dict(value interface{}) map[string]interface{} {
+	dict, _ := o.resolve(value).(map[string]interface{})
+	return dict
+}
+
+func (o qpdfObjects) array(value interface{}) []interface{} {
+	array, _ := o.resolve(value).([]interface{})
+	return array
+}
+
+func (o qpdfObjects) numbers(value interface{}) []float64 {
+	var numbers []float64
+	for _, item := range o.array(value) {
+		if number, ok := o.resolve(item).(float64); ok {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+func (o qpdfObjects) number(value interface{}) (float64, bool) {
+	number, ok := o.resolve(value).(float64)
+	return number, ok
+}
+
+// text decodes a string, which qpdf writes as "u:" and the text or "b:" and
+// the bytes in hex
+func (o qpdfObjects) text(value interface{}) string {
+	s, _ := o.resolve(value).(string)
+	if text, found := strings.CutPrefix(s, "u:"); found {
+		return text
+	}
+	if encoded, found := strings.CutPrefix(s, "b:"); found {
+		data, err := hex.DecodeString(encoded)
+		if err != nil {
+			return ""
+		}
+		// Bytes that aren't UTF-16 are read as Latin-1, close to PDFDocEncoding
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+func (o qpdfObjects) name(value interface{}) string {
+	s, _ := o.resolve(value).(string)
+	return strings.TrimPrefix(s, "/")
+}
+
+// loadObjects reads the pages and objects of the PDF at path with qpdf
+func loadObjects(path string) (qpdfDocument, qpdfObjects, error) {
+	var doc qpdfDocument
+	out, err := run("qpdf", "--warning-exit-0", "--json=2", "--json-key=pages", "--json-key=qpdf", path)
+	if err != nil {
+		return doc, nil, err
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return doc, nil, fmt.Errorf("reading qpdf output: %v", err)
+	}
+	if len(doc.QPDF) < 2 {
+		return doc, nil, fmt.Errorf("qpdf output has no objects")
+	}
+	if err := json.Unmarshal(doc.QPDF[0], &doc.Header); err != nil {
+		return doc, nil, fmt.Errorf("reading qpdf header: %v", err)
+	}
+	var objects qpdfObjects
+	if err := json.Unmarshal(doc.QPDF[1], &objects); err != nil {
+		return doc, nil, fmt.Errorf("reading qpdf objects: %v", err)
+	}
+	return doc, objects, nil
+}
+
+// pdfName writes a name, escaping the characters names can't hold
+func pdfName(name string) string {
+	var escaped strings.Builder
+	escaped.WriteByte('/')
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if b < 0x21 || b > 0x7e || strings.IndexByte("()<>[]{}/%#", b) >= 0 {
+			fmt.Fprintf(&escaped, "#%02X", b)
+		} else {
+			escaped.WriteByte(b)
+		}
+	}
+	return escaped.String()
+}
+
+// pdfTextString writes text as a literal string when it is ASCII and as
+// UTF-16 otherwise
+func pdfTextString(text string) string {
+	ascii := true
+	for _, r := range text {
+		if r >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return pdfString([]byte(text))
+	}
+	var encoded strings.Builder
+	encoded.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&encoded, "%04X", unit)
+	}
+	encoded.WriteByte('>')
+	return encoded.String()
+}
+
+// pdfSyntax writes a value of qpdf's JSON back in PDF syntax
+func pdfSyntax(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		switch {
+		case referencePattern.MatchString(v):
+			return v
+		case strings.HasPrefix(v, "/"):
+			return pdfName(v[1:])
+		case strings.HasPrefix(v, "n:/"):
+			return pdfName(v[3:])
+		case strings.HasPrefix(v, "u:"):
+			return pdfTextString(v[2:])
+		case strings.HasPrefix(v, "b:"):
+			return "<" + v[2:] + ">"
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = pdfSyntax(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var dict strings.Builder
+		dict.WriteString("<<")
+		for _, key := range keys {
+			dict.WriteString(" " + pdfSyntax(key) + " " + pdfSyntax(v[key]))
+		}
+		dict.WriteString(" >>")
+		return dict.String()
+	}
+	return "null"
+}
@@ -0,0 +1,193 @@
+package pdf
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// redactionDPI is the resolution redacted pages are rendered at
+	redactionDPI = 200
+	// maxRedactionPixels bounds the rendering of large pages, which get a
+	// lower resolution
+	maxRedactionPixels = 25_000_000
+)
+
+// Redaction is an area of a page whose content is removed, in points from the
+// top left corner of the unrotated page like drawings
+type Redaction struct {
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// searchWord folds a word for matching a search term, ignoring case and the
+// punctuation around it
+func searchWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}))
+}
+
+// FindRedactions covers every occurrence of term in the PDF's text, a box
+// over each word of it. Terms of several words match them in reading order,
+// across lines; case and punctuation around words are ignored.
+func FindRedactions(path, term string, sizes []PageSize) ([]Redaction, error) {
+	var terms []string
+	for _, word := range strings.Fields(term) {
+		if folded := searchWord(word); folded != "" {
+			terms = append(terms, folded)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("the search term has no words")
+	}
+	words, err := Words(path, sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	redactions := []Redaction{}
+	for start := 0; start+len(terms) <= len(words); start++ {
+		match := true
+		for i, term := range terms {
+			word := words[start+i]
+			if word.Page != words[start].Page || searchWord(word.Text) != term {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		for _, word := range words[start : start+len(terms)] {
+			redactions = append(redactions, Redaction{Page: word.Page, X: word.X, Y: word.Y, Width: word.Width, Height: word.Height})
+		}
+	}
+	return redactions, nil
+}
+
+// Redact writes src to dst with the content under the redactions removed.
+// Each page with a redaction is replaced by an image of it with the areas
+// painted black, so no text, vector or image data is left underneath; the
+// page loses its text and links. Other pages are kept as they are.
+func Redact(src, dst string, sizes []PageSize, redactions []Redaction) error {
+	byPage := make(map[int][]Redaction)
+	for _, r := range redactions {
+		if r.Page < 1 || r.Page > len(sizes) {
+			return fmt.Errorf("page %d doesn't exist", r.Page)
+		}
+		byPage[r.Page] = append(byPage[r.Page], r)
+	}
+	pages := make([]int, 0, len(byPage))
+	for page := range byPage {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+
+	dir := filepath.Dir(dst)
+	redacted := make(map[int]string, len(pages))
+	for _, page := range pages {
+		path := filepath.Join(dir, "redacted-"+strconv.Itoa(page)+".pdf")
+		if err := redactPage(src, path, page, sizes[page-1], byPage[page]); err != nil {
+			return err
+		}
+		redacted[page] = path
+	}
+
+	// Pages are taken in order, from the redacted copies or runs of the original
+	var inputs []MergeInput
+	for page := 1; page <= len(sizes); page++ {
+		if path, ok := redacted[page]; ok {
+			inputs = append(inputs, MergeInput{Path: path})
+			continue
+		}
+		if last := len(inputs) - 1; last >= 0 && inputs[last].Path == src {
+			inputs[last].Ranges[0].To = page
+			continue
+		}
+		inputs = append(inputs, MergeInput{Path: src, Ranges: []PageRange{{From: page, To: page}}})
+	}
+	return Merge(inputs, dst)
+}
+
+// redactPage renders a page, paints its redactions and writes the image to dst
+// as a page of the same size and rotation
+func redactPage(src, dst string, page int, size PageSize, redactions []Redaction) error {
+	width, height := size.Unrotated()
+	dpi := float64(redactionDPI)
+	if pixels := width * height * dpi * dpi / (72 * 72); pixels > maxRedactionPixels {
+		dpi *= math.Sqrt(maxRedactionPixels / pixels)
+	}
+	rendered := filepath.Join(filepath.Dir(dst), "render-"+strconv.Itoa(page)+".png")
+	if err := RenderPage(src, rendered, page, int(dpi), FormatPNG); err != nil {
+		return err
+	}
+	defer os.Remove(rendered)
+	f, err := os.Open(rendered)
+	if err != nil {
+		return err
+	}
+	shown, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// The rendering shows the page turned; redactions are placed unrotated
+	img := unrotateImage(shown, size.Rotation)
+	bounds := img.Bounds()
+	scaleX, scaleY := float64(bounds.Dx())/width, float64(bounds.Dy())/height
+	for _, r := range redactions {
+		area := image.Rect(int(math.Floor(r.X*scaleX)), int(math.Floor(r.Y*scaleY)),
+			int(math.Ceil((r.X+r.Width)*scaleX)), int(math.Ceil((r.Y+r.Height)*scaleY)))
+		draw.Draw(img, area.Intersect(bounds), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+
+	stamp, err := newStampImage(img)
+	if err != nil {
+		return err
+	}
+	d := newStampDocument()
+	name := d.image(stamp)
+	d.addPage(size, fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm %s Do Q", width, height, name))
+	return d.write(dst)
+}
+
+// unrotateImage turns an image of a page shown with the rotation back to the
+// unrotated page
+func unrotateImage(shown image.Image, rotation int) *image.RGBA {
+	b := shown.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if rotation%180 != 0 {
+		w, h = h, w
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Where the unrotated pixel shows on the turned page
+			sx, sy := x, y
+			switch rotation {
+			case 90:
+				sx, sy = h-1-y, x
+			case 180:
+				sx, sy = w-1-x, h-1-y
+			case 270:
+				sx, sy = y, w-1-x
+			}
+			img.Set(x, y, shown.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return img
+}
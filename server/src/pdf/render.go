@@ -0,0 +1,37 @@
+package pdf
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Thumbnail renders the first page of src as a PNG whose longer side is size
+// pixels. dst must end in ".png", which pdftoppm appends to its output name.
+func Thumbnail(src, dst string, size int) error {
+	_, err := run("pdftoppm", "-png", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to", strconv.Itoa(size), src, strings.TrimSuffix(dst, ".png"))
+	return err
+}
+
+// Image formats pages can be rendered to
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+)
+
+// ImageExtension returns the file extension pdftoppm gives images of format
+func ImageExtension(format string) string {
+	if format == FormatJPEG {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// RenderPage renders one page of src, counted from 1, as an image of format at
+// the given resolution. dst must end in ImageExtension(format).
+func RenderPage(src, dst string, page, dpi int, format string) error {
+	number := strconv.Itoa(page)
+	_, err := run("pdftoppm", "-"+format, "-f", number, "-l", number, "-singlefile",
+		"-r", strconv.Itoa(dpi), src, strings.TrimSuffix(dst, ImageExtension(format)))
+	return err
+}
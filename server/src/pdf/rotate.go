@@ -0,0 +1,46 @@
+package pdf
+
+import "fmt"
+
+// pdftkRotations maps clockwise angles to pdftk's relative rotations
+var pdftkRotations = map[int]string{
+	90:  "right",
+	180: "down",
+	270: "left",
+}
+
+// ValidRotation reports whether pages can be rotated by angle degrees
+func ValidRotation(angle int) bool {
+	_, ok := pdftkRotations[angle]
+	return ok
+}
+
+// Rotate writes a copy of src to dst with the selected pages turned clockwise
+// by angle, which must be 90, 180 or 270. No selection rotates every page.
+func Rotate(src, dst string, pageCount int, selected map[int]bool, angle int) error {
+	rotation, ok := pdftkRotations[angle]
+	if !ok {
+		return fmt.Errorf("invalid rotation %d", angle)
+	}
+	if len(selected) == 0 {
+		_, err := run("pdftk", src, "cat", "1-end"+rotation, "output", dst)
+		return err
+	}
+
+	// Consecutive pages that are treated alike form one range
+	args := []string{src, "cat"}
+	for first := 1; first <= pageCount; {
+		last := first
+		for last < pageCount && selected[last+1] == selected[first] {
+			last++
+		}
+		part := fmt.Sprintf("%d-%d", first, last)
+		if selected[first] {
+			part += rotation
+		}
+		args = append(args, part)
+		first = last + 1
+	}
+	_, err := run("pdftk", append(args, "output", dst)...)
+	return err
+}
@@ -0,0 +1,259 @@
+package pdf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sheetCell is a box of a sheet a page is fitted into, in points from the
+// bottom left corner of the sheet
+type sheetCell struct {
+	// Page counts from 1; 0 leaves the cell blank
+	Page                int
+	X, Y, Width, Height float64
+}
+
+// sheet is a page of a document laid out from the pages of another, like a
+// side of a booklet. Content is drawn over the pages and may use Helvetica as
+// /F1 and Helvetica-Bold as /F2.
+type sheet struct {
+	Width, Height float64
+	Cells         []sheetCell
+	Content       string
+}
+
+// sheetFonts are the fonts sheet content may use
+var sheetFonts = fmt.Sprintf("/F1 << /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >> /F2 << /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>",
+	helvetica.base, helveticaBold.base)
+
+// pageMatrix returns the matrix drawing the crop box of a page upright, turned
+// by rotation degrees clockwise, with its bottom left corner at the origin,
+// and the size it then fills
+func pageMatrix(box [4]float64, rotation int) ([6]float64, float64, float64) {
+	x0, y0 := box[0], box[1]
+	width, height := box[2]-box[0], box[3]-box[1]
+	switch rotation {
+	case 90:
+		return [6]float64{0, -1, 1, 0, -y0, width + x0}, height, width
+	case 180:
+		return [6]float64{-1, 0, 0, -1, width + x0, height + y0}, width, height
+	case 270:
+		return [6]float64{0, 1, -1, 0, height + y0, -x0}, height, width
+	}
+	return [6]float64{1, 0, 0, 1, -x0, -y0}, width, height
+}
+
+// visibleBox is the crop box of a page, or its media box when it has none
+func visibleBox(page PageGeometry) [4]float64 {
+	if box := page.CropBox; box[2] > box[0] && box[3] > box[1] {
+		return box
+	}
+	return page.MediaBox
+}
+
+// fitPage returns the matrix fitting a page into a cell, centered and as
+// large as it gets. Pages are turned a quarter when they fill the cell better
+// that way, like a landscape drawing in a portrait cell.
+func fitPage(page PageGeometry, cell sheetCell) string {
+	box := visibleBox(page)
+	scale := func(width, height float64) float64 {
+		return math.Min(cell.Width/width, cell.Height/height)
+	}
+	m, width, height := pageMatrix(box, page.Rotation)
+	if turned, w, h := pageMatrix(box, (page.Rotation+90)%360); scale(w, h) > scale(width, height)*1.01 {
+		m, width, height = turned, w, h
+	}
+	s := scale(width, height)
+	tx := cell.X + (cell.Width-width*s)/2
+	ty := cell.Y + (cell.Height-height*s)/2
+	return fmt.Sprintf("%.5f %.5f %.5f %.5f %.3f %.3f cm", m[0]*s, m[1]*s, m[2]*s, m[3]*s, m[4]*s+tx, m[5]*s+ty)
+}
+
+// pageContents lists the references of the content streams of a page
+func pageContents(objects qpdfObjects, page map[string]interface{}) []string {
+	var refs []string
+	value := page["/Contents"]
+	if ref, ok := value.(string); ok && referencePattern.MatchString(ref) {
+		if object, found := objects["obj:"+ref]; found && object.Stream != nil {
+			return []string{ref}
+		}
+	}
+	for _, item := range objects.array(value) {
+		if ref, ok := item.(string); ok && referencePattern.MatchString(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// inheritedResources finds the resources of a page, which it may take from
+// the page tree above it
+func inheritedResources(objects qpdfObjects, page map[string]interface{}) interface{} {
+	for i := 0; page != nil && i < 64; i++ {
+		if resources, ok := page["/Resources"]; ok {
+			return resources
+		}
+		page = objects.dict(page["/Parent"])
+	}
+	return map[string]interface{}{}
+}
+
+// streamData reads the decoded data of streams with qpdf
+func streamData(path string, refs []string) (map[string][]byte, error) {
+	// Without objects named qpdf would write every one
+	if len(refs) == 0 {
+		return map[string][]byte{}, nil
+	}
+	args := []string{"--warning-exit-0", "--json=2", "--json-key=qpdf", "--json-stream-data=inline"}
+	for _, ref := range refs {
+		var id, gen int
+		fmt.Sscanf(ref, "%d %d", &id, &gen)
+		args = append(args, fmt.Sprintf("--json-object=%d,%d", id, gen))
+	}
+	out, err := run("qpdf", append(args, path)...)
+	if err != nil {
+		return nil, err
+	}
+	var doc qpdfDocument
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("reading qpdf output: %v", err)
+	}
+	if len(doc.QPDF) < 2 {
+		return nil, errors.New("qpdf output has no objects")
+	}
+	var objects qpdfObjects
+	if err := json.Unmarshal(doc.QPDF[1], &objects); err != nil {
+		return nil, fmt.Errorf("reading qpdf objects: %v", err)
+	}
+	data := make(map[string][]byte, len(refs))
+	for _, ref := range refs {
+		object, found := objects["obj:"+ref]
+		if !found || object.Stream == nil {
+			return nil, fmt.Errorf("content stream %s is missing", ref)
+		}
+		// qpdf leaves the filter in place of data it can't decode
+		if dict, _ := object.Stream.Dict.(map[string]interface{}); dict["/Filter"] != nil {
+			return nil, fmt.Errorf("content stream %s can't be decoded", ref)
+		}
+		data[ref] = object.Stream.Data
+	}
+	return data, nil
+}
+
+// writeSheets writes a document of sheets made of the pages of src to dst.
+// layout lays the sheets out given the pages of src. Annotations and form
+// fields are flattened into the pages first; bookmarks and other document
+// parts don't carry over.
+func writeSheets(src, dst string, layout func(pages []PageGeometry) ([]sheet, error)) error {
+	dir := filepath.Dir(dst)
+	flattened := filepath.Join(dir, "sheets-flattened.pdf")
+	defer os.Remove(flattened)
+	if _, err := run("qpdf", "--warning-exit-0", "--generate-appearances", "--flatten-annotations=print", src, flattened); err != nil {
+		return err
+	}
+
+	u, doc, objects, err := openUpdate(flattened)
+	if err != nil {
+		return err
+	}
+	pages, err := PageGeometries(flattened, len(doc.Pages))
+	if err != nil {
+		return err
+	}
+	if len(pages) != len(doc.Pages) {
+		return errors.New("the page boxes could not be read")
+	}
+	sheets, err := layout(pages)
+	if err != nil {
+		return err
+	}
+
+	// Each page placed becomes a form drawn on the sheets
+	used := make(map[int]bool)
+	var refs []string
+	for _, s := range sheets {
+		for _, cell := range s.Cells {
+			if cell.Page < 1 || cell.Page > len(pages) {
+				continue
+			}
+			if !used[cell.Page] {
+				used[cell.Page] = true
+				refs = append(refs, pageContents(objects, objects.dict(doc.Pages[cell.Page-1].Object))...)
+			}
+		}
+	}
+	data, err := streamData(flattened, refs)
+	if err != nil {
+		return err
+	}
+	forms := make(map[int]string, len(used))
+	for number := 1; number <= len(pages); number++ {
+		if !used[number] {
+			continue
+		}
+		page := objects.dict(doc.Pages[number-1].Object)
+		var content []byte
+		for _, ref := range pageContents(objects, page) {
+			content = append(append(content, data[ref]...), '\n')
+		}
+		compressed, err := deflate(content)
+		if err != nil {
+			return err
+		}
+		box := visibleBox(pages[number-1])
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [%.3f %.3f %.3f %.3f] /Resources %s /Filter /FlateDecode",
+			box[0], box[1], box[2], box[3], pdfSyntax(inheritedResources(objects, page)))
+		if group, ok := page["/Group"]; ok {
+			dict += " /Group " + pdfSyntax(group)
+		}
+		forms[number] = u.newRef()
+		u.stream(forms[number], dict, compressed)
+	}
+
+	pagesRef := u.newRef()
+	kids := make([]string, len(sheets))
+	for i, s := range sheets {
+		var content, xobjects strings.Builder
+		named := make(map[int]bool)
+		for _, cell := range s.Cells {
+			form, ok := forms[cell.Page]
+			if !ok {
+				continue
+			}
+			name := "/P" + strconv.Itoa(cell.Page)
+			if !named[cell.Page] {
+				named[cell.Page] = true
+				fmt.Fprintf(&xobjects, " %s %s", name, form)
+			}
+			fmt.Fprintf(&content, "q %s %s Do Q\n", fitPage(pages[cell.Page-1], cell), name)
+		}
+		content.WriteString(s.Content)
+		compressed, err := deflate([]byte(content.String()))
+		if err != nil {
+			return err
+		}
+		contentRef := u.newRef()
+		u.stream(contentRef, "/Filter /FlateDecode", compressed)
+		kids[i] = u.newRef()
+		u.object(kids[i], fmt.Sprintf("<< /Type /Page /Parent %s /MediaBox [0 0 %.2f %.2f] /Resources << /XObject <<%s >> /Font << %s >> >> /Contents %s >>",
+			pagesRef, s.Width, s.Height, xobjects.String(), sheetFonts, contentRef))
+	}
+	u.object(pagesRef, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	u.object(u.trailer["/Root"].(string), fmt.Sprintf("<< /Type /Catalog /Pages %s >>", pagesRef))
+	u.finish()
+
+	// Rewriting the update leaves out the pages nothing refers to anymore
+	updated := filepath.Join(dir, "sheets-updated.pdf")
+	defer os.Remove(updated)
+	if err := os.WriteFile(updated, u.buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	_, err = run("qpdf", "--warning-exit-0", "--object-streams=generate", updated, dst)
+	return err
+}
@@ -0,0 +1,353 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureReserve is the number of bytes kept for the CMS signature, enough
+// for a chain of a few RSA-4096 certificates
+const signatureReserve = 16384
+
+// byteRangePlaceholder is overwritten with the real byte range once the
+// offsets are known, which fit in the ten digits each
+const byteRangePlaceholder = "[0 0000000000 0000000000 0000000000]"
+
+var startXrefPattern = regexp.MustCompile(`startxref\s+(\d+)`)
+
+// Signature describes a digital signature added by Sign
+type Signature struct {
+	// Page shows the signature on a page, counted from 1; 0 signs without
+	// showing anything
+	Page int
+	// X, Y, Width and Height place the signature in points from the top left
+	// corner of the unrotated page, like drawings
+	X, Y, Width, Height float64
+	// Name of the signer, shown with the signature
+	Name     string
+	Reason   string
+	Location string
+	Time     time.Time
+}
+
+// incrementalUpdate appends objects to a PDF without changing its bytes, so
+// earlier signatures stay valid
+type incrementalUpdate struct {
+	buf     bytes.Buffer
+	nextID  int
+	offsets map[int]int
+	gens    map[int]int
+	// trailer is written again with the update; /Info may be changed
+	trailer map[string]interface{}
+	// prev is where the original cross reference section starts
+	prev       int
+	xrefStream bool
+}
+
+// openUpdate reads src to append an update to it, returning its pages and
+// objects as well. Encrypted documents are refused, since the objects added
+// would have to be encrypted too.
+func openUpdate(src string) (*incrementalUpdate, qpdfDocument, qpdfObjects, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, qpdfDocument{}, nil, err
+	}
+	doc, objects, err := loadObjects(src)
+	if err != nil {
+		return nil, doc, nil, err
+	}
+	trailer := objects.dict(objects["trailer"].Value)
+	if trailer == nil {
+		return nil, doc, nil, errors.New("the document has no trailer")
+	}
+	if trailer["/Encrypt"] != nil {
+		return nil, doc, nil, errors.New("encrypted documents can't be updated")
+	}
+	rootRef, _ := trailer["/Root"].(string)
+	if !referencePattern.MatchString(rootRef) || objects.dict(rootRef) == nil {
+		return nil, doc, nil, errors.New("the document has no catalog")
+	}
+	prev, xrefStream, err := lastXref(data)
+	if err != nil {
+		return nil, doc, nil, err
+	}
+
+	u := &incrementalUpdate{
+		nextID:     doc.Header.MaxObjectID + 1,
+		offsets:    make(map[int]int),
+		gens:       make(map[int]int),
+		trailer:    copyDict(trailer),
+		prev:       prev,
+		xrefStream: xrefStream,
+	}
+	if size, ok := objects.number(trailer["/Size"]); ok && int(size) > u.nextID {
+		u.nextID = int(size)
+	}
+	u.buf.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		u.buf.WriteByte('\n')
+	}
+	return u, doc, objects, nil
+}
+
+func (u *incrementalUpdate) reserve() int {
+	u.nextID++
+	return u.nextID - 1
+}
+
+// newRef reserves an object and returns a reference to it
+func (u *incrementalUpdate) newRef() string {
+	return fmt.Sprintf("%d 0 R", u.reserve())
+}
+
+// object writes an object and returns where it starts
+func (u *incrementalUpdate) object(ref string, body string) int {
+	var id, gen int
+	fmt.Sscanf(ref, "%d %d", &id, &gen)
+	start := u.buf.Len()
+	u.offsets[id], u.gens[id] = start, gen
+	fmt.Fprintf(&u.buf, "%d %d obj\n%s\nendobj\n", id, gen, body)
+	return start
+}
+
+func (u *incrementalUpdate) stream(ref string, dict string, data []byte) {
+	u.object(ref, fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data))
+}
+
+// finish writes the cross reference section, in the form the original uses
+func (u *incrementalUpdate) finish() {
+	// A cross reference stream lists itself, so it is numbered first
+	self := 0
+	if u.xrefStream {
+		self = u.reserve()
+	}
+	entries := fmt.Sprintf("/Size %d /Root %s /Prev %d", u.nextID, pdfSyntax(u.trailer["/Root"]), u.prev)
+	for _, key := range []string{"/Info", "/ID"} {
+		if value, ok := u.trailer[key]; ok {
+			entries += " " + key + " " + pdfSyntax(value)
+		}
+	}
+
+	start := u.buf.Len()
+	if u.xrefStream {
+		u.offsets[self], u.gens[self] = start, 0
+	}
+	ids := make([]int, 0, len(u.offsets))
+	for id := range u.offsets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	if !u.xrefStream {
+		u.buf.WriteString("xref\n")
+		for _, id := range ids {
+			fmt.Fprintf(&u.buf, "%d 1\n%010d %05d n \n", id, u.offsets[id], u.gens[id])
+		}
+		fmt.Fprintf(&u.buf, "trailer\n<< %s >>\nstartxref\n%d\n%%%%EOF\n", entries, start)
+		return
+	}
+	var index []string
+	var data []byte
+	for _, id := range ids {
+		index = append(index, fmt.Sprintf("%d 1", id))
+		entry := make([]byte, 7)
+		entry[0] = 1
+		binary.BigEndian.PutUint32(entry[1:5], uint32(u.offsets[id]))
+		binary.BigEndian.PutUint16(entry[5:], uint16(u.gens[id]))
+		data = append(data, entry...)
+	}
+	u.stream(fmt.Sprintf("%d 0 R", self), fmt.Sprintf("/Type /XRef %s /W [1 4 2] /Index [%s]", entries, strings.Join(index, " ")), data)
+	fmt.Fprintf(&u.buf, "startxref\n%d\n%%%%EOF\n", start)
+}
+
+// lastXref finds the cross reference section a PDF ends with and whether it
+// is a stream
+func lastXref(data []byte) (int, bool, error) {
+	tail := data[max(0, len(data)-2048):]
+	matches := startXrefPattern.FindAllSubmatch(tail, -1)
+	if matches == nil {
+		return 0, false, errors.New("no startxref found")
+	}
+	offset, err := strconv.Atoi(string(matches[len(matches)-1][1]))
+	if err != nil || offset >= len(data) {
+		return 0, false, errors.New("invalid startxref")
+	}
+	return offset, !bytes.HasPrefix(bytes.TrimLeft(data[offset:], " \t\r\n"), []byte("xref")), nil
+}
+
+// copyDict makes a shallow copy of a dictionary, so entries can be changed
+func copyDict(dict map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(dict)+2)
+	for key, value := range dict {
+		copied[key] = value
+	}
+	return copied
+}
+
+// signatureAppearance draws the visible part of a signature in a box of the
+// size it has on the displayed page
+func signatureAppearance(width, height float64, sig Signature) string {
+	lines := []string{"Digitally signed by " + sig.Name, "Date: " + sig.Time.UTC().Format("2006-01-02 15:04:05 UTC")}
+	if sig.Reason != "" {
+		lines = append(lines, "Reason: "+sig.Reason)
+	}
+	if sig.Location != "" {
+		lines = append(lines, "Location: "+sig.Location)
+	}
+	const inset = 4.0
+	fontSize := math.Min(10, (height-2*inset)/(float64(len(lines))*1.2))
+	for _, line := range lines {
+		if lineWidth := helvetica.width(line); lineWidth*fontSize > width-2*inset {
+			fontSize = (width - 2*inset) / lineWidth
+		}
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "q 0.94 0.96 1 rg 0 0 %.2f %.2f re f 0.2 0.3 0.6 RG 1 w 0.5 0.5 %.2f %.2f re S Q\n",
+		width, height, width-1, height-1)
+	if fontSize < 1 {
+		return content.String()
+	}
+	content.WriteString("BT 0.1 0.15 0.35 rg\n")
+	y := height - inset - fontSize
+	for _, line := range lines {
+		encoded, _ := helvetica.encode(line)
+		fmt.Fprintf(&content, "/Helv %.2f Tf 1 0 0 1 %.2f %.2f Tm %s Tj\n", fontSize, inset, y, pdfString(encoded))
+		y -= fontSize * 1.2
+	}
+	content.WriteString("ET")
+	return content.String()
+}
+
+// appearanceMatrix turns an appearance drawn upright into the unrotated page
+// space, so it reads upright on a page displayed with its rotation
+func appearanceMatrix(rotation int, width, height float64) string {
+	switch rotation {
+	case 90:
+		return fmt.Sprintf("[0 1 -1 0 %.2f 0]", height)
+	case 180:
+		return fmt.Sprintf("[-1 0 0 -1 %.2f %.2f]", width, height)
+	case 270:
+		return fmt.Sprintf("[0 -1 1 0 0 %.2f]", width)
+	}
+	return "[1 0 0 1 0 0]"
+}
+
+// Sign writes src to dst with a PAdES signature made by signer, whose
+// certificate starts chain. The signature is added as an incremental update,
+// leaving the signed bytes of src, and any signatures it already has, intact.
+func Sign(src, dst string, signer crypto.Signer, chain []*x509.Certificate, sig Signature) error {
+	u, doc, objects, err := openUpdate(src)
+	if err != nil {
+		return err
+	}
+	if sig.Page < 0 || sig.Page > len(doc.Pages) {
+		return fmt.Errorf("page %d is out of range", sig.Page)
+	}
+	rootRef := u.trailer["/Root"].(string)
+	root := objects.dict(rootRef)
+
+	pageRef := doc.Pages[max(sig.Page, 1)-1].Object
+	page := copyDict(objects.dict(pageRef))
+	rect := "[0 0 0 0]"
+	appearance := ""
+	if sig.Page > 0 {
+		info, err := Info(src)
+		if err != nil {
+			return err
+		}
+		if sig.Page > len(info.PageSizes) {
+			return fmt.Errorf("page %d is out of range", sig.Page)
+		}
+		size := info.PageSizes[sig.Page-1]
+		_, pageHeight := size.Unrotated()
+		rect = fmt.Sprintf("[%.2f %.2f %.2f %.2f]", sig.X, pageHeight-sig.Y-sig.Height, sig.X+sig.Width, pageHeight-sig.Y)
+		// The appearance is drawn as the page is displayed, and turned back
+		// by its matrix
+		width, height := sig.Width, sig.Height
+		if size.Rotation == 90 || size.Rotation == 270 {
+			width, height = height, width
+		}
+		appearanceRef := u.newRef()
+		u.stream(appearanceRef, fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %.2f %.2f] /Matrix %s /Resources << /Font << /Helv << /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >> >> >>",
+			width, height, appearanceMatrix(size.Rotation, width, height)), []byte(signatureAppearance(width, height, sig)))
+		appearance = fmt.Sprintf(" /AP << /N %s >>", appearanceRef)
+	}
+
+	// The signature dictionary, with room for the signature and its byte range
+	sigRef := u.newRef()
+	sigDict := fmt.Sprintf("<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached /ByteRange %s /Contents <%s> /M %s",
+		byteRangePlaceholder, strings.Repeat("0", 2*signatureReserve), pdfString([]byte(sig.Time.UTC().Format("D:20060102150405Z"))))
+	if sig.Name != "" {
+		sigDict += " /Name " + pdfTextString(sig.Name)
+	}
+	if sig.Reason != "" {
+		sigDict += " /Reason " + pdfTextString(sig.Reason)
+	}
+	if sig.Location != "" {
+		sigDict += " /Location " + pdfTextString(sig.Location)
+	}
+	sigStart := u.object(sigRef, sigDict+" >>")
+
+	// The form gets a field for the signature, whose widget sits on the page
+	var form map[string]interface{}
+	formRef, formIsRef := root["/AcroForm"].(string)
+	formIsRef = formIsRef && referencePattern.MatchString(formRef)
+	form = copyDict(objects.dict(root["/AcroForm"]))
+	fields := append([]interface{}(nil), objects.array(form["/Fields"])...)
+	signatures := 0
+	for _, field := range fields {
+		if objects.name(objects.dict(field)["/FT"]) == "Sig" {
+			signatures++
+		}
+	}
+	fieldRef := u.newRef()
+	u.object(fieldRef, fmt.Sprintf("<< /Type /Annot /Subtype /Widget /FT /Sig /T %s /V %s /F 132 /Rect %s /P %s%s >>",
+		pdfString([]byte(fmt.Sprintf("Signature%d", signatures+1))), sigRef, rect, pageRef, appearance))
+	form["/Fields"] = append(fields, fieldRef)
+	form["/SigFlags"] = float64(3)
+	if formIsRef {
+		u.object(formRef, pdfSyntax(form))
+	} else {
+		root = copyDict(root)
+		root["/AcroForm"] = form
+		u.object(rootRef, pdfSyntax(root))
+	}
+	page["/Annots"] = append(append([]interface{}(nil), objects.array(page["/Annots"])...), fieldRef)
+	u.object(pageRef, pdfSyntax(page))
+	u.finish()
+
+	// The signature covers everything but its own hex string
+	signed := u.buf.Bytes()
+	contentsStart := sigStart + bytes.Index(signed[sigStart:], []byte("/Contents <")) + len("/Contents ")
+	contentsEnd := contentsStart + 2*signatureReserve + 2
+	byteRange := fmt.Sprintf("[0 %010d %010d %010d]", contentsStart, contentsEnd, len(signed)-contentsEnd)
+	byteRangeStart := sigStart + bytes.Index(signed[sigStart:], []byte(byteRangePlaceholder))
+	copy(signed[byteRangeStart:], byteRange)
+
+	digest := sha256.New()
+	digest.Write(signed[:contentsStart])
+	digest.Write(signed[contentsEnd:])
+	cms, err := signCMS(digest.Sum(nil), signer, chain)
+	if err != nil {
+		return fmt.Errorf("signing failed: %v", err)
+	}
+	if len(cms) > signatureReserve {
+		return fmt.Errorf("the signature of %d bytes doesn't fit the %d bytes reserved", len(cms), signatureReserve)
+	}
+	hex.Encode(signed[contentsStart+1:], cms)
+	return os.WriteFile(dst, signed, 0o644)
+}
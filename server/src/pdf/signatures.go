@@ -0,0 +1,111 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SignatureInfo describes a digital signature found in a PDF
+type SignatureInfo struct {
+	Field      string     `json:"field"`
+	SignerName string     `json:"signerName"`
+	SignerDN   string     `json:"signerDn"`
+	SignedAt   *time.Time `json:"signedAt"`
+	Hash       string     `json:"hashAlgorithm"`
+	Type       string     `json:"type"`
+	// WholeDocument is false when the document was changed after signing,
+	// in a later revision the signature doesn't cover
+	WholeDocument bool `json:"wholeDocument"`
+	// SignatureStatus is valid when the signed bytes are unchanged; otherwise
+	// invalid, digestMismatch, corrupted, notVerified or unknown
+	SignatureStatus string `json:"signatureStatus"`
+	// CertificateStatus is trusted, untrustedIssuer, unknownIssuer, revoked,
+	// expired, notVerified or unknown
+	CertificateStatus string `json:"certificateStatus"`
+}
+
+var signatureStatuses = map[string]string{
+	"Signature is Valid.":                      "valid",
+	"Signature is Invalid.":                    "invalid",
+	"Digest Mismatch.":                         "digestMismatch",
+	"Document isn't signed or corrupted data.": "corrupted",
+	"Signature has not yet been verified.":     "notVerified",
+}
+
+var certificateStatuses = map[string]string{
+	"Certificate is Trusted.":                "trusted",
+	"Certificate issuer isn't Trusted.":      "untrustedIssuer",
+	"Certificate issuer is unknown.":         "unknownIssuer",
+	"Certificate has been Revoked.":          "revoked",
+	"Certificate has Expired":                "expired",
+	"Certificate has not yet been verified.": "notVerified",
+}
+
+func lookupStatus(statuses map[string]string, text string) string {
+	if value, ok := statuses[strings.TrimSpace(text)]; ok {
+		return value
+	}
+	return "unknown"
+}
+
+// Signatures lists the digital signatures of the PDF at path and checks them
+// with pdfsig. Certificates are checked against poppler's NSS database, which
+// trusts nothing unless certificates were added to it.
+func Signatures(path string) ([]SignatureInfo, error) {
+	out, exit, err := runStatus("pdfsig", path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(out, []byte("does not contain any signatures")) {
+		return []SignatureInfo{}, nil
+	}
+	if exit != 0 {
+		return nil, fmt.Errorf("pdfsig failed with status %d", exit)
+	}
+
+	signatures := []SignatureInfo{}
+	var current *SignatureInfo
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Signature #") {
+			signatures = append(signatures, SignatureInfo{SignatureStatus: "unknown", CertificateStatus: "unknown"})
+			current = &signatures[len(signatures)-1]
+			continue
+		}
+		entry, found := strings.CutPrefix(strings.TrimSpace(line), "- ")
+		if current == nil || !found {
+			continue
+		}
+		if entry == "Total document signed" {
+			current.WholeDocument = true
+			continue
+		}
+		key, value, _ := strings.Cut(entry, ": ")
+		switch key {
+		case "Signature Field Name":
+			current.Field = value
+		case "Signer Certificate Common Name":
+			current.SignerName = value
+		case "Signer full Distinguished Name":
+			current.SignerDN = value
+		case "Signing Time":
+			// pdfsig writes the time in the local time zone
+			if at, err := time.ParseInLocation("Jan 02 2006 15:04:05", value, time.Local); err == nil {
+				current.SignedAt = &at
+			}
+		case "Signing Hash Algorithm":
+			current.Hash = value
+		case "Signature Type":
+			current.Type = value
+		case "Signature Validation":
+			current.SignatureStatus = lookupStatus(signatureStatuses, value)
+		case "Certificate Validation":
+			current.CertificateStatus = lookupStatus(certificateStatuses, value)
+		}
+	}
+	return signatures, scanner.Err()
+}
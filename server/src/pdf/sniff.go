@@ -0,0 +1,41 @@
+package pdf
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// headerWindow is how far into a file readers look for the PDF header; the
+// specification lets it follow up to 1024 bytes of other data
+const headerWindow = 1024
+
+// IsPDF reports whether the file starts with a PDF header
+func IsPDF(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, headerWindow+len("%PDF-"))
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return HasHeader(head[:n]), nil
+}
+
+// HasHeader reports whether the start of a file contains a PDF header
+func HasHeader(head []byte) bool {
+	if len(head) > headerWindow+len("%PDF-") {
+		head = head[:headerWindow+len("%PDF-")]
+	}
+	return bytes.Contains(head, []byte("%PDF-"))
+}
+
+// RulesOutPDF reports whether the first bytes of a partially received file are
+// enough to tell it isn't a PDF
+func RulesOutPDF(head []byte) bool {
+	return len(head) >= headerWindow+len("%PDF-") && !HasHeader(head)
+}
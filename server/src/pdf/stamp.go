@@ -0,0 +1,136 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// stampMargin keeps stamps away from the page edges, in points
+const stampMargin = 36
+
+// Stamp positions, naming the page edges a stamp is placed at
+var stampPositions = map[string][2]float64{
+	"top-left":     {0, 1},
+	"top":          {0.5, 1},
+	"top-right":    {1, 1},
+	"left":         {0, 0.5},
+	"center":       {0.5, 0.5},
+	"right":        {1, 0.5},
+	"bottom-left":  {0, 0},
+	"bottom":       {0.5, 0},
+	"bottom-right": {1, 0},
+}
+
+// ValidStampPosition reports whether position names a place for a stamp
+func ValidStampPosition(position string) bool {
+	_, ok := stampPositions[position]
+	return ok
+}
+
+// Watermark is text or an image stamped on pages
+type Watermark struct {
+	// Text is written in Helvetica Bold; characters outside Latin-1 print as "?"
+	Text string
+	// FontSize in points; 0 picks 72 in the center and 24 elsewhere
+	FontSize float64
+	// Color of the text, red, green and blue from 0 to 1
+	Color [3]float64
+	// Image is the path of a PNG or JPEG stamped instead of text
+	Image string
+	// Width of the image in points; 0 keeps its size at 96 dpi, up to half the page
+	Width float64
+	// Position is one of center, top, bottom, left, right and the corners
+	// like top-left
+	Position string
+	// Opacity from 0 to 1
+	Opacity float64
+	// Rotation in degrees counterclockwise, around the stamp's center
+	Rotation float64
+}
+
+// placement returns the matrix that draws a box of the given size at the
+// position on a page, rotated around its center
+func placement(page PageSize, width, height float64, mark Watermark) string {
+	anchor := stampPositions[mark.Position]
+	// The box touches the margin on the sides it is placed at
+	cx := stampMargin + width/2 + anchor[0]*(page.Width-2*stampMargin-width)
+	cy := stampMargin + height/2 + anchor[1]*(page.Height-2*stampMargin-height)
+	angle := mark.Rotation * math.Pi / 180
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	// Translate to the center, rotate, then move the box's corner to the origin
+	return fmt.Sprintf("%.4f %.4f %.4f %.4f %.2f %.2f cm", cos, sin, -sin, cos,
+		cx-cos*width/2+sin*height/2, cy-sin*width/2-cos*height/2)
+}
+
+// WriteStamp writes a PDF with one page for each of the sizes, carrying the
+// watermark on the selected pages and nothing on the others. No selection
+// marks every page.
+func WriteStamp(dst string, sizes []PageSize, selected map[int]bool, mark Watermark) error {
+	if _, ok := stampPositions[mark.Position]; !ok {
+		return fmt.Errorf("invalid position %q", mark.Position)
+	}
+
+	doc := newStampDocument()
+	var img stampImage
+	var imageName string
+	if mark.Image != "" {
+		data, err := os.ReadFile(mark.Image)
+		if err != nil {
+			return err
+		}
+		if img, err = decodeStampImage(data); err != nil {
+			return err
+		}
+		imageName = doc.image(img)
+	}
+	text, textWidth := helveticaBold.encode(mark.Text)
+
+	for i, size := range sizes {
+		if len(selected) > 0 && !selected[i+1] {
+			doc.addPage(size, "")
+			continue
+		}
+
+		var content string
+		if imageName != "" {
+			width := mark.Width
+			if width == 0 {
+				width = math.Min(float64(img.width)*0.75, size.Width/2)
+			}
+			height := width * float64(img.height) / float64(img.width)
+			content = fmt.Sprintf("%s %.2f 0 0 %.2f 0 0 cm %s Do",
+				placement(size, width, height, mark), width, height, imageName)
+		} else {
+			fontSize := mark.FontSize
+			if fontSize == 0 {
+				fontSize = 24
+				if mark.Position == "center" {
+					fontSize = 72
+				}
+			}
+			// Shrink text that wouldn't fit the page
+			room := size.Width - 2*stampMargin
+			if mark.Rotation != 0 {
+				room = math.Max(room, math.Hypot(size.Width, size.Height)*0.8)
+			}
+			if textWidth*fontSize > room && textWidth > 0 {
+				fontSize = room / textWidth
+			}
+			// Capitals reach about 0.72 of the font size above the baseline
+			height := fontSize * 0.72
+			content = fmt.Sprintf("%s %.3f %.3f %.3f rg BT %s %.2f Tf 0 0 Td %s Tj ET",
+				placement(size, textWidth*fontSize, height, mark),
+				mark.Color[0], mark.Color[1], mark.Color[2], doc.font(helveticaBold), fontSize, pdfString(text))
+		}
+		doc.addPage(size, fmt.Sprintf("q %s %s %s Q", visibleSpace(size), doc.opacity(mark.Opacity), content))
+	}
+	return doc.write(dst)
+}
+
+// StampPages writes src to dst with each page of stamp, as written by
+// WriteStamp, drawn over the page of src with the same number
+func StampPages(src, stamp, dst string) error {
+	_, err := run("pdftk", src, "multistamp", stamp, "output", dst)
+	return err
+}
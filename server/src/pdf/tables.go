@@ -0,0 +1,215 @@
+package pdf
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Area is a rectangle of a page in points from the top left corner of the
+// unrotated page, like drawings
+type Area struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// contains tells whether the center of the box lies in the area
+func (a Area) contains(x, y, width, height float64) bool {
+	cx, cy := x+width/2, y+height/2
+	return cx >= a.X && cx <= a.X+a.Width && cy >= a.Y && cy <= a.Y+a.Height
+}
+
+// Table is a table found on a page, like a quantity schedule of a drawing
+type Table struct {
+	// Area bounds the table's text
+	Area
+	Columns int `json:"columns"`
+	// Rows hold one cell for each column, empty where the row has no text
+	Rows [][]string `json:"rows"`
+}
+
+// tableCell is a run of words of a line with no wide gap between them
+type tableCell struct {
+	text        []string
+	left, right float64
+}
+
+// tableLine is a line of a page split into cells
+type tableLine struct {
+	top, bottom float64
+	cells       []tableCell
+}
+
+func (l tableLine) height() float64 {
+	return l.bottom - l.top
+}
+
+// splitLines groups words into lines of text, each split into cells where
+// the words are further apart than a wide space
+func splitLines(words []Word) []tableLine {
+	sort.SliceStable(words, func(i, j int) bool { return words[i].Y < words[j].Y })
+	var lines []tableLine
+	var lineWords [][]Word
+	for _, word := range words {
+		center := word.Y + word.Height/2
+		if n := len(lines) - 1; n >= 0 && center >= lines[n].top && center <= lines[n].bottom {
+			lines[n].bottom = math.Max(lines[n].bottom, word.Y+word.Height)
+			lineWords[n] = append(lineWords[n], word)
+			continue
+		}
+		lines = append(lines, tableLine{top: word.Y, bottom: word.Y + word.Height})
+		lineWords = append(lineWords, []Word{word})
+	}
+
+	for i := range lines {
+		words := lineWords[i]
+		sort.SliceStable(words, func(a, b int) bool { return words[a].X < words[b].X })
+		// A space is about a quarter of the font size, columns are set wider apart
+		gap := math.Max(lines[i].height()*0.7, 4)
+		for _, word := range words {
+			cells := lines[i].cells
+			if n := len(cells) - 1; n >= 0 && word.X-cells[n].right <= gap {
+				cells[n].text = append(cells[n].text, word.Text)
+				cells[n].right = math.Max(cells[n].right, word.X+word.Width)
+				continue
+			}
+			lines[i].cells = append(cells, tableCell{text: []string{word.Text}, left: word.X, right: word.X + word.Width})
+		}
+	}
+	return lines
+}
+
+// tableColumns finds the columns of a table from its rows with the usual
+// number of cells, merging cells that overlap
+func tableColumns(rows []tableLine) [][2]float64 {
+	counts := make(map[int]int)
+	usual := 0
+	for _, row := range rows {
+		n := len(row.cells)
+		counts[n]++
+		if counts[n] > counts[usual] || (counts[n] == counts[usual] && n > usual) {
+			usual = n
+		}
+	}
+	var spans [][2]float64
+	for _, row := range rows {
+		if len(row.cells) != usual {
+			continue
+		}
+		for _, cell := range row.cells {
+			spans = append(spans, [2]float64{cell.left, cell.right})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var columns [][2]float64
+	for _, span := range spans {
+		if n := len(columns) - 1; n >= 0 && span[0] <= columns[n][1] {
+			columns[n][1] = math.Max(columns[n][1], span[1])
+			continue
+		}
+		columns = append(columns, span)
+	}
+	return columns
+}
+
+// nearestColumn is the column a cell overlaps the most, or the closest one
+func nearestColumn(columns [][2]float64, cell tableCell) int {
+	best, bestScore := 0, math.Inf(-1)
+	for i, column := range columns {
+		// Overlap is positive, the distance between them negative
+		score := math.Min(cell.right, column[1]) - math.Max(cell.left, column[0])
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// buildTable lays the rows out in columns
+func buildTable(rows []tableLine) (Table, bool) {
+	columns := tableColumns(rows)
+	if len(columns) < 2 {
+		return Table{}, false
+	}
+	table := Table{Columns: len(columns), Rows: make([][]string, len(rows))}
+	left, right := math.Inf(1), math.Inf(-1)
+	for i, row := range rows {
+		cells := make([][]string, len(columns))
+		for _, cell := range row.cells {
+			column := nearestColumn(columns, cell)
+			cells[column] = append(cells[column], cell.text...)
+			left, right = math.Min(left, cell.left), math.Max(right, cell.right)
+		}
+		table.Rows[i] = make([]string, len(columns))
+		for j, text := range cells {
+			table.Rows[i][j] = strings.Join(text, " ")
+		}
+	}
+	table.Area = Area{X: left, Y: rows[0].top, Width: right - left, Height: rows[len(rows)-1].bottom - rows[0].top}
+	return table, true
+}
+
+// detectTables finds runs of lines split into several cells that follow each
+// other closely. A line of one cell set tightly under a row continues that
+// row's text, like a description wrapping in its cell.
+func detectTables(lines []tableLine) []Table {
+	tables := []Table{}
+	var rows []tableLine
+	flush := func() {
+		if len(rows) >= 2 {
+			if table, ok := buildTable(rows); ok {
+				tables = append(tables, table)
+			}
+		}
+		rows = nil
+	}
+	for _, line := range lines {
+		var spacing float64
+		if n := len(rows) - 1; n >= 0 {
+			spacing = line.top - rows[n].bottom
+		}
+		switch {
+		case len(line.cells) >= 2:
+			if len(rows) > 0 && spacing > 2*math.Max(line.height(), rows[len(rows)-1].height()) {
+				flush()
+			}
+			rows = append(rows, line)
+		case len(rows) > 0 && spacing < 0.5*line.height():
+			last := &rows[len(rows)-1]
+			last.cells = append(last.cells, line.cells...)
+			last.bottom = line.bottom
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tables
+}
+
+// FindTables detects the tables of a page from the way its words line up in
+// rows and columns. size is the page's size, to undo page rotation, and area
+// limits the search to part of the page when it isn't nil.
+func FindTables(path string, page int, size PageSize, area *Area) ([]Table, error) {
+	words, err := displayedWords(path, page, page)
+	if err != nil {
+		return nil, err
+	}
+	if area != nil {
+		kept := words[:0]
+		for _, word := range words {
+			if area.contains(unrotateBox(size, word.X, word.Y, word.X+word.Width, word.Y+word.Height)) {
+				kept = append(kept, word)
+			}
+		}
+		words = kept
+	}
+
+	tables := detectTables(splitLines(words))
+	for i, table := range tables {
+		a := table.Area
+		tables[i].X, tables[i].Y, tables[i].Width, tables[i].Height = unrotateBox(size, a.X, a.Y, a.X+a.Width, a.Y+a.Height)
+	}
+	return tables, nil
+}
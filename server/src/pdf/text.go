@@ -0,0 +1,124 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// wordPattern matches a word of pdftotext's bounding box output
+var wordPattern = regexp.MustCompile(`<word xMin="([\d.]+)" yMin="([\d.]+)" xMax="([\d.]+)" yMax="([\d.]+)">(.*)</word>`)
+
+// ExtractText reads the text of every page of a PDF, in reading order. Pages
+// without text are empty strings.
+func ExtractText(path string) ([]string, error) {
+	output, err := run("pdftotext", "-enc", "UTF-8", path, "-")
+	if err != nil {
+		return nil, err
+	}
+	// pdftotext ends every page with a form feed
+	pages := strings.Split(string(output), "\f")
+	if len(pages) > 1 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages, nil
+}
+
+// Word is a word of a page's text with its bounding box, in points from the
+// top left corner of the unrotated page like drawings
+type Word struct {
+	Page   int     `json:"page"`
+	Text   string  `json:"text"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// unrotateBox turns a box on the displayed page, which pdftotext measures, into
+// the unrotated page of the given size
+func unrotateBox(size PageSize, xMin, yMin, xMax, yMax float64) (x, y, width, height float64) {
+	w, h := size.Unrotated()
+	switch size.Rotation {
+	case 90:
+		xMin, yMin, xMax, yMax = yMin, h-xMax, yMax, h-xMin
+	case 180:
+		xMin, yMin, xMax, yMax = w-xMax, h-yMax, w-xMin, h-yMin
+	case 270:
+		xMin, yMin, xMax, yMax = w-yMax, xMin, w-yMin, xMax
+	}
+	return xMin, yMin, xMax - xMin, yMax - yMin
+}
+
+// displayedWords reads the words of the pages first to last, or of every page
+// when first is 0, with their boxes as the page is displayed
+func displayedWords(path string, first, last int) ([]Word, error) {
+	args := []string{"-enc", "UTF-8", "-bbox"}
+	page := 0
+	if first > 0 {
+		args = append(args, "-f", strconv.Itoa(first), "-l", strconv.Itoa(last))
+		page = first - 1
+	}
+	output, err := run("pdftotext", append(args, path, "-")...)
+	if err != nil {
+		return nil, err
+	}
+	words := []Word{}
+	started := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "<page ") {
+			page++
+			started = true
+			continue
+		}
+		m := wordPattern.FindStringSubmatch(line)
+		if m == nil || !started {
+			continue
+		}
+		var box [4]float64
+		for i := range box {
+			box[i], _ = strconv.ParseFloat(m[i+1], 64)
+		}
+		words = append(words, Word{
+			Page: page, Text: html.UnescapeString(m[5]),
+			X: box[0], Y: box[1], Width: box[2] - box[0], Height: box[3] - box[1],
+		})
+	}
+	return words, scanner.Err()
+}
+
+// PageWords reads the words of one page like Words. size is the page's size.
+func PageWords(path string, page int, size PageSize) ([]Word, error) {
+	words, err := displayedWords(path, page, page)
+	if err != nil {
+		return nil, err
+	}
+	for i, word := range words {
+		words[i].X, words[i].Y, words[i].Width, words[i].Height = unrotateBox(size, word.X, word.Y, word.X+word.Width, word.Y+word.Height)
+	}
+	return words, nil
+}
+
+// Words reads the words of every page of a PDF in reading order, with the
+// boxes they fill. sizes are the document's page sizes, to undo page rotation.
+func Words(path string, sizes []PageSize) ([]Word, error) {
+	displayed, err := displayedWords(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]Word, 0, len(displayed))
+	for _, word := range displayed {
+		if word.Page > len(sizes) {
+			continue
+		}
+		word.X, word.Y, word.Width, word.Height = unrotateBox(sizes[word.Page-1], word.X, word.Y, word.X+word.Width, word.Y+word.Height)
+		words = append(words, word)
+	}
+	return words, nil
+}
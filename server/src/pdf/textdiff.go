@@ -0,0 +1,118 @@
+package pdf
+
+import "strings"
+
+// maxDiffCells bounds the table compared for one page, lines of one revision
+// times lines of the other; larger pages are reported as replaced
+const maxDiffCells = 4_000_000
+
+// TextChange is a passage of consecutive lines added or removed on a page
+type TextChange struct {
+	// Type is "added" or "removed"
+	Type string `json:"type"`
+	// Line is the first line of the passage in its revision, counted from 1
+	// among the page's lines with text
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// PageDiff lists the changes of a page, counted from 1
+type PageDiff struct {
+	Page    int          `json:"page"`
+	Changes []TextChange `json:"changes"`
+}
+
+// textLines splits the text of a page into lines with their spacing
+// normalized, dropping empty ones, so layout changes don't show as edits
+func textLines(page string) []string {
+	var lines []string
+	for _, line := range strings.Split(page, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// DiffText compares the text of two revisions page by page, as ExtractText
+// returns it, and lists the pages whose text changed. Pages are matched by
+// number, so pages only one revision has are all added or removed.
+func DiffText(from, to []string) []PageDiff {
+	diffs := []PageDiff{}
+	for i := 0; i < max(len(from), len(to)); i++ {
+		var before, after []string
+		if i < len(from) {
+			before = textLines(from[i])
+		}
+		if i < len(to) {
+			after = textLines(to[i])
+		}
+		if changes := diffLines(before, after); len(changes) > 0 {
+			diffs = append(diffs, PageDiff{Page: i + 1, Changes: changes})
+		}
+	}
+	return diffs
+}
+
+// diffLines finds the passages removed from before and added in after along
+// their longest common subsequence of lines
+func diffLines(before, after []string) []TextChange {
+	// Lines the revisions start and end with alike need no table
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	a, b := before[prefix:len(before)-suffix], after[prefix:len(after)-suffix]
+
+	var changes []TextChange
+	add := func(kind string, line int, text string) {
+		if last := len(changes) - 1; last >= 0 && changes[last].Type == kind &&
+			changes[last].Line+strings.Count(changes[last].Text, "\n")+1 == line {
+			changes[last].Text += "\n" + text
+			return
+		}
+		changes = append(changes, TextChange{Type: kind, Line: line, Text: text})
+	}
+	if len(a)*len(b) > maxDiffCells {
+		for i, line := range a {
+			add("removed", prefix+i+1, line)
+		}
+		for j, line := range b {
+			add("added", prefix+j+1, line)
+		}
+		return changes
+	}
+
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			add("removed", prefix+i+1, a[i])
+			i++
+		default:
+			add("added", prefix+j+1, b[j])
+			j++
+		}
+	}
+	return changes
+}
@@ -0,0 +1,61 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// toolTimeout bounds how long a single external PDF tool may run
+const toolTimeout = 2 * time.Minute
+
+// run executes an external PDF tool and returns its standard output
+func run(name string, args ...string) ([]byte, error) {
+	return runWithTimeout(toolTimeout, name, args...)
+}
+
+// exitStatus runs a tool that answers with its exit status
+func exitStatus(name string, args ...string) (int, error) {
+	_, status, err := runStatus(name, args...)
+	return status, err
+}
+
+// runStatus runs a tool whose output matters whatever its exit status, and
+// returns both
+func runStatus(name string, args ...string) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), toolTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return stdout.Bytes(), exitErr.ExitCode(), nil
+	}
+	return stdout.Bytes(), 0, err
+}
+
+// runWithTimeout executes a tool that may take longer than toolTimeout
+func runWithTimeout(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %s", name, timeout)
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
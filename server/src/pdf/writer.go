@@ -0,0 +1,310 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+)
+
+// maxStampImagePixels bounds the images that are decoded for a stamp
+const maxStampImagePixels = 25_000_000
+
+// stampFont is one of the standard fonts every PDF reader has
+type stampFont struct {
+	base string
+	// widths of the printable ASCII characters, in thousandths of the font size
+	widths *[95]int
+}
+
+var (
+	helvetica = stampFont{base: "Helvetica", widths: &[95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}}
+	helveticaBold = stampFont{base: "Helvetica-Bold", widths: &[95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}}
+)
+
+// encode converts text for the font, where the Latin-1 range matches
+// WinAnsiEncoding and other characters print as "?", and returns its width at
+// a font size of 1
+func (f stampFont) encode(text string) ([]byte, float64) {
+	encoded := make([]byte, 0, len(text))
+	width := 0
+	for _, r := range text {
+		if r > 255 || r < 32 || r >= 127 && r < 160 {
+			r = '?'
+		}
+		encoded = append(encoded, byte(r))
+		if r < 127 {
+			width += f.widths[r-32]
+		} else {
+			width += 556
+		}
+	}
+	return encoded, float64(width) / 1000
+}
+
+// width measures text at a font size of 1
+func (f stampFont) width(text string) float64 {
+	_, width := f.encode(text)
+	return width
+}
+
+// pdfString writes bytes as a PDF literal string
+func pdfString(value []byte) string {
+	var escaped strings.Builder
+	escaped.WriteByte('(')
+	for _, b := range value {
+		if b == '\\' || b == '(' || b == ')' {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(b)
+	}
+	escaped.WriteByte(')')
+	return escaped.String()
+}
+
+// stampImage holds an image decoded for a stamp as PDF image streams
+type stampImage struct {
+	width, height int
+	rgb           []byte
+	// alpha is nil for opaque images
+	alpha []byte
+	// dct is the color space of rgb holding JPEG data as it is, and empty
+	// for flate compressed samples
+	dct string
+}
+
+// decodeStampImage decodes a PNG or JPEG into compressed RGB and alpha samples
+func decodeStampImage(data []byte) (stampImage, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return stampImage{}, fmt.Errorf("unsupported image: %v", err)
+	}
+	if config.Width*config.Height > maxStampImagePixels {
+		return stampImage{}, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return stampImage{}, fmt.Errorf("unsupported image: %v", err)
+	}
+	return newStampImage(img)
+}
+
+// newStampImage compresses the RGB and alpha samples of a decoded image
+func newStampImage(img image.Image) (stampImage, error) {
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Samples are stored without premultiplied alpha
+			if a > 0 && a < 0xffff {
+				r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			}
+			rgb = append(rgb, byte(r>>8), byte(g>>8), byte(b>>8))
+			alpha = append(alpha, byte(a>>8))
+			opaque = opaque && a == 0xffff
+		}
+	}
+
+	var err error
+	stamp := stampImage{width: bounds.Dx(), height: bounds.Dy()}
+	if stamp.rgb, err = deflate(rgb); err != nil {
+		return stamp, err
+	}
+	if !opaque {
+		if stamp.alpha, err = deflate(alpha); err != nil {
+			return stamp, err
+		}
+	}
+	return stamp, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// pdfWriter numbers objects and records their offsets for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func newPDFWriter() *pdfWriter {
+	w := &pdfWriter{}
+	// The binary comment marks the file as binary for transfer tools
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	return w
+}
+
+// reserve allocates the number of an object written later
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *pdfWriter) object(id int, body string) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *pdfWriter) stream(id int, dict string, data []byte) {
+	w.offsets[id-1] = w.buf.Len()
+	if dict != "" {
+		dict += " "
+	}
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s/Length %d >>\nstream\n", id, dict, len(data))
+	w.buf.Write(data)
+	fmt.Fprintf(&w.buf, "\nendstream\nendobj\n")
+}
+
+func (w *pdfWriter) finish(root int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, root, xref)
+	return w.buf.Bytes()
+}
+
+// stampDocument builds a PDF whose pages are drawn over the pages of another
+// one by StampPages. The pages share one resource dictionary.
+type stampDocument struct {
+	w         *pdfWriter
+	catalog   int
+	pages     int
+	resources int
+	kids      []string
+	states    map[float64]string
+	fonts     map[string]string
+	images    []string
+	// Resource entries like "/GS1 5 0 R"
+	stateEntries, fontEntries []string
+}
+
+func newStampDocument() *stampDocument {
+	w := newPDFWriter()
+	return &stampDocument{
+		w:         w,
+		catalog:   w.reserve(),
+		pages:     w.reserve(),
+		resources: w.reserve(),
+		states:    make(map[float64]string),
+		fonts:     make(map[string]string),
+	}
+}
+
+// opacity returns the operator that sets stroke and fill opacity
+func (d *stampDocument) opacity(alpha float64) string {
+	name, ok := d.states[alpha]
+	if !ok {
+		id := d.w.reserve()
+		d.w.object(id, fmt.Sprintf("<< /Type /ExtGState /ca %.3f /CA %.3f >>", alpha, alpha))
+		name = fmt.Sprintf("/GS%d", len(d.states)+1)
+		d.states[alpha] = name
+		d.stateEntries = append(d.stateEntries, fmt.Sprintf("%s %d 0 R", name, id))
+	}
+	return name + " gs"
+}
+
+// font returns the resource name of a standard font
+func (d *stampDocument) font(font stampFont) string {
+	name, ok := d.fonts[font.base]
+	if !ok {
+		id := d.w.reserve()
+		d.w.object(id, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.base))
+		name = fmt.Sprintf("/F%d", len(d.fonts)+1)
+		d.fonts[font.base] = name
+		d.fontEntries = append(d.fontEntries, fmt.Sprintf("%s %d 0 R", name, id))
+	}
+	return name
+}
+
+// image adds an image and returns its resource name
+func (d *stampDocument) image(img stampImage) string {
+	id := d.w.reserve()
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		img.width, img.height)
+	if img.dct != "" {
+		dict = fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, img.dct)
+	}
+	if img.alpha != nil {
+		mask := d.w.reserve()
+		d.w.stream(mask, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+			img.width, img.height), img.alpha)
+		dict += fmt.Sprintf(" /SMask %d 0 R", mask)
+	}
+	d.w.stream(id, dict, img.rgb)
+	name := fmt.Sprintf("/Im%d", len(d.images)+1)
+	d.images = append(d.images, fmt.Sprintf("%s %d 0 R", name, id))
+	return name
+}
+
+// addPage adds a page of the size, including its rotation, so it lines up with
+// the page it is drawn over. content draws in the unrotated page space.
+func (d *stampDocument) addPage(size PageSize, content string) {
+	width, height := size.Unrotated()
+	contents, page := d.w.reserve(), d.w.reserve()
+	data, err := deflate([]byte(content))
+	if err == nil {
+		d.w.stream(contents, "/Filter /FlateDecode", data)
+	} else {
+		d.w.stream(contents, "", []byte(content))
+	}
+	d.w.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Rotate %d /Resources %d 0 R /Contents %d 0 R >>",
+		d.pages, width, height, size.Rotation, d.resources, contents))
+	d.kids = append(d.kids, fmt.Sprintf("%d 0 R", page))
+}
+
+// write finishes the document and writes it to dst
+func (d *stampDocument) write(dst string) error {
+	d.w.object(d.resources, fmt.Sprintf("<< /ExtGState << %s >> /Font << %s >> /XObject << %s >> >>",
+		strings.Join(d.stateEntries, " "), strings.Join(d.fontEntries, " "), strings.Join(d.images, " ")))
+	d.w.object(d.pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(d.kids, " "), len(d.kids)))
+	d.w.object(d.catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", d.pages))
+	return os.WriteFile(dst, d.w.finish(d.catalog), 0o644)
+}
+
+// visibleSpace returns the matrix that lets content be drawn in the space of
+// the page as it is displayed, with its rotation applied
+func visibleSpace(size PageSize) string {
+	width, height := size.Unrotated()
+	switch size.Rotation {
+	case 90:
+		return fmt.Sprintf("0 1 -1 0 %.2f 0 cm", width)
+	case 180:
+		return fmt.Sprintf("-1 0 0 -1 %.2f %.2f cm", width, height)
+	case 270:
+		return fmt.Sprintf("0 -1 1 0 0 %.2f cm", height)
+	}
+	return ""
+}
@@ -0,0 +1,367 @@
+package pdf
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnnotationPrefix starts the names of annotations exported from drawings,
+	// followed by the drawing's ID
+	AnnotationPrefix = "pdf-factory-"
+	// markupTextHeight is the height given to underlined and crossed out text,
+	// whose drawings only hold the line, in points
+	markupTextHeight = 12
+)
+
+type xfdfDocument struct {
+	XMLName xml.Name   `xml:"http://ns.adobe.com/xfdf/ xfdf"`
+	File    *xfdfFile  `xml:"f,omitempty"`
+	Annots  xfdfAnnots `xml:"annots"`
+}
+
+type xfdfFile struct {
+	Href string `xml:"href,attr"`
+}
+
+type xfdfAnnots struct {
+	Items []xfdfAnnot `xml:",any"`
+}
+
+type xfdfInkList struct {
+	// Gestures are strokes of points like "x,y;x,y"
+	Gestures []string `xml:"gesture"`
+}
+
+// xfdfAnnot holds the attributes of every kind of annotation, named by the
+// element
+type xfdfAnnot struct {
+	XMLName       xml.Name
+	Page          int    `xml:"page,attr"`
+	Rect          string `xml:"rect,attr"`
+	Name          string `xml:"name,attr,omitempty"`
+	Date          string `xml:"date,attr,omitempty"`
+	Color         string `xml:"color,attr,omitempty"`
+	InteriorColor string `xml:"interior-color,attr,omitempty"`
+	Opacity       string `xml:"opacity,attr,omitempty"`
+	Width         string `xml:"width,attr,omitempty"`
+	Intent        string `xml:"intent,attr,omitempty"`
+	Icon          string `xml:"icon,attr,omitempty"`
+	// Coords are the quadrilaterals of text markups
+	Coords string `xml:"coords,attr,omitempty"`
+	// Start, End, Head and Tail are the ends of lines and their line endings
+	Start   string `xml:"start,attr,omitempty"`
+	End     string `xml:"end,attr,omitempty"`
+	Head    string `xml:"head,attr,omitempty"`
+	Tail    string `xml:"tail,attr,omitempty"`
+	Caption string `xml:"caption,attr,omitempty"`
+	// Callout and Fringe place the text box of a callout within its rect
+	Callout           string       `xml:"callout,attr,omitempty"`
+	Fringe            string       `xml:"fringe,attr,omitempty"`
+	Contents          string       `xml:"contents,omitempty"`
+	DefaultAppearance string       `xml:"defaultappearance,omitempty"`
+	DefaultStyle      string       `xml:"defaultstyle,omitempty"`
+	InkList           *xfdfInkList `xml:"inklist,omitempty"`
+	// Vertices are the points of polygons and polylines like "x,y;x,y"
+	Vertices string `xml:"vertices,omitempty"`
+}
+
+// formatNumber writes coordinates with two decimals and no trailing zeros
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+// hexColor writes a CSS color as #RRGGBB
+func hexColor(value string) string {
+	rgb := parseColor(value)
+	return fmt.Sprintf("#%02X%02X%02X", int(math.Round(rgb[0]*255)), int(math.Round(rgb[1]*255)), int(math.Round(rgb[2]*255)))
+}
+
+// annotationWriter converts the drawings of a page to annotations
+type annotationWriter struct {
+	annots []xfdfAnnot
+	// page is zero based like in XFDF
+	page   int
+	height float64
+	name   string
+	date   string
+	parts  int
+}
+
+// coord converts a point from the viewer's space to PDF space, which has its
+// origin in the bottom left corner
+func (w *annotationWriter) coord(p point) string {
+	return formatNumber(p.X) + "," + formatNumber(w.height-p.Y)
+}
+
+// add appends an annotation enclosing the points with margin to spare. The
+// parts of a drawing are named by the drawing and a number.
+func (w *annotationWriter) add(element string, margin float64, points ...point) *xfdfAnnot {
+	left, top, right, bottom := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		left, right = math.Min(left, p.X), math.Max(right, p.X)
+		top, bottom = math.Min(top, p.Y), math.Max(bottom, p.Y)
+	}
+	w.parts++
+	name := w.name
+	if w.parts > 1 {
+		name = fmt.Sprintf("%s-%d", w.name, w.parts)
+	}
+	w.annots = append(w.annots, xfdfAnnot{
+		XMLName: xml.Name{Local: element},
+		Page:    w.page,
+		Rect: strings.Join([]string{formatNumber(left - margin), formatNumber(w.height - bottom - margin),
+			formatNumber(right + margin), formatNumber(w.height - top + margin)}, ","),
+		Name: name,
+		Date: w.date,
+	})
+	return &w.annots[len(w.annots)-1]
+}
+
+// stroke sets the color, width and opacity of the annotation from style
+func (a *xfdfAnnot) stroke(style drawingStyle) {
+	a.Color = hexColor(style.StrokeColor)
+	a.Width = formatNumber(style.StrokeWidth)
+	if style.opacity() < 1 {
+		a.Opacity = formatNumber(style.opacity())
+	}
+}
+
+// quad writes the corners of a box as a text markup quadrilateral: top left,
+// top right, bottom left, bottom right
+func (w *annotationWriter) quad(left, top, right, bottom float64) string {
+	return strings.Join([]string{w.coord(point{left, top}), w.coord(point{right, top}),
+		w.coord(point{left, bottom}), w.coord(point{right, bottom})}, ",")
+}
+
+// write converts one drawing and reports whether it has an equivalent
+// annotation
+func (w *annotationWriter) write(shapes drawingShapes) bool {
+	switch shapes.Type {
+	case "freehand":
+		for i, path := range shapes.Paths {
+			if len(path) < 2 {
+				continue
+			}
+			style := shapes.Style
+			if i < len(shapes.PathStyles) {
+				style = shapes.PathStyles[i]
+			}
+			coords := make([]string, len(path))
+			for j, p := range path {
+				coords[j] = w.coord(p)
+			}
+			ink := w.add("ink", style.StrokeWidth/2, path...)
+			ink.stroke(style)
+			ink.InkList = &xfdfInkList{Gestures: []string{strings.Join(coords, ";")}}
+		}
+	case "line":
+		for i, line := range shapes.Lines {
+			start, end, ok := line.ends()
+			if !ok {
+				continue
+			}
+			style := shapes.Style
+			if i < len(shapes.LineStyles) {
+				style = shapes.LineStyles[i]
+			}
+			annot := w.add("line", style.StrokeWidth/2, start, end)
+			annot.stroke(style)
+			annot.Start, annot.End = w.coord(start), w.coord(end)
+		}
+	case "textUnderline", "textCrossedOut":
+		element, above := "underline", float64(markupTextHeight)
+		if shapes.Type == "textCrossedOut" {
+			element, above = "strikeout", markupTextHeight/2
+		}
+		var coords []string
+		var corners []point
+		for _, line := range shapes.Lines {
+			start, end, ok := line.ends()
+			if !ok {
+				continue
+			}
+			left, right := math.Min(start.X, end.X), math.Max(start.X, end.X)
+			y := (start.Y + end.Y) / 2
+			top, bottom := y-above, y-above+markupTextHeight
+			coords = append(coords, w.quad(left, top, right, bottom))
+			corners = append(corners, point{left, top}, point{right, bottom})
+		}
+		if len(coords) == 0 {
+			return true
+		}
+		annot := w.add(element, 0, corners...)
+		annot.stroke(shapes.Style)
+		annot.Width = ""
+		annot.Coords = strings.Join(coords, ",")
+		annot.Contents = shapes.Text
+	case "textHighlight":
+		var coords []string
+		var corners []point
+		for _, r := range shapes.Rects {
+			coords = append(coords, w.quad(r.X, r.Y, r.X+r.Width, r.Y+r.Height))
+			corners = append(corners, point{r.X, r.Y}, point{r.X + r.Width, r.Y + r.Height})
+		}
+		if len(coords) == 0 {
+			return true
+		}
+		opacity := 0.5
+		if shapes.Opacity != nil {
+			opacity = *shapes.Opacity
+		} else if shapes.Style.Opacity != nil {
+			opacity = *shapes.Style.Opacity
+		}
+		annot := w.add("highlight", 0, corners...)
+		annot.Color = hexColor(shapes.Style.StrokeColor)
+		annot.Opacity = formatNumber(opacity)
+		annot.Coords = strings.Join(coords, ",")
+		annot.Contents = shapes.Text
+	case "rectangle":
+		annot := w.add("square", 0, shapes.StartPoint, shapes.EndPoint)
+		annot.stroke(shapes.Style)
+	case "drawArea", "rectSelection":
+		// The viewer fills selections with their color at 30% opacity
+		annot := w.add("square", 0, shapes.StartPoint, shapes.EndPoint)
+		annot.stroke(shapes.Style)
+		annot.InteriorColor = annot.Color
+		annot.Opacity = "0.3"
+	case "textArea":
+		fontSize := shapes.FontSize
+		if fontSize == 0 {
+			fontSize = textAreaFontSize
+		}
+		color := hexColor(shapes.Style.StrokeColor)
+		rgb := parseColor(shapes.Style.StrokeColor)
+		annot := w.add("freetext", 0, shapes.StartPoint, shapes.EndPoint)
+		annot.Width = formatNumber(shapes.Style.StrokeWidth)
+		if shapes.Style.opacity() < 1 {
+			annot.Opacity = formatNumber(shapes.Style.opacity())
+		}
+		annot.Contents = shapes.Text
+		annot.DefaultAppearance = fmt.Sprintf("/Helv %s Tf %.3f %.3f %.3f rg", formatNumber(fontSize), rgb[0], rgb[1], rgb[2])
+		annot.DefaultStyle = fmt.Sprintf("font: Helvetica %spt; color: %s", formatNumber(fontSize), color)
+	case "pinSelection":
+		color := shapes.Color
+		if color == "" {
+			color = "#FF0000"
+		}
+		// A note icon stands on the pinned point
+		p := shapes.Position
+		annot := w.add("text", 0, point{p.X, p.Y - pinSize}, point{p.X + pinSize, p.Y})
+		annot.Color = hexColor(color)
+		annot.Icon = "Comment"
+	case "extensionLine":
+		layout := layoutExtensionLine(shapes)
+		points := []point{shapes.Position, layout.bend, layout.tailEnd}
+		if len(layout.lines) > 0 {
+			points = append(points, layout.box[0], layout.box[1])
+		}
+		annot := w.add("freetext", 0, points...)
+		annot.Intent = "FreeTextCallout"
+		annot.Color = hexColor(shapes.Color)
+		annot.Width = "1.5"
+		annot.Head = "OpenArrow"
+		annot.Callout = strings.Join([]string{w.coord(shapes.Position), w.coord(layout.bend), w.coord(layout.tailEnd)}, ",")
+		annot.Contents = shapes.Text
+		rgb := parseColor(shapes.Color)
+		annot.DefaultAppearance = fmt.Sprintf("/HeBo %d Tf %.3f %.3f %.3f rg", extensionFontSize, rgb[0], rgb[1], rgb[2])
+		if len(layout.lines) > 0 {
+			// The fringe insets the text box from the rect: left, bottom, right, top
+			left, top := math.Inf(1), math.Inf(1)
+			right, bottom := math.Inf(-1), math.Inf(-1)
+			for _, p := range points {
+				left, right = math.Min(left, p.X), math.Max(right, p.X)
+				top, bottom = math.Min(top, p.Y), math.Max(bottom, p.Y)
+			}
+			box := layout.box
+			annot.Fringe = strings.Join([]string{formatNumber(box[0].X - left), formatNumber(bottom - box[1].Y),
+				formatNumber(right - box[1].X), formatNumber(box[0].Y - top)}, ",")
+		}
+	case "rulers":
+		for _, r := range shapes.Rulers {
+			annot := w.add("line", rulerLineWidth, r.StartPoint, r.EndPoint)
+			annot.Color = hexColor(r.Color)
+			annot.Width = strconv.Itoa(rulerLineWidth)
+			annot.Start, annot.End = w.coord(r.StartPoint), w.coord(r.EndPoint)
+			annot.Head, annot.Tail = "OpenArrow", "OpenArrow"
+			annot.Intent = "LineDimension"
+			annot.Caption = "yes"
+			annot.Contents = rulerLabel(r, shapes)
+		}
+	default:
+		// Images have no annotation that other readers draw without an
+		// appearance stream
+		return false
+	}
+	return true
+}
+
+// WriteXFDF writes the drawings as annotations of an XFDF document, which
+// Acrobat and Bluebeam import into the PDF named by filename. sizes are the
+// pages of that PDF. Drawings that can't be converted, like images, or whose
+// data can't be read are skipped and counted.
+func WriteXFDF(w io.Writer, filename string, sizes []PageSize, overlays []Overlay) (int, error) {
+	doc := xfdfDocument{}
+	if filename != "" {
+		doc.File = &xfdfFile{Href: filename}
+	}
+
+	skipped := 0
+	for _, overlay := range overlays {
+		if overlay.Page < 1 || overlay.Page > len(sizes) {
+			skipped++
+			continue
+		}
+		_, height := sizes[overlay.Page-1].Unrotated()
+		writer := &annotationWriter{
+			page:   overlay.Page - 1,
+			height: height,
+			name:   fmt.Sprintf("%s%d", AnnotationPrefix, overlay.ID),
+		}
+		if !overlay.Modified.IsZero() {
+			writer.date = overlay.Modified.UTC().Format("D:20060102150405Z")
+		}
+
+		var shapes drawingShapes
+		if err := json.Unmarshal([]byte(overlay.Data), &shapes); err != nil {
+			skipped++
+			continue
+		}
+		shapes.Type = overlay.Type
+		converted := true
+		if overlay.Type == "misc" {
+			var misc miscShapes
+			if err := json.Unmarshal([]byte(overlay.Data), &misc); err != nil {
+				skipped++
+				continue
+			}
+			for _, group := range [][]drawingShapes{misc.Pathes, misc.Rectangles, misc.Lines, misc.TextAreas,
+				misc.Images, misc.ExtensionLines, misc.Rulers} {
+				for _, part := range group {
+					converted = writer.write(part) && converted
+				}
+			}
+		} else {
+			converted = writer.write(shapes)
+		}
+		if !converted {
+			skipped++
+		}
+		doc.Annots.Items = append(doc.Annots.Items, writer.annots...)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return skipped, err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return skipped, err
+	}
+	return skipped, encoder.Close()
+}
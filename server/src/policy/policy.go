@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"pdfsrv/src/models"
+)
+
+// Rule sets the minimum role for requests matching a path pattern and methods.
+// Patterns are split on "/"; "*" matches one segment and a trailing "**"
+// matches the path above it and anything below. Segments are compared ignoring
+// case, as the router matches routes.
+type Rule struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+	Role    string   `json:"role"`
+}
+
+// Policy is an ordered list of rules; the first matching rule decides and
+// requests no rule matches are denied
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Default - The built-in authorization: viewers may read, editors may create
+// and update, admins may delete and use the admin routes. The exceptions:
+//   - self-service routes, stars and comments are open to every role
+//   - resumable uploads need the editor role throughout
+//   - archives are read with a POST, so viewers may make them
+//   - editors may delete layers, drawing groups, folders and templates
+func Default() *Policy {
+	all := []string{"*"}
+	return &Policy{Rules: []Rule{
+		{Path: "/api/admin/**", Methods: all, Role: models.RoleAdmin},
+		{Path: "/api/audit", Methods: all, Role: models.RoleAdmin},
+		{Path: "/api/auth/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/sessions/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/api-keys/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/workspaces/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/groups", Methods: []string{"POST"}, Role: models.RoleEditor},
+		{Path: "/api/groups/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/permissions/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/group-permissions/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/share/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/guest-tokens/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/star", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/comments/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/drawings/*/comments/**", Methods: all, Role: models.RoleViewer},
+		{Path: "/api/files/*/layers/**", Methods: []string{"DELETE"}, Role: models.RoleEditor},
+		{Path: "/api/files/*/drawing-groups/*", Methods: []string{"DELETE"}, Role: models.RoleEditor},
+		{Path: "/api/folders/**", Methods: []string{"DELETE"}, Role: models.RoleEditor},
+		{Path: "/api/templates/**", Methods: []string{"DELETE"}, Role: models.RoleEditor},
+		{Path: "/api/uploads/**", Methods: all, Role: models.RoleEditor},
+		{Path: "/api/files/archive", Methods: []string{"POST"}, Role: models.RoleViewer},
+		{Path: "/api/**", Methods: []string{"GET", "HEAD", "OPTIONS"}, Role: models.RoleViewer},
+		{Path: "/api/**", Methods: []string{"DELETE"}, Role: models.RoleAdmin},
+		{Path: "/api/**", Methods: all, Role: models.RoleEditor},
+	}}
+}
+
+// Load reads a policy from a JSON file of the form {"rules": [...]}
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %v", err)
+	}
+	for i, rule := range p.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("rule %d: path must start with /", i)
+		}
+		if len(rule.Methods) == 0 {
+			return nil, fmt.Errorf("rule %d: at least one method is required", i)
+		}
+		if !models.ValidRole(rule.Role) {
+			return nil, fmt.Errorf("rule %d: unknown role %q", i, rule.Role)
+		}
+	}
+	return &p, nil
+}
+
+// FromEnv loads the policy file named by AUTH_POLICY_FILE, or the default policy
+func FromEnv() (*Policy, error) {
+	path := os.Getenv("AUTH_POLICY_FILE")
+	if path == "" {
+		return Default(), nil
+	}
+	return Load(path)
+}
+
+// Allows reports whether the role may call the method on the path
+func (p *Policy) Allows(role, method, path string) bool {
+	for _, rule := range p.Rules {
+		if rule.matches(method, path) {
+			return models.RoleAtLeast(role, rule.Role)
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(method, path string) bool {
+	methodMatches := false
+	for _, m := range r.Methods {
+		if m == "*" || strings.EqualFold(m, method) {
+			methodMatches = true
+			break
+		}
+	}
+	return methodMatches && matchPath(r.Path, path)
+}
+
+// matchPath matches a request path against a rule pattern
+func matchPath(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, part := range patternParts {
+		if part == "**" && i == len(patternParts)-1 {
+			return true
+		}
+		if i >= len(pathParts) {
+			return false
+		}
+		if part != "*" && !strings.EqualFold(part, pathParts[i]) {
+			return false
+		}
+	}
+	return len(patternParts) == len(pathParts)
+}
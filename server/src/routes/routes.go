@@ -2,6 +2,8 @@ package routes
 
 import (
 	"pdfsrv/src/controllers"
+	"pdfsrv/src/middleware"
+	"pdfsrv/src/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -9,18 +11,226 @@ import (
 func SetupRoutes(app *fiber.App) {
 	api := app.Group("/api")
 
+	// Rate limits per route group, overridable with RATE_LIMIT_<GROUP> env vars
+	authLimit := middleware.RateLimit("auth", "10/1m")
+	uploadLimit := middleware.RateLimit("upload", "30/1m")
+	bulkLimit := middleware.RateLimit("bulk", "60/1m")
+	shareLimit := middleware.RateLimit("share", "30/1m")
+	apiLimit := middleware.RateLimit("api", "600/1m")
+
+	// Public auth routes
+	api.Post("/auth/login", authLimit, controllers.Login)
+	api.Post("/auth/register", authLimit, controllers.Register)
+	api.Post("/auth/refresh", authLimit, controllers.RefreshSession)
+	api.Post("/auth/2fa/verify", authLimit, controllers.VerifyTwoFactor)
+	api.Post("/auth/password/forgot", authLimit, controllers.ForgotPassword)
+	api.Post("/auth/password/reset", authLimit, controllers.ResetPassword)
+	api.Get("/auth/invitations/:token", authLimit, controllers.GetInvitation)
+	api.Post("/auth/invitations/accept", authLimit, controllers.AcceptInvitation)
+	api.Get("/auth/oidc/login", controllers.OIDCLogin)
+	api.Get("/auth/oidc/callback", controllers.OIDCCallback)
+
+	// Public share links
+	api.Get("/share/:token", shareLimit, controllers.DownloadSharedFile)
+
+	// Everything registered below requires a valid token and a role the
+	// authorization policy allows, see policy.Default for the built-in rules
+	api.Use(middleware.RequireAuth, apiLimit, middleware.Authorize())
+	api.Get("/auth/me", controllers.GetCurrentUser)
+	api.Get("/auth/me/storage", controllers.GetCurrentUserStorage)
+	api.Post("/auth/logout", controllers.Logout)
+	api.Post("/auth/2fa/setup", controllers.SetupTwoFactor)
+	api.Post("/auth/2fa/enable", controllers.EnableTwoFactor)
+	api.Post("/auth/2fa/disable", controllers.DisableTwoFactor)
+	api.Post("/auth/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+
+	// Sessions belong to the caller, so any role may list and revoke its own
+	api.Get("/sessions", controllers.GetSessions)
+	api.Delete("/sessions/:id", controllers.RevokeSession)
+
+	// API keys belong to the caller, so any role may manage its own
+	api.Get("/api-keys", controllers.GetApiKeys)
+	api.Post("/api-keys", controllers.CreateApiKey)
+	api.Delete("/api-keys/:id", controllers.RevokeApiKey)
+
+	// Workspaces the caller belongs to
+	api.Get("/workspaces", controllers.GetWorkspaces)
+	api.Post("/workspaces/:id/switch", controllers.SwitchWorkspace)
+
+	// Admin routes, registered before workspace resolution as they span all
+	// workspaces. They need an admin whatever the policy says.
+	admin := api.Group("/admin", middleware.RequireRole(models.RoleAdmin))
+	admin.Get("/diagnostics", controllers.GetDiagnostics)
+	admin.Post("/storage/gc", controllers.CollectStorageGarbage)
+	admin.Get("/users", controllers.GetUsers)
+	admin.Get("/users/:id", controllers.GetUser)
+	admin.Put("/users/:id/role", controllers.ChangeUserRole)
+	admin.Post("/users/:id/disable", controllers.DisableUser)
+	admin.Post("/users/:id/enable", controllers.EnableUser)
+	admin.Put("/users/:id/password", controllers.SetUserPassword)
+	admin.Put("/users/:id/quota", controllers.SetUserQuota)
+	admin.Post("/users/:id/logout", controllers.ForceLogoutUser)
+	admin.Post("/workspaces", controllers.CreateWorkspace)
+	admin.Get("/workspaces/:id/members", controllers.GetWorkspaceMembers)
+	admin.Post("/workspaces/:id/members", controllers.AddWorkspaceMember)
+	admin.Delete("/workspaces/:id/members/:userId", controllers.RemoveWorkspaceMember)
+	admin.Get("/invitations", controllers.GetInvitations)
+	admin.Post("/invitations", controllers.CreateInvitation)
+	admin.Delete("/invitations/:id", controllers.RevokeInvitation)
+	api.Get("/audit", middleware.RequireRole(models.RoleAdmin), controllers.GetAuditEntries)
+
+	// Everything registered below only sees data of the resolved workspace
+	api.Use(middleware.ResolveWorkspace)
+
+	// Sharing is managed by the file owner or writers, whatever their role
+	api.Get("/files/:id/permissions", controllers.GetFilePermissions)
+	api.Put("/files/:id/permissions", controllers.SetFilePermission)
+	api.Delete("/files/:id/permissions/:userId", controllers.RevokeFilePermission)
+	api.Get("/files/:id/group-permissions", controllers.GetFileGroupPermissions)
+	api.Put("/files/:id/group-permissions", controllers.SetFileGroupPermission)
+	api.Delete("/files/:id/group-permissions/:groupId", controllers.RevokeFileGroupPermission)
+	api.Get("/files/:id/share", controllers.GetShareLinks)
+	api.Post("/files/:id/share", controllers.CreateShareLink)
+	api.Delete("/files/:id/share/:linkId", controllers.RevokeShareLink)
+	api.Get("/files/:id/guest-tokens", controllers.GetGuestTokens)
+	api.Post("/files/:id/guest-tokens", controllers.CreateGuestToken)
+	api.Delete("/files/:id/guest-tokens/:tokenId", controllers.RevokeGuestToken)
+
+	// Groups are managed by their creator, whatever their role
+	api.Get("/groups", controllers.GetGroups)
+	api.Post("/groups", controllers.CreateGroup)
+	api.Delete("/groups/:id", controllers.DeleteGroup)
+	api.Get("/groups/:id/members", controllers.GetGroupMembers)
+	api.Post("/groups/:id/members", controllers.AddGroupMember)
+	api.Delete("/groups/:id/members/:userId", controllers.RemoveGroupMember)
+
+	// Folders are changed by their creator or an admin
+	api.Get("/folders", controllers.GetFolders)
+	api.Post("/folders", controllers.CreateFolder)
+	api.Put("/folders/:id", controllers.UpdateFolder)
+	api.Delete("/folders/:id", controllers.DeleteFolder)
+
+	// HTML templates are changed by their creator or an admin
+	api.Get("/templates", controllers.GetTemplates)
+	api.Post("/templates", controllers.CreateTemplate)
+	api.Get("/templates/:id", controllers.GetTemplate)
+	api.Put("/templates/:id", controllers.UpdateTemplate)
+	api.Delete("/templates/:id", controllers.DeleteTemplate)
+
 	// File routes
-	api.Post("/upload", controllers.UploadFile)
+	api.Post("/upload", uploadLimit, controllers.UploadFile)
+	api.Options("/uploads", controllers.GetUploadOptions)
+	api.Post("/uploads", uploadLimit, controllers.CreateUpload)
+	api.Head("/uploads/:id", controllers.GetUploadOffset)
+	api.Patch("/uploads/:id", controllers.PatchUpload)
+	api.Delete("/uploads/:id", controllers.DeleteUpload)
+	api.Get("/conversions/:id", controllers.GetConversionJob)
 	api.Get("/files", controllers.GetFilesList)
+	api.Delete("/files", controllers.DeleteFiles)
+	api.Get("/files/search", controllers.SearchFiles)
+	api.Get("/search", controllers.SearchText)
+	api.Post("/files/archive", controllers.DownloadArchive)
+	api.Post("/files/import", uploadLimit, controllers.ImportFile)
+	api.Put("/files/:id", controllers.UpdateFile)
 	api.Delete("/files/:id", controllers.DeleteFile)
+	api.Post("/files/:id/copy", controllers.CopyFile)
+	api.Post("/files/:id/move", controllers.MoveFile)
+	api.Post("/files/:id/lock", controllers.LockFile)
+	api.Post("/files/:id/unlock", controllers.UnlockFile)
+	api.Put("/files/:id/expiry", controllers.SetFileExpiry)
+	api.Post("/files/:id/star", controllers.StarFile)
+	api.Delete("/files/:id/star", controllers.UnstarFile)
+	api.Get("/files/:id/accesses", controllers.GetFileAccesses)
+	api.Get("/files/:id/comments", controllers.GetFileComments)
+	api.Post("/files/:id/comments", controllers.CreateFileComment)
+	api.Put("/files/:id/comments/:commentId", controllers.UpdateFileComment)
+	api.Delete("/files/:id/comments/:commentId", controllers.DeleteFileComment)
+	api.Get("/files/:id/layers", controllers.GetLayers)
+	api.Post("/files/:id/layers", controllers.CreateLayer)
+	api.Put("/files/:id/layers/:layerId", controllers.UpdateLayer)
+	api.Delete("/files/:id/layers/:layerId", controllers.DeleteLayer)
+	api.Get("/files/:id/drawing-groups", controllers.GetDrawingGroups)
+	api.Post("/files/:id/drawing-groups", controllers.CreateDrawingGroup)
+	api.Put("/files/:id/drawing-groups/:groupId", controllers.UpdateDrawingGroup)
+	api.Put("/files/:id/drawing-groups/:groupId/drawings", controllers.UpdateGroupDrawings)
+	api.Delete("/files/:id/drawing-groups/:groupId", controllers.DeleteDrawingGroup)
+	api.Get("/trash", controllers.GetTrash)
+	api.Post("/trash/:id/restore", controllers.RestoreFile)
+	api.Delete("/trash/:id", controllers.PurgeFile)
 	api.Get("/files/:id/download", controllers.DownloadFile)
+	api.Get("/files/:id/export/flattened", controllers.ExportFlattened)
+	api.Get("/files/:id/export/xfdf", controllers.ExportXFDF)
+	api.Post("/files/:id/annotations/import", uploadLimit, controllers.ImportAnnotations)
+	api.Get("/files/:id/thumbnail", controllers.GetFileThumbnail)
+	api.Get("/files/:id/pages", controllers.GetPageGeometry)
+	api.Get("/files/:id/pages/:page/image", controllers.GetPageImage)
+	api.Get("/files/:id/pages/:page/render", controllers.RenderPage)
+	api.Get("/files/:id/pages/:page/text", controllers.GetPageText)
+	api.Get("/files/:id/pages/:page/words", controllers.GetPageWords)
+	api.Post("/files/:id/pages/:page/tables", controllers.FindTables)
+	api.Get("/files/:id/text", controllers.GetFileText)
+	api.Post("/files/:id/ocr", controllers.StartOCR)
+	api.Get("/files/:id/ocr", controllers.GetOCRStatus)
+	api.Get("/files/:id/ocr/pdf", controllers.DownloadOCRPDF)
+	api.Post("/files/:id/versions", uploadLimit, controllers.UploadFileVersion)
+	api.Get("/files/:id/versions", controllers.GetFileVersions)
+	api.Get("/files/:id/versions/compare", controllers.CompareFileVersions)
+	api.Get("/files/:id/versions/:version/download", controllers.DownloadFileVersion)
+	api.Post("/files/stamp", controllers.StampFiles)
+	api.Post("/files/metadata-batch", controllers.UpdateFilesMetadataBatch)
+	api.Put("/files/:id/metadata", controllers.UpdateDocumentMetadata)
+	api.Get("/files/:id/form-fields", controllers.GetFormFields)
+	api.Post("/files/:id/form-fields", controllers.FillFormFields)
+	api.Get("/files/:id/signatures", controllers.GetSignatures)
+	api.Get("/files/:id/outline", controllers.GetOutline)
+	api.Put("/files/:id/outline", controllers.UpdateOutline)
+	api.Get("/files/:id/attachments", controllers.GetAttachments)
+	api.Get("/files/:id/attachments/:index", controllers.DownloadAttachment)
+	api.Post("/files/:id/attachments/:index/import", controllers.ImportAttachment)
+
+	// Background jobs, visible to the user who queued them
+	api.Get("/jobs", controllers.GetJobs)
+	api.Get("/jobs/:id", controllers.GetJob)
+	api.Get("/jobs/:id/download", controllers.DownloadJobResult)
+
+	// PDF operations creating new files
+	api.Post("/pdf/merge", controllers.MergeFiles)
+	api.Post("/pdf/generate", controllers.GeneratePDF)
+	api.Post("/files/:id/extract", controllers.ExtractPages)
+	api.Post("/files/:id/rotate", controllers.RotatePages)
+	api.Post("/files/:id/pages/delete", controllers.DeletePages)
+	api.Post("/files/:id/pages/reorder", controllers.ReorderPages)
+	api.Post("/files/:id/pages/insert", controllers.InsertPages)
+	api.Post("/files/:id/optimize", controllers.OptimizeFile)
+	api.Post("/files/:id/watermark", controllers.WatermarkFile)
+	api.Post("/files/:id/stamp", controllers.StampFile)
+	api.Post("/files/:id/encrypt", controllers.EncryptFile)
+	api.Post("/files/:id/decrypt", controllers.DecryptFile)
+	api.Post("/files/:id/sign", controllers.SignFile)
+	api.Post("/files/:id/redact", controllers.RedactFile)
+	api.Post("/files/:id/impose", controllers.ImposeFile)
+	api.Post("/files/:id/contact-sheet", controllers.ContactSheet)
+	api.Post("/files/:id/convert/pdfa", controllers.ConvertToPDFA)
 
 	// Drawing routes
 	api.Post("/drawings", controllers.CreateDrawing)
-	api.Get("/drawings", controllers.GetDrawings) // With query param ?fileId=X
+	api.Get("/drawings", controllers.GetDrawings)              // With query param ?fileId=X
+	api.Get("/drawings/bounds", controllers.GetDrawingsBounds) // With query params ?fileId=X&pageNumber=N
 	api.Get("/drawings/:id", controllers.GetDrawing)
 	api.Put("/drawings/:id", controllers.UpdateDrawing)
+	api.Patch("/drawings/:id", controllers.PatchDrawing)
+	api.Get("/drawings/:id/history", controllers.GetDrawingHistory)
+	api.Post("/drawings/:id/history/:revision/revert", controllers.RevertDrawing)
+	api.Post("/drawings/restore", controllers.RestoreDrawings)
+	api.Post("/drawings/:id/restore", controllers.RestoreDrawing)
+	api.Post("/drawings/:id/lock", controllers.LockDrawing)
+	api.Post("/drawings/:id/unlock", controllers.UnlockDrawing)
+	api.Get("/drawings/:id/comments", controllers.GetDrawingComments)
+	api.Post("/drawings/:id/comments", controllers.CreateDrawingComment)
+	api.Put("/drawings/:id/comments/:commentId", controllers.UpdateDrawingComment)
+	api.Delete("/drawings/:id/comments/:commentId", controllers.DeleteDrawingComment)
 	api.Delete("/drawings/file", controllers.DeleteDrawingsByFile) // With query param ?fileId=X
 	api.Delete("/drawings/:id", controllers.DeleteDrawing)
-	api.Post("/drawings/bulk", controllers.BulkCreateDrawings)
+	api.Post("/drawings/bulk", bulkLimit, controllers.BulkCreateDrawings)
+	api.Post("/drawings/sync", bulkLimit, controllers.SyncDrawings)
 }
@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// chunkSize is how much content is sent to clamd per INSTREAM chunk
+	chunkSize = 64 * 1024
+	// scanTimeout bounds a whole scan including the upload to clamd
+	scanTimeout = 10 * time.Minute
+)
+
+// Result is the verdict of a scan
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// address returns the clamd address from CLAMD_ADDRESS, either host:port or
+// unix:/path/to/clamd.sock
+func address() (network, addr string) {
+	value := os.Getenv("CLAMD_ADDRESS")
+	if path, found := strings.CutPrefix(value, "unix:"); found {
+		return "unix", path
+	}
+	return "tcp", value
+}
+
+// Enabled reports whether a clamd address is configured
+func Enabled() bool {
+	return os.Getenv("CLAMD_ADDRESS") != ""
+}
+
+// Scan streams content to clamd with the INSTREAM command and returns its verdict
+func Scan(r io.Reader) (Result, error) {
+	network, addr := address()
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(scanTimeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+
+	// Each chunk is prefixed with its length, a zero length ends the stream
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return Result{}, fmt.Errorf("failed to send content to clamd: %v", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply interprets replies like "stream: OK" and "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd error: %s", reply)
+	}
+}
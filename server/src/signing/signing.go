@@ -0,0 +1,199 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// remoteTimeout bounds a request to the remote signing service
+const remoteTimeout = 30 * time.Second
+
+// Enabled reports whether a certificate is configured, either in a PKCS#12
+// file with SIGNING_CERT or held by a remote signing service at
+// SIGNING_REMOTE_URL
+func Enabled() bool {
+	return os.Getenv("SIGNING_CERT") != "" || os.Getenv("SIGNING_REMOTE_URL") != ""
+}
+
+// Load returns the configured signer and its certificate chain, starting with
+// the signing certificate
+func Load() (crypto.Signer, []*x509.Certificate, error) {
+	if url := os.Getenv("SIGNING_REMOTE_URL"); url != "" {
+		return loadRemote(url)
+	}
+	path := os.Getenv("SIGNING_CERT")
+	if path == "" {
+		return nil, nil, errors.New("signing is not configured")
+	}
+	return loadPKCS12(path, os.Getenv("SIGNING_CERT_PASSWORD"))
+}
+
+// loadPKCS12 reads the key and certificates of a .p12 or .pfx file
+func loadPKCS12(path, password string) (crypto.Signer, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	var signer crypto.Signer
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "PRIVATE KEY":
+			// Keys come as PKCS #1 for RSA and SEC 1 for ECDSA
+			if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+				signer = key
+			} else if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+				signer = key
+			} else {
+				return nil, nil, fmt.Errorf("reading the key in %s: %v", path, err)
+			}
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading a certificate in %s: %v", path, err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if signer == nil {
+		return nil, nil, fmt.Errorf("%s holds no private key", path)
+	}
+	chain, err := orderChain(signer.Public(), certs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return signer, chain, nil
+}
+
+// orderChain moves the certificate of key to the front of certs
+func orderChain(key crypto.PublicKey, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	for i, cert := range certs {
+		if matches(key, cert.PublicKey) {
+			chain := append([]*x509.Certificate{cert}, certs[:i]...)
+			return append(chain, certs[i+1:]...), nil
+		}
+	}
+	return nil, errors.New("no certificate matches the signing key")
+}
+
+func matches(key, other crypto.PublicKey) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key.Equal(other)
+	case *ecdsa.PublicKey:
+		return key.Equal(other)
+	}
+	return false
+}
+
+// remoteSigner signs digests with a key that never leaves an HSM or signing
+// service. The service takes a POST of {"digest": ..., "hash": "SHA-256"},
+// with the digest in base64, and answers {"signature": ...}: a PKCS #1 v1.5
+// signature for RSA keys or an ASN.1 one for ECDSA keys.
+type remoteSigner struct {
+	url    string
+	token  string
+	public crypto.PublicKey
+	client *http.Client
+}
+
+// loadRemote sets up signing by the service at url, with the certificate
+// chain read from the PEM file SIGNING_CERT_CHAIN. SIGNING_REMOTE_TOKEN is
+// sent as a bearer token when set.
+func loadRemote(url string) (crypto.Signer, []*x509.Certificate, error) {
+	path := os.Getenv("SIGNING_CERT_CHAIN")
+	if path == "" {
+		return nil, nil, errors.New("SIGNING_CERT_CHAIN is required for remote signing")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading a certificate in %s: %v", path, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("%s holds no certificates", path)
+	}
+	return &remoteSigner{
+		url:    url,
+		token:  os.Getenv("SIGNING_REMOTE_TOKEN"),
+		public: chain[0].PublicKey,
+		client: &http.Client{Timeout: remoteTimeout},
+	}, chain, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, errors.New("only SHA-256 digests can be signed remotely")
+	}
+	body, err := json.Marshal(map[string]string{
+		"digest": base64.StdEncoding.EncodeToString(digest),
+		"hash":   "SHA-256",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote signing failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote signing failed with status %d", resp.StatusCode)
+	}
+	var result struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("reading remote signature: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(result.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, errors.New("remote signing returned no signature")
+	}
+	return signature, nil
+}
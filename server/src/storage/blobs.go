@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// AddRef records another file using existing content and returns the content's
+// key. ok is false when no content with the hash is stored yet.
+func AddRef(hash string) (key string, ok bool, err error) {
+	result := database.DB.Model(&models.Blob{}).
+		Where("hash = ? AND ref_count > 0", hash).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return "", false, result.Error
+	}
+
+	var blob models.Blob
+	if err := database.DB.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		return "", false, err
+	}
+	return blob.Key, true, nil
+}
+
+// Register records content just stored under key with one reference. When the
+// same content was registered concurrently, the reference is added to that blob
+// instead and its key is returned.
+func Register(hash, key string, size int64) (string, error) {
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+	}).Create(&models.Blob{Hash: hash, Key: key, Size: size, RefCount: 1}).Error
+	if err != nil {
+		return "", err
+	}
+
+	var blob models.Blob
+	if err := database.DB.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		return "", err
+	}
+	return blob.Key, nil
+}
+
+// Release drops a file's reference to content and deletes the content once no
+// file uses it anymore. The content is deleted while the blob is locked, so
+// the same content stored again meanwhile waits and is stored afresh.
+func Release(hash string) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var blob models.Blob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ?", hash).First(&blob).Error; err != nil {
+			return err
+		}
+		if blob.RefCount > 1 {
+			return tx.Model(&blob).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
+		}
+		if err := deleteContent(hash, blob.Key); err != nil {
+			return err
+		}
+		if err := tx.Where("hash = ?", hash).Delete(&models.PageText{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&blob).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// deleteContent removes stored content along with what was generated from it.
+// Generated objects are only cached, so failing to remove them is logged
+// rather than keeping the content.
+func deleteContent(hash, key string) error {
+	if err := Store.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	var derived []string
+	err := Store.Walk(hash+"/.derived/", func(object Object) error {
+		derived = append(derived, object.Key)
+		return nil
+	})
+	for _, key := range derived {
+		if deleteErr := Store.Delete(key); deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("ERROR removing what was generated from %s: %v\n", hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// cacheDir holds the renderings below "<hash>/.derived/" that can be made
+// again at will, unlike OCR output or thumbnails
+const cacheDir = "cache/"
+
+// CacheKey returns the storage key of a cached rendering of the content with
+// the given hash. Cached renderings are deleted along with the content, and
+// evicted when the cache outgrows its size.
+func CacheKey(hash, name string) string {
+	return DerivedKey(hash, cacheDir+name)
+}
+
+// CacheReport is the outcome of a cache eviction
+type CacheReport struct {
+	// Objects and Bytes are what the cache holds after the eviction
+	Objects        int   `json:"objects"`
+	Bytes          int64 `json:"bytes"`
+	EvictedObjects int   `json:"evictedObjects"`
+	FreedBytes     int64 `json:"freedBytes"`
+}
+
+// EvictCache removes cached renderings, oldest first, until the cache holds at
+// most limit bytes
+func EvictCache(limit int64) (CacheReport, error) {
+	var report CacheReport
+	var cached []Object
+	err := Store.Walk("", func(object Object) error {
+		if strings.Contains(object.Key, "/.derived/"+cacheDir) {
+			cached = append(cached, object)
+			report.Objects++
+			report.Bytes += object.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	sort.Slice(cached, func(i, j int) bool { return cached[i].Modified.Before(cached[j].Modified) })
+	for _, object := range cached {
+		if report.Bytes <= limit {
+			break
+		}
+		if err := Store.Delete(object.Key); err != nil {
+			return report, err
+		}
+		report.Objects--
+		report.Bytes -= object.Size
+		report.EvictedObjects++
+		report.FreedBytes += object.Size
+	}
+	return report, nil
+}
+
+// DropCache removes the cached renderings of the content with the given hash
+// whose names start with prefix, all of them for an empty prefix
+func DropCache(hash, prefix string) error {
+	var keys []string
+	err := Store.Walk(CacheKey(hash, prefix), func(object Object) error {
+		keys = append(keys, object.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := Store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
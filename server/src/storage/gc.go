@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"pdfsrv/src/database"
+	"pdfsrv/src/models"
+)
+
+// orphanGracePeriod spares recently written content, which is stored before
+// its blob is registered
+const orphanGracePeriod = time.Hour
+
+// GCReport is the outcome of a garbage collection
+type GCReport struct {
+	// OrphanedHashes are stored contents no blob refers to
+	OrphanedHashes []string `json:"orphanedHashes"`
+	RemovedObjects int      `json:"removedObjects"`
+	FreedBytes     int64    `json:"freedBytes"`
+	// MissingHashes are blobs whose content isn't in storage
+	MissingHashes []string `json:"missingHashes"`
+}
+
+// CollectGarbage removes stored content, along with what was derived from it,
+// whose hash has no blob, and reports blobs whose content is missing. With
+// dryRun nothing is removed and the report tells what would be.
+func CollectGarbage(dryRun bool) (GCReport, error) {
+	report := GCReport{OrphanedHashes: []string{}, MissingHashes: []string{}}
+
+	// Blobs are read before listing so content registered meanwhile is either
+	// known or within the grace period
+	var blobs []models.Blob
+	if err := database.DB.Find(&blobs).Error; err != nil {
+		return report, err
+	}
+	known := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		known[blob.Hash] = true
+	}
+
+	stored := make(map[string]bool)
+	orphans := make(map[string][]Object)
+	recent := make(map[string]bool)
+	err := Store.Walk("", func(object Object) error {
+		stored[object.Key] = true
+		hash := HashOf(object.Key)
+		if known[hash] {
+			return nil
+		}
+		if time.Since(object.Modified) < orphanGracePeriod {
+			recent[hash] = true
+		}
+		orphans[hash] = append(orphans[hash], object)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for hash, objects := range orphans {
+		if recent[hash] {
+			continue
+		}
+		report.OrphanedHashes = append(report.OrphanedHashes, hash)
+		for _, object := range objects {
+			if !dryRun {
+				if err := Store.Delete(object.Key); err != nil {
+					return report, err
+				}
+			}
+			report.RemovedObjects++
+			report.FreedBytes += object.Size
+		}
+	}
+
+	sort.Strings(report.OrphanedHashes)
+
+	var missing []string
+	for _, blob := range blobs {
+		if !stored[blob.Key] {
+			missing = append(missing, blob.Hash)
+		}
+	}
+	if len(missing) > 0 {
+		// Skip blobs released while storage was listed
+		if err := database.DB.Model(&models.Blob{}).Where("hash IN ?", missing).
+			Pluck("hash", &report.MissingHashes).Error; err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
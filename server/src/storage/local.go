@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps objects as files below a directory, one subdirectory per hash
+type Local struct {
+	Root string
+}
+
+// NewLocal returns a local disk backend rooted at dir
+func NewLocal(dir string) *Local {
+	return &Local{Root: dir}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+// path maps a key to its file, refusing keys that would escape the root
+func (l *Local) path(key string) string {
+	clean := filepath.Clean("/" + key)
+	return filepath.Join(l.Root, filepath.FromSlash(clean))
+}
+
+func (l *Local) Put(key string, r io.Reader, size int64) error {
+	target := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	// Write next to the target and rename so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("wrote %d of %d bytes", written, size)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// rangeReader reads a section of a file and closes the file
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (l *Local) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return rangeReader{Reader: io.NewSectionReader(f, offset, length), Closer: f}, nil
+}
+
+func (l *Local) Size(key string) (int64, error) {
+	info, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (l *Local) Exists(key string) (bool, error) {
+	_, err := os.Stat(l.path(key))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (l *Local) Move(src, dst string) error {
+	target := l.path(dst)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path(src), target); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	l.removeEmptyDir(src)
+	return nil
+}
+
+func (l *Local) Delete(key string) error {
+	if err := os.Remove(l.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l.removeEmptyDir(key)
+	return nil
+}
+
+// removeEmptyDir removes the directories of the key up to the root once
+// nothing is left in them
+func (l *Local) removeEmptyDir(key string) {
+	root := filepath.Clean(l.Root)
+	for dir := filepath.Dir(l.path(key)); dir != root && dir != "."; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return // The directory still has other files
+		}
+	}
+}
+
+func (l *Local) Walk(prefix string, fn func(object Object) error) error {
+	// Walk the deepest directory covering the prefix and filter the rest
+	start := l.Root
+	if dir, _ := path.Split(prefix); dir != "" {
+		start = l.path(dir)
+	}
+	err := filepath.WalkDir(start, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == start {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+	})
+	return err
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps file contents under keys of the form "<hash>/<filename>", and
+// files derived from them, such as thumbnails, below "<hash>/.derived/"
+type Storage interface {
+	// Name identifies the driver in diagnostics
+	Name() string
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(key string, r io.Reader, size int64) error
+	// Get opens an object for reading and returns its size
+	Get(key string) (io.ReadCloser, int64, error)
+	// GetRange opens length bytes of an object starting at offset for reading
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	// Size returns the size of an object
+	Size(key string) (int64, error)
+	// Exists reports whether an object is stored under key
+	Exists(key string) (bool, error)
+	// Move renames an object
+	Move(src, dst string) error
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(key string) error
+	// Walk calls fn for every stored object whose key starts with prefix
+	Walk(prefix string, fn func(object Object) error) error
+}
+
+// Object describes a stored object found by Walk
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Store is the storage backend selected with STORAGE_DRIVER
+var Store Storage
+
+// Connect sets up the backend selected with STORAGE_DRIVER: "local" (the
+// default) keeps files in STORAGE_DIR, "s3" uses an S3-compatible bucket
+func Connect() {
+	driver := os.Getenv("STORAGE_DRIVER")
+	switch driver {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		Store = NewLocal(dir)
+	case "s3":
+		s3, err := NewS3FromEnv()
+		if err != nil {
+			panic(fmt.Sprintf("failed to configure S3 storage: %v", err))
+		}
+		Store = s3
+	default:
+		panic(fmt.Sprintf("unknown storage driver %q", driver))
+	}
+	fmt.Println("Using", Store.Name(), "storage")
+}
+
+// Key returns the storage key of a file's content
+func Key(hash, filename string) string {
+	return hash + "/" + filename
+}
+
+// DerivedKey returns the storage key of a file generated from the content with
+// the given hash, which is deleted along with the content
+func DerivedKey(hash, name string) string {
+	return hash + "/.derived/" + name
+}
+
+// HashOf returns the hash part of a storage key
+func HashOf(key string) string {
+	hash, _, _ := strings.Cut(key, "/")
+	return hash
+}
+
+// PutFile stores the contents of a local file under key
+func PutFile(key, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return Store.Put(key, f, info.Size())
+}
+
+// LocalPath returns a path on local disk holding the object's content, for
+// tools that need a real file. The returned cleanup must always be called.
+func LocalPath(key string) (string, func(), error) {
+	if local, ok := Store.(*Local); ok {
+		p := local.path(key)
+		if _, err := os.Stat(p); err != nil {
+			if os.IsNotExist(err) {
+				return "", func() {}, ErrNotFound
+			}
+			return "", func() {}, err
+		}
+		return p, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "pdfsrv-object-")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	r, _, err := Store.Get(key)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	defer r.Close()
+
+	p := dir + "/" + path.Base(key)
+	out, err := os.Create(p)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		cleanup()
+		return "", func() {}, err
+	}
+	if err := out.Close(); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return p, cleanup, nil
+}
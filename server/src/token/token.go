@@ -0,0 +1,124 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Access tokens are short-lived; clients renew them with a refresh token
+const (
+	defaultTTL        = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Claims are the JWT claims issued to authenticated users
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// WorkspaceID is the workspace the token was issued for, 0 if none was chosen
+	WorkspaceID uint `json:"wid,omitempty"`
+	jwt.RegisteredClaims
+
+	// ApiKeyScopes is set instead of a signed token when the caller used an API key
+	ApiKeyScopes []string `json:"-"`
+	ViaApiKey    bool     `json:"-"`
+
+	// GuestFileID is set instead of a signed token when the caller used a guest
+	// token, limiting it to reading that one file
+	GuestFileID  uint `json:"-"`
+	GuestTokenID uint `json:"-"`
+}
+
+// IsGuest reports whether the caller is an external reviewer with a guest token
+func (c *Claims) IsGuest() bool {
+	return c.GuestFileID != 0
+}
+
+// SessionID returns the ID of the session the token belongs to
+func (c *Claims) SessionID() uint {
+	id, _ := strconv.ParseUint(c.ID, 10, 32)
+	return uint(id)
+}
+
+// UserID returns the ID of the user the token was issued to
+func (c *Claims) UserID() uint {
+	id, _ := strconv.ParseUint(c.Subject, 10, 32)
+	return uint(id)
+}
+
+func secret() ([]byte, error) {
+	value := os.Getenv("JWT_SECRET")
+	if value == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+	return []byte(value), nil
+}
+
+// TTL returns how long issued tokens stay valid, configured with JWT_TTL
+func TTL() time.Duration {
+	if value := os.Getenv("JWT_TTL"); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultTTL
+}
+
+// RefreshTTL returns how long a session can be kept alive with refresh tokens,
+// configured with REFRESH_TOKEN_TTL
+func RefreshTTL() time.Duration {
+	if value := os.Getenv("REFRESH_TOKEN_TTL"); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultRefreshTTL
+}
+
+// Issue creates a signed token for the user's session in a workspace
+func Issue(userID uint, username, role string, sessionID, workspaceID uint, expiresAt time.Time) (string, error) {
+	key, err := secret()
+	if err != nil {
+		return "", err
+	}
+
+	claims := Claims{
+		Username:    username,
+		Role:        role,
+		WorkspaceID: workspaceID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        strconv.FormatUint(uint64(sessionID), 10),
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+	return signed, nil
+}
+
+// Parse validates a signed token and returns its claims
+func Parse(signed string) (*Claims, error) {
+	key, err := secret()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(signed, claims, func(t *jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}